@@ -0,0 +1,130 @@
+// src/rawdata/js/lib/reorderable.js
+function initReorderable(container, {
+  onReorder = (item) => {
+  }
+} = {}) {
+  let dragItem = null;
+  let dragPointerId = null;
+  let dragItemStartY = 0;
+  let dragMouseStartY = 0;
+  const dummy = document.createElement("div");
+  dummy.classList.add("reorderable-dummy");
+  function startDrag(e) {
+    if (!e.isPrimary || e.button !== 0) {
+      return;
+    }
+    e.preventDefault();
+    const item = e.target.closest(".reorderable-item");
+    const top = item.offsetTop;
+    item.style.position = "absolute";
+    item.style.top = `${top}px`;
+    item.classList.add("reorderable-dragging");
+    dummy.style.height = `${item.offsetHeight}px`;
+    item.insertAdjacentElement("beforebegin", dummy);
+    document.body.classList.add("grabbing");
+    dragItem = item;
+    dragPointerId = e.pointerId;
+    dragItemStartY = top;
+    dragMouseStartY = e.pageY;
+    container.setPointerCapture(e.pointerId);
+    container.addEventListener("pointermove", doDrag);
+    container.addEventListener("lostpointercapture", endDrag, { once: true });
+  }
+  function doDrag(e) {
+    const delta = e.pageY - dragMouseStartY;
+    const top = dragItemStartY + delta;
+    const middle = top + dragItem.offsetHeight / 2;
+    const items = container.querySelectorAll(".reorderable-item");
+    let closestItem = null;
+    let closestItemDist = Infinity;
+    let insertBefore = null;
+    for (const item of items) {
+      if (item === dragItem) {
+        continue;
+      }
+      const itemMiddle = item.offsetTop + item.offsetHeight / 2;
+      const dist = middle - itemMiddle;
+      if (Math.abs(dist) < closestItemDist) {
+        closestItem = item;
+        closestItemDist = Math.abs(dist);
+        insertBefore = dist < 0;
+      }
+    }
+    if (closestItem) {
+      let alreadyOrdered = true;
+      let n = closestItem;
+      while (true) {
+        if (insertBefore) {
+          n = n.previousSibling;
+        } else {
+          n = n.nextSibling;
+        }
+        if (!n) {
+          alreadyOrdered = false;
+          break;
+        }
+        if (n === dragItem) {
+          break;
+        }
+        if (n.classList?.contains("reorderable-item")) {
+          alreadyOrdered = false;
+          break;
+        }
+      }
+      if (!alreadyOrdered) {
+        closestItem.insertAdjacentElement(insertBefore ? "beforebegin" : "afterend", dummy);
+        dragItem.remove();
+        dummy.insertAdjacentElement("beforebegin", dragItem);
+        onReorder(dragItem);
+      }
+    }
+    const maxTop = container.offsetHeight - dragItem.offsetHeight;
+    const newTop = Math.max(0, Math.min(maxTop, top));
+    dragItem.style.top = `${newTop}px`;
+  }
+  function endDrag(e) {
+    container.removeEventListener("pointermove", doDrag);
+    dragItem.remove();
+    dummy.insertAdjacentElement("beforebegin", dragItem);
+    dummy.remove();
+    dragItem.style.position = null;
+    dragItem.style.top = null;
+    dragItem.classList.remove("reorderable-dragging");
+    document.body.classList.remove("grabbing");
+    onReorder(dragItem);
+    dragItem = null;
+    dragPointerId = null;
+    dragItemStartY = 0;
+    dragMouseStartY = 0;
+  }
+  return {
+    startDrag
+  };
+}
+
+// src/rawdata/js/lib/utils.ts
+function assert(cond, msg, soft = false) {
+  if (!cond) {
+    if (soft) {
+      console.error(msg ?? "Assertion failed");
+    } else {
+      throw new Error(msg ?? "Assertion failed");
+    }
+  }
+}
+function must(val, msg) {
+  assert(val, msg);
+  return val;
+}
+
+// src/rawdata/js/fishbowl_edit.ts
+function init() {
+  const container = must(document.querySelector("#fishbowl-messages"));
+  const { startDrag } = initReorderable(container);
+  for (const handle of container.querySelectorAll(".fishbowl-grab-handle")) {
+    handle.addEventListener("pointerdown", startDrag);
+  }
+}
+export {
+  init
+};
@@ -266,6 +266,8 @@ function strToUTF8Arr(sDOMStr) {
 }
 
 // src/rawdata/js/lib/markdown_upload.ts
+var resumableUploadThreshold = 16 * 1024 * 1024;
+var maxResumeAttempts = 10;
 function setupMarkdownUpload(eSubmits, eFileInput, eUploadBar, eText, doMarkdown, maxFileSize, uploadUrl) {
   const submitTexts = Array.from(eSubmits).map((e) => e.value);
   const uploadProgress = must(eUploadBar.querySelector(".progress"));
@@ -276,7 +278,6 @@ function setupMarkdownUpload(eSubmits, eFileInput, eUploadBar, eText, doMarkdown
   let enterCounter = 0;
   let uploadQueue = [];
   let currentUpload = null;
-  let currentXhr = null;
   let currentBatchSize = 0;
   let currentBatchDone = 0;
   eFileInput.addEventListener("change", () => {
@@ -401,39 +402,98 @@ function setupMarkdownUpload(eSubmits, eFileInput, eUploadBar, eText, doMarkdown
     currentBatchSize++;
     uploadProgressText.textContent = `Uploading files ${currentBatchDone + 1}/${currentBatchSize}`;
   }
-  function uploadDone(ev) {
-    assert(currentXhr);
+  function uploadDone(result) {
     assert(currentUpload);
-    try {
-      if (currentXhr.status == 200 && currentXhr.response) {
-        if (currentXhr.response.url) {
-          let url = currentXhr.response.url;
-          let newString = `[${currentUpload.file.name}](${url})`;
-          if (currentXhr.response.mime.startsWith("image")) {
-            newString = "!" + newString;
-          }
-          replaceUploadString(currentUpload, newString);
-        } else if (currentXhr.response.error) {
-          replaceUploadString(currentUpload, `Upload failed for \`${currentUpload.file.name}\`: ${currentXhr.response.error}.`);
-        } else {
-          replaceUploadStringError(currentUpload);
-        }
-      } else {
-        replaceUploadStringError(currentUpload);
+    if (result?.url) {
+      let newString = `[${currentUpload.file.name}](${result.url})`;
+      if (result.mime?.startsWith("image")) {
+        newString = "!" + newString;
       }
-    } catch (err) {
-      console.error(err);
+      replaceUploadString(currentUpload, newString);
+    } else if (result?.error) {
+      replaceUploadString(currentUpload, `Upload failed for \`${currentUpload.file.name}\`: ${result.error}.`);
+    } else {
       replaceUploadStringError(currentUpload);
     }
     currentUpload = null;
-    currentXhr = null;
     currentBatchDone++;
     uploadNext();
   }
-  function updateUploadProgress(ev) {
-    if (ev.lengthComputable) {
-      let progress = ev.loaded / ev.total;
-      uploadProgressBarFill.style.width = Math.floor(progress * 100) + "%";
+  function updateUploadProgress(progress) {
+    uploadProgressBarFill.style.width = Math.floor(progress * 100) + "%";
+  }
+  function sendXhr(method, url, headers, body, onProgress) {
+    return new Promise((resolve) => {
+      const xhr = new XMLHttpRequest();
+      if (onProgress) {
+        xhr.upload.addEventListener("progress", (ev) => onProgress(ev.loaded));
+      }
+      xhr.open(method, url, true);
+      for (const [name, value] of Object.entries(headers)) {
+        xhr.setRequestHeader(name, value);
+      }
+      xhr.responseType = "json";
+      xhr.addEventListener("loadend", () => resolve(xhr));
+      xhr.send(body);
+    });
+  }
+  async function uploadFile(file, filenameHeader) {
+    const xhr = await sendXhr("POST", uploadUrl, {
+      "Hmn-Upload-Filename": filenameHeader
+    }, file, (loaded) => {
+      updateUploadProgress(loaded / file.size);
+    });
+    return xhr.status == 200 ? xhr.response : null;
+  }
+  async function uploadFileResumable(file, filenameHeader) {
+    const start = await sendXhr("POST", uploadUrl, {
+      "Hmn-Upload-Filename": filenameHeader,
+      "Hmn-Upload-Length": String(file.size)
+    }, null);
+    if (start.status != 200 || !start.response) {
+      return null;
+    }
+    if (start.response.error) {
+      return { error: start.response.error };
+    }
+    const chunkUrl = start.response.chunkUrl;
+    const chunkSize = start.response.chunkSize;
+    let offset = 0;
+    let failures = 0;
+    while (true) {
+      const chunk = file.slice(offset, offset + chunkSize);
+      const xhr = await sendXhr("PATCH", chunkUrl, {
+        "Hmn-Upload-Offset": String(offset)
+      }, chunk, (loaded) => {
+        updateUploadProgress((offset + loaded) / file.size);
+      });
+      if (xhr.status == 200 && xhr.response) {
+        if (xhr.response.url || xhr.response.error) {
+          return xhr.response;
+        }
+        offset = xhr.response.received;
+        failures = 0;
+      } else if (xhr.status == 409) {
+        failures++;
+        if (failures > maxResumeAttempts) {
+          return null;
+        }
+        offset = Number(xhr.getResponseHeader("Hmn-Upload-Offset"));
+      } else if (xhr.status == 0 || xhr.status >= 500) {
+        failures++;
+        if (failures > maxResumeAttempts) {
+          return null;
+        }
+        uploadProgressText.textContent = `Connection lost, retrying (${failures}/${maxResumeAttempts})...`;
+        await new Promise((resolve) => setTimeout(resolve, Math.min(1e3 * 2 ** (failures - 1), 3e4)));
+        uploadProgressText.textContent = `Uploading files ${currentBatchDone + 1}/${currentBatchSize}`;
+        const status = await sendXhr("HEAD", chunkUrl, {}, null);
+        if (status.status == 204) {
+          offset = Number(status.getResponseHeader("Hmn-Upload-Offset"));
+        }
+      } else {
+        return xhr.response?.error ? { error: xhr.response.error } : null;
+      }
     }
   }
   function uploadNext() {
@@ -447,22 +507,14 @@ function setupMarkdownUpload(eSubmits, eFileInput, eUploadBar, eText, doMarkdown
           e.disabled = true;
           e.value = "Uploading files...";
         }
-        try {
-          let utf8Filename = strToUTF8Arr(next.file.name);
-          let base64Filename = base64EncArr(utf8Filename);
-          currentXhr = new XMLHttpRequest();
-          currentXhr.upload.addEventListener("progress", updateUploadProgress);
-          currentXhr.open("POST", uploadUrl, true);
-          currentXhr.setRequestHeader("Hmn-Upload-Filename", base64Filename);
-          currentXhr.responseType = "json";
-          currentXhr.addEventListener("loadend", uploadDone);
-          currentXhr.send(next.file);
-          currentUpload = next;
-        } catch (err) {
-          replaceUploadStringError(next);
+        currentUpload = next;
+        let utf8Filename = strToUTF8Arr(next.file.name);
+        let base64Filename = base64EncArr(utf8Filename);
+        const upload = next.file.size > resumableUploadThreshold ? uploadFileResumable(next.file, base64Filename) : uploadFile(next.file, base64Filename);
+        upload.catch((err) => {
           console.error(err);
-          uploadNext();
-        }
+          return null;
+        }).then(uploadDone);
       } else {
         for (const [i, e] of Array.from(eSubmits).entries()) {
           e.disabled = false;
@@ -799,6 +851,11 @@ function autosaveContent({
   };
 }
 var markdownIds = [];
+var previewHandlers = {};
+previewWorker.onmessage = ({ data }) => {
+  const { elementID, html } = data;
+  previewHandlers[elementID]?.(html);
+};
 function initLiveMarkdown({
   inputEl,
   previewEl,
@@ -811,12 +868,9 @@ function initLiveMarkdown({
     console.warn(`Multiple elements with ID "${inputEl.id}" are being used for Markdown. Results will be very confusing!`);
   }
   markdownIds.push(inputEl.id);
-  previewWorker.onmessage = ({ data }) => {
-    const { elementID, html } = data;
-    if (elementID === inputEl.id) {
-      previewEl.innerHTML = html;
-      MathJax.typeset?.();
-    }
+  previewHandlers[inputEl.id] = (html) => {
+    previewEl.innerHTML = html;
+    MathJax.typeset?.();
   };
   function doMarkdown() {
     previewWorker.postMessage({
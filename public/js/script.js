@@ -1,3 +1,26 @@
 function rem2px(rem) {    
     return rem * parseFloat(getComputedStyle(document.documentElement).fontSize);
 }
+
+// Moves a timeline gallery one attachment forward (direction = 1) or back (direction = -1).
+function scrollTimelineGallery(controlEl, direction) {
+    const gallery = controlEl.closest(".timeline-gallery-container").querySelector(".timeline-gallery");
+    gallery.scrollBy({ left: direction * gallery.clientWidth, behavior: "smooth" });
+}
+
+// Seeks a timeline audio player to wherever its waveform was clicked.
+function seekTimelineAudio(waveformEl, event) {
+    const audio = waveformEl.closest(".timeline-audio").querySelector("audio");
+    const rect = waveformEl.getBoundingClientRect();
+    const fraction = Math.min(Math.max((event.clientX - rect.left) / rect.width, 0), 1);
+    const duration = isFinite(audio.duration) ? audio.duration : parseFloat(waveformEl.dataset.duration);
+    audio.currentTime = fraction * duration;
+    audio.play();
+}
+
+// Shades the part of a timeline audio waveform that has been played.
+function updateTimelineWaveform(audioEl) {
+    const waveform = audioEl.closest(".timeline-audio").querySelector(".timeline-waveform");
+    const duration = isFinite(audioEl.duration) ? audioEl.duration : parseFloat(waveform.dataset.duration);
+    waveform.style.setProperty("--progress", `${100 * audioEl.currentTime / duration}%`);
+}
@@ -1,3 +1,39 @@
+// src/rawdata/js/lib/markdown_previews.ts
+var previewWorker = new Worker("/assets/markdown_worker.js");
+var markdownIds = [];
+var previewHandlers = {};
+previewWorker.onmessage = ({ data }) => {
+  const { elementID, html } = data;
+  previewHandlers[elementID]?.(html);
+};
+function initLiveMarkdown({
+  inputEl,
+  previewEl,
+  parserName
+}) {
+  if (!parserName) {
+    parserName = "parseMarkdown";
+  }
+  if (markdownIds.includes(inputEl.id)) {
+    console.warn(`Multiple elements with ID "${inputEl.id}" are being used for Markdown. Results will be very confusing!`);
+  }
+  markdownIds.push(inputEl.id);
+  previewHandlers[inputEl.id] = (html) => {
+    previewEl.innerHTML = html;
+    MathJax.typeset?.();
+  };
+  function doMarkdown() {
+    previewWorker.postMessage({
+      elementID: inputEl.id,
+      markdown: inputEl.value,
+      parserName
+    });
+  }
+  doMarkdown();
+  inputEl.addEventListener("input", () => doMarkdown());
+  return doMarkdown;
+}
+
 // src/rawdata/js/lib/utils.ts
 function assert(cond, msg, soft = false) {
   if (!cond) {
@@ -83,6 +119,7 @@ function emptyElement(el) {
 // src/rawdata/js/snippetedit.ts
 var snippetEditTemplate = makeTemplateCloner("snippet-edit");
 var snippetEditProjectTemplate = makeTemplateCloner("snippet-edit-project");
+var snippetEditAttachmentMetaTemplate = makeTemplateCloner("snippet-edit-attachment-meta");
 function readableByteSize(numBytes) {
   const scales = [
     " bytes",
@@ -99,13 +136,16 @@ function readableByteSize(numBytes) {
 }
 function makeSnippetEdit({
   maxFilesize,
+  maxAttachments,
   availableProjects,
   ownerName,
   ownerAvatar,
   ownerUrl,
   date,
   text,
+  topics,
   attachmentElement,
+  attachmentMeta,
   projectIds,
   stickyProjectId,
   onDeleteRedirectUrl,
@@ -116,7 +156,9 @@ function makeSnippetEdit({
   let projectSelector = null;
   let originalAttachment = null;
   const originalText = text;
+  const originalTopics = topics ?? "";
   let attachmentChanged = false;
+  let attachmentMetaChanged = false;
   let hasAttachment = false;
   snippetEdit.redirect.value = location.href;
   if (ownerAvatar) {
@@ -131,15 +173,23 @@ function makeSnippetEdit({
   snippetEdit.username.href = ownerUrl ?? "";
   snippetEdit.date.textContent = new Intl.DateTimeFormat([], { month: "2-digit", day: "2-digit", year: "numeric" }).format(date);
   snippetEdit.text.value = text;
+  snippetEdit.topics.value = originalTopics;
   if (attachmentElement) {
     originalAttachment = attachmentElement.cloneNode(true);
     clearAttachment(true);
   }
   if (snippetId !== void 0 && snippetId !== null) {
     snippetEdit.snippetId.value = snippetId;
+    snippetEdit.crosspostBox.remove();
   } else {
     snippetEdit.deleteButton.remove();
   }
+  snippetEdit.text.id = `snippet-text-${snippetId ?? "new"}`;
+  initLiveMarkdown({
+    inputEl: snippetEdit.text,
+    previewEl: snippetEdit.textPreview,
+    parserName: "parseDiscordMarkdown"
+  });
   for (let i = 0; i < projectIds.length; ++i) {
     let proj = null;
     for (let j = 0; j < availableProjects.length; ++j) {
@@ -240,35 +290,74 @@ function makeSnippetEdit({
       snippetEdit.projectList.appendChild(projectSelector);
     }
   }
-  function setFile(file) {
+  function setFiles(files) {
     let dt = new DataTransfer();
-    dt.items.add(file);
+    for (const file of files) {
+      dt.items.add(file);
+    }
     snippetEdit.file.files = dt.files;
     attachmentChanged = true;
     snippetEdit.removeAttachment.value = "false";
-    hasAttachment = true;
-    let el = null;
+    hasAttachment = files.length > 0;
+    const el = document.createElement("div");
+    el.classList.add("flex", "flex-column", "g2");
+    for (const file of files) {
+      el.appendChild(makeFilePreview(file));
+    }
+    setPreview(el);
+    for (const file of files) {
+      const meta = snippetEditAttachmentMetaTemplate();
+      meta.assetId.remove();
+      meta.label.textContent = file.name;
+      meta.altText.name = "file_alt_text";
+      meta.caption.name = "file_caption";
+      snippetEdit.attachmentMeta.appendChild(meta.root);
+    }
+    validate();
+  }
+  function addExistingAttachmentMeta() {
+    const metas = attachmentMeta ?? [];
+    for (let i = 0; i < metas.length; ++i) {
+      const meta = snippetEditAttachmentMetaTemplate();
+      meta.assetId.name = "asset_id";
+      meta.assetId.value = metas[i].assetId;
+      meta.label.textContent = metas.length > 1 ? `Attachment ${i + 1}` : "";
+      meta.altText.name = "asset_alt_text";
+      meta.altText.value = metas[i].altText;
+      meta.caption.name = "asset_caption";
+      meta.caption.value = metas[i].caption;
+      for (const input of [meta.altText, meta.caption]) {
+        input.addEventListener("input", () => {
+          attachmentMetaChanged = true;
+        });
+      }
+      snippetEdit.attachmentMeta.appendChild(meta.root);
+    }
+  }
+  function makeFilePreview(file) {
     if (file.type.startsWith("image/")) {
-      el = document.createElement("img");
+      const el = document.createElement("img");
       el.src = URL.createObjectURL(file);
+      return el;
     } else if (file.type.startsWith("video/")) {
-      el = document.createElement("video");
+      const el = document.createElement("video");
       el.src = URL.createObjectURL(file);
       el.controls = true;
+      return el;
     } else if (file.type.startsWith("audio/")) {
-      el = document.createElement("audio");
+      const el = document.createElement("audio");
       el.src = URL.createObjectURL(file);
+      return el;
     } else {
-      el = document.createElement("div");
+      const el = document.createElement("div");
       el.classList.add("project-card", "br2", "pv1", "ph2");
       let anchor = document.createElement("a");
       anchor.href = URL.createObjectURL(file);
       anchor.setAttribute("target", "_blank");
       anchor.textContent = file.name + " (" + readableByteSize(file.size) + ")";
       el.appendChild(anchor);
+      return el;
     }
-    setPreview(el);
-    validate();
   }
   function clearAttachment(restoreOriginal) {
     snippetEdit.file.value = "";
@@ -286,9 +375,13 @@ function makeSnippetEdit({
       }
     }
     setPreview(el);
+    if (el && el == originalAttachment) {
+      addExistingAttachmentMeta();
+    }
     validate();
   }
   function setPreview(el) {
+    snippetEdit.attachmentMeta = emptyElement(snippetEdit.attachmentMeta);
     if (el) {
       snippetEdit.uploadBox.style.display = "none";
       snippetEdit.previewBox.style.display = "block";
@@ -306,9 +399,13 @@ function makeSnippetEdit({
   }
   function validate() {
     let sizeGood = true;
-    if (snippetEdit.file.files.length > 0 && snippetEdit.file.files[0].size > maxFilesize) {
+    const tooBig = Array.from(snippetEdit.file.files).find((file) => file.size > maxFilesize);
+    if (tooBig) {
       let readableSize = new Intl.NumberFormat([], { useGrouping: true }).format(maxFilesize);
-      snippetEdit.errors.textContent = "File is too big! Max filesize is " + readableSize + " bytes.";
+      snippetEdit.errors.textContent = tooBig.name + " is too big! Max filesize is " + readableSize + " bytes.";
+      sizeGood = false;
+    } else if (snippetEdit.file.files.length > maxAttachments) {
+      snippetEdit.errors.textContent = "Too many files! You can attach at most " + maxAttachments + ".";
       sizeGood = false;
     } else {
       snippetEdit.errors.textContent = "";
@@ -337,7 +434,7 @@ function makeSnippetEdit({
   });
   snippetEdit.file.addEventListener("change", () => {
     if (snippetEdit.file.files.length > 0) {
-      setFile(snippetEdit.file.files[0]);
+      setFiles(Array.from(snippetEdit.file.files));
     }
   });
   snippetEdit.root.addEventListener("dragover", (ev) => {
@@ -373,7 +470,7 @@ function makeSnippetEdit({
     enterCounter = 0;
     snippetEdit.root.classList.remove("drop");
     if (ev.dataTransfer && ev.dataTransfer.files && ev.dataTransfer.files.length > 0) {
-      setFile(ev.dataTransfer.files[0]);
+      setFiles(Array.from(ev.dataTransfer.files));
     }
     ev.preventDefault();
   });
@@ -381,7 +478,7 @@ function makeSnippetEdit({
     assert(ev.clipboardData);
     const files = ev.clipboardData.files ?? [];
     if (files.length > 0) {
-      setFile(files[0]);
+      setFiles(Array.from(files));
     }
   });
   snippetEdit.text.addEventListener("input", () => {
@@ -413,7 +510,8 @@ function makeSnippetEdit({
         }
       }
     }
-    if (originalSnippetEl && (!attachmentChanged && originalText == snippetEdit.text.value.trim() && !projectsChanged)) {
+    const topicsChanged = originalTopics != snippetEdit.topics.value.trim();
+    if (originalSnippetEl && (!attachmentChanged && !attachmentMetaChanged && originalText == snippetEdit.text.value.trim() && !projectsChanged && !topicsChanged)) {
       ev.preventDefault();
       cancel();
     }
@@ -431,6 +529,7 @@ function makeSnippetEdit({
 }
 function editTimelineSnippet(timelineItemEl, {
   maxFilesize,
+  maxAttachments,
   availableProjects,
   stickyProjectId,
   onDeleteRedirectUrl
@@ -440,7 +539,17 @@ function editTimelineSnippet(timelineItemEl, {
   const ownerAvatar = timelineItemEl.querySelector(".avatar")?.src;
   const creationDate = new Date(must(timelineItemEl.querySelector("time")).dateTime);
   const rawDesc = must(timelineItemEl.querySelector(".rawdesc")).textContent;
-  const attachment = timelineItemEl.querySelector(".timeline-media")?.children?.[0];
+  const topics = timelineItemEl.querySelector(".topic-list")?.textContent ?? "";
+  const attachment = timelineItemEl.querySelector(".timeline-gallery") ?? timelineItemEl.querySelector(".timeline-media")?.children?.[0];
+  const attachmentMeta = [];
+  const assetMetaEls = timelineItemEl.querySelectorAll(".asset-meta-list > input");
+  for (let i = 0; i < assetMetaEls.length; ++i) {
+    attachmentMeta.push({
+      assetId: assetMetaEls[i].dataset.assetId ?? "",
+      altText: assetMetaEls[i].dataset.altText ?? "",
+      caption: assetMetaEls[i].dataset.caption ?? ""
+    });
+  }
   const projectIds = [];
   const projectEls = timelineItemEl.querySelectorAll(".project-id-list > input");
   for (let i = 0; i < projectEls.length; ++i) {
@@ -451,13 +560,16 @@ function editTimelineSnippet(timelineItemEl, {
   }
   let snippetEdit = makeSnippetEdit({
     maxFilesize,
+    maxAttachments,
     availableProjects,
     ownerName,
     ownerAvatar,
     ownerUrl,
     date: creationDate,
     text: rawDesc,
+    topics,
     attachmentElement: attachment,
+    attachmentMeta,
     projectIds,
     stickyProjectId,
     onDeleteRedirectUrl,
@@ -0,0 +1,186 @@
+package admintools
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"git.handmade.network/hmn/hmn/src/assets"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/utils"
+	"github.com/spf13/cobra"
+)
+
+func addAssetCommands(adminCommand *cobra.Command) {
+	assetCommand := &cobra.Command{
+		Use:   "asset",
+		Short: "Admin commands for managing assets",
+	}
+	adminCommand.AddCommand(assetCommand)
+
+	addAssetGCCommand(assetCommand)
+	addAssetPinCommand(assetCommand)
+	addAssetSanitizeCommand(assetCommand)
+}
+
+func addAssetGCCommand(assetCommand *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Find assets that nothing refers to, and delete them after a grace period",
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun := utils.Must1(cmd.Flags().GetBool("dry-run"))
+			verbose := utils.Must1(cmd.Flags().GetBool("verbose"))
+
+			ctx := context.Background()
+			conn := db.NewConn()
+			defer conn.Close(ctx)
+
+			report, err := assets.CollectGarbage(ctx, conn, dryRun)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+				os.Exit(1)
+			}
+
+			if report.DryRun {
+				fmt.Printf("Dry run; nothing was changed.\n\n")
+			}
+			printAssetList := func(title string, list []*models.Asset) {
+				fmt.Printf("%s: %d\n", title, len(list))
+				if verbose {
+					for _, asset := range list {
+						fmt.Printf("- %s (%s, %d bytes)\n", asset.S3Key, asset.MimeType, asset.Size)
+					}
+				}
+			}
+			printAssetList("Newly orphaned", report.NewlyOrphaned)
+			printAssetList("Referenced again", report.Rescued)
+			if report.DryRun {
+				printAssetList("Would delete", report.Deleted)
+			} else {
+				printAssetList("Deleted", report.Deleted)
+			}
+			fmt.Printf("Space reclaimed: %d bytes\n", report.DeletedBytes())
+
+			if len(report.Errors) > 0 {
+				fmt.Printf("!!!!!!!!!!!!!!!!\n")
+				fmt.Printf("!!!  ERRORS  !!!\n")
+				fmt.Printf("!!!!!!!!!!!!!!!!\n")
+				for _, err := range report.Errors {
+					fmt.Println(err)
+				}
+			}
+		},
+	}
+	cmd.Flags().Bool("dry-run", false, "Report what would be deleted without changing anything")
+	cmd.Flags().BoolP("verbose", "v", false, "List every affected asset")
+	assetCommand.AddCommand(cmd)
+}
+
+func addAssetPinCommand(assetCommand *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "pin <asset id or key>...",
+		Short: "Keep assets from being garbage collected, e.g. because a template links to them",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Usage()
+				os.Exit(1)
+			}
+			unpin := utils.Must1(cmd.Flags().GetBool("unpin"))
+
+			ctx := context.Background()
+			conn := db.NewConn()
+			defer conn.Close(ctx)
+
+			for _, arg := range args {
+				tag, err := conn.Exec(ctx,
+					`
+					UPDATE asset
+					SET pinned = $2
+					WHERE id::TEXT = $1 OR s3_key = $1
+					`,
+					arg,
+					!unpin,
+				)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+					os.Exit(1)
+				}
+				if tag.RowsAffected() == 0 {
+					fmt.Printf("%s: no such asset\n", arg)
+				} else if unpin {
+					fmt.Printf("%s: unpinned\n", arg)
+				} else {
+					fmt.Printf("%s: pinned\n", arg)
+				}
+			}
+		},
+	}
+	cmd.Flags().Bool("unpin", false, "Let garbage collection delete the assets again")
+	assetCommand.AddCommand(cmd)
+}
+
+func addAssetSanitizeCommand(assetCommand *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "sanitize",
+		Short: "Strip location and other metadata from images uploaded before it was done automatically",
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun := utils.Must1(cmd.Flags().GetBool("dry-run"))
+
+			ctx := context.Background()
+			conn := db.NewConn()
+			defer conn.Close(ctx)
+
+			unsanitized, err := db.Query[models.Asset](ctx, conn,
+				`
+				SELECT $columns
+				FROM asset
+				WHERE
+					NOT sanitized
+					AND mime_type = ANY($1)
+				`,
+				[]string{"image/jpeg", "image/png", "image/webp"},
+			)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+				os.Exit(1)
+			}
+
+			if dryRun {
+				fmt.Printf("Dry run; nothing will be changed.\n\n")
+			}
+
+			numChanged := 0
+			var errs []error
+			for i, asset := range unsanitized {
+				fmt.Printf("(%d/%d) %s...", i+1, len(unsanitized), asset.S3Key)
+				changed, err := assets.SanitizeExistingAsset(ctx, conn, asset, dryRun)
+				if err != nil {
+					fmt.Printf("FAIL\n")
+					errs = append(errs, oops.New(err, "for asset %s", asset.ID))
+					continue
+				}
+				if changed {
+					numChanged++
+					fmt.Printf("stripped metadata\n")
+				} else {
+					fmt.Printf("ok\n")
+				}
+			}
+
+			fmt.Printf("\nChecked %d images, %d had metadata.\n", len(unsanitized), numChanged)
+
+			if len(errs) > 0 {
+				fmt.Printf("!!!!!!!!!!!!!!!!\n")
+				fmt.Printf("!!!  ERRORS  !!!\n")
+				fmt.Printf("!!!!!!!!!!!!!!!!\n")
+				for _, err := range errs {
+					fmt.Println(err)
+				}
+			}
+		},
+	}
+	cmd.Flags().Bool("dry-run", false, "Report which images have metadata without changing anything")
+	assetCommand.AddCommand(cmd)
+}
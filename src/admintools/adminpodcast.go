@@ -0,0 +1,137 @@
+package admintools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/utils"
+	"git.handmade.network/hmn/hmn/src/website"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+func addPodcastCommands(adminCommand *cobra.Command) {
+	podcastCommand := &cobra.Command{
+		Use:   "podcast",
+		Short: "Admin commands for managing podcasts",
+	}
+	adminCommand.AddCommand(podcastCommand)
+
+	addPodcastMigrateAudioCommand(podcastCommand)
+}
+
+func addPodcastMigrateAudioCommand(podcastCommand *cobra.Command) {
+	cmd := &cobra.Command{
+		Use:   "migrate-audio",
+		Short: "Upload episode audio from public/media/podcast as assets",
+		Long:  "Upload episode audio from public/media/podcast as assets. Run this from the website's working directory on the server that has the files.",
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun := utils.Must1(cmd.Flags().GetBool("dry-run"))
+
+			ctx := context.Background()
+			conn := db.NewConn()
+			defer conn.Close(ctx)
+
+			type legacyEpisode struct {
+				GUID        uuid.UUID `db:"episode.guid"`
+				Title       string    `db:"episode.title"`
+				AudioFile   string    `db:"episode.audio_filename"`
+				ProjectSlug string    `db:"project.slug"`
+			}
+			episodes, err := db.Query[legacyEpisode](ctx, conn,
+				`
+				SELECT $columns
+				FROM
+					podcast_episode AS episode
+					JOIN podcast ON podcast.id = episode.podcast_id
+					JOIN project ON project.id = podcast.project_id
+				WHERE
+					episode.audio_asset IS NULL
+					AND episode.audio_filename != ''
+				ORDER BY episode.pub_date
+				`,
+			)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+				os.Exit(1)
+			}
+
+			if dryRun {
+				fmt.Printf("Dry run; nothing will be changed.\n\n")
+			}
+
+			numMigrated := 0
+			var errs []error
+			for i, episode := range episodes {
+				path := filepath.Join("public/media/podcast", episode.ProjectSlug, episode.AudioFile)
+				fmt.Printf("(%d/%d) %s (%s)...", i+1, len(episodes), episode.Title, path)
+				if dryRun {
+					if _, err := os.Stat(path); err != nil {
+						fmt.Printf("MISSING\n")
+						errs = append(errs, oops.New(err, "for episode %s", episode.GUID))
+					} else {
+						fmt.Printf("ok\n")
+					}
+					continue
+				}
+
+				err := migratePodcastEpisodeAudio(ctx, conn, episode.GUID, path)
+				if err != nil {
+					fmt.Printf("FAIL\n")
+					errs = append(errs, oops.New(err, "for episode %s", episode.GUID))
+					continue
+				}
+				numMigrated++
+				fmt.Printf("done\n")
+			}
+
+			fmt.Printf("\nMigrated %d of %d episodes.\n", numMigrated, len(episodes))
+
+			if len(errs) > 0 {
+				fmt.Printf("!!!!!!!!!!!!!!!!\n")
+				fmt.Printf("!!!  ERRORS  !!!\n")
+				fmt.Printf("!!!!!!!!!!!!!!!!\n")
+				for _, err := range errs {
+					fmt.Println(err)
+				}
+			}
+		},
+	}
+	cmd.Flags().Bool("dry-run", false, "Check which episode files exist without changing anything")
+	podcastCommand.AddCommand(cmd)
+}
+
+func migratePodcastEpisodeAudio(ctx context.Context, conn db.ConnOrTx, guid uuid.UUID, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return oops.New(err, "failed to open episode file")
+	}
+	defer file.Close()
+
+	// Episodes could only ever use MP3s from this folder.
+	audio, duration, err := website.CreatePodcastEpisodeAudio(ctx, conn, file, filepath.Base(path), "audio/mpeg", nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(ctx,
+		`
+		UPDATE podcast_episode
+		SET
+			audio_asset = $2,
+			duration = CASE WHEN $3 > 0 THEN $3 ELSE duration END
+		WHERE guid = $1
+		`,
+		guid,
+		audio.ID,
+		duration,
+	)
+	if err != nil {
+		return oops.New(err, "failed to update episode")
+	}
+	return nil
+}
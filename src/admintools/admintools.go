@@ -5,7 +5,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -613,14 +612,16 @@ func init() {
 			conn := db.NewConn()
 			defer conn.Close(ctx)
 
-			assetContents := utils.Must1(io.ReadAll(utils.Must1(os.Open(fname))))
+			assetFile := utils.Must1(os.Open(fname))
+			defer assetFile.Close()
 			assetFilename := filepath.Base(fname)
 
 			fmt.Printf("Uploading %s with content type %s...\n", assetFilename, contentType)
 			asset := utils.Must1(assets.Create(ctx, conn, assets.CreateInput{
-				Content:     assetContents,
+				Content:     assetFile,
 				Filename:    assetFilename,
 				ContentType: contentType,
+				Pinned:      true, // These are usually linked from templates, which GC can't see.
 			}))
 			fmt.Printf("Uploaded and accessible at %s\n", hmnurl.BuildS3Asset(asset.S3Key))
 		},
@@ -645,4 +646,6 @@ func init() {
 	addProjectCommands(adminCommand)
 	addPostCommands(adminCommand)
 	addEventCommands(adminCommand)
+	addAssetCommands(adminCommand)
+	addPodcastCommands(adminCommand)
 }
@@ -1,6 +1,7 @@
 package assets
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/sha1"
@@ -12,61 +13,37 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"slices"
 	"strings"
 
 	"git.handmade.network/hmn/hmn/src/config"
 	"git.handmade.network/hmn/hmn/src/db"
-	"git.handmade.network/hmn/hmn/src/hmnurl"
 	"git.handmade.network/hmn/hmn/src/jobs"
 	"git.handmade.network/hmn/hmn/src/logging"
 	"git.handmade.network/hmn/hmn/src/models"
 	"git.handmade.network/hmn/hmn/src/oops"
 	"git.handmade.network/hmn/hmn/src/utils"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/aws/smithy-go"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-var client *s3.Client
-
-func init() {
-	cfg, err := awsconfig.LoadDefaultConfig(context.Background(),
-		awsconfig.WithCredentialsProvider(
-			credentials.NewStaticCredentialsProvider(
-				config.Config.DigitalOcean.AssetsSpacesKey,
-				config.Config.DigitalOcean.AssetsSpacesSecret,
-				"",
-			),
-		),
-		awsconfig.WithRegion(config.Config.DigitalOcean.AssetsSpacesRegion),
-		awsconfig.WithEndpointResolver(aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
-			return aws.Endpoint{
-				URL: config.Config.DigitalOcean.AssetsSpacesEndpoint,
-			}, nil
-		})),
-	)
-	if err != nil {
-		panic(err)
-	}
-	client = s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.UsePathStyle = true
-	})
-}
-
 type CreateInput struct {
-	Content  []byte
+	Content  io.Reader
 	Filename string
 
 	// Optional params
 
-	ContentType   string // Defaults to http.DetectContentType(Content)
+	ContentType   string // Defaults to http.DetectContentType on the start of Content
 	UploaderID    *int   // HMN user id
 	Width, Height int
+	AltText       string
+	Caption       string
+	Private       bool // See models.Asset.Private
+	Pinned        bool // See models.Asset.Pinned
+
+	// Leaves audio analysis to the background job, for requests that can't
+	// wait for ffmpeg to get through a whole file.
+	AnalyzeAudioLater bool
 }
 
 var REIllegalFilenameChars = regexp.MustCompile(`[^\w\-.]`)
@@ -87,96 +64,238 @@ type InvalidAssetError error
 func Create(ctx context.Context, dbConn db.ConnOrTx, in CreateInput) (*models.Asset, error) {
 	filename := SanitizeFilename(in.Filename)
 
-	if len(in.Content) == 0 {
+	content := bufio.NewReaderSize(in.Content, 512)
+	head, err := content.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, oops.New(err, "failed to read asset")
+	}
+	if len(head) == 0 {
 		return nil, InvalidAssetError(fmt.Errorf("could not upload asset '%s': no bytes of data were provided", filename))
 	}
 
-	// Upload the asset to the DO space
-	id := uuid.New()
-	key := AssetKey(id.String(), filename)
-	checksum := fmt.Sprintf("%x", sha1.Sum(in.Content))
-	contentType := utils.OrDefault(in.ContentType, http.DetectContentType(in.Content))
-
-	upload := func() error {
-		_, err := client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket:      &config.Config.DigitalOcean.AssetsSpacesBucket,
-			Key:         &key,
-			Body:        bytes.NewReader(in.Content),
-			ACL:         types.ObjectCannedACLPublicRead,
-			ContentType: &contentType,
-		})
-		return err
-	}
+	contentType := utils.OrDefault(in.ContentType, http.DetectContentType(head))
 
-	err := upload()
-	if err != nil {
-		var apiError smithy.APIError
-		if errors.As(err, &apiError) && apiError.ErrorCode() == "NoSuchBucket" {
-			_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{
-				Bucket: &config.Config.DigitalOcean.AssetsSpacesBucket,
-			})
-			if err != nil {
-				return nil, oops.New(err, "failed to create assets bucket")
-			}
+	// Images are small enough to work with in memory, and we need the whole
+	// thing to strip metadata and generate variants. Everything else is
+	// streamed straight to S3.
+	var body io.Reader = content
+	var imageContent []byte
+	sanitized := false
+	if CanSanitize(contentType) || slices.Contains(variantSourceTypes, contentType) {
+		imageContent, err = io.ReadAll(content)
+		if err != nil {
+			return nil, oops.New(err, "failed to read asset")
+		}
 
-			err = upload()
+		if CanSanitize(contentType) {
+			clean, err := SanitizeImage(contentType, imageContent)
 			if err != nil {
-				return nil, oops.New(err, "failed to upload asset")
+				logging.ExtractLogger(ctx).Warn().Err(err).Str("filename", filename).Msg("Failed to strip metadata from image; storing it as-is")
+			} else {
+				imageContent = clean
+				sanitized = true
 			}
-		} else {
-			return nil, oops.New(err, "failed to upload asset")
 		}
+
+		existing, err := findDuplicate(ctx, dbConn, fmt.Sprintf("%x", sha1.Sum(imageContent)), int64(len(imageContent)), contentType, in.Private, in.UploaderID)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && canAdoptDuplicate(existing, in) {
+			return adoptDuplicate(ctx, dbConn, existing, in)
+		}
+
+		body = bytes.NewReader(imageContent)
 	}
 
-	var thumbnailKey *string
-
-	width := in.Width
-	height := in.Height
-	if previewBytes, thumbWidth, thumbHeight, err := ExtractPreview(ctx, in.ContentType, in.Content); err != nil {
-		logging.Error().Err(err).Msg("Failed to generate preview for asset")
-	} else if len(previewBytes) > 0 {
-		keyStr := AssetKey(id.String(), fmt.Sprintf("%s_thumb.jpg", id.String()))
-		thumbnailType := "image/jpeg"
-		_, err = client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket:      &config.Config.DigitalOcean.AssetsSpacesBucket,
-			Key:         &keyStr,
-			Body:        bytes.NewReader(previewBytes),
-			ACL:         types.ObjectCannedACLPublicRead,
-			ContentType: &thumbnailType,
-		})
+	// Video thumbnails and audio waveforms are extracted by ffmpeg, which wants
+	// a file.
+	var mediaFile *os.File
+	isAudio := strings.HasPrefix(contentType, "audio")
+	if (strings.HasPrefix(contentType, "video") || (isAudio && !in.AnalyzeAudioLater)) && getFFMpegPath() != "" {
+		mediaFile, err = os.CreateTemp("", "hmnasset")
 		if err != nil {
-			logging.Error().Err(err).Msg("Failed to upload thumbnail for video")
-		} else {
-			thumbnailKey = &keyStr
+			return nil, oops.New(err, "failed to create temp file for preview generation")
 		}
+		defer os.Remove(mediaFile.Name())
+		defer mediaFile.Close()
+		body = io.TeeReader(body, mediaFile)
+	}
+
+	// Upload the asset to the DO space
+	id := uuid.New()
+	key := AssetKey(id.String(), filename)
+
+	hash := sha1.New()
+	size, err := putObject(ctx, key, contentType, in.Private, io.TeeReader(body, hash))
+	if err != nil {
+		return nil, err
+	}
+	checksum := fmt.Sprintf("%x", hash.Sum(nil))
 
-		if width == 0 || height == 0 {
-			width = thumbWidth
-			height = thumbHeight
+	if imageContent == nil {
+		existing, err := findDuplicate(ctx, dbConn, checksum, size, contentType, in.Private, in.UploaderID)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil && canAdoptDuplicate(existing, in) {
+			deleteObjects(ctx, key)
+			return adoptDuplicate(ctx, dbConn, existing, in)
 		}
 	}
 
+	var mediaPath string
+	if mediaFile != nil {
+		mediaPath = mediaFile.Name()
+	}
+
+	return saveAsset(ctx, dbConn, assetRecord{
+		ID:          id,
+		S3Key:       key,
+		Filename:    filename,
+		Size:        size,
+		ContentType: contentType,
+		Checksum:    checksum,
+		Width:       in.Width,
+		Height:      in.Height,
+		UploaderID:  in.UploaderID,
+		Sanitized:   sanitized,
+		AltText:     in.AltText,
+		Caption:     in.Caption,
+		Private:     in.Private,
+		Pinned:      in.Pinned,
+	}, imageContent, mediaPath)
+}
+
+// Finds an existing asset with exactly the same content, so we can reuse it
+// instead of storing another copy. Returns nil if there is no duplicate.
+//
+// Orphaned assets are skipped so that garbage collection never deletes an asset
+// out from under a new reference. A duplicate must also match in privacy, so
+// that private uploads never end up public or vice versa, and private assets are
+// only ever shared with the same uploader, since whoever can see one copy can
+// see them all.
+func findDuplicate(ctx context.Context, dbConn db.ConnOrTx, checksum string, size int64, contentType string, private bool, uploaderID *int) (*models.Asset, error) {
+	existing, err := db.QueryOne[models.Asset](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM asset
+		WHERE
+			sha1sum = $1
+			AND size = $2
+			AND mime_type = $3
+			AND private = $4
+			AND (NOT private OR uploader_id = $5)
+			AND orphaned_at IS NULL
+		LIMIT 1
+		`,
+		checksum,
+		size,
+		contentType,
+		private,
+		uploaderID,
+	)
+	if errors.Is(err, db.NotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, oops.New(err, "failed to check for duplicate asset")
+	}
+	return existing, nil
+}
+
+// Alt text and captions live on the asset, and only its uploader may change
+// them. An upload that describes itself can therefore only reuse its own
+// uploader's copy of a file, or the description would be lost.
+func canAdoptDuplicate(existing *models.Asset, in CreateInput) bool {
+	if in.AltText == "" && in.Caption == "" {
+		return true
+	}
+	return existing.UploaderID != nil && in.UploaderID != nil && *existing.UploaderID == *in.UploaderID
+}
+
+// Reuses an existing copy of an uploaded file, giving it the new upload's alt
+// text and caption if it doesn't have its own. Pinning the new upload pins the
+// existing copy.
+func adoptDuplicate(ctx context.Context, dbConn db.ConnOrTx, existing *models.Asset, in CreateInput) (*models.Asset, error) {
+	if (existing.AltText != "" || in.AltText == "") && (existing.Caption != "" || in.Caption == "") && (existing.Pinned || !in.Pinned) {
+		return existing, nil
+	}
+
+	existing.AltText = utils.OrDefault(existing.AltText, in.AltText)
+	existing.Caption = utils.OrDefault(existing.Caption, in.Caption)
+	existing.Pinned = existing.Pinned || in.Pinned
+	_, err := dbConn.Exec(ctx,
+		`
+		UPDATE asset
+		SET alt_text = $2, caption = $3, pinned = $4
+		WHERE id = $1
+		`,
+		existing.ID,
+		existing.AltText,
+		existing.Caption,
+		existing.Pinned,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to update duplicate asset")
+	}
+	return existing, nil
+}
+
+type assetRecord struct {
+	ID          uuid.UUID
+	S3Key       string
+	Filename    string
+	Size        int64
+	ContentType string
+	Checksum    string
+	Width       int
+	Height      int
+	UploaderID  *int
+	Sanitized   bool
+	AltText     string
+	Caption     string
+	Private     bool
+	Pinned      bool
+}
+
+// Records an asset whose file is already in S3. If they are available,
+// imageContent is used to generate variants and mediaPath, a copy of the file
+// on disk, to generate a video thumbnail or analyze audio.
+func saveAsset(ctx context.Context, dbConn db.ConnOrTx, rec assetRecord, imageContent []byte, mediaPath string) (*models.Asset, error) {
+	transcodeStatus := models.TranscodeStatusNone
+	if strings.HasPrefix(rec.ContentType, "video") {
+		transcodeStatus = models.TranscodeStatusPending
+	}
+
 	// Save a record in our database
 	// TODO(db): Would be convient to use RETURNING here...
-	_, err = dbConn.Exec(ctx,
+	_, err := dbConn.Exec(ctx,
 		`
-		INSERT INTO asset (id, s3_key, thumbnail_s3_key, filename, size, mime_type, sha1sum, width, height, uploader_id)
-		VALUES            ($1, $2,     $3,               $4,       $5,   $6,        $7,      $8,    $9,     $10)
+		INSERT INTO asset (id, s3_key, filename, size, mime_type, sha1sum, width, height, uploader_id, sanitized, transcode_status, alt_text, caption, private, pinned)
+		VALUES            ($1, $2,     $3,       $4,   $5,        $6,      $7,    $8,     $9,          $10,       $11,              $12,      $13,     $14,     $15)
 		`,
-		id,
-		key,
-		thumbnailKey,
-		filename,
-		len(in.Content),
-		in.ContentType,
-		checksum,
-		width,
-		height,
-		in.UploaderID,
+		rec.ID,
+		rec.S3Key,
+		rec.Filename,
+		rec.Size,
+		rec.ContentType,
+		rec.Checksum,
+		rec.Width,
+		rec.Height,
+		rec.UploaderID,
+		rec.Sanitized,
+		transcodeStatus,
+		rec.AltText,
+		rec.Caption,
+		rec.Private,
+		rec.Pinned,
 	)
 	if err != nil {
 		return nil, oops.New(err, "failed to save asset record")
 	}
+	if transcodeStatus == models.TranscodeStatusPending {
+		notifyTranscodeQueued()
+	}
 
 	// Fetch and return the new record
 	asset, err := db.QueryOne[models.Asset](ctx, dbConn,
@@ -185,12 +304,37 @@ func Create(ctx context.Context, dbConn db.ConnOrTx, in CreateInput) (*models.As
 		FROM asset
 		WHERE id = $1
 		`,
-		id,
+		rec.ID,
 	)
 	if err != nil {
 		return nil, oops.New(err, "failed to fetch newly-created asset")
 	}
 
+	if strings.HasPrefix(asset.MimeType, "audio") {
+		if mediaPath != "" {
+			err = saveAudioInfo(ctx, dbConn, asset, mediaPath)
+			if err != nil {
+				// The background job will try again later.
+				logging.ExtractLogger(ctx).Error().Err(err).Msg("Failed to analyze audio for asset")
+			}
+		} else {
+			notifyAudioQueued()
+		}
+	} else if mediaPath != "" {
+		err = saveVideoThumbnail(ctx, dbConn, asset, mediaPath)
+		if err != nil {
+			logging.ExtractLogger(ctx).Error().Err(err).Msg("Failed to generate preview for asset")
+		}
+	}
+
+	if imageContent != nil {
+		err = GenerateVariants(ctx, dbConn, asset, imageContent)
+		if err != nil {
+			// The background job will try again later.
+			logging.ExtractLogger(ctx).Error().Err(err).Msg("Failed to generate image variants for asset")
+		}
+	}
+
 	return asset, nil
 }
 
@@ -207,7 +351,8 @@ func getFFMpegPath() string {
 	return ""
 }
 
-func ExtractPreview(ctx context.Context, mimeType string, inBytes []byte) ([]byte, int, int, error) {
+// Extracts a thumbnail from a video file on disk.
+func ExtractPreview(ctx context.Context, mimeType string, videoPath string) ([]byte, int, int, error) {
 	log := logging.ExtractLogger(ctx)
 
 	execPath := getFFMpegPath()
@@ -219,21 +364,7 @@ func ExtractPreview(ctx context.Context, mimeType string, inBytes []byte) ([]byt
 		return nil, 0, 0, nil
 	}
 
-	file, err := os.CreateTemp("", "hmnasset")
-	if err != nil {
-		return nil, 0, 0, oops.New(err, "Failed to create temp file for preview generation")
-	}
-	defer os.Remove(file.Name())
-	_, err = file.Write(inBytes)
-	if err != nil {
-		return nil, 0, 0, oops.New(err, "Failed to write to temp file for preview generation")
-	}
-	err = file.Close()
-	if err != nil {
-		return nil, 0, 0, oops.New(err, "Failed to close temp file for preview generation")
-	}
-
-	args := fmt.Sprintf("-i %s -filter_complex [0]select=gte(n\\,1)[s0] -map [s0] -c:v mjpeg -f mjpeg -vframes 1 pipe:1", file.Name())
+	args := fmt.Sprintf("-i %s -filter_complex [0]select=gte(n\\,1)[s0] -map [s0] -c:v mjpeg -f mjpeg -vframes 1 pipe:1", videoPath)
 	if config.Config.PreviewGeneration.CPULimitPath != "" {
 		args = fmt.Sprintf("-l 10 -- %s %s", execPath, args)
 		execPath = config.Config.PreviewGeneration.CPULimitPath
@@ -244,7 +375,7 @@ func ExtractPreview(ctx context.Context, mimeType string, inBytes []byte) ([]byt
 	var errorOut bytes.Buffer
 	ffmpegCmd.Stdout = &output
 	ffmpegCmd.Stderr = &errorOut
-	err = ffmpegCmd.Run()
+	err := ffmpegCmd.Run()
 	if err != nil {
 		log.Error().Str("ffmpeg output", errorOut.String()).Msg("FFMpeg returned error while generating preview thumbnail")
 		return nil, 0, 0, oops.New(err, "FFMpeg failed for preview generation")
@@ -260,6 +391,47 @@ func ExtractPreview(ctx context.Context, mimeType string, inBytes []byte) ([]byt
 	return imageBytes, cfg.Width, cfg.Height, nil
 }
 
+// Extracts a thumbnail from a video asset, uploads it, and records it on the
+// asset along with the video's dimensions.
+func saveVideoThumbnail(ctx context.Context, dbConn db.ConnOrTx, asset *models.Asset, videoPath string) error {
+	thumbBytes, width, height, err := ExtractPreview(ctx, asset.MimeType, videoPath)
+	if err != nil {
+		return err
+	} else if len(thumbBytes) == 0 {
+		return nil
+	}
+
+	keyStr := AssetKey(asset.ID.String(), fmt.Sprintf("%s_thumb.jpg", asset.ID.String()))
+	thumbnailType := "image/jpeg"
+	_, err = putObject(ctx, keyStr, thumbnailType, asset.Private, bytes.NewReader(thumbBytes))
+	if err != nil {
+		return oops.New(err, "failed to upload thumbnail for video")
+	}
+
+	_, err = dbConn.Exec(ctx,
+		`
+		UPDATE asset
+		SET
+			thumbnail_s3_key = $1,
+			width = $2,
+			height = $3
+		WHERE asset.id = $4
+		`,
+		keyStr,
+		width,
+		height,
+		asset.ID,
+	)
+	if err != nil {
+		return oops.New(err, "failed to save video thumbnail")
+	}
+	asset.ThumbnailS3Key = keyStr
+	asset.Width = width
+	asset.Height = height
+
+	return nil
+}
+
 func BackgroundPreviewGeneration(conn *pgxpool.Pool) *jobs.Job {
 	job := jobs.New("preview generation")
 	log := job.Logger
@@ -306,59 +478,18 @@ func BackgroundPreviewGeneration(conn *pgxpool.Pool) *jobs.Job {
 			ctx := logging.AttachLoggerToContext(&log, job.Ctx)
 
 			log.Debug().Msg("Generating preview")
-			assetUrl := hmnurl.BuildS3Asset(asset.S3Key)
-			resp, err := http.Get(assetUrl)
-			if err != nil || resp.StatusCode != 200 {
-				log.Error().Err(err).Msg("Failed to fetch asset file for preview generation")
-				continue
-			}
-			defer resp.Body.Close()
-			body, err := io.ReadAll(resp.Body)
+			videoPath, err := downloadAssetToFile(ctx, asset)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to read asset body for preview generation")
+				log.Error().Err(err).Msg("Failed to fetch asset file for preview generation")
 				continue
 			}
-			thumbBytes, width, height, err := ExtractPreview(ctx, asset.MimeType, body)
+			err = saveVideoThumbnail(ctx, conn, asset, videoPath)
+			os.Remove(videoPath)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to run extraction for preview generation")
+				log.Error().Err(err).Msg("Failed to generate preview")
 				continue
-			} else if len(thumbBytes) > 0 {
-				keyStr := AssetKey(asset.ID.String(), fmt.Sprintf("%s_thumb.jpg", asset.ID.String()))
-				thumbnailType := "image/jpeg"
-				_, err = client.PutObject(ctx, &s3.PutObjectInput{
-					Bucket:      &config.Config.DigitalOcean.AssetsSpacesBucket,
-					Key:         &keyStr,
-					Body:        bytes.NewReader(thumbBytes),
-					ACL:         types.ObjectCannedACLPublicRead,
-					ContentType: &thumbnailType,
-				})
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to upload thumbnail for video")
-					continue
-				}
-
-				_, err = conn.Exec(ctx,
-					`
-					UPDATE asset
-					SET
-						thumbnail_s3_key = $1,
-						width = $2,
-						height = $3
-					WHERE asset.id = $4
-					`,
-					keyStr,
-					width,
-					height,
-					asset.ID,
-				)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to update asset for preview generation")
-					continue
-				}
-				log.Debug().Msg("Generated preview successfully!")
-			} else {
-				log.Debug().Msg("No error, but no thumbnail was generated, skipping")
 			}
+			log.Debug().Msg("Generated preview successfully!")
 		}
 		log.Debug().Msg("No more previews to generate")
 	}()
@@ -1,8 +1,23 @@
 package assets
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/models"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -11,3 +26,212 @@ func TestSanitizeFilename(t *testing.T) {
 	assert.Equal(t, "__hi_doggy__", SanitizeFilename("😎 hi doggy 🐶"))
 	assert.Equal(t, "newlines_aretotallylegal", SanitizeFilename("newlines\naretotallylegal"))
 }
+
+func TestVariantKeys(t *testing.T) {
+	asset := models.Asset{
+		ID:             uuid.MustParse("b122c7be-dc6d-41fe-a5ed-033fe991927e"),
+		VariantWidths:  []int{320, 640},
+		VariantFormats: []string{"jpg", "webp"},
+	}
+	assert.Equal(t, []string{
+		"b122c7be-dc6d-41fe-a5ed-033fe991927e/b122c7be-dc6d-41fe-a5ed-033fe991927e_320w.jpg",
+		"b122c7be-dc6d-41fe-a5ed-033fe991927e/b122c7be-dc6d-41fe-a5ed-033fe991927e_640w.jpg",
+		"b122c7be-dc6d-41fe-a5ed-033fe991927e/b122c7be-dc6d-41fe-a5ed-033fe991927e_320w.webp",
+		"b122c7be-dc6d-41fe-a5ed-033fe991927e/b122c7be-dc6d-41fe-a5ed-033fe991927e_640w.webp",
+	}, VariantKeys(&asset))
+	assert.Empty(t, VariantKeys(&models.Asset{ID: asset.ID}))
+}
+
+func TestSanitizeJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+	assert.Nil(t, err)
+	original := buf.Bytes()
+
+	exif := append([]byte("Exif\x00\x00"), minimalExif(6)...)
+	app1 := []byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}
+	app1 = append(app1, exif...)
+	com := []byte{0xFF, 0xFE, 0, 8}
+	com = append(com, "SECRET"...)
+	dirty := slices.Concat(original[:2], app1, com, original[2:])
+
+	clean, err := SanitizeImage("image/jpeg", dirty)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(clean, []byte("SECRET")))
+
+	_, err = jpeg.Decode(bytes.NewReader(clean))
+	assert.Nil(t, err)
+
+	exifStart := bytes.Index(clean, []byte("Exif\x00\x00"))
+	if assert.NotEqual(t, -1, exifStart) {
+		assert.Equal(t, 6, exifOrientation(clean[exifStart+6:]))
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// A 2x1 image with a red pixel on the left and a blue one on the right.
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, red)
+	img.Set(1, 0, blue)
+
+	assert.Equal(t, image.Image(img), orientImage(img, 1))
+
+	mirrored := orientImage(img, 2)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), mirrored.Bounds())
+	assert.Equal(t, blue, mirrored.At(0, 0))
+
+	// Rotating clockwise puts the left edge on top.
+	clockwise := orientImage(img, 6)
+	assert.Equal(t, image.Rect(0, 0, 1, 2), clockwise.Bounds())
+	assert.Equal(t, red, clockwise.At(0, 0))
+	assert.Equal(t, blue, clockwise.At(0, 1))
+
+	counterclockwise := orientImage(img, 8)
+	assert.Equal(t, blue, counterclockwise.At(0, 0))
+	assert.Equal(t, red, counterclockwise.At(0, 1))
+}
+
+func TestImageOrientation(t *testing.T) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil)
+	assert.Nil(t, err)
+	original := buf.Bytes()
+	assert.Equal(t, 0, imageOrientation("image/jpeg", original))
+
+	exif := append([]byte("Exif\x00\x00"), minimalExif(8)...)
+	app1 := []byte{0xFF, 0xE1, 0, byte(len(exif) + 2)}
+	app1 = append(app1, exif...)
+	assert.Equal(t, 8, imageOrientation("image/jpeg", slices.Concat(original[:2], app1, original[2:])))
+}
+
+func TestTranscodedKey(t *testing.T) {
+	assert.Equal(t,
+		"b122c7be-dc6d-41fe-a5ed-033fe991927e/b122c7be-dc6d-41fe-a5ed-033fe991927e_low.mp4",
+		TranscodedKey("b122c7be-dc6d-41fe-a5ed-033fe991927e", "low"),
+	)
+}
+
+func TestWaveform(t *testing.T) {
+	var pcm bytes.Buffer
+	for i := 0; i < audioAnalysisWindow*3; i++ {
+		level := []int16{100, -2000, 500}[i/audioAnalysisWindow]
+		binary.Write(&pcm, binary.LittleEndian, level)
+	}
+	binary.Write(&pcm, binary.LittleEndian, int16(1000)) // A partial window at the end
+
+	peaks, numSamples, err := readPeaks(&pcm)
+	assert.Nil(t, err)
+	assert.Equal(t, audioAnalysisWindow*3+1, numSamples)
+	assert.Equal(t, []int{100, 2000, 500, 1000}, peaks)
+
+	assert.Equal(t, []byte{12, 255, 63, 127}, buildWaveform(peaks, 10))
+	assert.Equal(t, []byte{255, 127}, buildWaveform(peaks, 2))
+	assert.Equal(t, []byte{0, 0}, buildWaveform([]int{0, 0}, 10))
+	assert.Empty(t, buildWaveform(nil, 10))
+}
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	s := newLocalStorage(t.TempDir())
+
+	size, err := s.Put(ctx, "abc/hello.txt", "text/plain", false, strings.NewReader("Hello, world!"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), size)
+
+	body, err := s.Get(ctx, "abc/hello.txt")
+	if assert.Nil(t, err) {
+		content, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, "Hello, world!", string(content))
+	}
+
+	_, err = s.Get(ctx, "../abc/hello.txt")
+	assert.Nil(t, err, "keys should not be able to escape the storage directory")
+	_, err = s.Get(ctx, ".uploads/whatever")
+	assert.ErrorIs(t, err, ObjectNotFound)
+
+	uploadID, err := s.CreateMultipartUpload(ctx, "abc/big.bin", "application/octet-stream", false)
+	assert.Nil(t, err)
+	var etags []string
+	for _, part := range []string{"first ", "second"} {
+		etag, err := s.UploadPart(ctx, "abc/big.bin", uploadID, len(etags)+1, []byte(part))
+		assert.Nil(t, err)
+		etags = append(etags, etag)
+	}
+	assert.Nil(t, s.CompleteMultipartUpload(ctx, "abc/big.bin", uploadID, etags))
+	body, err = s.Get(ctx, "abc/big.bin")
+	if assert.Nil(t, err) {
+		content, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, "first second", string(content))
+	}
+	assert.NotNil(t, s.AbortMultipartUpload(ctx, "abc/big.bin", uploadID), "completed uploads should be gone")
+
+	assert.Nil(t, s.Delete(ctx, "abc/hello.txt"))
+	_, err = s.Get(ctx, "abc/hello.txt")
+	assert.ErrorIs(t, err, ObjectNotFound)
+	assert.Nil(t, s.Delete(ctx, "abc/hello.txt"))
+}
+
+func TestLocalStoragePrivate(t *testing.T) {
+	ctx := context.Background()
+	s := newLocalStorage(t.TempDir())
+
+	_, err := s.Put(ctx, "abc/secret.txt", "text/plain", true, strings.NewReader("shh"))
+	assert.Nil(t, err)
+	_, err = s.open("abc/secret.txt", false)
+	assert.ErrorIs(t, err, ObjectNotFound, "private objects should not be served publicly")
+	body, err := s.Get(ctx, "abc/secret.txt")
+	if assert.Nil(t, err) {
+		body.Close()
+	}
+
+	assert.Nil(t, s.SetPrivate(ctx, "abc/secret.txt", false))
+	file, err := s.open("abc/secret.txt", false)
+	if assert.Nil(t, err) {
+		file.Close()
+	}
+	assert.Nil(t, s.SetPrivate(ctx, "abc/secret.txt", false), "setting the same privacy twice should be fine")
+
+	assert.Nil(t, s.SetPrivate(ctx, "abc/secret.txt", true))
+	_, err = s.open("abc/secret.txt", false)
+	assert.ErrorIs(t, err, ObjectNotFound)
+
+	_, err = s.Put(ctx, "abc/secret.txt", "text/plain", false, strings.NewReader("not anymore"))
+	assert.Nil(t, err)
+	_, err = s.open("abc/secret.txt", true)
+	assert.ErrorIs(t, err, ObjectNotFound, "replacing an object should remove the copy with the old privacy")
+
+	assert.ErrorIs(t, s.SetPrivate(ctx, "abc/nope.txt", true), ObjectNotFound)
+}
+
+func TestFindAssetFileLinks(t *testing.T) {
+	id1 := uuid.MustParse("b122c7be-dc6d-41fe-a5ed-033fe991927e")
+	id2 := uuid.MustParse("0f1e2d3c-4b5a-6978-8796-a5b4c3d2e1f0")
+	text := fmt.Sprintf(
+		"![diagram](%s) and a [video](%s), but not %s",
+		hmnurl.BuildAssetFile(id1.String(), ""),
+		hmnurl.BuildAssetFile(id2.String(), "whatever/thumb.jpg"),
+		hmnurl.BuildS3Asset("b122c7be-dc6d-41fe-a5ed-033fe991927e/file.png"),
+	)
+	assert.Equal(t, []uuid.UUID{id1, id2}, FindAssetFileLinks(text))
+}
+
+func TestLocalSignature(t *testing.T) {
+	s := newLocalStorage(t.TempDir())
+	signedUrl, err := s.PresignedURL(context.Background(), "abc/secret.png", time.Minute)
+	assert.Nil(t, err)
+	parsed, err := url.Parse(signedUrl)
+	assert.Nil(t, err)
+
+	assert.True(t, CheckLocalSignature("abc/secret.png", parsed.Query()))
+	assert.False(t, CheckLocalSignature("abc/other.png", parsed.Query()))
+	assert.False(t, CheckLocalSignature("abc/secret.png", url.Values{}))
+
+	expired, err := s.PresignedURL(context.Background(), "abc/secret.png", -time.Minute)
+	assert.Nil(t, err)
+	parsed, _ = url.Parse(expired)
+	assert.False(t, CheckLocalSignature("abc/secret.png", parsed.Query()))
+}
@@ -0,0 +1,274 @@
+package assets
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/jobs"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Audio files get their duration, bitrate, and a waveform for the player to
+draw. ffmpeg decodes the file to low-rate mono PCM, which we read as it
+streams out, keeping only the loudest sample of each short window. Even a
+two-hour podcast episode only ever needs a small slice of peaks in memory.
+*/
+
+// How many bars a waveform has.
+const WaveformSamples = 200
+
+const (
+	audioAnalysisSampleRate = 8000
+	audioAnalysisWindow     = audioAnalysisSampleRate / 10
+)
+
+type AudioInfo struct {
+	Duration float64 // Seconds
+	Waveform []byte  // WaveformSamples peak levels, scaled so the loudest is 255
+}
+
+// Decodes an audio file on disk and measures it. Returns nil if ffmpeg is not
+// available.
+func AnalyzeAudio(ctx context.Context, audioPath string) (*AudioInfo, error) {
+	if getFFMpegPath() == "" {
+		return nil, nil
+	}
+
+	cmd := ffmpegCommand(ctx,
+		"-v", "error",
+		"-i", audioPath,
+		"-vn",
+		"-ac", "1",
+		"-ar", strconv.Itoa(audioAnalysisSampleRate),
+		"-acodec", "pcm_s16le",
+		"-f", "s16le",
+		"pipe:1",
+	)
+	var errorOut strings.Builder
+	cmd.Stderr = &errorOut
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, oops.New(err, "failed to get ffmpeg output")
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, oops.New(err, "failed to start ffmpeg")
+	}
+
+	peaks, numSamples, readErr := readPeaks(bufio.NewReader(stdout))
+	err = cmd.Wait()
+	if err != nil {
+		return nil, oops.New(err, "FFMpeg failed to decode audio: %s", errorOut.String())
+	} else if readErr != nil {
+		return nil, oops.New(readErr, "failed to read decoded audio")
+	}
+
+	return &AudioInfo{
+		Duration: float64(numSamples) / audioAnalysisSampleRate,
+		Waveform: buildWaveform(peaks, WaveformSamples),
+	}, nil
+}
+
+// Reads 16-bit PCM samples and returns the peak level of each window, along
+// with the total number of samples.
+func readPeaks(r io.Reader) ([]int, int, error) {
+	var peaks []int
+	numSamples := 0
+	peak := 0
+	var buf [2]byte
+	for {
+		_, err := io.ReadFull(r, buf[:])
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		sample := int(int16(binary.LittleEndian.Uint16(buf[:])))
+		peak = max(peak, sample, -sample)
+		numSamples++
+		if numSamples%audioAnalysisWindow == 0 {
+			peaks = append(peaks, peak)
+			peak = 0
+		}
+	}
+	if numSamples%audioAnalysisWindow != 0 {
+		peaks = append(peaks, peak)
+	}
+	return peaks, numSamples, nil
+}
+
+// Squeezes peaks into at most n bars, keeping the loudest peak of each, and
+// scales them so the loudest bar is 255.
+func buildWaveform(peaks []int, n int) []byte {
+	n = min(n, len(peaks))
+	bars := make([]int, n)
+	loudest := 0
+	for i := range bars {
+		start := i * len(peaks) / n
+		end := (i + 1) * len(peaks) / n
+		for _, peak := range peaks[start:end] {
+			bars[i] = max(bars[i], peak)
+		}
+		loudest = max(loudest, bars[i])
+	}
+
+	waveform := make([]byte, n)
+	if loudest == 0 {
+		return waveform
+	}
+	for i, bar := range bars {
+		waveform[i] = byte(bar * 255 / loudest)
+	}
+	return waveform
+}
+
+// Analyzes an audio asset from a copy of its file on disk and saves the results
+// on the asset. The asset is updated in place.
+func saveAudioInfo(ctx context.Context, dbConn db.ConnOrTx, asset *models.Asset, audioPath string) error {
+	info, err := AnalyzeAudio(ctx, audioPath)
+	if err != nil {
+		return err
+	} else if info == nil {
+		return nil
+	}
+
+	bitrate := 0
+	if info.Duration > 0 {
+		bitrate = int(float64(asset.Size) * 8 / info.Duration)
+	}
+
+	_, err = dbConn.Exec(ctx,
+		`
+		UPDATE asset
+		SET
+			audio_analyzed = TRUE,
+			duration = $2,
+			bitrate = $3,
+			waveform = $4
+		WHERE id = $1
+		`,
+		asset.ID,
+		info.Duration,
+		bitrate,
+		info.Waveform,
+	)
+	if err != nil {
+		return oops.New(err, "failed to save audio info")
+	}
+	asset.AudioAnalyzed = true
+	asset.Duration = info.Duration
+	asset.Bitrate = bitrate
+	asset.Waveform = info.Waveform
+
+	return nil
+}
+
+// Gives up on analyzing an asset that ffmpeg can't make sense of, so that the
+// background job doesn't keep trying.
+func markAudioAnalyzed(ctx context.Context, dbConn db.ConnOrTx, asset *models.Asset) error {
+	_, err := dbConn.Exec(ctx, `UPDATE asset SET audio_analyzed = TRUE WHERE id = $1`, asset.ID)
+	if err != nil {
+		return oops.New(err, "failed to mark audio as analyzed")
+	}
+	asset.AudioAnalyzed = true
+	return nil
+}
+
+var audioQueued = make(chan struct{}, 1)
+
+// Wakes up the audio analysis job if it is waiting for work.
+func notifyAudioQueued() {
+	select {
+	case audioQueued <- struct{}{}:
+	default:
+	}
+}
+
+func BackgroundAudioAnalysis(conn *pgxpool.Pool) *jobs.Job {
+	job := jobs.New("audio analysis")
+	log := job.Logger
+
+	go func() {
+		defer job.Finish()
+		log.Debug().Msg("Starting audio analysis job")
+
+		if getFFMpegPath() == "" {
+			log.Warn().Msg("Couldn't find ffmpeg! No audio will be analyzed.")
+			return
+		}
+
+		for {
+			assets, err := db.Query[models.Asset](job.Ctx, conn,
+				`
+				SELECT $columns
+				FROM asset
+				WHERE
+					NOT audio_analyzed
+					AND mime_type LIKE 'audio/%'
+				`,
+			)
+			if err != nil {
+				log.Error().Err(oops.New(err, "Failed to fetch assets for audio analysis")).Msg("Audio analysis job failed")
+			}
+
+			if len(assets) > 0 {
+				log.Debug().Int("Num assets", len(assets)).Msg("Processing...")
+			}
+
+			for _, asset := range assets {
+				select {
+				case <-job.Canceled():
+					return
+				default:
+				}
+
+				log := log.With().Str("AssetID", asset.ID.String()).Logger()
+				ctx := logging.AttachLoggerToContext(&log, job.Ctx)
+
+				audioPath, err := downloadAssetToFile(ctx, asset)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to fetch asset file for audio analysis")
+					continue
+				}
+				err = saveAudioInfo(ctx, conn, asset, audioPath)
+				os.Remove(audioPath)
+				if err != nil {
+					select {
+					case <-job.Canceled():
+						return
+					default:
+					}
+					log.Warn().Err(err).Msg("Failed to analyze audio")
+					err = markAudioAnalyzed(ctx, conn, asset)
+					if err != nil {
+						log.Error().Err(err).Msg("Failed to give up on audio")
+					}
+				}
+			}
+
+			// Uploads that couldn't wait for ffmpeg wake us up. Otherwise, an
+			// occasional look picks up anything that failed to download.
+			select {
+			case <-audioQueued:
+			case <-time.After(time.Hour):
+			case <-job.Canceled():
+				return
+			}
+		}
+	}()
+
+	return job
+}
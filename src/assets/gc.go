@@ -0,0 +1,371 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/jobs"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/utils"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// How long an asset must go unreferenced before garbage collection deletes
+// it. This leaves time for things like forum post drafts, where an asset is
+// uploaded well before anything in the database points at it.
+const OrphanGracePeriod = 14 * 24 * time.Hour
+
+type GCReport struct {
+	DryRun bool
+
+	NewlyOrphaned []*models.Asset // Assets that just lost their last reference
+	Rescued       []*models.Asset // Previously orphaned assets that are referenced again
+	Deleted       []*models.Asset // Assets whose grace period expired (or would have, in a dry run)
+
+	Errors []error
+}
+
+func (r *GCReport) DeletedBytes() int {
+	total := 0
+	for _, asset := range r.Deleted {
+		total += asset.Size
+	}
+	return total
+}
+
+/*
+Finds assets that nothing refers to and deletes them, along with their S3
+objects, once they have been orphaned for longer than OrphanGracePeriod.
+
+Assets are considered referenced if they are pinned, if any foreign key in the
+database points at them, or if their URL appears in user-authored Markdown. In
+a dry run, nothing is modified, and the report describes what would have
+happened.
+*/
+func CollectGarbage(ctx context.Context, conn db.ConnOrTx, dryRun bool) (GCReport, error) {
+	report := GCReport{DryRun: dryRun}
+
+	referencedCondition, err := assetReferencedCondition(ctx, conn)
+	if err != nil {
+		return report, err
+	}
+	textReferencedIDs, err := fetchTextReferencedAssetIDs(ctx, conn)
+	if err != nil {
+		return report, err
+	}
+
+	isReferenced := fmt.Sprintf("(asset.pinned OR %s OR asset.id = ANY($1))", referencedCondition)
+
+	report.NewlyOrphaned, err = db.Query[models.Asset](ctx, conn,
+		fmt.Sprintf(
+			`
+			SELECT $columns
+			FROM asset
+			WHERE asset.orphaned_at IS NULL AND NOT %s
+			`,
+			isReferenced,
+		),
+		textReferencedIDs,
+	)
+	if err != nil {
+		return report, oops.New(err, "failed to find newly orphaned assets")
+	}
+
+	report.Rescued, err = db.Query[models.Asset](ctx, conn,
+		fmt.Sprintf(
+			`
+			SELECT $columns
+			FROM asset
+			WHERE asset.orphaned_at IS NOT NULL AND %s
+			`,
+			isReferenced,
+		),
+		textReferencedIDs,
+	)
+	if err != nil {
+		return report, oops.New(err, "failed to find rescued assets")
+	}
+
+	expired, err := db.Query[models.Asset](ctx, conn,
+		fmt.Sprintf(
+			`
+			SELECT $columns
+			FROM asset
+			WHERE asset.orphaned_at < $2 AND NOT %s
+			`,
+			isReferenced,
+		),
+		textReferencedIDs,
+		time.Now().Add(-OrphanGracePeriod),
+	)
+	if err != nil {
+		return report, oops.New(err, "failed to find expired orphaned assets")
+	}
+
+	if dryRun {
+		report.Deleted = expired
+		return report, nil
+	}
+
+	_, err = conn.Exec(ctx,
+		fmt.Sprintf(`UPDATE asset SET orphaned_at = NOW() WHERE asset.orphaned_at IS NULL AND NOT %s`, isReferenced),
+		textReferencedIDs,
+	)
+	if err != nil {
+		return report, oops.New(err, "failed to mark orphaned assets")
+	}
+	_, err = conn.Exec(ctx,
+		fmt.Sprintf(`UPDATE asset SET orphaned_at = NULL WHERE asset.orphaned_at IS NOT NULL AND %s`, isReferenced),
+		textReferencedIDs,
+	)
+	if err != nil {
+		return report, oops.New(err, "failed to unmark rescued assets")
+	}
+
+	for _, asset := range expired {
+		select {
+		case <-ctx.Done():
+			return report, nil
+		default:
+		}
+
+		// Check the references again while deleting, in case something
+		// started using the asset since we looked.
+		tag, err := conn.Exec(ctx,
+			fmt.Sprintf(`DELETE FROM asset WHERE asset.id = $2 AND asset.orphaned_at IS NOT NULL AND NOT %s`, isReferenced),
+			textReferencedIDs,
+			asset.ID,
+		)
+		if err != nil {
+			report.Errors = append(report.Errors, oops.New(err, "failed to delete asset %s", asset.ID))
+			continue
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+		report.Deleted = append(report.Deleted, asset)
+
+		for _, key := range ObjectKeys(asset) {
+			err := storage.Delete(ctx, key)
+			if err != nil {
+				report.Errors = append(report.Errors, oops.New(err, "failed to delete stored object %s", key))
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// Builds a SQL condition that is true when any foreign key in the database
+// refers to the asset. Foreign keys are looked up from the database itself so
+// that new tables referencing assets are picked up automatically.
+func assetReferencedCondition(ctx context.Context, conn db.ConnOrTx) (string, error) {
+	refs, err := fetchAssetForeignKeys(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	if len(refs) == 0 {
+		return "FALSE", nil
+	}
+
+	var conditions []string
+	for _, ref := range refs {
+		table := pgx.Identifier{ref.Table}.Sanitize()
+		column := pgx.Identifier{ref.Column}.Sanitize()
+		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM %s WHERE %s.%s = asset.id)", table, table, column))
+	}
+	return "(" + strings.Join(conditions, " OR ") + ")", nil
+}
+
+type assetForeignKey struct {
+	Table  string `db:"table_name"`
+	Column string `db:"column_name"`
+}
+
+func fetchAssetForeignKeys(ctx context.Context, conn db.ConnOrTx) ([]*assetForeignKey, error) {
+	refs, err := db.Query[assetForeignKey](ctx, conn,
+		`
+		SELECT $columns
+		FROM (
+			SELECT
+				cl.relname::TEXT AS table_name,
+				att.attname::TEXT AS column_name
+			FROM
+				pg_constraint AS con
+				JOIN pg_class AS cl ON cl.oid = con.conrelid
+				JOIN pg_attribute AS att ON att.attrelid = con.conrelid AND att.attnum = ANY(con.conkey)
+			WHERE
+				con.contype = 'f'
+				AND con.confrelid = 'asset'::regclass
+		) AS refs
+		ORDER BY table_name, column_name
+		`,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to find foreign keys referencing assets")
+	}
+	return refs, nil
+}
+
+// Markdown written on the site can link to assets directly without any
+// foreign key to show for it. Each of these queries finds text containing $1.
+var textReferenceSources = []string{
+	`SELECT text_raw FROM post_version WHERE text_raw LIKE '%' || $1 || '%'`,
+	`SELECT content_raw FROM education_article_version WHERE content_raw LIKE '%' || $1 || '%'`,
+	`SELECT description FROM project WHERE description LIKE '%' || $1 || '%'`,
+	`SELECT bio FROM hmn_user WHERE bio LIKE '%' || $1 || '%'`,
+	`SELECT signature FROM hmn_user WHERE signature LIKE '%' || $1 || '%'`,
+	`SELECT description FROM snippet WHERE description LIKE '%' || $1 || '%'`,
+	`SELECT description FROM podcast WHERE description LIKE '%' || $1 || '%'`,
+	`SELECT description FROM podcast_episode WHERE description LIKE '%' || $1 || '%'`,
+	`SELECT content FROM fishbowl_message WHERE content LIKE '%' || $1 || '%'`,
+}
+
+// Forum posts track text references in post_asset_usage, but only for their
+// current version, so scan everything ourselves.
+func fetchTextReferencedAssetIDs(ctx context.Context, conn db.ConnOrTx) ([]uuid.UUID, error) {
+	sources := textReferenceSources
+
+	keyIdx := hmnurl.RegexS3Asset.SubexpIndex("key")
+	var keys []string
+	for _, source := range sources {
+		texts, err := db.QueryScalar[string](ctx, conn, source, hmnurl.S3BaseUrl)
+		if err != nil {
+			return nil, oops.New(err, "failed to fetch text that may reference assets")
+		}
+		for _, text := range texts {
+			for _, match := range hmnurl.RegexS3Asset.FindAllStringSubmatch(text, -1) {
+				keys = append(keys, match[keyIdx])
+			}
+		}
+	}
+
+	// Private assets are linked through the website by ID instead.
+	var fileIDs []uuid.UUID
+	for _, source := range sources {
+		texts, err := db.QueryScalar[string](ctx, conn, source, hmnurl.BuildAssetFile("", ""))
+		if err != nil {
+			return nil, oops.New(err, "failed to fetch text that may reference assets")
+		}
+		for _, text := range texts {
+			fileIDs = append(fileIDs, FindAssetFileLinks(text)...)
+		}
+	}
+
+	ids, err := db.QueryScalar[uuid.UUID](ctx, conn,
+		`
+		SELECT id
+		FROM asset
+		WHERE s3_key = ANY($1) OR id = ANY($2)
+		`,
+		keys,
+		fileIDs,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to get assets matching keys")
+	}
+	return ids, nil
+}
+
+// Finds the IDs of assets linked from text through BuildAssetFile URLs, which
+// is how private assets are linked.
+func FindAssetFileLinks(text string) []uuid.UUID {
+	reAssetFile := regexp.MustCompile(regexp.QuoteMeta(hmnurl.BuildAssetFile("", "")) + `([0-9a-f-]{36})`)
+	var ids []uuid.UUID
+	for _, match := range reAssetFile.FindAllStringSubmatch(text, -1) {
+		if id, err := uuid.Parse(match[1]); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Counts everything that refers to an asset: rows with a foreign key to it, and
+// pieces of text that link to it. Pinning counts as a reference.
+func CountReferences(ctx context.Context, conn db.ConnOrTx, asset *models.Asset) (int, error) {
+	refs, err := fetchAssetForeignKeys(ctx, conn)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	if asset.Pinned {
+		total += 1
+	}
+	for _, ref := range refs {
+		table := pgx.Identifier{ref.Table}.Sanitize()
+		column := pgx.Identifier{ref.Column}.Sanitize()
+		count, err := db.QueryOneScalar[int](ctx, conn,
+			fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s.%s = $1`, table, table, column),
+			asset.ID,
+		)
+		if err != nil {
+			return 0, oops.New(err, "failed to count references to asset")
+		}
+		total += count
+	}
+
+	for _, source := range textReferenceSources {
+		for _, link := range []string{asset.S3Key, hmnurl.BuildAssetFile(asset.ID.String(), "")} {
+			count, err := db.QueryOneScalar[int](ctx, conn,
+				fmt.Sprintf(`SELECT COUNT(*) FROM (%s) AS refs`, source),
+				link,
+			)
+			if err != nil {
+				return 0, oops.New(err, "failed to count text references to asset")
+			}
+			total += count
+		}
+	}
+
+	return total, nil
+}
+
+// Runs garbage collection once a day. In a dry run, the job only logs what it
+// would have done.
+func PeriodicallyCollectGarbage(conn *pgxpool.Pool, dryRun bool) *jobs.Job {
+	job := jobs.New("asset garbage collection")
+	go func() {
+		defer job.Finish()
+
+		t := time.NewTicker(24 * time.Hour)
+		for {
+			select {
+			case <-t.C:
+				err := func() (err error) {
+					defer utils.RecoverPanicAsError(&err)
+
+					report, err := CollectGarbage(job.Ctx, conn, dryRun)
+					if err != nil {
+						return err
+					}
+					for _, err := range report.Errors {
+						job.Logger.Error().Err(err).Msg("Error during asset garbage collection")
+					}
+					job.Logger.Info().
+						Bool("dry run", report.DryRun).
+						Int("newly orphaned", len(report.NewlyOrphaned)).
+						Int("rescued", len(report.Rescued)).
+						Int("deleted", len(report.Deleted)).
+						Int("deleted bytes", report.DeletedBytes()).
+						Msg("Collected asset garbage")
+					return nil
+				}()
+				if err != nil {
+					job.Logger.Error().Err(err).Msg("Asset garbage collection failed")
+				}
+			case <-job.Canceled():
+				return
+			}
+		}
+	}()
+	return job
+}
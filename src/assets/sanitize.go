@@ -0,0 +1,439 @@
+package assets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"slices"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+)
+
+/*
+Image metadata can contain all sorts of things people don't mean to share, like
+GPS coordinates and camera serial numbers. We strip it from uploaded images
+without re-encoding them, so there is no loss in quality. The only metadata
+we keep is orientation, since without it phone photos show up sideways, and
+things needed for correct colors (ICC profiles and the like).
+*/
+
+var sanitizableTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+}
+
+func CanSanitize(mimeType string) bool {
+	return slices.Contains(sanitizableTypes, mimeType)
+}
+
+// Removes privacy-sensitive metadata from an image. Returns the content
+// unchanged if the type is not one we know how to sanitize.
+func SanitizeImage(mimeType string, content []byte) ([]byte, error) {
+	switch mimeType {
+	case "image/jpeg":
+		return sanitizeJPEG(content)
+	case "image/png":
+		return sanitizePNG(content)
+	case "image/webp":
+		return sanitizeWebP(content)
+	default:
+		return content, nil
+	}
+}
+
+const (
+	jpegSOI   = 0xD8
+	jpegEOI   = 0xD9
+	jpegSOS   = 0xDA
+	jpegAPP0  = 0xE0
+	jpegAPP1  = 0xE1
+	jpegAPP2  = 0xE2
+	jpegAPP14 = 0xEE
+	jpegAPP15 = 0xEF
+	jpegCOM   = 0xFE
+)
+
+var jpegExifHeader = []byte("Exif\x00\x00")
+
+func sanitizeJPEG(content []byte) ([]byte, error) {
+	if len(content) < 2 || content[0] != 0xFF || content[1] != jpegSOI {
+		return nil, oops.New(nil, "not a JPEG file")
+	}
+
+	var out bytes.Buffer
+	out.Write(content[:2])
+	orientation := 0
+	exifPos := out.Len()
+
+	i := 2
+	for i < len(content) {
+		if content[i] != 0xFF {
+			return nil, oops.New(nil, "invalid JPEG marker at offset %d", i)
+		}
+		// Markers may be preceded by any number of fill bytes.
+		for i < len(content) && content[i] == 0xFF {
+			i++
+		}
+		if i >= len(content) {
+			break
+		}
+		marker := content[i]
+		i++
+
+		if marker == jpegSOS {
+			// Everything from here on is image data. Copy the rest verbatim.
+			out.WriteByte(0xFF)
+			out.WriteByte(marker)
+			out.Write(content[i:])
+			break
+		}
+		if marker == jpegEOI || marker == 0x01 || (0xD0 <= marker && marker <= 0xD7) {
+			out.WriteByte(0xFF)
+			out.WriteByte(marker)
+			continue
+		}
+
+		if i+2 > len(content) {
+			return nil, oops.New(nil, "truncated JPEG segment")
+		}
+		length := int(binary.BigEndian.Uint16(content[i:]))
+		if length < 2 || i+length > len(content) {
+			return nil, oops.New(nil, "invalid JPEG segment length")
+		}
+		segment := content[i-2 : i+length]
+		payload := content[i+2 : i+length]
+		i += length
+
+		keep := true
+		switch {
+		case marker == jpegAPP1:
+			if bytes.HasPrefix(payload, jpegExifHeader) {
+				orientation = exifOrientation(payload[len(jpegExifHeader):])
+			}
+			keep = false
+		case marker == jpegCOM:
+			keep = false
+		case jpegAPP0 <= marker && marker <= jpegAPP15:
+			// JFIF, ICC profiles, and Adobe color transforms all affect how
+			// the image looks. Other application segments are vendor
+			// metadata.
+			keep = marker == jpegAPP0 || marker == jpegAPP2 || marker == jpegAPP14
+		}
+		if keep {
+			out.Write(segment)
+			if marker == jpegAPP0 && exifPos == 2 {
+				exifPos = out.Len()
+			}
+		}
+	}
+
+	result := out.Bytes()
+	if orientation > 1 {
+		exif := append(slices.Clone(jpegExifHeader), minimalExif(orientation)...)
+		segment := []byte{0xFF, jpegAPP1, 0, 0}
+		binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+		segment = append(segment, exif...)
+		result = slices.Insert(result, exifPos, segment...)
+	}
+
+	return result, nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+var pngMetadataChunks = []string{"tEXt", "zTXt", "iTXt", "tIME", "eXIf"}
+
+func sanitizePNG(content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, pngSignature) {
+		return nil, oops.New(nil, "not a PNG file")
+	}
+
+	var out bytes.Buffer
+	out.Write(pngSignature)
+
+	i := len(pngSignature)
+	for i < len(content) {
+		if i+8 > len(content) {
+			return nil, oops.New(nil, "truncated PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(content[i:]))
+		chunkType := string(content[i+4 : i+8])
+		end := i + 8 + length + 4
+		if end > len(content) {
+			return nil, oops.New(nil, "invalid PNG chunk length")
+		}
+		data := content[i+8 : i+8+length]
+		chunk := content[i:end]
+		i = end
+
+		if chunkType == "eXIf" {
+			if orientation := exifOrientation(data); orientation > 1 {
+				writePNGChunk(&out, "eXIf", minimalExif(orientation))
+			}
+			continue
+		}
+		if slices.Contains(pngMetadataChunks, chunkType) {
+			continue
+		}
+		out.Write(chunk)
+	}
+
+	return out.Bytes(), nil
+}
+
+func writePNGChunk(out *bytes.Buffer, chunkType string, data []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(data)))
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	out.WriteString(chunkType)
+	out.Write(data)
+	binary.Write(out, binary.BigEndian, crc.Sum32())
+}
+
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+func sanitizeWebP(content []byte) ([]byte, error) {
+	if len(content) < 12 || string(content[0:4]) != "RIFF" || string(content[8:12]) != "WEBP" {
+		return nil, oops.New(nil, "not a WebP file")
+	}
+
+	var out bytes.Buffer
+	out.Write(content[:12])
+	vp8xFlagsPos := -1
+	orientation := 0
+
+	i := 12
+	for i+8 <= len(content) {
+		fourCC := string(content[i : i+4])
+		length := int(binary.LittleEndian.Uint32(content[i+4:]))
+		end := i + 8 + length + length%2
+		if end > len(content) {
+			// Some encoders leave off the final padding byte.
+			if i+8+length == len(content) {
+				end = len(content)
+			} else {
+				return nil, oops.New(nil, "invalid WebP chunk length")
+			}
+		}
+		data := content[i+8 : i+8+length]
+		chunk := content[i:end]
+		i = end
+
+		switch fourCC {
+		case "EXIF":
+			// Some encoders include the JPEG-style header here, even though
+			// they shouldn't.
+			orientation = exifOrientation(bytes.TrimPrefix(data, jpegExifHeader))
+		case "XMP ":
+		default:
+			if fourCC == "VP8X" {
+				vp8xFlagsPos = out.Len() + 8
+			}
+			out.Write(chunk)
+		}
+	}
+
+	result := out.Bytes()
+	if vp8xFlagsPos >= 0 && vp8xFlagsPos < len(result) {
+		result[vp8xFlagsPos] &^= webpFlagXMP | webpFlagEXIF
+		if orientation > 1 {
+			// EXIF chunks go at the end, after the image data.
+			exif := minimalExif(orientation)
+			chunk := []byte("EXIF")
+			chunk = binary.LittleEndian.AppendUint32(chunk, uint32(len(exif)))
+			chunk = append(chunk, exif...)
+			if len(exif)%2 == 1 {
+				chunk = append(chunk, 0)
+			}
+			result = append(result, chunk...)
+			result[vp8xFlagsPos] |= webpFlagEXIF
+		}
+	}
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+
+	return result, nil
+}
+
+const exifTagOrientation = 0x0112
+
+// Reads the orientation tag out of TIFF-formatted EXIF data. Returns 0 if
+// there is no orientation.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:]))
+	if ifdOffset+2 > len(tiff) {
+		return 0
+	}
+	numEntries := int(order.Uint16(tiff[ifdOffset:]))
+	for e := 0; e < numEntries; e++ {
+		entry := ifdOffset + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == exifTagOrientation {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 0
+			}
+			return orientation
+		}
+	}
+
+	return 0
+}
+
+// Finds the EXIF orientation of an image file, without decoding it. Returns 0
+// if the image has no orientation or is of a type we don't know how to read.
+func imageOrientation(mimeType string, content []byte) int {
+	switch mimeType {
+	case "image/jpeg":
+		i := 2
+		for i+4 <= len(content) && content[i] == 0xFF {
+			marker := content[i+1]
+			if marker == jpegSOS || marker == jpegEOI {
+				break
+			}
+			length := int(binary.BigEndian.Uint16(content[i+2:]))
+			if length < 2 || i+2+length > len(content) {
+				break
+			}
+			payload := content[i+4 : i+2+length]
+			if marker == jpegAPP1 && bytes.HasPrefix(payload, jpegExifHeader) {
+				return exifOrientation(payload[len(jpegExifHeader):])
+			}
+			i += 2 + length
+		}
+	case "image/png":
+		i := len(pngSignature)
+		for i+8 <= len(content) {
+			length := int(binary.BigEndian.Uint32(content[i:]))
+			if i+8+length > len(content) {
+				break
+			}
+			if string(content[i+4:i+8]) == "eXIf" {
+				return exifOrientation(content[i+8 : i+8+length])
+			}
+			i += 8 + length + 4
+		}
+	case "image/webp":
+		i := 12
+		for i+8 <= len(content) {
+			length := int(binary.LittleEndian.Uint32(content[i+4:]))
+			if i+8+length > len(content) {
+				break
+			}
+			if string(content[i:i+4]) == "EXIF" {
+				return exifOrientation(bytes.TrimPrefix(content[i+8:i+8+length], jpegExifHeader))
+			}
+			i += 8 + length + length%2
+		}
+	case "image/tiff":
+		// A TIFF file's first IFD is where EXIF would put the orientation.
+		return exifOrientation(content)
+	}
+	return 0
+}
+
+// Builds TIFF-formatted EXIF data containing only an orientation tag.
+func minimalExif(orientation int) []byte {
+	var tiff []byte
+	tiff = append(tiff, "MM"...)
+	tiff = binary.BigEndian.AppendUint16(tiff, 42)
+	tiff = binary.BigEndian.AppendUint32(tiff, 8) // offset of IFD0
+
+	tiff = binary.BigEndian.AppendUint16(tiff, 1) // number of entries
+	tiff = binary.BigEndian.AppendUint16(tiff, exifTagOrientation)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.BigEndian.AppendUint32(tiff, 1) // count
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = binary.BigEndian.AppendUint16(tiff, 0) // padding
+	tiff = binary.BigEndian.AppendUint32(tiff, 0) // no next IFD
+
+	return tiff
+}
+
+/*
+Strips metadata from an asset that was uploaded before we did that on upload.
+The file is replaced in place, keeping its key, so existing links keep working.
+Returns whether the file had anything to strip. In a dry run, the file is
+checked but nothing is changed.
+*/
+func SanitizeExistingAsset(ctx context.Context, dbConn db.ConnOrTx, asset *models.Asset, dryRun bool) (bool, error) {
+	if !CanSanitize(asset.MimeType) {
+		return false, nil
+	}
+
+	content, err := fetchAssetContent(ctx, asset)
+	if err != nil {
+		return false, err
+	}
+
+	_, changed, err := sanitizeStoredAsset(ctx, dbConn, asset, content, dryRun)
+	return changed, err
+}
+
+// Strips metadata from the content of an asset already in S3, replacing the
+// stored file if anything changed. Returns the sanitized content.
+func sanitizeStoredAsset(ctx context.Context, dbConn db.ConnOrTx, asset *models.Asset, content []byte, dryRun bool) ([]byte, bool, error) {
+	clean, err := SanitizeImage(asset.MimeType, content)
+	if err != nil {
+		return nil, false, err
+	}
+	changed := !bytes.Equal(clean, content)
+	if dryRun {
+		return clean, changed, nil
+	}
+
+	if changed {
+		_, err = putObject(ctx, asset.S3Key, asset.MimeType, asset.Private, bytes.NewReader(clean))
+		if err != nil {
+			return nil, false, oops.New(err, "failed to upload sanitized asset")
+		}
+	}
+
+	checksum := fmt.Sprintf("%x", sha1.Sum(clean))
+	_, err = dbConn.Exec(ctx,
+		`
+		UPDATE asset
+		SET
+			sanitized = TRUE,
+			size = $2,
+			sha1sum = $3
+		WHERE id = $1
+		`,
+		asset.ID,
+		len(clean),
+		checksum,
+	)
+	if err != nil {
+		return nil, false, oops.New(err, "failed to mark asset as sanitized")
+	}
+	asset.Sanitized = true
+	asset.Size = len(clean)
+	asset.Sha1Sum = checksum
+
+	return clean, changed, nil
+}
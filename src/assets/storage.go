@@ -0,0 +1,219 @@
+package assets
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+)
+
+/*
+Asset files are kept in a storage backend, which is S3 (really DigitalOcean
+Spaces) in production. Setups that don't want to run S3 at all, like small
+mirrors and CI, can keep files on local disk instead, in which case the
+website serves them itself.
+
+Objects are addressed by key, which is what asset.s3_key and friends hold no
+matter which backend is in use.
+*/
+
+type Storage interface {
+	// Stores an object, streaming it from r. Returns the number of bytes stored.
+	// Private objects can only be fetched through presigned URLs.
+	Put(ctx context.Context, key, contentType string, private bool, r io.Reader) (int64, error)
+	// Opens an object for reading. Returns ObjectNotFound if there is no such
+	// object.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Deletes an object. Deleting an object that doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// Makes an existing object private or public.
+	SetPrivate(ctx context.Context, key string, private bool) error
+
+	PublicURL(key string) string
+	// Builds a URL that allows anyone to fetch the object until it expires.
+	PresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+
+	// Multipart uploads store an object one part at a time, in order. Parts
+	// must be at least uploadPartSize bytes, except for the last one.
+	CreateMultipartUpload(ctx context.Context, key, contentType string, private bool) (string, error)
+	UploadPart(ctx context.Context, key, uploadID string, partNumber int, content []byte) (string, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+var ObjectNotFound = errors.New("object not found")
+
+var storage Storage
+
+func init() {
+	switch config.Config.AssetStorage.Backend {
+	case config.AssetStorageLocal:
+		storage = newLocalStorage(config.Config.AssetStorage.LocalDir)
+	case config.AssetStorageS3, "":
+		storage = newS3Storage()
+	default:
+		panic(oops.New(nil, "unknown asset storage backend '%s'", config.Config.AssetStorage.Backend))
+	}
+}
+
+// Builds a short-lived URL for an object that works even if the object isn't
+// public.
+func PresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	url, err := storage.PresignedURL(ctx, key, expiry)
+	if err != nil {
+		return "", oops.New(err, "failed to presign asset URL")
+	}
+	return url, nil
+}
+
+const uploadPartSize = 8 * 1024 * 1024
+
+func putObject(ctx context.Context, key, contentType string, private bool, r io.Reader) (int64, error) {
+	size, err := storage.Put(ctx, key, contentType, private, r)
+	if err != nil {
+		return 0, oops.New(err, "failed to upload asset")
+	}
+	return size, nil
+}
+
+func createMultipartUpload(ctx context.Context, key, contentType string, private bool) (string, error) {
+	uploadID, err := storage.CreateMultipartUpload(ctx, key, contentType, private)
+	if err != nil {
+		return "", oops.New(err, "failed to start multipart upload")
+	}
+	return uploadID, nil
+}
+
+func uploadPart(ctx context.Context, key, uploadID string, partNumber int, content []byte) (string, error) {
+	etag, err := storage.UploadPart(ctx, key, uploadID, partNumber, content)
+	if err != nil {
+		return "", oops.New(err, "failed to upload part %d", partNumber)
+	}
+	return etag, nil
+}
+
+func completeMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	err := storage.CompleteMultipartUpload(ctx, key, uploadID, etags)
+	if err != nil {
+		return oops.New(err, "failed to complete multipart upload")
+	}
+	return nil
+}
+
+func abortMultipartUpload(ctx context.Context, key, uploadID string) {
+	err := storage.AbortMultipartUpload(ctx, key, uploadID)
+	if err != nil {
+		logging.ExtractLogger(ctx).Error().Err(err).Str("key", key).Msg("Failed to abort multipart upload")
+	}
+}
+
+func setObjectsPrivate(ctx context.Context, private bool, keys ...string) error {
+	for _, key := range keys {
+		err := storage.SetPrivate(ctx, key, private)
+		if err != nil && !errors.Is(err, ObjectNotFound) {
+			return oops.New(err, "failed to change privacy of stored object %s", key)
+		}
+	}
+	return nil
+}
+
+func deleteObjects(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		err := storage.Delete(ctx, key)
+		if err != nil {
+			logging.ExtractLogger(ctx).Error().Err(err).Str("key", key).Msg("Failed to delete stored object")
+		}
+	}
+}
+
+// Lists the keys of every stored object that belongs to an asset: the file
+// itself, its thumbnail, transcodes, and image variants.
+func ObjectKeys(asset *models.Asset) []string {
+	keys := []string{asset.S3Key}
+	if asset.ThumbnailS3Key != "" {
+		keys = append(keys, asset.ThumbnailS3Key)
+	}
+	if asset.TranscodedS3Key != "" {
+		keys = append(keys, asset.TranscodedS3Key)
+	}
+	if asset.TranscodedLowS3Key != "" {
+		keys = append(keys, asset.TranscodedLowS3Key)
+	}
+	keys = append(keys, VariantKeys(asset)...)
+	return keys
+}
+
+// Makes an asset and all its stored objects private or public. The asset is
+// updated in place.
+func SetAssetPrivate(ctx context.Context, dbConn db.ConnOrTx, asset *models.Asset, private bool) error {
+	if asset.Private == private {
+		return nil
+	}
+
+	// Hide the files before marking the asset private, and mark the asset
+	// public before exposing the files, so a failure partway never leaves a
+	// private asset's files public.
+	if private {
+		err := setObjectsPrivate(ctx, true, ObjectKeys(asset)...)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := dbConn.Exec(ctx, `UPDATE asset SET private = $2 WHERE id = $1`, asset.ID, private)
+	if err != nil {
+		return oops.New(err, "failed to update asset privacy")
+	}
+	if !private {
+		err := setObjectsPrivate(ctx, false, ObjectKeys(asset)...)
+		if err != nil {
+			return err
+		}
+	}
+
+	asset.Private = private
+	return nil
+}
+
+// Downloads an asset's file into memory. Only use this for files known to be
+// reasonably small, like images.
+func fetchAssetContent(ctx context.Context, asset *models.Asset) ([]byte, error) {
+	body, err := storage.Get(ctx, asset.S3Key)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch asset")
+	}
+	defer body.Close()
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, oops.New(err, "failed to read asset")
+	}
+	return content, nil
+}
+
+// Downloads an asset's file to a temp file. The caller must remove the file
+// when done with it.
+func downloadAssetToFile(ctx context.Context, asset *models.Asset) (string, error) {
+	body, err := storage.Get(ctx, asset.S3Key)
+	if err != nil {
+		return "", oops.New(err, "failed to fetch asset")
+	}
+	defer body.Close()
+
+	file, err := os.CreateTemp("", "hmnasset")
+	if err != nil {
+		return "", oops.New(err, "failed to create temp file for asset")
+	}
+	defer file.Close()
+	_, err = io.Copy(file, body)
+	if err != nil {
+		os.Remove(file.Name())
+		return "", oops.New(err, "failed to download asset")
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,323 @@
+package assets
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"github.com/google/uuid"
+)
+
+/*
+Local storage keeps objects in a directory on disk, at <dir>/<key>, and the
+website serves them through LocalAsset. Private objects live at
+<dir>/.private/<key> instead, and are only served with a valid signature.
+Multipart uploads collect their parts in <dir>/.uploads until they are
+completed.
+
+Presigned URLs are signed with a key that is generated when the server starts,
+so they stop working after a restart. They are short-lived anyway.
+*/
+
+type localStorage struct {
+	dir string
+}
+
+var _ Storage = &localStorage{}
+
+var localSigningKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+func newLocalStorage(dir string) *localStorage {
+	if dir == "" {
+		dir = "./tmp/assets"
+	}
+	return &localStorage{dir: dir}
+}
+
+// Maps a key to a path inside the storage directory. Keys can't escape the
+// directory or reach the hidden bookkeeping directories.
+func (s *localStorage) path(key string, private bool) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || strings.HasPrefix(cleaned, "/.") {
+		return "", ObjectNotFound
+	}
+	root := s.dir
+	if private {
+		root = filepath.Join(s.dir, ".private")
+	}
+	return filepath.Join(root, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *localStorage) Put(ctx context.Context, key, contentType string, private bool, r io.Reader) (int64, error) {
+	dest, err := s.path(key, private)
+	if err != nil {
+		return 0, fmt.Errorf("invalid key '%s'", key)
+	}
+
+	tmp, err := s.tempFile()
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	tmp.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.commit(tmp.Name(), dest, s.otherPath(key, private))
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (s *localStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := s.open(key, false)
+	if errors.Is(err, ObjectNotFound) {
+		return s.open(key, true)
+	}
+	return file, err
+}
+
+func (s *localStorage) open(key string, private bool) (*os.File, error) {
+	p, err := s.path(key, private)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ObjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, key string) error {
+	for _, private := range []bool{false, true} {
+		p, err := s.path(key, private)
+		if err != nil {
+			return nil
+		}
+		err = os.Remove(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *localStorage) SetPrivate(ctx context.Context, key string, private bool) error {
+	dest, err := s.path(key, private)
+	if err != nil {
+		return err
+	}
+	src := s.otherPath(key, private)
+	if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(dest); err == nil {
+			return nil // Already where it should be
+		}
+		return ObjectNotFound
+	}
+	return s.commit(src, dest, "")
+}
+
+// The path an object would have if its privacy were the opposite.
+func (s *localStorage) otherPath(key string, private bool) string {
+	p, _ := s.path(key, !private)
+	return p
+}
+
+func (s *localStorage) PublicURL(key string) string {
+	return hmnurl.BuildLocalAsset(key)
+}
+
+func (s *localStorage) PresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	q := url.Values{}
+	q.Set("expires", expires)
+	q.Set("signature", localSignature(key, expires))
+	return fmt.Sprintf("%s?%s", hmnurl.BuildLocalAsset(key), q.Encode()), nil
+}
+
+func (s *localStorage) CreateMultipartUpload(ctx context.Context, key, contentType string, private bool) (string, error) {
+	if _, err := s.path(key, private); err != nil {
+		return "", fmt.Errorf("invalid key '%s'", key)
+	}
+	uploadID := uuid.New().String()
+	err := os.MkdirAll(s.uploadDir(uploadID), fs.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	if private {
+		err = os.WriteFile(filepath.Join(s.uploadDir(uploadID), "private"), nil, 0o644)
+		if err != nil {
+			return "", err
+		}
+	}
+	return uploadID, nil
+}
+
+func (s *localStorage) UploadPart(ctx context.Context, key, uploadID string, partNumber int, content []byte) (string, error) {
+	dir, err := s.existingUploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(filepath.Join(dir, strconv.Itoa(partNumber)), content, 0o644)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`"%x"`, md5.Sum(content)), nil
+}
+
+func (s *localStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	dir, err := s.existingUploadDir(uploadID)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(filepath.Join(dir, "private"))
+	private := err == nil
+	dest, err := s.path(key, private)
+	if err != nil {
+		return fmt.Errorf("invalid key '%s'", key)
+	}
+
+	tmp, err := s.tempFile()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	for i, etag := range etags {
+		part, err := os.ReadFile(filepath.Join(dir, strconv.Itoa(i+1)))
+		if err != nil {
+			return fmt.Errorf("failed to read part %d: %w", i+1, err)
+		}
+		if fmt.Sprintf(`"%x"`, md5.Sum(part)) != etag {
+			return fmt.Errorf("part %d does not match its ETag", i+1)
+		}
+		_, err = tmp.Write(part)
+		if err != nil {
+			return err
+		}
+	}
+	tmp.Close()
+
+	err = s.commit(tmp.Name(), dest, s.otherPath(key, private))
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (s *localStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	dir, err := s.existingUploadDir(uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (s *localStorage) uploadDir(uploadID string) string {
+	return filepath.Join(s.dir, ".uploads", uploadID)
+}
+
+func (s *localStorage) existingUploadDir(uploadID string) (string, error) {
+	if uuid.Validate(uploadID) != nil {
+		return "", fmt.Errorf("no multipart upload with id '%s'", uploadID)
+	}
+	dir := s.uploadDir(uploadID)
+	if _, err := os.Stat(dir); err != nil {
+		return "", fmt.Errorf("no multipart upload with id '%s'", uploadID)
+	}
+	return dir, nil
+}
+
+func (s *localStorage) tempFile() (*os.File, error) {
+	tmpDir := filepath.Join(s.dir, ".tmp")
+	err := os.MkdirAll(tmpDir, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(tmpDir, "object*")
+}
+
+// Moves a finished file into place, so that nobody ever reads half of one.
+// Any copy at stale, left over from before a change in privacy, is removed.
+func (s *localStorage) commit(tmpPath, dest, stale string) error {
+	err := os.MkdirAll(filepath.Dir(dest), fs.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, dest)
+	if err != nil {
+		return err
+	}
+	if stale != "" {
+		err = os.Remove(stale)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func localSignature(key, expires string) string {
+	mac := hmac.New(sha256.New, localSigningKey)
+	fmt.Fprintf(mac, "%s\n%s", key, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Opens a locally stored object so the website can serve it. Private objects
+// are only found if allowPrivate is set, which it should only be for requests
+// with a valid signature. Returns ObjectNotFound if assets aren't stored
+// locally or there is no such object.
+func OpenLocalObject(key string, allowPrivate bool) (*os.File, error) {
+	local, ok := storage.(*localStorage)
+	if !ok {
+		return nil, ObjectNotFound
+	}
+	file, err := local.open(key, false)
+	if errors.Is(err, ObjectNotFound) && allowPrivate {
+		return local.open(key, true)
+	}
+	return file, err
+}
+
+// Checks the signature on a presigned local asset URL. Returns false if the
+// URL is not signed, is signed wrong, or has expired.
+func CheckLocalSignature(key string, query url.Values) bool {
+	expires := query.Get("expires")
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if expires == "" || err != nil {
+		return false
+	}
+	expected, _ := hex.DecodeString(localSignature(key, expires))
+	if !hmac.Equal(signature, expected) {
+		return false
+	}
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	return err == nil && time.Now().Unix() <= expiresUnix
+}
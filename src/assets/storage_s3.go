@@ -0,0 +1,244 @@
+package assets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+type s3Storage struct {
+	client *s3.Client
+	bucket string
+}
+
+var _ Storage = &s3Storage{}
+
+func newS3Storage() *s3Storage {
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(),
+		awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(
+				config.Config.DigitalOcean.AssetsSpacesKey,
+				config.Config.DigitalOcean.AssetsSpacesSecret,
+				"",
+			),
+		),
+		awsconfig.WithRegion(config.Config.DigitalOcean.AssetsSpacesRegion),
+		awsconfig.WithEndpointResolver(aws.EndpointResolverFunc(func(service, region string) (aws.Endpoint, error) {
+			return aws.Endpoint{
+				URL: config.Config.DigitalOcean.AssetsSpacesEndpoint,
+			}, nil
+		})),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return &s3Storage{
+		client: s3.NewFromConfig(cfg, func(o *s3.Options) {
+			o.UsePathStyle = true
+		}),
+		bucket: config.Config.DigitalOcean.AssetsSpacesBucket,
+	}
+}
+
+// Streams an object to S3, splitting it into a multipart upload if it is too
+// big for one part. Only one part is held in memory at a time, and small
+// objects only take as much memory as they need.
+func (s *s3Storage) Put(ctx context.Context, key, contentType string, private bool, r io.Reader) (int64, error) {
+	// ReadAll grows the buffer as it goes, so most uploads, which are much
+	// smaller than a part, don't pay for a whole one.
+	buf, err := io.ReadAll(io.LimitReader(r, uploadPartSize))
+	if err != nil {
+		return 0, err
+	}
+	n := len(buf)
+	if n < uploadPartSize {
+		err = s.withBucket(ctx, func() error {
+			_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:      &s.bucket,
+				Key:         &key,
+				Body:        bytes.NewReader(buf),
+				ACL:         objectACL(private),
+				ContentType: &contentType,
+			})
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		return int64(n), nil
+	}
+
+	uploadID, err := s.CreateMultipartUpload(ctx, key, contentType, private)
+	if err != nil {
+		return 0, err
+	}
+
+	var etags []string
+	var size int64
+	for n > 0 {
+		etag, err := s.UploadPart(ctx, key, uploadID, len(etags)+1, buf[:n])
+		if err != nil {
+			s.AbortMultipartUpload(ctx, key, uploadID)
+			return 0, err
+		}
+		etags = append(etags, etag)
+		size += int64(n)
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			s.AbortMultipartUpload(ctx, key, uploadID)
+			return 0, err
+		}
+	}
+
+	err = s.CompleteMultipartUpload(ctx, key, uploadID, etags)
+	if err != nil {
+		s.AbortMultipartUpload(ctx, key, uploadID)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func (s *s3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ObjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	return err
+}
+
+func (s *s3Storage) SetPrivate(ctx context.Context, key string, private bool) error {
+	_, err := s.client.PutObjectAcl(ctx, &s3.PutObjectAclInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+		ACL:    objectACL(private),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return ObjectNotFound
+	}
+	return err
+}
+
+func (s *s3Storage) PublicURL(key string) string {
+	return hmnurl.BuildS3Asset(key)
+}
+
+func (s *s3Storage) PresignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	req, err := s3.NewPresignClient(s.client).PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+func (s *s3Storage) CreateMultipartUpload(ctx context.Context, key, contentType string, private bool) (string, error) {
+	var res *s3.CreateMultipartUploadOutput
+	err := s.withBucket(ctx, func() error {
+		var err error
+		res, err = s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket:      &s.bucket,
+			Key:         &key,
+			ACL:         objectACL(private),
+			ContentType: &contentType,
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return *res.UploadId, nil
+}
+
+func (s *s3Storage) UploadPart(ctx context.Context, key, uploadID string, partNumber int, content []byte) (string, error) {
+	res, err := s.client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     &s.bucket,
+		Key:        &key,
+		UploadId:   &uploadID,
+		PartNumber: aws.Int32(int32(partNumber)),
+		Body:       bytes.NewReader(content),
+	})
+	if err != nil {
+		return "", err
+	}
+	return *res.ETag, nil
+}
+
+func (s *s3Storage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, etags []string) error {
+	var parts []types.CompletedPart
+	for i, etag := range etags {
+		parts = append(parts, types.CompletedPart{
+			ETag:       aws.String(etag),
+			PartNumber: aws.Int32(int32(i + 1)),
+		})
+	}
+	_, err := s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &s.bucket,
+		Key:             &key,
+		UploadId:        &uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	return err
+}
+
+func (s *s3Storage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &s.bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	})
+	return err
+}
+
+func objectACL(private bool) types.ObjectCannedACL {
+	if private {
+		return types.ObjectCannedACLPrivate
+	}
+	return types.ObjectCannedACLPublicRead
+}
+
+// Runs an S3 operation, creating the assets bucket first if it doesn't exist
+// yet. This mostly matters for fresh local setups.
+func (s *s3Storage) withBucket(ctx context.Context, op func() error) error {
+	err := op()
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) && apiError.ErrorCode() == "NoSuchBucket" {
+		_, err := s.client.CreateBucket(ctx, &s3.CreateBucketInput{
+			Bucket: &s.bucket,
+		})
+		if err != nil {
+			return err
+		}
+		return op()
+	}
+	return err
+}
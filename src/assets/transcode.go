@@ -0,0 +1,318 @@
+package assets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/jobs"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+People upload videos in whatever format their recording software spits out,
+which often means MOV or HEVC files that browsers can't play. Every video is
+queued for transcoding when it is uploaded, and a background job works
+through the queue, producing an H.264 MP4 at a reasonable size plus a smaller
+one for slow connections. Queue state lives on the asset itself, so
+transcoding picks up where it left off after a restart.
+*/
+
+const maxTranscodeAttempts = 3
+
+type transcodeRendition struct {
+	Suffix   string
+	MaxWidth int
+	Args     []string
+}
+
+var transcodeRenditions = []transcodeRendition{
+	{
+		Suffix:   "web",
+		MaxWidth: 1920,
+		Args:     []string{"-crf", "23", "-c:a", "aac", "-b:a", "128k"},
+	},
+	{
+		Suffix:   "low",
+		MaxWidth: 854,
+		Args:     []string{"-crf", "30", "-maxrate", "800k", "-bufsize", "1600k", "-c:a", "aac", "-b:a", "64k"},
+	},
+}
+
+var transcodeQueued = make(chan struct{}, 1)
+
+// Wakes up the transcoding job if it is waiting for work.
+func notifyTranscodeQueued() {
+	select {
+	case transcodeQueued <- struct{}{}:
+	default:
+	}
+}
+
+func TranscodedKey(assetID string, suffix string) string {
+	return AssetKey(assetID, fmt.Sprintf("%s_%s.mp4", assetID, suffix))
+}
+
+/*
+Transcodes a video asset, uploads the results, and marks the asset done. If the
+asset has no thumbnail yet, one is extracted from the transcoded video to use
+as a poster frame. The asset is updated in place.
+*/
+func TranscodeVideo(ctx context.Context, dbConn db.ConnOrTx, asset *models.Asset) error {
+	inputPath, err := downloadAssetToFile(ctx, asset)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(inputPath)
+
+	var keys []string
+	var mainPath string
+	for _, rendition := range transcodeRenditions {
+		outputPath, err := transcodeToFile(ctx, inputPath, rendition)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(outputPath)
+		if mainPath == "" {
+			mainPath = outputPath
+		}
+
+		key := TranscodedKey(asset.ID.String(), rendition.Suffix)
+		err = func() error {
+			file, err := os.Open(outputPath)
+			if err != nil {
+				return oops.New(err, "failed to open transcoded video")
+			}
+			defer file.Close()
+			_, err = putObject(ctx, key, "video/mp4", asset.Private, file)
+			return err
+		}()
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	if asset.ThumbnailS3Key == "" {
+		err = saveVideoThumbnail(ctx, dbConn, asset, mainPath)
+		if err != nil {
+			logging.ExtractLogger(ctx).Error().Err(err).Msg("Failed to extract poster frame from transcoded video")
+		}
+	}
+
+	_, err = dbConn.Exec(ctx,
+		`
+		UPDATE asset
+		SET
+			transcode_status = $2,
+			transcoded_s3_key = $3,
+			transcoded_low_s3_key = $4
+		WHERE id = $1
+		`,
+		asset.ID,
+		models.TranscodeStatusDone,
+		keys[0],
+		keys[1],
+	)
+	if err != nil {
+		return oops.New(err, "failed to save transcoded video")
+	}
+
+	asset.TranscodeStatus = models.TranscodeStatusDone
+	asset.TranscodedS3Key = keys[0]
+	asset.TranscodedLowS3Key = keys[1]
+
+	return nil
+}
+
+func transcodeToFile(ctx context.Context, inputPath string, rendition transcodeRendition) (string, error) {
+	output, err := os.CreateTemp("", "hmntranscode*.mp4")
+	if err != nil {
+		return "", oops.New(err, "failed to create temp file for transcoding")
+	}
+	output.Close()
+
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		// H.264 needs even dimensions.
+		"-vf", fmt.Sprintf("scale='min(%d,trunc(iw/2)*2)':-2", rendition.MaxWidth),
+		"-c:v", "libx264",
+		"-preset", "medium",
+		"-pix_fmt", "yuv420p",
+		"-movflags", "+faststart",
+	}
+	args = append(args, rendition.Args...)
+	args = append(args, "-f", "mp4", output.Name())
+
+	var errorOut bytes.Buffer
+	cmd := ffmpegCommand(ctx, args...)
+	cmd.Stderr = &errorOut
+	err = cmd.Run()
+	if err != nil {
+		os.Remove(output.Name())
+		return "", oops.New(err, "FFMpeg failed to transcode video: %s", errorOut.String())
+	}
+
+	return output.Name(), nil
+}
+
+// Runs ffmpeg under cpulimit, if configured.
+func ffmpegCommand(ctx context.Context, args ...string) *exec.Cmd {
+	execPath := getFFMpegPath()
+	if config.Config.PreviewGeneration.CPULimitPath != "" {
+		args = append([]string{"-l", "10", "--", execPath}, args...)
+		execPath = config.Config.PreviewGeneration.CPULimitPath
+	}
+	return exec.CommandContext(ctx, execPath, args...)
+}
+
+func BackgroundVideoTranscoding(conn *pgxpool.Pool) *jobs.Job {
+	job := jobs.New("video transcoding")
+	log := job.Logger
+
+	go func() {
+		defer job.Finish()
+
+		if getFFMpegPath() == "" {
+			log.Warn().Msg("Couldn't find ffmpeg! No videos will be transcoded.")
+			return
+		}
+
+		// Anything still marked as running was interrupted by a restart.
+		_, err := conn.Exec(job.Ctx,
+			`
+			UPDATE asset
+			SET transcode_status = $1
+			WHERE transcode_status = $2
+			`,
+			models.TranscodeStatusPending,
+			models.TranscodeStatusRunning,
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to resume interrupted transcodes")
+		}
+
+		for {
+			asset, err := claimNextTranscode(job.Ctx, conn)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to fetch next video to transcode")
+			}
+			if asset == nil {
+				select {
+				case <-transcodeQueued:
+				case <-time.After(time.Minute):
+				case <-job.Canceled():
+					return
+				}
+				continue
+			}
+
+			log := log.With().Str("AssetID", asset.ID.String()).Logger()
+			ctx := logging.AttachLoggerToContext(&log, job.Ctx)
+
+			log.Debug().Msg("Transcoding video")
+			err = TranscodeVideo(ctx, conn, asset)
+			if err == nil {
+				log.Debug().Msg("Transcoded video successfully")
+				continue
+			}
+
+			select {
+			case <-job.Canceled():
+				// Shutting down killed ffmpeg. That's not the video's fault,
+				// so don't count it against it.
+				_, err = conn.Exec(context.Background(),
+					`
+					UPDATE asset
+					SET
+						transcode_status = $2,
+						transcode_attempts = transcode_attempts - 1
+					WHERE id = $1
+					`,
+					asset.ID,
+					models.TranscodeStatusPending,
+				)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to requeue interrupted transcode")
+				}
+				return
+			default:
+			}
+
+			newStatus := models.TranscodeStatusPending
+			if asset.TranscodeAttempts >= maxTranscodeAttempts {
+				newStatus = models.TranscodeStatusFailed
+			}
+			log.Error().Err(err).Int("attempt", asset.TranscodeAttempts).Msg("Failed to transcode video")
+			_, err = conn.Exec(job.Ctx,
+				`
+				UPDATE asset
+				SET transcode_status = $2
+				WHERE id = $1
+				`,
+				asset.ID,
+				newStatus,
+			)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to update transcode status")
+			}
+		}
+	}()
+
+	return job
+}
+
+// Marks the next pending video as running and returns it, or nil if there is
+// nothing to do.
+func claimNextTranscode(ctx context.Context, conn db.ConnOrTx) (*models.Asset, error) {
+	id, err := db.QueryOneScalar[uuid.UUID](ctx, conn,
+		`
+		UPDATE asset
+		SET
+			transcode_status = $1,
+			transcode_attempts = transcode_attempts + 1
+		WHERE id = (
+			SELECT id
+			FROM asset
+			WHERE transcode_status = $2
+			ORDER BY transcode_attempts
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id
+		`,
+		models.TranscodeStatusRunning,
+		models.TranscodeStatusPending,
+	)
+	if errors.Is(err, db.NotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, oops.New(err, "failed to claim video for transcoding")
+	}
+
+	asset, err := db.QueryOne[models.Asset](ctx, conn,
+		`
+		SELECT $columns
+		FROM asset
+		WHERE id = $1
+		`,
+		id,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch video to transcode")
+	}
+	return asset, nil
+}
@@ -0,0 +1,352 @@
+package assets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding"
+	"errors"
+	"fmt"
+	"hash"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/google/uuid"
+)
+
+/*
+Resumable uploads let big files arrive over several requests, so that a dropped
+connection only loses the chunk in flight instead of the whole file.
+
+The client starts an upload by declaring the file's name and size, then sends
+the file in order, UploadChunkSize bytes at a time, saying where each chunk
+starts. If a request fails, the client asks how much we have received and
+carries on from there. Each chunk becomes one part of an S3 multipart upload,
+and we keep the SHA-1 state between chunks, so nothing is ever buffered beyond
+a single chunk.
+*/
+
+// Chunks of a resumable upload must be exactly this size, except for the
+// last one.
+const UploadChunkSize = uploadPartSize
+
+// Uploads that haven't finished after this long are abandoned and cleaned up.
+const UploadExpiry = 24 * time.Hour
+
+var UploadOffsetMismatch = errors.New("chunk offset does not match the number of bytes received")
+
+func StartUpload(ctx context.Context, dbConn db.ConnOrTx, filename string, size int64, uploaderID int, private bool) (*models.AssetUpload, error) {
+	filename = SanitizeFilename(filename)
+	if size <= 0 {
+		return nil, InvalidAssetError(fmt.Errorf("could not upload asset '%s': no bytes of data were provided", filename))
+	}
+
+	sha1State, err := sha1.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, oops.New(err, "failed to save hash state")
+	}
+
+	id := uuid.New()
+	_, err = dbConn.Exec(ctx,
+		`
+		INSERT INTO asset_upload (id, uploader_id, filename, size, s3_key, sha1_state, private)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`,
+		id,
+		uploaderID,
+		filename,
+		size,
+		AssetKey(id.String(), filename),
+		sha1State,
+		private,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to save upload")
+	}
+
+	return FetchUpload(ctx, dbConn, id, uploaderID)
+}
+
+func FetchUpload(ctx context.Context, dbConn db.ConnOrTx, id uuid.UUID, uploaderID int) (*models.AssetUpload, error) {
+	upload, err := db.QueryOne[models.AssetUpload](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM asset_upload
+		WHERE id = $1 AND uploader_id = $2
+		`,
+		id,
+		uploaderID,
+	)
+	if err != nil {
+		if errors.Is(err, db.NotFound) {
+			return nil, db.NotFound
+		}
+		return nil, oops.New(err, "failed to fetch upload")
+	}
+	return upload, nil
+}
+
+/*
+Adds the chunk starting at offset to an upload. The upload is updated in place.
+When the final chunk arrives, the file becomes an asset and is returned;
+otherwise the returned asset is nil.
+
+Returns UploadOffsetMismatch if offset is not the number of bytes received so
+far, in which case the client should check where to resume from.
+*/
+func WriteUploadChunk(ctx context.Context, dbConn db.ConnOrTx, upload *models.AssetUpload, offset int64, chunk io.Reader) (*models.Asset, error) {
+	if offset == upload.Size && upload.Received == upload.Size {
+		// Every byte arrived, but something went wrong turning the file into
+		// an asset. The client is trying again.
+		hash, err := restoreUploadHash(upload)
+		if err != nil {
+			return nil, err
+		}
+		return finishUpload(ctx, dbConn, upload, fmt.Sprintf("%x", hash.Sum(nil)))
+	}
+	if offset != upload.Received || offset >= upload.Size {
+		return nil, UploadOffsetMismatch
+	}
+
+	expected := min(int64(UploadChunkSize), upload.Size-offset)
+	data, err := io.ReadAll(io.LimitReader(chunk, expected+1))
+	if err != nil {
+		return nil, oops.New(err, "failed to read chunk")
+	}
+	if int64(len(data)) != expected {
+		return nil, InvalidAssetError(fmt.Errorf("expected a chunk of %d bytes but got %d", expected, len(data)))
+	}
+
+	contentType := upload.ContentType
+	uploadID := upload.S3UploadID
+	if offset == 0 {
+		contentType = http.DetectContentType(data)
+		uploadID, err = createMultipartUpload(ctx, upload.S3Key, contentType, upload.Private)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hash, err := restoreUploadHash(upload)
+	if err != nil {
+		return nil, err
+	}
+	hash.Write(data)
+	sha1State, err := hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, oops.New(err, "failed to save hash state")
+	}
+
+	partNumber := int(offset/UploadChunkSize) + 1
+	etag, err := uploadPart(ctx, upload.S3Key, uploadID, partNumber, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only advance if nobody else has written this chunk in the meantime.
+	received := offset + int64(len(data))
+	tag, err := dbConn.Exec(ctx,
+		`
+		UPDATE asset_upload
+		SET
+			received = $3,
+			part_etags = array_append(part_etags, $4),
+			sha1_state = $5,
+			content_type = $6,
+			s3_upload_id = $7
+		WHERE id = $1 AND received = $2
+		`,
+		upload.ID,
+		offset,
+		received,
+		etag,
+		sha1State,
+		contentType,
+		uploadID,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to save upload progress")
+	}
+	if tag.RowsAffected() == 0 {
+		if offset == 0 {
+			abortMultipartUpload(ctx, upload.S3Key, uploadID)
+		}
+		return nil, UploadOffsetMismatch
+	}
+
+	upload.Received = received
+	upload.PartETags = append(upload.PartETags, etag)
+	upload.Sha1State = sha1State
+	upload.ContentType = contentType
+	upload.S3UploadID = uploadID
+
+	if upload.Received < upload.Size {
+		return nil, nil
+	}
+
+	return finishUpload(ctx, dbConn, upload, fmt.Sprintf("%x", hash.Sum(nil)))
+}
+
+func restoreUploadHash(upload *models.AssetUpload) (hash.Hash, error) {
+	h := sha1.New()
+	err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.Sha1State)
+	if err != nil {
+		return nil, oops.New(err, "failed to restore hash state")
+	}
+	return h, nil
+}
+
+// Turns a fully received upload into an asset. This may run more than once for
+// the same upload if saving the asset fails, so the multipart upload is marked
+// complete as soon as S3 has put it together.
+func finishUpload(ctx context.Context, dbConn db.ConnOrTx, upload *models.AssetUpload, checksum string) (*models.Asset, error) {
+	if upload.S3UploadID != "" {
+		err := completeMultipartUpload(ctx, upload.S3Key, upload.S3UploadID, upload.PartETags)
+		if err != nil {
+			return nil, err
+		}
+		_, err = dbConn.Exec(ctx, `UPDATE asset_upload SET s3_upload_id = '' WHERE id = $1`, upload.ID)
+		if err != nil {
+			return nil, oops.New(err, "failed to mark upload as complete")
+		}
+		upload.S3UploadID = ""
+	}
+
+	asset, err := findDuplicate(ctx, dbConn, checksum, upload.Size, upload.ContentType, upload.Private, &upload.UploaderID)
+	if err != nil {
+		return nil, err
+	}
+	if asset != nil {
+		deleteObjects(ctx, upload.S3Key)
+	} else {
+		asset, err = saveAsset(ctx, dbConn, assetRecord{
+			ID:          upload.ID,
+			S3Key:       upload.S3Key,
+			Filename:    upload.Filename,
+			Size:        upload.Size,
+			ContentType: upload.ContentType,
+			Checksum:    checksum,
+			UploaderID:  &upload.UploaderID,
+			Private:     upload.Private,
+		}, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		processUploadedAsset(ctx, dbConn, asset)
+	}
+
+	_, err = dbConn.Exec(ctx, `DELETE FROM asset_upload WHERE id = $1`, upload.ID)
+	if err != nil {
+		return nil, oops.New(err, "failed to delete finished upload")
+	}
+
+	return asset, nil
+}
+
+// Does the work Create does on the way in for a file that arrived in chunks:
+// stripping metadata, finding dimensions, generating variants or thumbnails,
+// and analyzing audio. Failures are logged, since the file itself is fine.
+func processUploadedAsset(ctx context.Context, dbConn db.ConnOrTx, asset *models.Asset) {
+	log := logging.ExtractLogger(ctx).With().Str("AssetID", asset.ID.String()).Logger()
+
+	if CanSanitize(asset.MimeType) || slices.Contains(variantSourceTypes, asset.MimeType) {
+		content, err := fetchAssetContent(ctx, asset)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch uploaded image")
+			return
+		}
+
+		if CanSanitize(asset.MimeType) {
+			clean, _, err := sanitizeStoredAsset(ctx, dbConn, asset, content, false)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to strip metadata from image")
+			} else {
+				content = clean
+			}
+		}
+
+		if config, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+			_, err = dbConn.Exec(ctx,
+				`UPDATE asset SET width = $2, height = $3 WHERE id = $1`,
+				asset.ID,
+				config.Width,
+				config.Height,
+			)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to save image dimensions")
+			} else {
+				asset.Width = config.Width
+				asset.Height = config.Height
+			}
+		}
+
+		err = GenerateVariants(ctx, dbConn, asset, content)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to generate image variants for asset")
+		}
+	} else if strings.HasPrefix(asset.MimeType, "video") && getFFMpegPath() != "" {
+		videoPath, err := downloadAssetToFile(ctx, asset)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch uploaded video")
+			return
+		}
+		defer os.Remove(videoPath)
+
+		err = saveVideoThumbnail(ctx, dbConn, asset, videoPath)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to generate video thumbnail")
+		}
+	} else if strings.HasPrefix(asset.MimeType, "audio") && getFFMpegPath() != "" {
+		audioPath, err := downloadAssetToFile(ctx, asset)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to fetch uploaded audio")
+			return
+		}
+		defer os.Remove(audioPath)
+
+		err = saveAudioInfo(ctx, dbConn, asset, audioPath)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to analyze audio")
+		}
+	}
+}
+
+// Aborts uploads that were started but never finished, so that S3 doesn't
+// keep their parts around forever.
+func CleanUpStaleUploads(ctx context.Context, conn db.ConnOrTx) (int, error) {
+	stale, err := db.Query[models.AssetUpload](ctx, conn,
+		`
+		SELECT $columns
+		FROM asset_upload
+		WHERE created_at < $1
+		`,
+		time.Now().Add(-UploadExpiry),
+	)
+	if err != nil {
+		return 0, oops.New(err, "failed to fetch stale uploads")
+	}
+
+	for _, upload := range stale {
+		if upload.S3UploadID != "" {
+			abortMultipartUpload(ctx, upload.S3Key, upload.S3UploadID)
+		} else if upload.Received == upload.Size {
+			// The file was put together but never became an asset.
+			deleteObjects(ctx, upload.S3Key)
+		}
+		_, err := conn.Exec(ctx, `DELETE FROM asset_upload WHERE id = $1`, upload.ID)
+		if err != nil {
+			return 0, oops.New(err, "failed to delete stale upload")
+		}
+	}
+
+	return len(stale), nil
+}
@@ -0,0 +1,347 @@
+package assets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/jobs"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/image/draw"
+)
+
+// Widths of the scaled-down copies we make of uploaded images. Images are
+// never scaled up, so small images get fewer variants, or none at all.
+var VariantWidths = []int{320, 640, 1280}
+
+// Animated GIFs and SVGs would not survive being scaled down, so they are not
+// in this list.
+var variantSourceTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+	"image/bmp",
+	"image/tiff",
+}
+
+var variantMimeTypes = map[string]string{
+	"webp": "image/webp",
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+}
+
+func VariantKey(assetID string, width int, format string) string {
+	return AssetKey(assetID, fmt.Sprintf("%s_%dw.%s", assetID, width, format))
+}
+
+func VariantMimeType(format string) string {
+	return variantMimeTypes[format]
+}
+
+// Returns the S3 keys of all variants of an asset.
+func VariantKeys(asset *models.Asset) []string {
+	var keys []string
+	for _, format := range asset.VariantFormats {
+		for _, width := range asset.VariantWidths {
+			keys = append(keys, VariantKey(asset.ID.String(), width, format))
+		}
+	}
+	return keys
+}
+
+/*
+Generates scaled-down variants of an image asset, uploads them, and records them
+on the asset. The asset is updated in place.
+
+Every variant is produced in a format that all browsers support (JPEG for
+opaque images, PNG for images with transparency), and also in WebP if ffmpeg
+is available and can encode it.
+*/
+func GenerateVariants(ctx context.Context, dbConn db.ConnOrTx, asset *models.Asset, content []byte) error {
+	var widths []int
+	var formats []string
+	width, height := asset.Width, asset.Height
+
+	if slices.Contains(variantSourceTypes, asset.MimeType) {
+		img, _, err := image.Decode(bytes.NewReader(content))
+		if err != nil {
+			// Nothing we can do with this image, but it's also not worth
+			// trying again later.
+			logging.ExtractLogger(ctx).Warn().Err(err).Str("AssetID", asset.ID.String()).Msg("Failed to decode image for variant generation")
+		} else {
+			img = orientImage(img, imageOrientation(asset.MimeType, content))
+			// The dimensions we got at upload time don't account for EXIF
+			// rotation, but the variants do, so record the upright size.
+			width, height = img.Bounds().Dx(), img.Bounds().Dy()
+			widths, formats, err = uploadVariants(ctx, asset, img)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if widths == nil {
+		widths = []int{}
+	}
+	if formats == nil {
+		formats = []string{}
+	}
+
+	_, err := dbConn.Exec(ctx,
+		`
+		UPDATE asset
+		SET
+			variants_generated = TRUE,
+			variant_widths = $2,
+			variant_formats = $3,
+			width = $4,
+			height = $5
+		WHERE id = $1
+		`,
+		asset.ID,
+		widths,
+		formats,
+		width,
+		height,
+	)
+	if err != nil {
+		return oops.New(err, "failed to save asset variants")
+	}
+
+	asset.VariantsGenerated = true
+	asset.VariantWidths = widths
+	asset.VariantFormats = formats
+	asset.Width = width
+	asset.Height = height
+
+	return nil
+}
+
+func uploadVariants(ctx context.Context, asset *models.Asset, img image.Image) ([]int, []string, error) {
+	assetID := asset.ID.String()
+	bounds := img.Bounds()
+
+	var widths []int
+	for _, width := range VariantWidths {
+		if width < bounds.Dx() {
+			widths = append(widths, width)
+		}
+	}
+	if len(widths) == 0 {
+		return nil, nil, nil
+	}
+
+	fallbackFormat := "jpg"
+	if opaque, ok := img.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+		fallbackFormat = "png"
+	}
+	formats := []string{fallbackFormat}
+	doWebP := getFFMpegPath() != ""
+	var webpKeys []string
+
+	for _, width := range widths {
+		height := max(1, bounds.Dy()*width/bounds.Dx())
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+
+		var fallback bytes.Buffer
+		var err error
+		if fallbackFormat == "jpg" {
+			err = jpeg.Encode(&fallback, scaled, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&fallback, scaled)
+		}
+		if err != nil {
+			return nil, nil, oops.New(err, "failed to encode image variant")
+		}
+		err = putVariant(ctx, VariantKey(assetID, width, fallbackFormat), fallbackFormat, asset.Private, fallback.Bytes())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if doWebP {
+			webp, err := encodeWebP(ctx, scaled)
+			if err != nil {
+				// Most likely ffmpeg was built without libwebp. The fallback
+				// format is good enough, but any WebP variants we already
+				// uploaded won't be recorded, so they have to go.
+				logging.ExtractLogger(ctx).Warn().Err(err).Msg("Failed to encode WebP image variant")
+				deleteObjects(ctx, webpKeys...)
+				doWebP = false
+				continue
+			}
+			webpKey := VariantKey(assetID, width, "webp")
+			err = putVariant(ctx, webpKey, "webp", asset.Private, webp)
+			if err != nil {
+				return nil, nil, err
+			}
+			webpKeys = append(webpKeys, webpKey)
+		}
+	}
+
+	if doWebP {
+		formats = append(formats, "webp")
+	}
+
+	return widths, formats, nil
+}
+
+/*
+Turns an image upright according to its EXIF orientation. Browsers do this for
+the original file, but the variants we encode don't carry the orientation
+along, so the pixels themselves have to be rotated.
+*/
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		// Orientations 5 through 8 all swap the width and height.
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // rotated 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // mirrored along the top-left diagonal
+				sx, sy = y, x
+			case 6: // needs rotating 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // mirrored along the top-right diagonal
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs rotating 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+
+	return dst
+}
+
+func putVariant(ctx context.Context, key string, format string, private bool, content []byte) error {
+	contentType := VariantMimeType(format)
+	_, err := putObject(ctx, key, contentType, private, bytes.NewReader(content))
+	if err != nil {
+		return oops.New(err, "failed to upload image variant")
+	}
+	return nil
+}
+
+func encodeWebP(ctx context.Context, img image.Image) ([]byte, error) {
+	var input bytes.Buffer
+	err := png.Encode(&input, img)
+	if err != nil {
+		return nil, oops.New(err, "failed to encode image for WebP conversion")
+	}
+
+	execPath := getFFMpegPath()
+	args := "-f png_pipe -i pipe:0 -c:v libwebp -quality 80 -f webp pipe:1"
+	if config.Config.PreviewGeneration.CPULimitPath != "" {
+		args = fmt.Sprintf("-l 10 -- %s %s", execPath, args)
+		execPath = config.Config.PreviewGeneration.CPULimitPath
+	}
+	ffmpegCmd := exec.CommandContext(ctx, execPath, strings.Split(args, " ")...)
+
+	var output bytes.Buffer
+	var errorOut bytes.Buffer
+	ffmpegCmd.Stdin = &input
+	ffmpegCmd.Stdout = &output
+	ffmpegCmd.Stderr = &errorOut
+	err = ffmpegCmd.Run()
+	if err != nil {
+		return nil, oops.New(err, "FFMpeg failed to encode WebP: %s", errorOut.String())
+	}
+
+	return output.Bytes(), nil
+}
+
+func BackgroundVariantGeneration(conn *pgxpool.Pool) *jobs.Job {
+	job := jobs.New("image variant generation")
+	log := job.Logger
+
+	go func() {
+		defer job.Finish()
+		log.Debug().Msg("Starting image variant generation job")
+
+		for {
+			assets, err := db.Query[models.Asset](job.Ctx, conn,
+				`
+				SELECT $columns
+				FROM asset
+				WHERE
+					NOT variants_generated
+					AND mime_type = ANY($1)
+				`,
+				variantSourceTypes,
+			)
+			if err != nil {
+				log.Error().Err(oops.New(err, "Failed to fetch assets for variant generation")).Msg("Image variant generation job failed")
+			}
+
+			if len(assets) > 0 {
+				log.Debug().Int("Num assets", len(assets)).Msg("Processing...")
+			}
+
+			for _, asset := range assets {
+				select {
+				case <-job.Canceled():
+					return
+				default:
+				}
+
+				log := log.With().Str("AssetID", asset.ID.String()).Logger()
+				ctx := logging.AttachLoggerToContext(&log, job.Ctx)
+
+				body, err := fetchAssetContent(ctx, asset)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to fetch asset file for variant generation")
+					continue
+				}
+
+				err = GenerateVariants(ctx, conn, asset, body)
+				if err != nil {
+					log.Error().Err(err).Msg("Failed to generate image variants")
+					continue
+				}
+			}
+
+			// Variants that failed at upload time, e.g. because storage was
+			// unavailable, get another try here.
+			select {
+			case <-time.After(time.Hour):
+			case <-job.Canceled():
+				return
+			}
+		}
+	}()
+
+	return job
+}
@@ -34,6 +34,7 @@ type CalendarEvent struct {
 	EndTime   time.Time
 	Duration  time.Duration
 	CalName   string
+	Recurring bool // if so, there's an event per occurrence, all with the same ID
 }
 
 var unifiedCalendar *ical.Calendar
@@ -148,7 +149,7 @@ func GetFutureEvents() []CalendarEvent {
 			continue
 		}
 
-		endTime, err := ev.DateTimeStart(nil)
+		endTime, err := ev.DateTimeEnd(nil)
 		if err != nil {
 			logging.Error().Err(err).Str("Event name", summary).Msg("Failed to get end time for calendar event")
 			continue
@@ -165,6 +166,7 @@ func GetFutureEvents() []CalendarEvent {
 				EndTime:   t.Add(evDuration),
 				Duration:  evDuration,
 				CalName:   calName,
+				Recurring: set != nil,
 			})
 		}
 	}
@@ -174,6 +176,17 @@ func GetFutureEvents() []CalendarEvent {
 	return futureEvents
 }
 
+// The names of the calendars that were downloaded the last time they were
+// reloaded. Calendars that failed to download are left out, along with their
+// events.
+func LoadedCalendars() []string {
+	names := make([]string, 0, len(rawCalendarData))
+	for _, d := range rawCalendarData {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
 func MonitorCalendars() *jobs.Job {
 	job := jobs.New("calendar monitor")
 	log := job.Logger
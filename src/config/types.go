@@ -26,6 +26,7 @@ type HMNConfig struct {
 	Admin             AdminConfig
 	Email             EmailConfig
 	DigitalOcean      DigitalOceanConfig
+	AssetStorage      AssetStorageConfig
 	Discord           DiscordConfig
 	Twitch            TwitchConfig
 	EpisodeGuide      EpisodeGuide
@@ -66,6 +67,18 @@ type DigitalOceanConfig struct {
 	FakeAddr      string
 }
 
+type AssetStorageBackend string
+
+const (
+	AssetStorageS3    AssetStorageBackend = "s3"
+	AssetStorageLocal AssetStorageBackend = "local"
+)
+
+type AssetStorageConfig struct {
+	Backend  AssetStorageBackend // Defaults to S3, configured by DigitalOcean
+	LocalDir string              // Where assets are kept when using local storage
+}
+
 type EmailConfig struct {
 	ServerAddress  string
 	ServerPort     int
@@ -80,6 +93,10 @@ type DiscordConfig struct {
 	BotToken  string
 	BotUserID string
 
+	// Where to send API requests, e.g. to a fake Discord in tests. Leave
+	// blank for the real Discord.
+	ApiUrl string
+
 	OAuthClientID     string
 	OAuthClientSecret string
 
@@ -91,6 +108,19 @@ type DiscordConfig struct {
 	LibraryChannelID        string
 	StreamsChannelID        string
 	ShowcaseWhitelistRoleID string
+
+	// Along with MemberRoleID, these roles are kept in sync with HMN by
+	// discord.RunRoleSync. Leave any of them blank to leave that role alone.
+	// The sync removes them from anyone who doesn't qualify, so don't reuse
+	// roles that are handed out by hand.
+	ApprovedRoleID             string
+	StaffRoleID                string
+	FeaturedProjectOwnerRoleID string
+
+	// Subforum IDs to the Discord forum channels their threads are mirrored
+	// to. New threads get a Discord post, and replies, edits, and deletes
+	// flow both ways; see discord/forum_bridge.go.
+	ForumBridges map[int]string
 }
 
 type TwitchConfig struct {
@@ -104,6 +134,13 @@ type TwitchConfig struct {
 type CalendarSource struct {
 	Name string
 	Url  string
+
+	// Where the calendar's events happen, for mirroring them to the Discord
+	// server's Events tab. Set either a voice or stage channel, or an external
+	// location such as a URL (at most 100 characters). Calendars with neither
+	// aren't mirrored.
+	DiscordChannelID string
+	DiscordLocation  string
 }
 
 type EpisodeGuide struct {
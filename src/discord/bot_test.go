@@ -0,0 +1,747 @@
+package discord_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/calendar"
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/discord"
+	"git.handmade.network/hmn/hmn/src/hmndata"
+	"git.handmade.network/hmn/hmn/src/hmndiscord"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/migration"
+	"git.handmade.network/hmn/hmn/src/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+/*
+These tests run the whole bot against a fake Discord and a scratch database,
+so they can check what the bot does end to end: what it saves, what it sends
+back, and how it survives losing its connection.
+*/
+
+type botTest struct {
+	fake *hmndiscord.Server
+	conn *pgxpool.Pool
+
+	showcase *hmndiscord.Channel
+	library  *hmndiscord.Channel
+	general  *hmndiscord.Channel
+
+	linkedUser   hmndiscord.User // Linked to an HMN account
+	unlinkedUser hmndiscord.User
+}
+
+func startBot(t *testing.T) *botTest {
+	if !config.Config.DevConfig.LiveDBTests {
+		t.Skip("connects to real DB")
+	}
+
+	// Never touch the dev database; these tests wipe theirs.
+	oldPostgres := config.Config.Postgres
+	config.Config.Postgres.DbName += "_discord_test"
+	t.Cleanup(func() { config.Config.Postgres = oldPostgres })
+	migration.ResetDB()
+	migration.Migrate(migration.LatestVersion())
+
+	fake := hmndiscord.NewServer(*logging.GlobalLogger())
+	fake.Token = "bot-token"
+	fake.HeartbeatInterval = time.Second
+	t.Cleanup(fake.Close)
+
+	bt := &botTest{
+		fake:     fake,
+		showcase: fake.AddChannel("project-showcase"),
+		library:  fake.AddChannel("the-library"),
+		general:  fake.AddChannel("general"),
+	}
+	bt.linkedUser = fake.AddUser("linked")
+	bt.unlinkedUser = fake.AddUser("unlinked")
+
+	oldDiscord := config.Config.Discord
+	config.Config.Discord = config.DiscordConfig{
+		BotToken:          fake.Token,
+		BotUserID:         fake.BotUser.ID,
+		ApiUrl:            fake.ApiUrl(),
+		GuildID:           fake.GuildID,
+		ShowcaseChannelID: bt.showcase.ID,
+		LibraryChannelID:  bt.library.ID,
+	}
+	t.Cleanup(func() { config.Config.Discord = oldDiscord })
+
+	bt.conn = db.NewConnPool()
+	t.Cleanup(bt.conn.Close)
+	bt.linkUser(t, bt.linkedUser)
+
+	bot := discord.RunDiscordBot(bt.conn)
+	t.Cleanup(func() {
+		bot.Cancel()
+		<-bot.Finished()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.Nil(t, fake.WaitForConnection(ctx))
+	bt.eventually(t, "the bot never registered its commands", func() bool {
+		return len(fake.Commands()) > 0
+	})
+
+	return bt
+}
+
+func (bt *botTest) linkUser(t *testing.T, user hmndiscord.User) {
+	ctx := context.Background()
+	hmnUserID, err := db.QueryOneScalar[int](ctx, bt.conn,
+		`
+		INSERT INTO hmn_user (username, name, email, password, status, date_joined, registration_ip)
+		VALUES ($1, $1, $2, '', $3, NOW(), '127.0.0.1')
+		RETURNING id
+		`,
+		user.Username,
+		user.Username+"@example.com",
+		models.UserStatusApproved,
+	)
+	require.Nil(t, err)
+	_, err = bt.conn.Exec(ctx,
+		`
+		INSERT INTO discord_user (username, discriminator, access_token, refresh_token, locale, userid, expiry, hmn_user_id)
+		VALUES ($1, $2, '', '', 'en-US', $3, NOW() + INTERVAL '1 week', $4)
+		`,
+		user.Username,
+		user.Discriminator,
+		user.ID,
+		hmnUserID,
+	)
+	require.Nil(t, err)
+}
+
+func (bt *botTest) eventually(t *testing.T, msg string, condition func() bool) {
+	t.Helper()
+	require.Eventually(t, condition, 10*time.Second, 20*time.Millisecond, msg)
+}
+
+func (bt *botTest) snippetFor(t *testing.T, msgID string) string {
+	snippet, err := discord.FetchSnippetForMessage(context.Background(), bt.conn, msgID)
+	require.Nil(t, err)
+	if snippet == nil {
+		return ""
+	}
+	return snippet.Description
+}
+
+func TestBotShowcase(t *testing.T) {
+	bt := startBot(t)
+	ctx := context.Background()
+
+	msg, err := bt.fake.PostMessage(bt.showcase.ID, bt.linkedUser.ID, "Look what I made https://example.com/cool-thing")
+	require.Nil(t, err)
+	bt.eventually(t, "no snippet was created for the showcase post", func() bool {
+		return strings.Contains(bt.snippetFor(t, msg.ID), "Look what I made")
+	})
+
+	_, err = bt.fake.EditMessage(bt.showcase.ID, msg.ID, "Look what I made today https://example.com/cool-thing")
+	require.Nil(t, err)
+	bt.eventually(t, "the snippet was not updated after an edit", func() bool {
+		return strings.Contains(bt.snippetFor(t, msg.ID), "made today")
+	})
+
+	require.Nil(t, bt.fake.DeleteMessage(bt.showcase.ID, msg.ID))
+	bt.eventually(t, "the message was not deleted from the database", func() bool {
+		_, err := discord.FetchInternedMessage(ctx, bt.conn, msg.ID)
+		return errors.Is(err, db.NotFound)
+	})
+
+	// Unlinked users' messages are tracked, but their content is never saved.
+	msg, err = bt.fake.PostMessage(bt.showcase.ID, bt.unlinkedUser.ID, "Mine too https://example.com/other-thing")
+	require.Nil(t, err)
+	bt.eventually(t, "the unlinked user's message was not tracked", func() bool {
+		_, err := discord.FetchInternedMessage(ctx, bt.conn, msg.ID)
+		return err == nil
+	})
+	interned, err := discord.FetchInternedMessage(ctx, bt.conn, msg.ID)
+	require.Nil(t, err)
+	assert.Nil(t, interned.MessageContent)
+	assert.Empty(t, bt.snippetFor(t, msg.ID))
+}
+
+func TestBotRebuke(t *testing.T) {
+	bt := startBot(t)
+
+	msg, err := bt.fake.PostMessage(bt.showcase.ID, bt.unlinkedUser.ID, "What's everyone working on?")
+	require.Nil(t, err)
+	bt.eventually(t, "the chatty showcase post was not deleted", func() bool {
+		return bt.fake.Message(bt.showcase.ID, msg.ID).Deleted
+	})
+	bt.eventually(t, "the user was not told why their post was deleted", func() bool {
+		dms := bt.fake.DirectMessages(bt.unlinkedUser.ID)
+		return len(dms) == 1 && strings.Contains(dms[0].Content, "What's everyone working on?")
+	})
+
+	msg, err = bt.fake.PostMessage(bt.library.ID, bt.unlinkedUser.ID, "no links here")
+	require.Nil(t, err)
+	bt.eventually(t, "the link-less library post was not deleted", func() bool {
+		return bt.fake.Message(bt.library.ID, msg.ID).Deleted
+	})
+}
+
+func TestBotInteraction(t *testing.T) {
+	bt := startBot(t)
+
+	interaction, err := bt.fake.Interact(bt.general.ID, bt.unlinkedUser.ID, hmndiscord.InteractionData{
+		Name: discord.SlashCommandManifesto,
+	})
+	require.Nil(t, err)
+	bt.eventually(t, "the bot did not respond to /manifesto", func() bool {
+		res := bt.fake.InteractionResponse(interaction.ID)
+		return res != nil && res.Original != nil && strings.Contains(res.Original.Content, "manifesto")
+	})
+}
+
+func TestBotLookups(t *testing.T) {
+	bt := startBot(t)
+
+	interaction, err := bt.fake.Interact(bt.general.ID, bt.unlinkedUser.ID, hmndiscord.InteractionData{
+		Name: discord.SlashCommandJam,
+	})
+	require.Nil(t, err)
+	bt.eventually(t, "the bot did not respond to /jam", func() bool {
+		res := bt.fake.InteractionResponse(interaction.ID)
+		return res != nil && res.Original != nil && strings.Contains(res.Original.Content, "/jam")
+	})
+
+	interaction, err = bt.fake.Interact(bt.general.ID, bt.unlinkedUser.ID, hmndiscord.InteractionData{
+		Name: discord.SlashCommandEvents,
+	})
+	require.Nil(t, err)
+	bt.eventually(t, "the bot did not respond to /events", func() bool {
+		res := bt.fake.InteractionResponse(interaction.ID)
+		return res != nil && res.Original != nil && strings.Contains(res.Original.Content, "/calendar")
+	})
+
+	// Discord needs a list of choices even when nothing matches.
+	interaction, err = bt.fake.Autocomplete(bt.general.ID, bt.unlinkedUser.ID, hmndiscord.InteractionData{
+		Name: discord.SlashCommandProject,
+		Options: []hmndiscord.InteractionOption{
+			{Name: discord.ProjectOptionName, Type: 3, Value: "no such project", Focused: true},
+		},
+	})
+	require.Nil(t, err)
+	bt.eventually(t, "the bot did not respond to autocomplete", func() bool {
+		res := bt.fake.InteractionResponse(interaction.ID)
+		return res != nil && res.Data["choices"] != nil
+	})
+}
+
+func TestBotSaveSnippet(t *testing.T) {
+	bt := startBot(t)
+
+	// Outside the showcase, nothing is saved unless the author asks.
+	msg, err := bt.fake.PostMessage(bt.general.ID, bt.linkedUser.ID, "Made a thing https://example.com/thing")
+	require.Nil(t, err)
+
+	interaction, err := bt.fake.Interact(bt.general.ID, bt.unlinkedUser.ID, hmndiscord.InteractionData{
+		Name:     discord.MessageCommandSaveSnippet,
+		TargetID: msg.ID,
+	})
+	require.Nil(t, err)
+	bt.eventually(t, "an unlinked user was not told to link their account", func() bool {
+		res := bt.fake.InteractionResponse(interaction.ID)
+		return res != nil && res.Original != nil && strings.Contains(res.Original.Content, "link a Handmade Network account")
+	})
+
+	// The linked user has no tagged projects, so there's no modal.
+	interaction, err = bt.fake.Interact(bt.general.ID, bt.linkedUser.ID, hmndiscord.InteractionData{
+		Name:     discord.MessageCommandSaveSnippet,
+		TargetID: msg.ID,
+	})
+	require.Nil(t, err)
+	bt.eventually(t, "the user was not sent the snippet's URL", func() bool {
+		res := bt.fake.InteractionResponse(interaction.ID)
+		return res != nil && res.Original != nil && strings.Contains(res.Original.Content, "/snippet/")
+	})
+	assert.Contains(t, bt.snippetFor(t, msg.ID), "Made a thing")
+}
+
+func TestFishbowlImport(t *testing.T) {
+	bt := startBot(t)
+	ctx := context.Background()
+
+	thread := bt.fake.AddChannel("fishbowl-testing")
+	_, err := bt.fake.PostMessage(thread.ID, bt.linkedUser.ID, "Welcome to the fishbowl!")
+	require.Nil(t, err)
+	_, err = bt.fake.PostMessage(thread.ID, bt.unlinkedUser.ID, "Here's my diagram", hmndiscord.File{
+		Name:        "diagram.txt",
+		ContentType: "text/plain",
+		Data:        []byte("boxes and arrows"),
+	})
+	require.Nil(t, err)
+
+	fishbowl, err := db.QueryOne[models.Fishbowl](ctx, bt.conn,
+		`
+		INSERT INTO fishbowl (slug, title, month, year, thread_id)
+		VALUES ('testing', 'Testing', 5, 2023, $1)
+		RETURNING $columns
+		`,
+		thread.ID,
+	)
+	require.Nil(t, err)
+	uploaderID, err := db.QueryOneScalar[int](ctx, bt.conn, `SELECT id FROM hmn_user LIMIT 1`)
+	require.Nil(t, err)
+
+	added, err := discord.ImportFishbowlThread(ctx, bt.conn, fishbowl, uploaderID, nil)
+	require.Nil(t, err)
+	assert.Equal(t, 2, added)
+
+	messages, err := db.Query[models.FishbowlMessage](ctx, bt.conn,
+		`SELECT $columns FROM fishbowl_message WHERE fishbowl_id = $1 ORDER BY ordering`,
+		fishbowl.ID,
+	)
+	require.Nil(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "Welcome to the fishbowl!", messages[0].Content)
+	assert.Equal(t, bt.unlinkedUser.ID, messages[1].AuthorID)
+	attachments, err := db.QueryScalar[int](ctx, bt.conn,
+		`SELECT COUNT(*) FROM fishbowl_message_attachment WHERE message_id = $1`,
+		messages[1].ID,
+	)
+	require.Nil(t, err)
+	assert.Equal(t, []int{1}, attachments)
+
+	// Importing again only adds new messages, and leaves staff edits alone.
+	_, err = bt.conn.Exec(ctx, `UPDATE fishbowl_message SET hidden = TRUE WHERE id = $1`, messages[0].ID)
+	require.Nil(t, err)
+	_, err = bt.fake.PostMessage(thread.ID, bt.linkedUser.ID, "Thanks everyone!")
+	require.Nil(t, err)
+
+	added, err = discord.ImportFishbowlThread(ctx, bt.conn, fishbowl, uploaderID, nil)
+	require.Nil(t, err)
+	assert.Equal(t, 1, added)
+
+	messages, err = db.Query[models.FishbowlMessage](ctx, bt.conn,
+		`SELECT $columns FROM fishbowl_message WHERE fishbowl_id = $1 ORDER BY ordering`,
+		fishbowl.ID,
+	)
+	require.Nil(t, err)
+	require.Len(t, messages, 3)
+	assert.True(t, messages[0].Hidden)
+	assert.Equal(t, "Thanks everyone!", messages[2].Content)
+	assert.Equal(t, 2, messages[2].Ordering)
+}
+
+func TestRoleSync(t *testing.T) {
+	bt := startBot(t)
+	ctx := context.Background()
+
+	member := bt.fake.AddRole("member")
+	approved := bt.fake.AddRole("approved")
+	staff := bt.fake.AddRole("staff")
+	config.Config.Discord.MemberRoleID = member.ID
+	config.Config.Discord.ApprovedRoleID = approved.ID
+	config.Config.Discord.StaffRoleID = staff.ID
+
+	// Someone who unlinked their account, and someone who got banned, should
+	// both lose their roles.
+	leftover := bt.fake.AddUser("leftover", member.ID, staff.ID)
+	banned := bt.fake.AddUser("banned", member.ID, approved.ID)
+	bt.linkUser(t, banned)
+	_, err := bt.conn.Exec(ctx, `UPDATE hmn_user SET status = $1 WHERE username = 'banned'`, models.UserStatusBanned)
+	require.Nil(t, err)
+
+	roleChanges := func(changes []discord.RoleChange) map[string][]string {
+		result := make(map[string][]string)
+		for _, change := range changes {
+			verb := "remove "
+			if change.Add {
+				verb = "add "
+			}
+			result[change.DiscordUserID] = append(result[change.DiscordUserID], verb+change.Role.Name)
+		}
+		return result
+	}
+
+	// Planning is a dry run.
+	changes, err := discord.PlanGuildRoleChanges(ctx, bt.conn)
+	require.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		bt.linkedUser.ID: {"add Member", "add Approved"},
+		leftover.ID:      {"remove Member", "remove Staff"},
+		banned.ID:        {"remove Member", "remove Approved"},
+	}, roleChanges(changes))
+	assert.Empty(t, bt.fake.Member(bt.linkedUser.ID).Roles)
+
+	report := discord.SyncGuildRoles(ctx, bt.conn)
+	require.Nil(t, report.Err)
+	assert.Zero(t, report.Failed)
+	assert.Len(t, report.Changes, 6)
+	assert.ElementsMatch(t, []string{member.ID, approved.ID}, bt.fake.Member(bt.linkedUser.ID).Roles)
+	assert.Empty(t, bt.fake.Member(leftover.ID).Roles)
+	assert.Empty(t, bt.fake.Member(banned.ID).Roles)
+
+	changes, err = discord.PlanGuildRoleChanges(ctx, bt.conn)
+	require.Nil(t, err)
+	assert.Empty(t, changes)
+
+	// Syncing individual members picks up changes on HMN.
+	_, err = bt.conn.Exec(ctx, `UPDATE hmn_user SET is_staff = TRUE WHERE username = 'linked'`)
+	require.Nil(t, err)
+	report = discord.SyncMemberRoles(ctx, bt.conn, []string{bt.linkedUser.ID, leftover.ID})
+	require.Nil(t, report.Err)
+	assert.Equal(t, map[string][]string{
+		bt.linkedUser.ID: {"add Staff"},
+	}, roleChanges(report.Changes))
+	assert.Contains(t, bt.fake.Member(bt.linkedUser.ID).Roles, staff.ID)
+}
+
+func TestBotResume(t *testing.T) {
+	bt := startBot(t)
+	ctx := context.Background()
+
+	// Whatever happens while the bot is away should reach it when it resumes.
+	bt.fake.Disconnect()
+	msg, err := bt.fake.PostMessage(bt.showcase.ID, bt.linkedUser.ID, "Posted while the bot was away https://example.com/late")
+	require.Nil(t, err)
+
+	bt.eventually(t, "the missed message was not handled after resuming", func() bool {
+		return bt.snippetFor(t, msg.ID) != ""
+	})
+
+	// A session Discord no longer knows about means starting over.
+	oldSessionID, err := db.QueryOneScalar[string](ctx, bt.conn, `SELECT session_id FROM discord_session`)
+	require.Nil(t, err)
+	bt.fake.InvalidateSessions()
+	bt.eventually(t, "the bot did not identify again", func() bool {
+		sessionID, err := db.QueryOneScalar[string](ctx, bt.conn, `SELECT session_id FROM discord_session`)
+		return err == nil && sessionID != oldSessionID
+	})
+}
+
+func TestBotBackfill(t *testing.T) {
+	bt := startBot(t)
+	ctx := context.Background()
+
+	watcher := discord.RunHistoryWatcher(bt.conn)
+	t.Cleanup(func() {
+		watcher.Cancel()
+		<-watcher.Finished()
+	})
+
+	isTracked := func(msgID string) bool {
+		_, err := discord.FetchInternedMessage(ctx, bt.conn, msgID)
+		return err == nil
+	}
+
+	// The gateway doesn't replay messages from before a new session, so
+	// the history watcher has to fetch them.
+	first, err := bt.fake.PostMessage(bt.showcase.ID, bt.linkedUser.ID, "Before the outage https://example.com/before")
+	require.Nil(t, err)
+	bt.eventually(t, "the first message was not handled", func() bool {
+		return bt.snippetFor(t, first.ID) != ""
+	})
+
+	bt.fake.Disconnect()
+	bt.fake.InvalidateSessions()
+	missed, err := bt.fake.PostMessage(bt.showcase.ID, bt.linkedUser.ID, "During the outage https://example.com/during")
+	require.Nil(t, err)
+
+	bt.eventually(t, "the missed message was not backfilled", func() bool {
+		return bt.snippetFor(t, missed.ID) != ""
+	})
+	bt.eventually(t, "the backfill did not finish", func() bool {
+		backfills, err := discord.FetchBackfills(ctx, bt.conn)
+		require.Nil(t, err)
+		for _, backfill := range backfills {
+			if backfill.ChannelID == bt.showcase.ID {
+				return len(backfill.Ranges) == 0 && backfill.LastFinished != nil
+			}
+		}
+		return false
+	})
+
+	// Ranges can be queued by hand, even for channels the bot ignores.
+	var msgs []*hmndiscord.Message
+	for _, content := range []string{"one", "two", "three"} {
+		msg, err := bt.fake.PostMessage(bt.general.ID, bt.linkedUser.ID, content)
+		require.Nil(t, err)
+		msgs = append(msgs, msg)
+	}
+	err = discord.QueueBackfill(ctx, bt.conn, bt.general.ID, hmndata.DiscordBackfillRange{
+		After:  msgs[0].ID,
+		Before: msgs[2].ID,
+	})
+	require.Nil(t, err)
+	bt.eventually(t, "the queued range was not backfilled", func() bool {
+		return isTracked(msgs[1].ID)
+	})
+	bt.eventually(t, "the queued backfill did not finish", func() bool {
+		backfills, err := discord.FetchBackfills(ctx, bt.conn)
+		require.Nil(t, err)
+		for _, backfill := range backfills {
+			if backfill.ChannelID == bt.general.ID {
+				return len(backfill.Ranges) == 0
+			}
+		}
+		return false
+	})
+	assert.False(t, isTracked(msgs[0].ID))
+	assert.False(t, isTracked(msgs[2].ID))
+}
+
+func TestScheduledEventSync(t *testing.T) {
+	bt := startBot(t)
+	ctx := context.Background()
+
+	start := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Hour)
+	var calendarStatus int
+	var calendarEvents string
+	calendarServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		w.WriteHeader(calendarStatus)
+		fmt.Fprintf(w, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:test\r\n%sEND:VCALENDAR\r\n", calendarEvents)
+	}))
+	t.Cleanup(calendarServer.Close)
+	setEvent := func(summary string) {
+		calendarStatus = http.StatusOK
+		calendarEvents = fmt.Sprintf(
+			"BEGIN:VEVENT\r\nUID:stream-1\r\nDTSTAMP:%[1]s\r\nDTSTART:%[1]s\r\nDTEND:%[2]s\r\nSUMMARY:%[3]s\r\nDESCRIPTION:\r\nEND:VEVENT\r\n",
+			start.Format("20060102T150405Z"), start.Add(2*time.Hour).Format("20060102T150405Z"), summary,
+		)
+	}
+
+	voice := bt.fake.AddVoiceChannel("Streams", false)
+	oldCalendars := config.Config.Calendars
+	config.Config.Calendars = []config.CalendarSource{
+		{Name: "Streams", Url: calendarServer.URL, DiscordChannelID: voice.ID},
+	}
+	t.Cleanup(func() { config.Config.Calendars = oldCalendars })
+
+	sync := func() discord.ScheduledEventSyncReport {
+		calendar.ReloadCalendars(ctx)
+		report, err := discord.SyncScheduledEvents(ctx, bt.conn)
+		require.Nil(t, err)
+		assert.Zero(t, report.Failed)
+		return report
+	}
+	streamEvents := func() []hmndiscord.ScheduledEvent {
+		var result []hmndiscord.ScheduledEvent
+		for _, ev := range bt.fake.ScheduledEvents() {
+			if ev.ChannelID != nil && *ev.ChannelID == voice.ID {
+				result = append(result, ev)
+			}
+		}
+		return result
+	}
+
+	setEvent("Stream")
+	sync()
+	events := streamEvents()
+	require.Len(t, events, 1)
+	assert.Equal(t, "Stream", events[0].Name)
+	assert.Equal(t, hmndiscord.ScheduledEventEntityTypeVoice, events[0].EntityType)
+	assert.Equal(t, start.Format(time.RFC3339), events[0].ScheduledStartTime)
+
+	// Nothing changed, so nothing is sent.
+	assert.Equal(t, discord.ScheduledEventSyncReport{}, sync())
+
+	setEvent("Stream, renamed")
+	assert.Equal(t, 1, sync().Updated)
+	renamed := streamEvents()
+	require.Len(t, renamed, 1)
+	assert.Equal(t, events[0].ID, renamed[0].ID)
+	assert.Equal(t, "Stream, renamed", renamed[0].Name)
+
+	// Events deleted in Discord come back while they're on the calendar.
+	require.Nil(t, bt.fake.DeleteScheduledEvent(renamed[0].ID))
+	assert.Equal(t, 1, sync().Created)
+	require.Len(t, streamEvents(), 1)
+
+	// A calendar that fails to download keeps its events.
+	calendarStatus = http.StatusInternalServerError
+	assert.Zero(t, sync().Deleted)
+	require.Len(t, streamEvents(), 1)
+
+	calendarStatus = http.StatusOK
+	calendarEvents = ""
+	assert.Equal(t, 1, sync().Deleted)
+	assert.Empty(t, streamEvents())
+}
+
+func TestForumBridge(t *testing.T) {
+	bt := startBot(t)
+	ctx := context.Background()
+
+	_, err := bt.conn.Exec(ctx,
+		`
+		INSERT INTO project (id, slug, name, blurb, description, descparsed, color_1, color_2, featured, personal, lifecycle, hidden, forum_enabled, blog_enabled, date_created)
+		VALUES ($1, 'hmn', 'Handmade Network', '', '', '', '', '', FALSE, FALSE, $2, FALSE, TRUE, FALSE, NOW())
+		`,
+		models.HMNProjectID,
+		models.ProjectLifecycleActive,
+	)
+	require.Nil(t, err)
+	subforumID, err := db.QueryOneScalar[int](ctx, bt.conn,
+		`
+		INSERT INTO subforum (slug, name, project_id)
+		VALUES ('help', 'Help', $1)
+		RETURNING id
+		`,
+		models.HMNProjectID,
+	)
+	require.Nil(t, err)
+	authorID, err := db.QueryOneScalar[int](ctx, bt.conn,
+		`SELECT hmn_user_id FROM discord_user WHERE userid = $1`,
+		bt.linkedUser.ID,
+	)
+	require.Nil(t, err)
+
+	forum := bt.fake.AddForumChannel("help")
+	config.Config.Discord.ForumBridges = map[int]string{subforumID: forum.ID}
+
+	inTx := func(f func(tx pgx.Tx)) {
+		tx, err := bt.conn.Begin(ctx)
+		require.Nil(t, err)
+		defer tx.Rollback(ctx)
+		f(tx)
+		require.Nil(t, tx.Commit(ctx))
+	}
+	sync := func(threadID int) {
+		require.Nil(t, discord.SyncBridgedThread(ctx, bt.conn, threadID))
+	}
+	bridgedPost := func(msgID string) *models.DiscordForumPost {
+		p, err := db.QueryOne[models.DiscordForumPost](ctx, bt.conn,
+			`SELECT $columns FROM discord_forum_post WHERE message_id = $1`,
+			msgID,
+		)
+		if errors.Is(err, db.NotFound) {
+			return nil
+		}
+		require.Nil(t, err)
+		return p
+	}
+	postText := func(postID int) string {
+		text, err := db.QueryOneScalar[string](ctx, bt.conn,
+			`
+			SELECT ver.text_raw
+			FROM post JOIN post_version AS ver ON ver.id = post.current_id
+			WHERE post.id = $1
+			`,
+			postID,
+		)
+		require.Nil(t, err)
+		return text
+	}
+	postDeleted := func(postID int) bool {
+		deleted, err := db.QueryOneScalar[bool](ctx, bt.conn, `SELECT deleted FROM post WHERE id = $1`, postID)
+		require.Nil(t, err)
+		return deleted
+	}
+
+	// A new thread on the site starts a post on Discord.
+	var threadID, firstPostID int
+	inTx(func(tx pgx.Tx) {
+		threadID, err = db.QueryOneScalar[int](ctx, tx,
+			`
+			INSERT INTO thread (title, sticky, type, project_id, subforum_id, first_id, last_id)
+			VALUES ('Help me', FALSE, $1, $2, $3, -1, -1)
+			RETURNING id
+			`,
+			models.ThreadTypeForumPost,
+			models.HMNProjectID,
+			subforumID,
+		)
+		require.Nil(t, err)
+		firstPostID, _ = hmndata.CreateNewPost(ctx, tx, models.HMNProjectID, threadID, models.ThreadTypeForumPost, authorID, nil, "How do I **allocate**?", "")
+	})
+	sync(threadID)
+	threads := bt.fake.Threads(forum.ID)
+	require.Len(t, threads, 1)
+	dthread := threads[0]
+	assert.Equal(t, "Help me", dthread.Name)
+	msgs := bt.fake.Messages(dthread.ID)
+	require.Len(t, msgs, 1)
+	assert.Contains(t, msgs[0].Content, "How do I **allocate**?")
+	assert.Equal(t, "linked", msgs[0].Author.Username)
+	assert.NotEmpty(t, msgs[0].WebhookAvatarUrl)
+
+	sync(threadID)
+	assert.Len(t, bt.fake.Messages(dthread.ID), 1, "syncing again should not send anything")
+
+	// Replies from linked users on Discord become posts on the site.
+	_, err = bt.fake.PostMessage(dthread.ID, bt.unlinkedUser.ID, "malloc lol")
+	require.Nil(t, err)
+	reply, err := bt.fake.PostMessage(dthread.ID, bt.linkedUser.ID, "Use an arena")
+	require.Nil(t, err)
+	bt.eventually(t, "the Discord reply was not posted on the site", func() bool {
+		return bridgedPost(reply.ID) != nil
+	})
+	replyPost := bridgedPost(reply.ID)
+	assert.True(t, replyPost.FromDiscord)
+	assert.Equal(t, "Use an arena", postText(replyPost.PostID))
+	postCount, err := db.QueryOneScalar[int](ctx, bt.conn,
+		`SELECT COUNT(*) FROM post WHERE thread_id = $1 AND NOT deleted`,
+		threadID,
+	)
+	require.Nil(t, err)
+	assert.Equal(t, 2, postCount, "the unlinked user's message should stay on Discord")
+
+	// Posts from Discord are never sent back.
+	sync(threadID)
+	assert.Len(t, bt.fake.Messages(dthread.ID), 3)
+
+	_, err = bt.fake.EditMessage(dthread.ID, reply.ID, "Use an arena allocator")
+	require.Nil(t, err)
+	bt.eventually(t, "the Discord edit did not reach the site", func() bool {
+		return postText(replyPost.PostID) == "Use an arena allocator"
+	})
+
+	// Replies, edits, and title changes on the site go to Discord.
+	var sitePostID int
+	inTx(func(tx pgx.Tx) {
+		sitePostID, _ = hmndata.CreateNewPost(ctx, tx, models.HMNProjectID, threadID, models.ThreadTypeForumPost, authorID, nil, "Thanks!", "")
+	})
+	sync(threadID)
+	msgs = bt.fake.Messages(dthread.ID)
+	require.Len(t, msgs, 4)
+	siteReply := msgs[3]
+	assert.Equal(t, "Thanks!", siteReply.Content)
+	require.NotNil(t, siteReply.WebhookID)
+	assert.False(t, bridgedPost(siteReply.ID).FromDiscord)
+
+	inTx(func(tx pgx.Tx) {
+		hmndata.CreatePostVersion(ctx, tx, sitePostID, "Thanks a lot!", "", "", nil)
+		_, err := tx.Exec(ctx, `UPDATE thread SET title = 'Help me, renamed' WHERE id = $1`, threadID)
+		require.Nil(t, err)
+	})
+	sync(threadID)
+	assert.Equal(t, "Thanks a lot!", bt.fake.Message(dthread.ID, siteReply.ID).Content)
+	assert.Equal(t, "Help me, renamed", bt.fake.Channel(dthread.ID).Name)
+
+	// Deletes go both ways.
+	require.Nil(t, bt.fake.DeleteMessage(dthread.ID, reply.ID))
+	bt.eventually(t, "the Discord delete did not reach the site", func() bool {
+		return postDeleted(replyPost.PostID)
+	})
+
+	inTx(func(tx pgx.Tx) {
+		hmndata.DeletePost(ctx, tx, threadID, sitePostID)
+	})
+	sync(threadID)
+	assert.True(t, bt.fake.Message(dthread.ID, siteReply.ID).Deleted)
+
+	inTx(func(tx pgx.Tx) {
+		hmndata.DeletePost(ctx, tx, threadID, firstPostID)
+	})
+	sync(threadID)
+	assert.Nil(t, bt.fake.Channel(dthread.ID))
+	assert.Empty(t, bt.fake.Threads(forum.ID))
+}
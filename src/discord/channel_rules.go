@@ -0,0 +1,496 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/utils"
+)
+
+/*
+Channel rules decide what the bot does with messages in each channel: whether
+to store them, whether to make snippets out of them, and what to delete. They
+live in the database so staff can change them without a deploy.
+*/
+
+// Returns nil if the bot doesn't know about the channel.
+func FetchChannel(ctx context.Context, dbConn db.ConnOrTx, channelID string) (*models.DiscordChannel, error) {
+	channel, err := db.QueryOne[models.DiscordChannel](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM discord_channel
+		WHERE channel_id = $1
+		`,
+		channelID,
+	)
+	if errors.Is(err, db.NotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, oops.New(err, "failed to fetch Discord channel")
+	}
+	return channel, nil
+}
+
+func FetchChannels(ctx context.Context, dbConn db.ConnOrTx) ([]*models.DiscordChannel, error) {
+	channels, err := db.Query[models.DiscordChannel](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM discord_channel
+		ORDER BY name
+		`,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch Discord channels")
+	}
+	return channels, nil
+}
+
+func FetchChannelRules(ctx context.Context, dbConn db.ConnOrTx, channelID string, onlyEnabled bool) ([]*models.DiscordChannelRule, error) {
+	rules, err := db.Query[models.DiscordChannelRule](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM discord_channel_rule
+		WHERE
+			channel_id = $1
+			AND (enabled OR NOT $2)
+		ORDER BY ordering, id
+		`,
+		channelID,
+		onlyEnabled,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch Discord channel rules")
+	}
+	return rules, nil
+}
+
+/*
+The bot looks up the channel and its rules for every message it sees, so they
+are cached in memory. Anything that changes a channel or its rules must call
+ChannelRulesUpdated afterward.
+*/
+var channelRulesCache struct {
+	sync.Mutex
+	channels   map[string]*cachedChannel
+	generation int
+}
+
+type cachedChannel struct {
+	channel *models.DiscordChannel // nil if the bot doesn't know about the channel
+	rules   []*models.DiscordChannelRule
+}
+
+// Clears the cached channel rules, so the bot picks up the changes on the next
+// message.
+func ChannelRulesUpdated() {
+	channelRulesCache.Lock()
+	defer channelRulesCache.Unlock()
+	channelRulesCache.channels = nil
+	channelRulesCache.generation++
+}
+
+// Fetches a channel and its enabled rules, from the cache if possible.
+func fetchCachedChannel(ctx context.Context, dbConn db.ConnOrTx, channelID string) (*cachedChannel, error) {
+	channelRulesCache.Lock()
+	cached, ok := channelRulesCache.channels[channelID]
+	generation := channelRulesCache.generation
+	channelRulesCache.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	channel, err := FetchChannel(ctx, dbConn, channelID)
+	if err != nil {
+		return nil, err
+	}
+	cached = &cachedChannel{channel: channel}
+	if channel != nil {
+		cached.rules, err = FetchChannelRules(ctx, dbConn, channelID, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	channelRulesCache.Lock()
+	defer channelRulesCache.Unlock()
+	// If the rules changed while we were fetching, what we have may be stale
+	// already, so don't keep it.
+	if channelRulesCache.generation == generation {
+		if channelRulesCache.channels == nil {
+			channelRulesCache.channels = make(map[string]*cachedChannel)
+		}
+		channelRulesCache.channels[channelID] = cached
+	}
+	return cached, nil
+}
+
+type defaultChannel struct {
+	channel models.DiscordChannel
+	rules   []models.DiscordChannelRule
+}
+
+// The rules for the channels in the config, from before staff could edit them.
+func defaultChannels() []defaultChannel {
+	d := config.Config.Discord
+
+	var showcaseExemptRoles []string
+	if d.ShowcaseWhitelistRoleID != "" {
+		showcaseExemptRoles = []string{d.ShowcaseWhitelistRoleID}
+	}
+
+	return []defaultChannel{
+		{
+			channel: models.DiscordChannel{
+				ChannelID:     d.ShowcaseChannelID,
+				Name:          "project-showcase",
+				StoreMessages: true,
+				AutoSnippet:   models.DiscordChannelAutoSnippetAlways,
+			},
+			rules: []models.DiscordChannelRule{
+				{
+					Kind:          models.DiscordChannelRuleRequireLinkOrAttachment,
+					RebukeMessage: "Posts in #project-showcase are required to have an image, video, or link. Please create a thread if you wish to discuss a #project-showcase post.",
+				},
+				{
+					Kind:          models.DiscordChannelRuleForbidDomains,
+					Domains:       []string{"steampowered.com"},
+					RebukeMessage: "We do not allow Steam links or marketing material in #project-showcase.",
+					ExemptRoleIDs: showcaseExemptRoles,
+					AppliesAfter:  utils.P(time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC)),
+				},
+				{
+					Kind:    models.DiscordChannelRuleSuppressEmbeds,
+					Domains: []string{"github.com"},
+				},
+			},
+		},
+		{
+			channel: models.DiscordChannel{
+				ChannelID: d.LibraryChannelID,
+				Name:      "the-library",
+			},
+			rules: []models.DiscordChannelRule{
+				{
+					Kind:          models.DiscordChannelRuleRequireLink,
+					RebukeMessage: "Posts in #the-library are required to have a link. Please discuss library content in other relevant channels.",
+				},
+			},
+		},
+		{
+			channel: models.DiscordChannel{
+				ChannelID:     d.JamChannelID,
+				Name:          "jam",
+				StoreMessages: true,
+				AutoSnippet:   models.DiscordChannelAutoSnippetTaggedDuringJam,
+			},
+		},
+	}
+}
+
+/*
+Sets up any channel from the config that the database doesn't know about yet,
+with the rules the bot has always had for it. Channels staff have already set
+up are left alone.
+*/
+func EnsureDefaultChannels(ctx context.Context, dbConn db.ConnOrTx) error {
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return oops.New(err, "failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	for _, d := range defaultChannels() {
+		if d.channel.ChannelID == "" {
+			continue
+		}
+
+		tag, err := tx.Exec(ctx,
+			`
+			INSERT INTO discord_channel (channel_id, name, store_messages, auto_snippet)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+			`,
+			d.channel.ChannelID,
+			d.channel.Name,
+			d.channel.StoreMessages,
+			d.channel.AutoSnippet,
+		)
+		if err != nil {
+			return oops.New(err, "failed to create Discord channel")
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+
+		for i, rule := range d.rules {
+			_, err := tx.Exec(ctx,
+				`
+				INSERT INTO discord_channel_rule (channel_id, kind, domains, rebuke_message, exempt_role_ids, applies_after, enabled, ordering)
+				VALUES ($1, $2, COALESCE($3, '{}'), $4, COALESCE($5, '{}'), $6, TRUE, $7)
+				`,
+				d.channel.ChannelID,
+				rule.Kind,
+				rule.Domains,
+				rule.RebukeMessage,
+				rule.ExemptRoleIDs,
+				rule.AppliesAfter,
+				i,
+			)
+			if err != nil {
+				return oops.New(err, "failed to create Discord channel rule")
+			}
+		}
+		logging.ExtractLogger(ctx).Info().Str("channel", d.channel.Name).Msg("Set up default rules for Discord channel")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return oops.New(err, "failed to commit default Discord channels")
+	}
+	ChannelRulesUpdated()
+	return nil
+}
+
+/*
+What a rule gets to look at. Discord leaves unchanged fields out of message
+updates, so nil means "unknown", and rules give unknown fields the benefit of
+the doubt.
+*/
+type RuleSubject struct {
+	Content     *string
+	Attachments *int
+	EmbedURLs   []string
+	RoleIDs     []string
+	SentAt      time.Time
+}
+
+func ruleSubjectForMessage(msg *Message) RuleSubject {
+	subject := RuleSubject{
+		SentAt: msg.Time(),
+	}
+	if msg.OriginalHasFields("content") {
+		subject.Content = &msg.Content
+	}
+	if msg.OriginalHasFields("attachments") {
+		subject.Attachments = utils.P(len(msg.Attachments))
+	}
+	for _, e := range msg.Embeds {
+		if e.Url != nil {
+			subject.EmbedURLs = append(subject.EmbedURLs, *e.Url)
+		}
+	}
+	if msg.Member != nil {
+		subject.RoleIDs = msg.Member.Roles
+	}
+	return subject
+}
+
+// Whether a rule has anything to say about a message at all.
+func RuleApplies(rule *models.DiscordChannelRule, subject RuleSubject) bool {
+	if rule.AppliesAfter != nil && subject.SentAt.Before(*rule.AppliesAfter) {
+		return false
+	}
+	for _, roleID := range subject.RoleIDs {
+		if slices.Contains(rule.ExemptRoleIDs, roleID) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether a message should be deleted for breaking a rule. Rules that don't
+// rebuke, like hiding embeds, are never broken.
+func RuleBroken(rule *models.DiscordChannelRule, subject RuleSubject) bool {
+	if !RuleApplies(rule, subject) {
+		return false
+	}
+
+	hasLinks := subject.Content == nil || messageHasLinks(*subject.Content)
+	hasAttachments := subject.Attachments == nil || *subject.Attachments > 0
+
+	switch rule.Kind {
+	case models.DiscordChannelRuleRequireLink:
+		return !hasLinks
+	case models.DiscordChannelRuleRequireAttachment:
+		return !hasAttachments
+	case models.DiscordChannelRuleRequireLinkOrAttachment:
+		return !hasLinks && !hasAttachments
+	case models.DiscordChannelRuleForbidDomains:
+		if subject.Content != nil {
+			for _, word := range strings.Fields(*subject.Content) {
+				if hostMatchesDomains(hostForWord(word), rule.Domains) {
+					return true
+				}
+			}
+		}
+		for _, embedUrl := range subject.EmbedURLs {
+			if hostMatchesDomains(hostForWord(embedUrl), rule.Domains) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// Whether a message's embeds should be hidden.
+func RuleSuppressesEmbeds(rule *models.DiscordChannelRule, subject RuleSubject) bool {
+	if rule.Kind != models.DiscordChannelRuleSuppressEmbeds || !RuleApplies(rule, subject) {
+		return false
+	}
+	if len(subject.EmbedURLs) == 0 {
+		return false
+	}
+	for _, embedUrl := range subject.EmbedURLs {
+		if !hostMatchesDomains(hostForWord(embedUrl), rule.Domains) {
+			return false
+		}
+	}
+	return true
+}
+
+// Finds the host in a word from a message, whether or not it has a scheme,
+// e.g. "store.steampowered.com/app/123" or "<https://github.com/foo>".
+func hostForWord(word string) string {
+	word = strings.Trim(word, "<>()[]\"'*_~`|,")
+	if !strings.Contains(word, "://") {
+		word = "https://" + word
+	}
+	u, err := url.Parse(word)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func hostMatchesDomains(host string, domains []string) bool {
+	if host == "" {
+		return false
+	}
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs a channel's rules on a message, deleting it or cleaning it up as
+// necessary. Returns true if the message was deleted.
+func applyChannelRules(ctx context.Context, dbConn db.ConnOrTx, msg *Message, rules []*models.DiscordChannelRule) (bool, error) {
+	// Ignore messages that are of unusual types.
+	switch msg.Type {
+	case MessageTypeDefault, MessageTypeReply, MessageTypeApplicationCommand:
+	default:
+		return false, nil
+	}
+
+	subject := ruleSubjectForMessage(msg)
+	for _, rule := range rules {
+		if RuleBroken(rule, subject) {
+			return RebukeMessage(ctx, dbConn, msg, rule.RebukeMessage)
+		}
+	}
+
+	for _, rule := range rules {
+		if RuleSuppressesEmbeds(rule, subject) {
+			_, err := EditMessage(ctx, msg.ChannelID, msg.ID, `{ "flags": 4 }`) // 4 = SUPPRESS_EMBEDS
+			if err != nil {
+				return false, oops.New(err, "Failed to remove embeds from message")
+			}
+			break
+		}
+	}
+
+	return false, nil
+}
+
+// How a rule would have treated a message we stored in the past.
+type RuleTestResult struct {
+	MessageID      string
+	Url            string
+	SentAt         time.Time
+	Content        string
+	Broken         bool
+	SuppressEmbeds bool
+}
+
+/*
+Runs a rule, enabled or not, against the most recent messages we've stored for
+its channel. We don't store authors' roles, so role exemptions can't be tested,
+and only messages from linked users have content to check.
+*/
+func TestChannelRule(ctx context.Context, dbConn db.ConnOrTx, rule *models.DiscordChannelRule, limit int) ([]RuleTestResult, error) {
+	type historyRow struct {
+		ID              string    `db:"id"`
+		Url             string    `db:"url"`
+		SentAt          time.Time `db:"sent_at"`
+		Content         string    `db:"last_content"`
+		AttachmentCount int       `db:"attachment_count"`
+		EmbedURLs       []string  `db:"embed_urls"`
+	}
+	rows, err := db.Query[historyRow](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM (
+			SELECT
+				message.id,
+				message.url,
+				message.sent_at,
+				content.last_content,
+				(
+					SELECT COUNT(*)
+					FROM discord_message_attachment AS attachment
+					WHERE attachment.message_id = message.id
+				) AS attachment_count,
+				ARRAY(
+					SELECT embed.url
+					FROM discord_message_embed AS embed
+					WHERE embed.message_id = message.id AND embed.url IS NOT NULL
+				) AS embed_urls
+			FROM
+				discord_message AS message
+				JOIN discord_message_content AS content ON content.message_id = message.id
+			WHERE message.channel_id = $1
+			ORDER BY message.sent_at DESC
+			LIMIT $2
+		) AS history
+		`,
+		rule.ChannelID,
+		limit,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch message history for rule test")
+	}
+
+	results := make([]RuleTestResult, 0, len(rows))
+	for _, row := range rows {
+		subject := RuleSubject{
+			Content:     &row.Content,
+			Attachments: &row.AttachmentCount,
+			EmbedURLs:   row.EmbedURLs,
+			SentAt:      row.SentAt,
+		}
+		results = append(results, RuleTestResult{
+			MessageID:      row.ID,
+			Url:            row.Url,
+			SentAt:         row.SentAt,
+			Content:        row.Content,
+			Broken:         RuleBroken(rule, subject),
+			SuppressEmbeds: RuleSuppressesEmbeds(rule, subject),
+		})
+	}
+	return results, nil
+}
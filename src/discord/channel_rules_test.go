@@ -0,0 +1,63 @@
+package discord
+
+import (
+	"testing"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleBroken(t *testing.T) {
+	cutoff := time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC)
+	before := cutoff.Add(-time.Hour)
+	after := cutoff.Add(time.Hour)
+
+	requireLinkOrAttachment := &models.DiscordChannelRule{Kind: models.DiscordChannelRuleRequireLinkOrAttachment}
+	forbidSteam := &models.DiscordChannelRule{
+		Kind:          models.DiscordChannelRuleForbidDomains,
+		Domains:       []string{"steampowered.com"},
+		ExemptRoleIDs: []string{"whitelisted"},
+		AppliesAfter:  &cutoff,
+	}
+
+	t.Run("require link or attachment", func(t *testing.T) {
+		assert.True(t, RuleBroken(requireLinkOrAttachment, RuleSubject{Content: utils.P("just text"), Attachments: utils.P(0)}))
+		assert.False(t, RuleBroken(requireLinkOrAttachment, RuleSubject{Content: utils.P("see https://example.com"), Attachments: utils.P(0)}))
+		assert.False(t, RuleBroken(requireLinkOrAttachment, RuleSubject{Content: utils.P("just text"), Attachments: utils.P(1)}))
+	})
+	t.Run("unknown fields pass", func(t *testing.T) {
+		assert.False(t, RuleBroken(requireLinkOrAttachment, RuleSubject{Attachments: utils.P(0)}))
+		assert.False(t, RuleBroken(requireLinkOrAttachment, RuleSubject{Content: utils.P("just text")}))
+	})
+	t.Run("forbidden domains", func(t *testing.T) {
+		assert.True(t, RuleBroken(forbidSteam, RuleSubject{Content: utils.P("buy it at store.steampowered.com/app/123"), SentAt: after}))
+		assert.True(t, RuleBroken(forbidSteam, RuleSubject{Content: utils.P("<https://steampowered.com>"), SentAt: after}))
+		assert.True(t, RuleBroken(forbidSteam, RuleSubject{Content: utils.P("look"), EmbedURLs: []string{"https://store.steampowered.com/app/123"}, SentAt: after}))
+		assert.False(t, RuleBroken(forbidSteam, RuleSubject{Content: utils.P("https://notsteampowered.com"), SentAt: after}))
+		assert.False(t, RuleBroken(forbidSteam, RuleSubject{Content: utils.P("https://itch.io/foo"), SentAt: after}))
+	})
+	t.Run("applies after", func(t *testing.T) {
+		assert.False(t, RuleBroken(forbidSteam, RuleSubject{Content: utils.P("store.steampowered.com/app/123"), SentAt: before}))
+	})
+	t.Run("exempt roles", func(t *testing.T) {
+		assert.False(t, RuleBroken(forbidSteam, RuleSubject{Content: utils.P("store.steampowered.com/app/123"), RoleIDs: []string{"other", "whitelisted"}, SentAt: after}))
+		assert.True(t, RuleBroken(forbidSteam, RuleSubject{Content: utils.P("store.steampowered.com/app/123"), RoleIDs: []string{"other"}, SentAt: after}))
+	})
+}
+
+func TestRuleSuppressesEmbeds(t *testing.T) {
+	rule := &models.DiscordChannelRule{Kind: models.DiscordChannelRuleSuppressEmbeds, Domains: []string{"github.com"}}
+
+	assert.True(t, RuleSuppressesEmbeds(rule, RuleSubject{EmbedURLs: []string{"https://github.com/foo/bar"}}))
+	assert.False(t, RuleSuppressesEmbeds(rule, RuleSubject{EmbedURLs: []string{"https://github.com/foo/bar", "https://youtube.com/watch"}}))
+	assert.False(t, RuleSuppressesEmbeds(rule, RuleSubject{}))
+	assert.False(t, RuleBroken(rule, RuleSubject{EmbedURLs: []string{"https://github.com/foo/bar"}}))
+}
+
+func TestHostForWord(t *testing.T) {
+	assert.Equal(t, "store.steampowered.com", hostForWord("store.steampowered.com/app/123"))
+	assert.Equal(t, "github.com", hostForWord("<https://GitHub.com/foo>"))
+	assert.Equal(t, "example.com", hostForWord("(http://example.com),"))
+}
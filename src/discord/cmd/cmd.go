@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 
+	"git.handmade.network/hmn/hmn/src/calendar"
 	"git.handmade.network/hmn/hmn/src/config"
 	"git.handmade.network/hmn/hmn/src/db"
 	"git.handmade.network/hmn/hmn/src/discord"
+	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/logging"
 	"git.handmade.network/hmn/hmn/src/website"
 	"github.com/spf13/cobra"
@@ -22,21 +25,55 @@ func init() {
 	}
 	website.WebsiteCommand.AddCommand(rootCommand)
 
-	scrapeCommand := &cobra.Command{
-		Use:   "scrapechannel [<channel id>...]",
-		Short: "Scrape the entire history of Discord channels",
-		Long:  "Scrape the entire history of Discord channels, saving message content (but not creating snippets)",
+	backfillCommand := &cobra.Command{
+		Use:   "backfill <channel id>...",
+		Short: "Queue a backfill of Discord channel history",
+		Long: `Queues a range of Discord channel history for the website's history watcher to fetch, saving message content (but not creating snippets, unless --live is given). By default the whole history of the channel is fetched.
+
+--after and --before take either a message ID or a date like 2024-03-28. The website picks up new backfills within a minute, and shows their progress on the Discord bot debug page.`,
 		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) < 1 {
+				cmd.Usage()
+				os.Exit(1)
+			}
+
+			afterStr, _ := cmd.Flags().GetString("after")
+			beforeStr, _ := cmd.Flags().GetString("before")
+			live, _ := cmd.Flags().GetBool("live")
+			after, err := parseBackfillBound(afterStr)
+			if err != nil {
+				fmt.Printf("Invalid --after: %v\n", err)
+				os.Exit(1)
+			}
+			before, err := parseBackfillBound(beforeStr)
+			if err != nil {
+				fmt.Printf("Invalid --before: %v\n", err)
+				os.Exit(1)
+			}
+
 			ctx := context.Background()
-			conn := db.NewConnPool()
-			defer conn.Close()
+			conn := db.NewConn()
+			defer conn.Close(ctx)
 
 			for _, channelID := range args {
-				discord.ScrapeAll(ctx, conn, channelID, time.Time{})
+				err := discord.QueueBackfill(ctx, conn, channelID, hmndata.DiscordBackfillRange{
+					After:  after,
+					Before: before,
+					Live:   live,
+					Reason: "Queued from the command line",
+				})
+				if err != nil {
+					logging.Error().Err(err).Str("channel", channelID).Msg("failed to queue backfill")
+					continue
+				}
+				fmt.Printf("Queued a backfill of channel %s\n", channelID)
 			}
 		},
 	}
-	rootCommand.AddCommand(scrapeCommand)
+	backfillCommand.Flags().String("after", "", "Only fetch messages after this message ID or date")
+	backfillCommand.Flags().String("before", "", "Only fetch messages before this message ID or date")
+	backfillCommand.Flags().Bool("live", false, "Handle messages as if they came from the gateway, applying channel rules and creating snippets")
+	rootCommand.AddCommand(backfillCommand)
 
 	makeSnippetCommand := &cobra.Command{
 		Use:   "makesnippet <channel id> [<message id>...]",
@@ -118,4 +155,39 @@ func init() {
 		},
 	}
 	rootCommand.AddCommand(processMessageCommand)
+
+	syncEventsCommand := &cobra.Command{
+		Use:   "syncevents",
+		Short: "Sync the calendars to Discord's scheduled events",
+		Long:  "Downloads the calendars and brings the Discord server's scheduled events in line with them, along with upcoming jams and expos. The website does this every 15 minutes on its own.",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			conn := db.NewConn()
+			defer conn.Close(ctx)
+
+			calendar.ReloadCalendars(ctx)
+			report, err := discord.SyncScheduledEvents(ctx, conn)
+			if err != nil {
+				logging.Error().Err(err).Msg("failed to sync scheduled events")
+				os.Exit(1)
+			}
+			fmt.Printf("Created %d, updated %d, and deleted %d scheduled events (%d failed)\n", report.Created, report.Updated, report.Deleted, report.Failed)
+		},
+	}
+	rootCommand.AddCommand(syncEventsCommand)
+}
+
+// Accepts a message ID or a date.
+func parseBackfillBound(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if _, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return value, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return "", errors.New("expected a message ID or a date like 2024-03-28")
+	}
+	return discord.SnowflakeForTime(t), nil
 }
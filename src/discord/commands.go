@@ -2,11 +2,17 @@ package discord
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"git.handmade.network/hmn/hmn/src/calendar"
 	"git.handmade.network/hmn/hmn/src/config"
 	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/utils"
@@ -27,9 +33,26 @@ const HMHReplayOptionToggle = "toggle"
 
 const SlashCommandJoinJam = "joinjam"
 
+const SlashCommandTopic = "topic"
+const TopicOptionName = "name"
+
+const SlashCommandProject = "project"
+const ProjectOptionName = "name"
+
+const SlashCommandJam = "jam"
+const SlashCommandEvents = "events"
+
 // User command names
 const UserCommandProfile = "HMN Profile"
 
+// Message command names
+const MessageCommandSaveSnippet = "Save to Handmade Network"
+
+// Modal custom IDs and inputs. Modals that are about a message put the
+// channel and message IDs after the modal's ID, separated by slashes.
+const ModalSaveSnippet = "save_snippet"
+const SaveSnippetInputTags = "tags"
+
 func (bot *botInstance) createApplicationCommands(ctx context.Context) {
 	doOrWarn := func(err error) {
 		if err == nil {
@@ -57,6 +80,12 @@ func (bot *botInstance) createApplicationCommands(ctx context.Context) {
 		Name: UserCommandProfile,
 	}))
 
+	doOrWarn(CreateGuildApplicationCommand(ctx, CreateGuildApplicationCommandRequest{
+		Type:         ApplicationCommandTypeMessage,
+		Name:         MessageCommandSaveSnippet,
+		DMPermission: utils.P(false),
+	}))
+
 	doOrWarn(CreateGuildApplicationCommand(ctx, CreateGuildApplicationCommandRequest{
 		Type:        ApplicationCommandTypeChatInput,
 		Name:        SlashCommandManifesto,
@@ -84,6 +113,47 @@ func (bot *botInstance) createApplicationCommands(ctx context.Context) {
 		Description:  "Join an upcoming jam",
 		DMPermission: utils.P(false),
 	}))
+
+	doOrWarn(CreateGuildApplicationCommand(ctx, CreateGuildApplicationCommandRequest{
+		Type:        ApplicationCommandTypeChatInput,
+		Name:        SlashCommandTopic,
+		Description: "Look up a topic on the Handmade Network",
+		Options: []ApplicationCommandOption{
+			{
+				Type:        ApplicationCommandOptionTypeString,
+				Name:        TopicOptionName,
+				Description: "The topic's name or slug",
+				Required:    true,
+			},
+		},
+	}))
+
+	doOrWarn(CreateGuildApplicationCommand(ctx, CreateGuildApplicationCommandRequest{
+		Type:        ApplicationCommandTypeChatInput,
+		Name:        SlashCommandProject,
+		Description: "Look up a project on the Handmade Network",
+		Options: []ApplicationCommandOption{
+			{
+				Type:         ApplicationCommandOptionTypeString,
+				Name:         ProjectOptionName,
+				Description:  "The project's name",
+				Required:     true,
+				Autocomplete: true,
+			},
+		},
+	}))
+
+	doOrWarn(CreateGuildApplicationCommand(ctx, CreateGuildApplicationCommandRequest{
+		Type:        ApplicationCommandTypeChatInput,
+		Name:        SlashCommandJam,
+		Description: "Find out when the next jam is",
+	}))
+
+	doOrWarn(CreateGuildApplicationCommand(ctx, CreateGuildApplicationCommandRequest{
+		Type:        ApplicationCommandTypeChatInput,
+		Name:        SlashCommandEvents,
+		Description: "See what's coming up on the Handmade calendar",
+	}))
 }
 
 func (bot *botInstance) doInteraction(ctx context.Context, i *Interaction) {
@@ -99,6 +169,15 @@ func (bot *botInstance) doInteraction(ctx context.Context, i *Interaction) {
 		}
 	}()
 
+	if i.Type == InteractionTypeModalSubmit {
+		bot.doModalSubmit(ctx, i)
+		return
+	}
+	if i.Type == InteractionTypeAutocomplete {
+		bot.doAutocomplete(ctx, i)
+		return
+	}
+
 	switch i.Data.Name {
 	case SlashCommandProfile:
 		userOpt := mustGetInteractionOption(i.Data.Options, ProfileOptionUser)
@@ -106,6 +185,8 @@ func (bot *botInstance) doInteraction(ctx context.Context, i *Interaction) {
 		bot.handleProfileCommand(ctx, i, userID)
 	case UserCommandProfile:
 		bot.handleProfileCommand(ctx, i, i.Data.TargetID)
+	case MessageCommandSaveSnippet:
+		bot.handleSaveSnippetCommand(ctx, i)
 	case SlashCommandManifesto:
 		err := CreateInteractionResponse(ctx, i.ID, i.Token, InteractionResponse{
 			Type: InteractionCallbackTypeChannelMessageWithSource,
@@ -213,6 +294,18 @@ func (bot *botInstance) doInteraction(ctx context.Context, i *Interaction) {
 			sendEphemeralMessageForInteraction(ctx, i, "You are now signed up for the jam! The next step is to create a project to act as your submission. See the pinned message in #jam for more info.")
 		}
 
+	case SlashCommandTopic:
+		nameOpt := mustGetInteractionOption(i.Data.Options, TopicOptionName)
+		name := nameOpt.Value.(string)
+		bot.handleTopicCommand(ctx, i, name)
+	case SlashCommandProject:
+		nameOpt := mustGetInteractionOption(i.Data.Options, ProjectOptionName)
+		name := nameOpt.Value.(string)
+		bot.handleProjectCommand(ctx, i, name)
+	case SlashCommandJam:
+		bot.handleJamCommand(ctx, i)
+	case SlashCommandEvents:
+		bot.handleEventsCommand(ctx, i)
 	default:
 		logging.ExtractLogger(ctx).Warn().Str("name", i.Data.Name).Msg("didn't recognize Discord interaction name")
 	}
@@ -300,6 +393,489 @@ func (bot *botInstance) handleProfileCommand(ctx context.Context, i *Interaction
 	}
 }
 
+func (bot *botInstance) handleTopicCommand(ctx context.Context, i *Interaction, name string) {
+	slug := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "-")
+	topic, err := hmndata.FetchTopic(ctx, bot.dbConn, hmndata.TopicQuery{Slugs: []string{slug}})
+	if errors.Is(err, db.NotFound) {
+		// People often type the name they saw on the site, e.g. "Memory Management".
+		topic, err = hmndata.FetchTopic(ctx, bot.dbConn, hmndata.TopicQuery{Names: []string{strings.TrimSpace(name)}})
+	}
+	if err != nil {
+		if errors.Is(err, db.NotFound) {
+			err = sendEphemeralMessageForInteraction(ctx, i, fmt.Sprintf("There's no topic called \"%s\". You can browse all topics at %s.", name, hmnurl.BuildTopicIndex()))
+			if err != nil {
+				logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to send topic response")
+			}
+		} else {
+			logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to look up topic")
+		}
+		return
+	}
+
+	subtopics, err := hmndata.FetchTopics(ctx, bot.dbConn, hmndata.TopicQuery{ParentIDs: []int{topic.ID}})
+	if err != nil {
+		logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to fetch subtopics")
+	}
+
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("**%s**: %s\n", topic.Name, hmnurl.BuildTopic(topic.Slug)))
+	if description := strings.TrimSpace(topic.Description); description != "" {
+		msg.WriteString(fmt.Sprintf("%s\n", description))
+	}
+	if len(subtopics) > 0 {
+		msg.WriteString("Subtopics:\n")
+		for _, subtopic := range subtopics {
+			msg.WriteString(fmt.Sprintf("- %s: %s\n", subtopic.Name, hmnurl.BuildTopic(subtopic.Slug)))
+		}
+	}
+
+	err = CreateInteractionResponse(ctx, i.ID, i.Token, InteractionResponse{
+		Type: InteractionCallbackTypeChannelMessageWithSource,
+		Data: &InteractionCallbackData{
+			Content: msg.String(),
+		},
+	})
+	if err != nil {
+		logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to send topic response")
+	}
+}
+
+// Discord allows at most this many autocomplete choices.
+const maxAutocompleteChoices = 25
+
+func (bot *botInstance) doAutocomplete(ctx context.Context, i *Interaction) {
+	choices := []ApplicationCommandOptionChoice{}
+	switch i.Data.Name {
+	case SlashCommandProject:
+		nameOpt := mustGetInteractionOption(i.Data.Options, ProjectOptionName)
+		name := strings.TrimSpace(nameOpt.Value.(string))
+		if name == "" {
+			break
+		}
+
+		projects, err := hmndata.FetchProjects(ctx, bot.dbConn, nil, hmndata.ProjectsQuery{
+			NameQuery: name,
+			Limit:     maxAutocompleteChoices,
+			OrderBy:   "name ASC",
+		})
+		if err != nil {
+			logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to fetch projects for autocomplete")
+			break
+		}
+		for _, p := range projects {
+			choiceName := p.Project.Name
+			if utf8.RuneCountInString(choiceName) > 100 {
+				choiceName = string([]rune(choiceName)[:99]) + "…"
+			}
+			// The value is what the command gets, so we send the ID to avoid
+			// any confusion between projects with similar names.
+			choices = append(choices, ApplicationCommandOptionChoice{
+				Name:  choiceName,
+				Value: strconv.Itoa(p.Project.ID),
+			})
+		}
+	default:
+		logging.ExtractLogger(ctx).Warn().Str("name", i.Data.Name).Msg("didn't recognize Discord autocomplete name")
+	}
+
+	err := CreateInteractionResponse(ctx, i.ID, i.Token, InteractionResponse{
+		Type: InteractionCallbackTypeAutocompleteResult,
+		Data: &InteractionCallbackData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to send autocomplete response")
+	}
+}
+
+// Looks up a project by whatever the user sent: an ID if they picked an
+// autocomplete suggestion, or otherwise whatever they typed.
+func (bot *botInstance) findProjectForCommand(ctx context.Context, name string) (*hmndata.ProjectAndStuff, error) {
+	name = strings.TrimSpace(name)
+	if id, err := strconv.Atoi(name); err == nil {
+		p, err := hmndata.FetchProject(ctx, bot.dbConn, nil, id, hmndata.ProjectsQuery{})
+		if err == nil {
+			return &p, nil
+		} else if !errors.Is(err, db.NotFound) {
+			return nil, err
+		}
+		// Could be a project with a number for a name.
+	}
+
+	bySlug, err := hmndata.FetchProjects(ctx, bot.dbConn, nil, hmndata.ProjectsQuery{
+		Slugs: []string{strings.ToLower(name)},
+		Limit: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(bySlug) > 0 {
+		return &bySlug[0], nil
+	}
+
+	// A page of matches is plenty to find an exact name, or to tell that what
+	// they typed is ambiguous.
+	projects, err := hmndata.FetchProjects(ctx, bot.dbConn, nil, hmndata.ProjectsQuery{
+		NameQuery: name,
+		Limit:     maxAutocompleteChoices,
+		OrderBy:   "name ASC",
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range projects {
+		if strings.EqualFold(p.Project.Name, name) {
+			return &projects[i], nil
+		}
+	}
+	if len(projects) == 1 {
+		return &projects[0], nil
+	}
+	return nil, nil
+}
+
+func (bot *botInstance) handleProjectCommand(ctx context.Context, i *Interaction, name string) {
+	log := logging.ExtractLogger(ctx).With().Str("interaction", i.ID).Logger()
+
+	p, err := bot.findProjectForCommand(ctx, name)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to look up project")
+		err = sendEphemeralMessageForInteraction(ctx, i, "Failed to look up that project. Please contact an admin.")
+		if err != nil {
+			log.Error().Err(err).Msg("failed to send project response")
+		}
+		return
+	}
+	if p == nil {
+		err = sendEphemeralMessageForInteraction(ctx, i, fmt.Sprintf("Couldn't find a project called \"%s\". You can browse all projects at %s.", name, hmnurl.BuildProjectIndex()))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to send project response")
+		}
+		return
+	}
+
+	links, err := db.Query[models.Link](ctx, bot.dbConn,
+		`
+		SELECT $columns
+		FROM
+			link as link
+		WHERE
+			link.project_id = $1
+		ORDER BY link.ordering ASC
+		`,
+		p.Project.ID,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to fetch project links")
+	}
+
+	embed := Embed{
+		Title: utils.P(p.Project.Name),
+		Url:   utils.P(hmndata.UrlContextForProject(&p.Project).BuildHomepage()),
+	}
+	if blurb := strings.TrimSpace(p.Project.Blurb); blurb != "" {
+		embed.Description = utils.P(blurb)
+	}
+	if p.LogoAsset != nil {
+		embed.Thumbnail = &EmbedThumbnail{EmbedImageish{
+			Url: utils.P(hmnurl.BuildAssetObject(p.LogoAsset, p.LogoAsset.S3Key)),
+		}}
+	}
+	if len(p.Owners) > 0 {
+		var owners []string
+		for _, owner := range p.Owners {
+			owners = append(owners, fmt.Sprintf("[%s](%s)", owner.BestName(), hmnurl.BuildUserProfile(owner.Username)))
+		}
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:  "Owners",
+			Value: strings.Join(owners, ", "),
+		})
+	}
+	if len(links) > 0 {
+		var linkLines []string
+		for _, link := range links {
+			linkName := link.Name
+			if linkName == "" {
+				linkName = link.URL
+			}
+			linkLines = append(linkLines, fmt.Sprintf("[%s](%s)", linkName, link.URL))
+		}
+		embed.Fields = append(embed.Fields, EmbedField{
+			Name:  "Links",
+			Value: strings.Join(linkLines, "\n"),
+		})
+	}
+
+	err = CreateInteractionResponse(ctx, i.ID, i.Token, InteractionResponse{
+		Type: InteractionCallbackTypeChannelMessageWithSource,
+		Data: &InteractionCallbackData{
+			Embeds: []Embed{embed},
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to send project response")
+	}
+}
+
+func (bot *botInstance) handleJamCommand(ctx context.Context, i *Interaction) {
+	var msg string
+	if jam := hmndata.CurrentJam(); jam != nil {
+		msg = fmt.Sprintf(
+			"**%s** is happening now! It ends <t:%d:R>. %s",
+			jam.Name, jam.EndTime.Unix(), hmnurl.BuildJamGenericIndex(jam.UrlSlug),
+		)
+	} else if jam := hmndata.UpcomingJam(365 * 24 * time.Hour); jam != nil {
+		msg = fmt.Sprintf(
+			"**%s** starts <t:%d:R>, on <t:%d:F>. %s",
+			jam.Name, jam.StartTime.Unix(), jam.StartTime.Unix(), hmnurl.BuildJamGenericIndex(jam.UrlSlug),
+		)
+		if jam.WithinGrace(time.Now(), hmndata.JamProjectCreateGracePeriod, 0) {
+			msg += fmt.Sprintf("\nUse `/%s` to sign up!", SlashCommandJoinJam)
+		}
+	} else {
+		msg = fmt.Sprintf("There's no jam coming up right now. You can see all our past jams at %s.", hmnurl.BuildJamsIndex())
+	}
+
+	err := CreateInteractionResponse(ctx, i.ID, i.Token, InteractionResponse{
+		Type: InteractionCallbackTypeChannelMessageWithSource,
+		Data: &InteractionCallbackData{
+			Content: msg,
+		},
+	})
+	if err != nil {
+		logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to send jam response")
+	}
+}
+
+// How many events /events lists.
+const maxEventsInCommand = 10
+
+func (bot *botInstance) handleEventsCommand(ctx context.Context, i *Interaction) {
+	events := calendar.GetFutureEvents()
+
+	var msg strings.Builder
+	if len(events) == 0 {
+		msg.WriteString("There's nothing on the calendar right now.")
+	} else {
+		msg.WriteString("Coming up:\n")
+		for idx, ev := range events {
+			if idx >= maxEventsInCommand {
+				break
+			}
+			msg.WriteString(fmt.Sprintf("- **%s**: <t:%d:F> (<t:%d:R>)\n", ev.Name, ev.StartTime.Unix(), ev.StartTime.Unix()))
+		}
+	}
+	msg.WriteString(fmt.Sprintf("\nSee the full calendar at %s", hmnurl.BuildCalendarIndex()))
+
+	err := CreateInteractionResponse(ctx, i.ID, i.Token, InteractionResponse{
+		Type: InteractionCallbackTypeChannelMessageWithSource,
+		Data: &InteractionCallbackData{
+			Content: msg.String(),
+		},
+	})
+	if err != nil {
+		logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to send events response")
+	}
+}
+
+func (bot *botInstance) doModalSubmit(ctx context.Context, i *Interaction) {
+	modalID, args, _ := strings.Cut(i.Data.CustomID, "/")
+	switch modalID {
+	case ModalSaveSnippet:
+		channelID, messageID, ok := strings.Cut(args, "/")
+		if !ok {
+			logging.ExtractLogger(ctx).Warn().Str("customID", i.Data.CustomID).Msg("got a save snippet modal without a message")
+			return
+		}
+
+		msg, err := GetChannelMessage(ctx, channelID, messageID)
+		if err != nil {
+			if errors.Is(err, NotFound) {
+				err = sendEphemeralMessageForInteraction(ctx, i, "That message doesn't exist anymore.")
+				if err != nil {
+					logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to send save snippet response")
+				}
+			} else {
+				logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to fetch message to save as snippet")
+			}
+			return
+		}
+
+		tags, _ := GetComponentValue(i.Data.Components, SaveSnippetInputTags)
+		bot.saveSnippetForInteraction(ctx, i, msg, parseTagList(tags))
+	default:
+		logging.ExtractLogger(ctx).Warn().Str("customID", i.Data.CustomID).Msg("didn't recognize Discord modal")
+	}
+}
+
+func (bot *botInstance) handleSaveSnippetCommand(ctx context.Context, i *Interaction) {
+	log := logging.ExtractLogger(ctx).With().Str("interaction", i.ID).Logger()
+	respond := func(content string) {
+		err := sendEphemeralMessageForInteraction(ctx, i, content)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to send save snippet response")
+		}
+	}
+
+	if i.Data.Resolved == nil {
+		log.Error().Msg("Discord didn't send the message to save as a snippet")
+		return
+	}
+	msg, ok := i.Data.Resolved.Messages[i.Data.TargetID]
+	if !ok {
+		log.Error().Msg("Discord didn't send the message to save as a snippet")
+		return
+	}
+
+	hmnUser, err := hmndata.FetchUser(ctx, bot.dbConn, nil, hmndata.UsersQuery{
+		DiscordUserIDs: []string{i.Member.User.ID},
+	})
+	if errors.Is(err, db.NotFound) {
+		settingsUrl := hmnurl.BuildUserSettings("discord")
+		respond(fmt.Sprintf(
+			"You must link a Handmade Network account to your Discord account to save snippets. [Sign up](%s) and link your Discord account in settings, then try again.",
+			hmnurl.BuildLoginPage(settingsUrl, ""),
+		))
+		return
+	} else if err != nil {
+		log.Error().Err(err).Msg("failed to look up Discord user")
+		respond("Failed to look up your linked HMN account. Please contact an admin.")
+		return
+	}
+
+	if msg.Author == nil || msg.Author.ID != i.Member.User.ID {
+		respond("You can only save your own messages as snippets.")
+		return
+	}
+	if !messageIsSnippetable(&msg) {
+		respond("Snippets need an image, video, or link.")
+		return
+	}
+
+	existing, err := FetchSnippetForMessage(ctx, bot.dbConn, msg.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check for an existing snippet")
+		respond("Failed to save your snippet. Please contact an admin.")
+		return
+	} else if existing != nil {
+		respond(fmt.Sprintf("This message is already a snippet: %s", hmnurl.BuildSnippet(existing.ID)))
+		return
+	}
+
+	// Users with tagged projects get to say which projects the snippet is
+	// for. Everyone else has nothing to choose, so we just save it.
+	projectTags, err := db.QueryScalar[string](ctx, bot.dbConn,
+		`
+		SELECT tag.text
+		FROM
+			tag
+			JOIN project ON project.tag = tag.id
+			JOIN user_project ON user_project.project_id = project.id
+		WHERE user_project.user_id = $1
+		ORDER BY tag.text
+		`,
+		hmnUser.ID,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to fetch user's project tags")
+	}
+	if len(projectTags) == 0 {
+		bot.saveSnippetForInteraction(ctx, i, &msg, nil)
+		return
+	}
+
+	placeholder := "e.g. &" + strings.Join(projectTags, ", &")
+	if utf8.RuneCountInString(placeholder) > 100 {
+		placeholder = string([]rune(placeholder)[:99]) + "…"
+	}
+	err = CreateInteractionResponse(ctx, i.ID, i.Token, InteractionResponse{
+		Type: InteractionCallbackTypeModal,
+		Data: &InteractionCallbackData{
+			CustomID: fmt.Sprintf("%s/%s/%s", ModalSaveSnippet, msg.ChannelID, msg.ID),
+			Title:    "Save to Handmade Network",
+			Components: []Component{{
+				Type: ComponentTypeActionRow,
+				Components: []Component{{
+					Type:        ComponentTypeTextInput,
+					CustomID:    SaveSnippetInputTags,
+					Style:       TextInputStyleShort,
+					Label:       "Project tags (optional)",
+					Placeholder: placeholder,
+					Required:    utils.P(false),
+					MaxLength:   200,
+				}},
+			}},
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to send save snippet modal")
+	}
+}
+
+// Saves a message as a snippet and tells the user where to find it. The tags
+// are for projects beyond any tagged in the message itself.
+func (bot *botInstance) saveSnippetForInteraction(ctx context.Context, i *Interaction, msg *Message, tags []string) {
+	log := logging.ExtractLogger(ctx).With().Str("interaction", i.ID).Logger()
+
+	if msg.Author == nil || msg.Author.ID != i.Member.User.ID {
+		err := sendEphemeralMessageForInteraction(ctx, i, "You can only save your own messages as snippets.")
+		if err != nil {
+			log.Error().Err(err).Msg("failed to send save snippet response")
+		}
+		return
+	}
+
+	// Saving attachments can take longer than Discord will wait for a
+	// response, so we reply for real once we're done.
+	err := CreateInteractionResponse(ctx, i.ID, i.Token, InteractionResponse{
+		Type: InteractionCallbackTypeDeferredChannelMessageWithSource,
+		Data: &InteractionCallbackData{
+			Flags: FlagEphemeral,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to defer save snippet response")
+		return
+	}
+
+	var reply string
+	snippet, unknownTags, err := SaveSnippetFromMessage(ctx, bot.dbConn, msg, tags)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to save snippet from Discord message")
+		reply = "Failed to save your snippet. Please contact an admin."
+	} else {
+		reply = fmt.Sprintf("Saved! Your snippet is at %s", hmnurl.BuildSnippet(snippet.ID))
+		if len(unknownTags) > 0 {
+			reply += fmt.Sprintf(
+				"\n\nNone of your projects have the tag &%s. Set a Discord tag in your project's settings on the Handmade Network website, then add it to the snippet there.",
+				strings.Join(unknownTags, ", &"),
+			)
+		}
+	}
+
+	payload, err := json.Marshal(CreateMessageRequest{Content: reply})
+	if err != nil {
+		panic(err)
+	}
+	_, err = EditOriginalInteractionResponse(ctx, i.Token, string(payload))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to send save snippet response")
+	}
+}
+
+// Parses tags typed in by a user, e.g. "&hmn, &orca" or "hmn orca".
+func parseTagList(s string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		tag = strings.ToLower(strings.TrimPrefix(tag, "&"))
+		if tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
 func sendEphemeralMessageForInteraction(ctx context.Context, i *Interaction, content string) error {
 	err := CreateInteractionResponse(ctx, i.ID, i.Token, InteractionResponse{
 		Type: InteractionCallbackTypeChannelMessageWithSource,
@@ -0,0 +1,247 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/jobs"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// How a fishbowl import running in the background is getting on. Imports are
+// only tracked in memory, since one that is cut short by a restart can just be
+// started again.
+type FishbowlImport struct {
+	Running    bool
+	Added      int
+	Total      int // New messages in the thread, once they have all been fetched
+	Error      error
+	FinishedAt time.Time
+}
+
+var fishbowlImportsMutex sync.Mutex
+var fishbowlImports = make(map[int]*FishbowlImport)
+
+// Starts importing a fishbowl's thread in the background. Returns false if an
+// import of that fishbowl is already running.
+func StartFishbowlImport(conn *pgxpool.Pool, fishbowl *models.Fishbowl, uploaderID int) bool {
+	fishbowlImportsMutex.Lock()
+	defer fishbowlImportsMutex.Unlock()
+	if current := fishbowlImports[fishbowl.ID]; current != nil && current.Running {
+		return false
+	}
+	status := &FishbowlImport{Running: true}
+	fishbowlImports[fishbowl.ID] = status
+
+	job := jobs.New("fishbowl import")
+	go func() {
+		defer job.Finish()
+		log := job.Logger.With().Str("fishbowl", fishbowl.Slug).Logger()
+		ctx := logging.AttachLoggerToContext(&log, job.Ctx)
+
+		_, err := ImportFishbowlThread(ctx, conn, fishbowl, uploaderID, func(added, total int) {
+			fishbowlImportsMutex.Lock()
+			defer fishbowlImportsMutex.Unlock()
+			status.Added = added
+			status.Total = total
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to import fishbowl")
+		}
+
+		fishbowlImportsMutex.Lock()
+		defer fishbowlImportsMutex.Unlock()
+		status.Running = false
+		status.Error = err
+		status.FinishedAt = time.Now()
+	}()
+
+	return true
+}
+
+// Returns the progress of the latest import of a fishbowl since the server
+// started, or nil if there hasn't been one.
+func FishbowlImportProgress(fishbowlID int) *FishbowlImport {
+	fishbowlImportsMutex.Lock()
+	defer fishbowlImportsMutex.Unlock()
+	status := fishbowlImports[fishbowlID]
+	if status == nil {
+		return nil
+	}
+	res := *status
+	return &res
+}
+
+/*
+Copies the messages from a fishbowl's Discord thread into the database, saving
+attachments and avatars as assets. Messages that have already been imported are
+left alone, so staff edits survive, and new ones are added to the end. Returns
+the number of messages added.
+
+This downloads every attachment in the thread, so it can take a while; see
+StartFishbowlImport. If progress is not nil, it is called after each message.
+*/
+func ImportFishbowlThread(ctx context.Context, dbConn db.ConnOrTx, fishbowl *models.Fishbowl, uploaderID int, progress func(added, total int)) (int, error) {
+	if fishbowl.ThreadID == nil {
+		return 0, oops.New(nil, "fishbowl %s has no Discord thread", fishbowl.Slug)
+	}
+	log := logging.ExtractLogger(ctx).With().Str("fishbowl", fishbowl.Slug).Logger()
+
+	var msgs []Message
+	before := ""
+	for {
+		page, err := GetChannelMessages(ctx, *fishbowl.ThreadID, GetChannelMessagesInput{
+			Limit:  100,
+			Before: before,
+		})
+		if err != nil {
+			return 0, oops.New(err, "failed to fetch messages from fishbowl thread")
+		}
+		if len(page) == 0 {
+			break
+		}
+		msgs = append(msgs, page...)
+		before = page[len(page)-1].ID
+	}
+	slices.SortFunc(msgs, func(a, b Message) int {
+		return a.Time().Compare(b.Time())
+	})
+
+	type existingRow struct {
+		MessageID string `db:"message_id"`
+		Ordering  int    `db:"ordering"`
+	}
+	existing, err := db.Query[existingRow](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM fishbowl_message
+		WHERE fishbowl_id = $1
+		`,
+		fishbowl.ID,
+	)
+	if err != nil {
+		return 0, oops.New(err, "failed to fetch existing fishbowl messages")
+	}
+	imported := make(map[string]bool)
+	ordering := 0
+	for _, row := range existing {
+		imported[row.MessageID] = true
+		ordering = max(ordering, row.Ordering+1)
+	}
+
+	msgs = slices.DeleteFunc(msgs, func(msg Message) bool {
+		return imported[msg.ID] || msg.Author == nil || (msg.Type != MessageTypeDefault && msg.Type != MessageTypeReply)
+	})
+	if progress != nil {
+		progress(0, len(msgs))
+	}
+
+	authorNames := make(map[string]string)
+	authorAvatars := make(map[string]*uuid.UUID)
+	added := 0
+	for _, msg := range msgs {
+
+		if _, ok := authorNames[msg.Author.ID]; !ok {
+			authorNames[msg.Author.ID] = msg.Author.Username
+			member, err := GetGuildMember(ctx, config.Config.Discord.GuildID, msg.Author.ID)
+			if err == nil {
+				authorNames[msg.Author.ID] = member.DisplayName()
+			} else if !errors.Is(err, NotFound) {
+				log.Warn().Err(err).Str("user", msg.Author.ID).Msg("failed to fetch fishbowl participant")
+			}
+
+			if msg.Author.Avatar != nil {
+				avatar, err := SaveAvatar(ctx, dbConn, msg.Author.ID, *msg.Author.Avatar)
+				if err == nil {
+					authorAvatars[msg.Author.ID] = &avatar.ID
+				} else {
+					// The default avatar will do.
+					log.Warn().Err(err).Str("user", msg.Author.ID).Msg("failed to save fishbowl participant avatar")
+				}
+			}
+		}
+
+		err := importFishbowlMessage(ctx, dbConn, fishbowl, &msg, authorNames[msg.Author.ID], authorAvatars[msg.Author.ID], ordering, uploaderID)
+		if err != nil {
+			return added, err
+		}
+		ordering++
+		added++
+		if progress != nil {
+			progress(added, len(msgs))
+		}
+	}
+
+	return added, nil
+}
+
+func importFishbowlMessage(
+	ctx context.Context,
+	dbConn db.ConnOrTx,
+	fishbowl *models.Fishbowl,
+	msg *Message,
+	authorName string,
+	avatarAssetID *uuid.UUID,
+	ordering int,
+	uploaderID int,
+) error {
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return oops.New(err, "failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx,
+		`
+		INSERT INTO fishbowl_message (fishbowl_id, message_id, author_id, author_name, author_avatar_asset_id, content, sent_at, ordering)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+		`,
+		fishbowl.ID,
+		msg.ID,
+		msg.Author.ID,
+		authorName,
+		avatarAssetID,
+		CleanUpMarkdown(ctx, msg.Content),
+		msg.Time(),
+		ordering,
+	).Scan(&id)
+	if err != nil {
+		return oops.New(err, "failed to save fishbowl message")
+	}
+
+	for i, attachment := range msg.Attachments {
+		asset, err := saveAttachmentAsset(ctx, tx, &attachment, uploaderID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx,
+			`
+			INSERT INTO fishbowl_message_attachment (message_id, asset_id, ordering)
+			VALUES ($1, $2, $3)
+			`,
+			id,
+			asset.ID,
+			i,
+		)
+		if err != nil {
+			return oops.New(err, "failed to save fishbowl attachment")
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return oops.New(err, "failed to commit fishbowl message")
+	}
+	return nil
+}
@@ -0,0 +1,903 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/hmndata"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/jobs"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/templates"
+	"git.handmade.network/hmn/hmn/src/utils"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+The forum bridge mirrors threads in some subforums to Discord forum channels,
+as configured in ForumBridges. When a thread is started on the site, the bot
+starts a post in the forum channel, and the conversation is kept together from
+then on:
+
+  - Posts made on the site are sent to Discord through a webhook, under the
+    author's name and avatar. Edits, deletes, and title changes follow them.
+  - Messages in the Discord post from linked accounts become posts on the site,
+    and their edits and deletes follow them too. Messages from people who
+    haven't linked their account stay on Discord.
+
+Every bridged post remembers which side it came from, and posts are only ever
+copied away from where they were written, so nothing echoes back. Messages
+sent by webhooks or bots are never copied to the site at all.
+
+Like the role sync, changes on the site queue their thread to be synced soon,
+and a sweep every hour catches anything that was missed. Each sync compares
+the thread on the site against what we last sent to Discord, so syncing a
+thread twice is harmless.
+*/
+
+// Threads older than this are never started on Discord, so that bridging an
+// existing subforum doesn't flood the forum channel with old threads.
+const forumBridgeNewThreadWindow = 24 * time.Hour
+
+const forumBridgeWebhookName = "Handmade Network"
+
+// https://discord.com/developers/docs/resources/channel#create-message-jsonform-params
+const maxMessageLength = 2000
+
+var forumBridgeQueue struct {
+	sync.Mutex
+	threadIDs map[int]bool
+}
+var forumBridgeQueued = make(chan struct{}, 1)
+
+// Asks the forum bridge to sync some threads soon. Call this after changing
+// anything about a thread or its posts. Threads that aren't bridged are
+// ignored.
+func QueueForumBridge(threadIDs ...int) {
+	if config.Config.Discord.BotToken == "" || len(config.Config.Discord.ForumBridges) == 0 || len(threadIDs) == 0 {
+		return
+	}
+
+	forumBridgeQueue.Lock()
+	if forumBridgeQueue.threadIDs == nil {
+		forumBridgeQueue.threadIDs = make(map[int]bool)
+	}
+	for _, id := range threadIDs {
+		forumBridgeQueue.threadIDs[id] = true
+	}
+	forumBridgeQueue.Unlock()
+
+	select {
+	case forumBridgeQueued <- struct{}{}:
+	default:
+	}
+}
+
+func takeQueuedForumBridges() []int {
+	forumBridgeQueue.Lock()
+	defer forumBridgeQueue.Unlock()
+
+	var ids []int
+	for id := range forumBridgeQueue.threadIDs {
+		ids = append(ids, id)
+	}
+	forumBridgeQueue.threadIDs = nil
+	slices.Sort(ids)
+	return ids
+}
+
+func RunForumBridge(dbConn *pgxpool.Pool) *jobs.Job {
+	job := jobs.New("discord forum bridge")
+	log := job.Logger
+
+	if config.Config.Discord.BotToken == "" {
+		log.Warn().Msg("No Discord bot token was provided, so forums will not be bridged to Discord.")
+		return job.Finish()
+	}
+	if len(config.Config.Discord.ForumBridges) == 0 {
+		return job.Finish()
+	}
+
+	go func() {
+		defer func() {
+			log.Debug().Msg("shut down Discord forum bridge")
+			job.Finish()
+		}()
+
+		sweepFirstRun := make(chan struct{}, 1)
+		sweepFirstRun <- struct{}{}
+		sweepTicker := time.NewTicker(1 * time.Hour)
+		defer sweepTicker.Stop()
+
+		syncThreads := func(threadIDs []int) {
+			for _, id := range threadIDs {
+				err := SyncBridgedThread(job.Ctx, dbConn, id)
+				if err != nil {
+					log.Error().Err(err).Int("thread", id).Msg("failed to sync bridged forum thread")
+				}
+			}
+		}
+		sweep := func() {
+			threadIDs, err := forumBridgeSweepThreadIDs(job.Ctx, dbConn)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to find forum threads to bridge")
+				return
+			}
+			syncThreads(threadIDs)
+		}
+
+		for {
+			done, err := func() (done bool, err error) {
+				defer utils.RecoverPanicAsError(&err)
+				select {
+				case <-job.Canceled():
+					return true, nil
+				case <-sweepFirstRun:
+					sweep()
+				case <-sweepTicker.C:
+					sweep()
+				case <-forumBridgeQueued:
+					syncThreads(takeQueuedForumBridges())
+				}
+				return false, nil
+			}()
+			if err != nil {
+				log.Error().Err(err).Msg("Panicked in RunForumBridge")
+			} else if done {
+				return
+			}
+		}
+	}()
+
+	return job
+}
+
+// The threads the hourly sweep looks at: every thread that is still bridged,
+// including deleted ones so that the deletion reaches Discord, and any new
+// threads that should be.
+func forumBridgeSweepThreadIDs(ctx context.Context, dbConn db.ConnOrTx) ([]int, error) {
+	var subforumIDs []int
+	for id := range config.Config.Discord.ForumBridges {
+		subforumIDs = append(subforumIDs, id)
+	}
+
+	threadIDs, err := db.QueryScalar[int](ctx, dbConn,
+		`
+		SELECT thread.id
+		FROM
+			thread
+			LEFT JOIN discord_forum_thread AS bridge ON bridge.thread_id = thread.id
+			LEFT JOIN post AS first_post ON first_post.id = thread.first_id
+		WHERE
+			thread.type = $1
+			AND thread.subforum_id = ANY ($2)
+			AND (
+				(bridge.thread_id IS NOT NULL AND NOT bridge.discord_deleted)
+				OR (bridge.thread_id IS NULL AND NOT thread.deleted AND first_post.postdate > $3)
+			)
+		ORDER BY thread.id
+		`,
+		models.ThreadTypeForumPost,
+		subforumIDs,
+		time.Now().Add(-forumBridgeNewThreadWindow),
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch bridged forum threads")
+	}
+	return threadIDs, nil
+}
+
+/*
+Brings a thread's Discord post in line with the site. New threads get a Discord
+post once their first post is public. After that, public posts from the site
+are sent, edited, and deleted to match, and the post is renamed when the
+thread is. Deleting the thread deletes the Discord post, along with any
+messages in it.
+
+If the Discord post was deleted by a moderator, the thread is left alone from
+then on.
+*/
+func SyncBridgedThread(ctx context.Context, dbConn db.ConnOrTx, threadID int) error {
+	log := logging.ExtractLogger(ctx).With().Int("thread", threadID).Logger()
+
+	thread, err := db.QueryOne[models.Thread](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM thread
+		WHERE id = $1
+		`,
+		threadID,
+	)
+	if errors.Is(err, db.NotFound) {
+		return nil
+	} else if err != nil {
+		return oops.New(err, "failed to fetch thread")
+	}
+	if thread.Type != models.ThreadTypeForumPost || thread.SubforumID == nil {
+		return nil
+	}
+	forumID := config.Config.Discord.ForumBridges[*thread.SubforumID]
+	if forumID == "" {
+		return nil
+	}
+
+	bridge, err := db.QueryOne[models.DiscordForumThread](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM discord_forum_thread
+		WHERE thread_id = $1
+		`,
+		thread.ID,
+	)
+	if errors.Is(err, db.NotFound) {
+		bridge = nil
+	} else if err != nil {
+		return oops.New(err, "failed to fetch bridged thread")
+	}
+	if bridge != nil {
+		if bridge.DiscordDeleted {
+			return nil
+		}
+		forumID = bridge.ChannelID
+	}
+
+	if thread.Deleted {
+		if bridge == nil {
+			return nil
+		}
+		err := DeleteChannel(ctx, bridge.DiscordThreadID)
+		if err != nil && !errors.Is(err, NotFound) {
+			return oops.New(err, "failed to delete Discord forum post")
+		}
+		// Posts in deleted threads stick around, so their rows have to be
+		// cleaned up by hand.
+		_, err = dbConn.Exec(ctx,
+			`
+			DELETE FROM discord_forum_post
+			WHERE post_id IN (SELECT id FROM post WHERE thread_id = $1)
+			`,
+			thread.ID,
+		)
+		if err != nil {
+			return oops.New(err, "failed to delete bridged posts")
+		}
+		_, err = dbConn.Exec(ctx,
+			`
+			DELETE FROM discord_forum_thread
+			WHERE thread_id = $1
+			`,
+			thread.ID,
+		)
+		if err != nil {
+			return oops.New(err, "failed to delete bridged thread")
+		}
+		log.Info().Msg("Deleted bridged forum thread from Discord")
+		return nil
+	}
+
+	// Only public posts are sent to Discord.
+	posts, err := hmndata.FetchPosts(ctx, dbConn, nil, hmndata.PostsQuery{
+		ThreadIDs:   []int{thread.ID},
+		ThreadTypes: []models.ThreadType{models.ThreadTypeForumPost},
+	})
+	if err != nil {
+		return oops.New(err, "failed to fetch posts for bridged thread")
+	}
+
+	type bridgedPost struct {
+		Bridged models.DiscordForumPost `db:"dpost"`
+		Deleted bool                    `db:"post.deleted"`
+	}
+	bridgedPosts, err := db.Query[bridgedPost](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM
+			discord_forum_post AS dpost
+			JOIN post ON post.id = dpost.post_id
+		WHERE post.thread_id = $1
+		`,
+		thread.ID,
+	)
+	if err != nil {
+		return oops.New(err, "failed to fetch bridged posts")
+	}
+	bridgedByPostID := make(map[int]*models.DiscordForumPost, len(bridgedPosts))
+	for _, p := range bridgedPosts {
+		bridgedByPostID[p.Bridged.PostID] = &p.Bridged
+	}
+
+	if bridge == nil && (len(posts) == 0 || posts[0].Post.ID != thread.FirstID || time.Since(posts[0].Post.PostDate) > forumBridgeNewThreadWindow) {
+		// Either the first post isn't public yet, or it's too late to start.
+		return nil
+	}
+
+	webhook, err := forumBridgeWebhook(ctx, dbConn, forumID)
+	if err != nil {
+		return err
+	}
+
+	lineageBuilder := models.MakeSubforumLineageBuilder(models.GetFullSubforumTree(ctx, dbConn))
+	subforumSlugs := lineageBuilder.GetSubforumLineageSlugs(*thread.SubforumID)
+	postRequest := func(p *hmndata.PostAndStuff) ExecuteWebhookRequest {
+		postUrl := hmndata.UrlContextForProject(&p.Project).BuildForumPost(subforumSlugs, thread.ID, p.Post.ID)
+		return forumBridgeRequest(p, postUrl, p.Post.ID == thread.FirstID)
+	}
+
+	// Webhooks get a 404 both when their thread is gone and when they are. If
+	// it was the webhook, forget it and try again with a new one next time.
+	handleNotFound := func() error {
+		_, chanErr := GetChannel(ctx, bridge.DiscordThreadID)
+		if chanErr == nil {
+			_, err := dbConn.Exec(ctx,
+				`
+				DELETE FROM discord_webhook
+				WHERE channel_id = $1
+				`,
+				forumID,
+			)
+			if err != nil {
+				return oops.New(err, "failed to forget Discord webhook")
+			}
+			return oops.New(nil, "the forum bridge's webhook was deleted")
+		} else if !errors.Is(chanErr, NotFound) {
+			return oops.New(chanErr, "failed to check for Discord forum post")
+		}
+
+		_, err := dbConn.Exec(ctx,
+			`
+			UPDATE discord_forum_thread
+			SET discord_deleted = TRUE
+			WHERE thread_id = $1
+			`,
+			thread.ID,
+		)
+		if err != nil {
+			return oops.New(err, "failed to mark bridged thread as deleted on Discord")
+		}
+		log.Info().Msg("Bridged forum post was deleted on Discord; no longer syncing it")
+		return nil
+	}
+
+	if bridge == nil {
+		op := &posts[0]
+		req := postRequest(op)
+		req.ThreadName = truncateRunes(thread.Title, 100)
+		msg, err := ExecuteWebhook(ctx, webhook, "", req)
+		if err != nil {
+			return oops.New(err, "failed to start Discord forum post")
+		}
+
+		bridge = &models.DiscordForumThread{
+			ThreadID:        thread.ID,
+			ChannelID:       forumID,
+			DiscordThreadID: msg.ChannelID,
+			Title:           thread.Title,
+		}
+		_, err = dbConn.Exec(ctx,
+			`
+			INSERT INTO discord_forum_thread (thread_id, channel_id, discord_thread_id, title)
+			VALUES ($1, $2, $3, $4)
+			`,
+			bridge.ThreadID,
+			bridge.ChannelID,
+			bridge.DiscordThreadID,
+			bridge.Title,
+		)
+		if err != nil {
+			// Without a record of it, the next sync would start the post
+			// over again, so take this one back down.
+			delErr := DeleteChannel(ctx, bridge.DiscordThreadID)
+			if delErr != nil && !errors.Is(delErr, NotFound) {
+				log.Error().Err(delErr).Str("discord thread", bridge.DiscordThreadID).Msg("Failed to delete unsaved Discord forum post")
+			}
+			return oops.New(err, "failed to save bridged thread")
+		}
+
+		bridged := models.DiscordForumPost{
+			PostID:    op.Post.ID,
+			MessageID: msg.ID,
+			VersionID: op.Post.CurrentID,
+		}
+		err = saveBridgedPost(ctx, dbConn, bridged)
+		if err != nil {
+			return err
+		}
+		bridgedByPostID[bridged.PostID] = &bridged
+		log.Info().Str("discord thread", bridge.DiscordThreadID).Msg("Started bridged forum post on Discord")
+	}
+
+	if bridge.Title != thread.Title {
+		name := truncateRunes(thread.Title, 100)
+		_, err := ModifyChannel(ctx, bridge.DiscordThreadID, ModifyChannelRequest{Name: &name})
+		if errors.Is(err, NotFound) {
+			return handleNotFound()
+		} else if err != nil {
+			return oops.New(err, "failed to rename Discord forum post")
+		}
+		_, err = dbConn.Exec(ctx,
+			`
+			UPDATE discord_forum_thread
+			SET title = $2
+			WHERE thread_id = $1
+			`,
+			thread.ID,
+			thread.Title,
+		)
+		if err != nil {
+			return oops.New(err, "failed to save bridged thread title")
+		}
+	}
+
+	// A post that fails to sync is logged and skipped, so that it doesn't
+	// hold up the rest of the thread. The next sync tries it again.
+	public := make(map[int]bool, len(posts))
+	for i := range posts {
+		p := &posts[i]
+		public[p.Post.ID] = true
+
+		bridged := bridgedByPostID[p.Post.ID]
+		if bridged == nil {
+			msg, err := ExecuteWebhook(ctx, webhook, bridge.DiscordThreadID, postRequest(p))
+			if errors.Is(err, NotFound) {
+				return handleNotFound()
+			} else if err != nil {
+				log.Error().Err(err).Int("post", p.Post.ID).Msg("Failed to send post to Discord")
+				continue
+			}
+			err = saveBridgedPost(ctx, dbConn, models.DiscordForumPost{
+				PostID:    p.Post.ID,
+				MessageID: msg.ID,
+				VersionID: p.Post.CurrentID,
+			})
+			if err != nil {
+				log.Error().Err(err).Int("post", p.Post.ID).Msg("Failed to save bridged post")
+				continue
+			}
+		} else if !bridged.FromDiscord && bridged.VersionID != p.Post.CurrentID {
+			// If a moderator deleted the message on Discord, it stays deleted.
+			_, err := EditWebhookMessage(ctx, webhook, bridge.DiscordThreadID, bridged.MessageID, postRequest(p))
+			if err != nil && !errors.Is(err, NotFound) {
+				log.Error().Err(err).Int("post", p.Post.ID).Msg("Failed to edit post on Discord")
+				continue
+			}
+			_, err = dbConn.Exec(ctx,
+				`
+				UPDATE discord_forum_post
+				SET version_id = $2
+				WHERE post_id = $1
+				`,
+				p.Post.ID,
+				p.Post.CurrentID,
+			)
+			if err != nil {
+				log.Error().Err(err).Int("post", p.Post.ID).Msg("Failed to save bridged post version")
+				continue
+			}
+		}
+	}
+
+	for _, p := range bridgedPosts {
+		if public[p.Bridged.PostID] {
+			continue
+		}
+
+		var err error
+		if p.Bridged.FromDiscord {
+			// Posts from Discord can be hidden on the site, e.g. if their
+			// author isn't approved yet. Only deleting them counts.
+			if !p.Deleted {
+				continue
+			}
+			err = DeleteMessage(ctx, bridge.DiscordThreadID, p.Bridged.MessageID)
+		} else {
+			err = DeleteWebhookMessage(ctx, webhook, bridge.DiscordThreadID, p.Bridged.MessageID)
+		}
+		if err != nil && !errors.Is(err, NotFound) {
+			log.Error().Err(err).Int("post", p.Bridged.PostID).Msg("Failed to delete post from Discord")
+			continue
+		}
+
+		_, err = dbConn.Exec(ctx,
+			`
+			DELETE FROM discord_forum_post
+			WHERE post_id = $1
+			`,
+			p.Bridged.PostID,
+		)
+		if err != nil {
+			log.Error().Err(err).Int("post", p.Bridged.PostID).Msg("Failed to delete bridged post")
+			continue
+		}
+	}
+
+	return nil
+}
+
+// Fetches the bot's webhook for a channel, making one if necessary.
+func forumBridgeWebhook(ctx context.Context, dbConn db.ConnOrTx, channelID string) (*Webhook, error) {
+	existing, err := db.QueryOne[models.DiscordWebhook](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM discord_webhook
+		WHERE channel_id = $1
+		`,
+		channelID,
+	)
+	if err == nil {
+		return &Webhook{
+			ID:        existing.WebhookID,
+			ChannelID: existing.ChannelID,
+			Token:     existing.Token,
+		}, nil
+	} else if !errors.Is(err, db.NotFound) {
+		return nil, oops.New(err, "failed to fetch Discord webhook")
+	}
+
+	webhook, err := CreateWebhook(ctx, channelID, forumBridgeWebhookName)
+	if err != nil {
+		return nil, oops.New(err, "failed to create Discord webhook")
+	}
+	_, err = dbConn.Exec(ctx,
+		`
+		INSERT INTO discord_webhook (channel_id, webhook_id, token)
+		VALUES ($1, $2, $3)
+		`,
+		channelID,
+		webhook.ID,
+		webhook.Token,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to save Discord webhook")
+	}
+	return webhook, nil
+}
+
+func saveBridgedPost(ctx context.Context, dbConn db.ConnOrTx, p models.DiscordForumPost) error {
+	_, err := dbConn.Exec(ctx,
+		`
+		INSERT INTO discord_forum_post (post_id, message_id, from_discord, version_id, attachments)
+		VALUES ($1, $2, $3, $4, $5)
+		`,
+		p.PostID,
+		p.MessageID,
+		p.FromDiscord,
+		p.VersionID,
+		p.Attachments,
+	)
+	if err != nil {
+		return oops.New(err, "failed to save bridged post")
+	}
+	return nil
+}
+
+// What to send to Discord for a post. Long posts are cut short with a link to
+// the rest, and the first post always links back to the thread on the site.
+// Mentions are never pinged.
+func forumBridgeRequest(p *hmndata.PostAndStuff, postUrl string, first bool) ExecuteWebhookRequest {
+	username := "Deleted user"
+	if p.Author != nil {
+		username = p.Author.BestName()
+	}
+
+	content := strings.TrimSpace(p.CurrentVersion.TextRaw)
+	footer := ""
+	if first {
+		footer = fmt.Sprintf("\n\n-# Posted on Handmade Network: <%s>", postUrl)
+	}
+	if content == "" || len([]rune(content))+len([]rune(footer)) > maxMessageLength {
+		footer = fmt.Sprintf("\n\n-# Continued on Handmade Network: <%s>", postUrl)
+		content = truncateRunes(content, maxMessageLength-len([]rune(footer)))
+	}
+
+	return ExecuteWebhookRequest{
+		Content:         content + footer,
+		Username:        truncateRunes(webhookUsername(username), 80),
+		AvatarUrl:       templates.UserAvatarUrl(p.Author),
+		AllowedMentions: &MessageAllowedMentions{Parse: []MentionType{}},
+	}
+}
+
+// Discord rejects webhook messages whose username contains "discord" or
+// "clyde", in any case, so those are cut out.
+// https://discord.com/developers/docs/resources/webhook#execute-webhook
+var forbiddenWebhookUsernameRegex = regexp.MustCompile(`(?i)discord|clyde`)
+
+func webhookUsername(name string) string {
+	// Cutting one out can make another, e.g. "discdiscordord".
+	for forbiddenWebhookUsernameRegex.MatchString(name) {
+		name = forbiddenWebhookUsernameRegex.ReplaceAllString(name, "")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Handmade Network user"
+	}
+	return name
+}
+
+/*
+Turns a message in a bridged Discord post into a post on the site, or edits
+the post to match if the message was edited. Only messages from linked
+accounts that can post on the site are copied, and never into locked or
+deleted threads.
+
+Attachments are saved as assets when the post is made and linked at the end of
+the post. Discord doesn't allow adding attachments in an edit, so edits keep
+the ones from the start.
+*/
+func HandleBridgedMessage(ctx context.Context, dbConn db.ConnOrTx, msg *Message) (err error) {
+	defer utils.RecoverPanicAsError(&err)
+
+	if msg.WebhookID != nil || (msg.Author != nil && msg.Author.IsBot) {
+		return nil
+	}
+	if msg.OriginalHasFields("type") && !slices.Contains(trackedTypes, msg.Type) {
+		return nil
+	}
+
+	// Almost every message is outside a bridged thread, so find that out
+	// before doing anything else.
+	bridge, err := db.QueryOne[models.DiscordForumThread](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM discord_forum_thread
+		WHERE discord_thread_id = $1 AND NOT discord_deleted
+		`,
+		msg.ChannelID,
+	)
+	if errors.Is(err, db.NotFound) {
+		return nil
+	} else if err != nil {
+		return oops.New(err, "failed to fetch bridged thread")
+	}
+
+	thread, err := db.QueryOne[models.Thread](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM thread
+		WHERE id = $1
+		`,
+		bridge.ThreadID,
+	)
+	if err != nil {
+		return oops.New(err, "failed to fetch bridged thread")
+	}
+	if thread.Deleted {
+		return nil
+	}
+
+	existing, err := db.QueryOne[models.DiscordForumPost](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM discord_forum_post
+		WHERE message_id = $1
+		`,
+		msg.ID,
+	)
+	if err == nil {
+		if !existing.FromDiscord || !msg.OriginalHasFields("content") {
+			return nil
+		}
+		return editBridgedPost(ctx, dbConn, msg, existing)
+	} else if !errors.Is(err, db.NotFound) {
+		return oops.New(err, "failed to fetch bridged post")
+	}
+
+	if !msg.OriginalHasFields("author", "content") || thread.Locked {
+		return nil
+	}
+
+	hmnUser, err := db.QueryOne[models.User](ctx, dbConn,
+		`
+		SELECT $columns{hmn_user}
+		FROM
+			discord_user AS duser
+			JOIN hmn_user ON duser.hmn_user_id = hmn_user.id
+		WHERE
+			duser.userid = $1
+			AND hmn_user.status = ANY ($2)
+		`,
+		msg.Author.ID,
+		[]models.UserStatus{models.UserStatusConfirmed, models.UserStatusApproved},
+	)
+	if errors.Is(err, db.NotFound) {
+		return nil
+	} else if err != nil {
+		return oops.New(err, "failed to fetch linked user")
+	}
+
+	// Attachments are downloaded before the transaction starts so that slow
+	// downloads don't hold it open. If the post never gets saved, the assets
+	// are left unreferenced and cleaned up like any other.
+	attachments, err := saveBridgedAttachments(ctx, dbConn, msg, hmnUser.ID)
+	if err != nil {
+		return err
+	}
+	content := bridgedPostContent(ctx, msg.Content, attachments)
+	if content == "" {
+		// e.g. just a sticker
+		return nil
+	}
+
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return oops.New(err, "failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	// If another event for the same message got here first, saving the
+	// bridged post fails on its unique message ID and nothing is posted.
+	postID, versionID := hmndata.CreateNewPost(ctx, tx, thread.ProjectID, thread.ID, thread.Type, hmnUser.ID, nil, content, "")
+	err = saveBridgedPost(ctx, tx, models.DiscordForumPost{
+		PostID:      postID,
+		MessageID:   msg.ID,
+		FromDiscord: true,
+		VersionID:   versionID,
+		Attachments: attachments,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return oops.New(err, "failed to save post from Discord")
+	}
+	return nil
+}
+
+// Edits a post from Discord to match its edited message.
+func editBridgedPost(ctx context.Context, dbConn db.ConnOrTx, msg *Message, existing *models.DiscordForumPost) error {
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return oops.New(err, "failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	type postInfo struct {
+		Post    models.Post `db:"post"`
+		TextRaw string      `db:"ver.text_raw"`
+	}
+	info, err := db.QueryOne[postInfo](ctx, tx,
+		`
+		SELECT $columns
+		FROM
+			post
+			JOIN post_version AS ver ON ver.id = post.current_id
+		WHERE post.id = $1
+		`,
+		existing.PostID,
+	)
+	if err != nil {
+		return oops.New(err, "failed to fetch bridged post")
+	}
+
+	content := bridgedPostContent(ctx, msg.Content, existing.Attachments)
+	if info.Post.Deleted || content == "" || content == info.TextRaw {
+		return nil
+	}
+
+	versionID := hmndata.CreatePostVersion(ctx, tx, info.Post.ID, content, "", "Edited on Discord", info.Post.AuthorID)
+	_, err = tx.Exec(ctx,
+		`
+		UPDATE discord_forum_post
+		SET version_id = $2
+		WHERE post_id = $1
+		`,
+		info.Post.ID,
+		versionID,
+	)
+	if err != nil {
+		return oops.New(err, "failed to save bridged post version")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return oops.New(err, "failed to save post from Discord")
+	}
+	return nil
+}
+
+/*
+Deletes the post for a deleted message in a bridged Discord post. If the
+message was one the bot sent for a post on the site, the post is left alone,
+and it won't be sent again.
+*/
+func HandleBridgedMessageDelete(ctx context.Context, dbConn db.ConnOrTx, messageID string) (err error) {
+	defer utils.RecoverPanicAsError(&err)
+
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return oops.New(err, "failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	type bridgedPost struct {
+		Bridged models.DiscordForumPost `db:"dpost"`
+		Post    models.Post             `db:"post"`
+	}
+	p, err := db.QueryOne[bridgedPost](ctx, tx,
+		`
+		SELECT $columns
+		FROM
+			discord_forum_post AS dpost
+			JOIN post ON post.id = dpost.post_id
+		WHERE dpost.message_id = $1
+		`,
+		messageID,
+	)
+	if errors.Is(err, db.NotFound) {
+		return nil
+	} else if err != nil {
+		return oops.New(err, "failed to fetch bridged post")
+	}
+	if !p.Bridged.FromDiscord || p.Post.Deleted {
+		return nil
+	}
+
+	hmndata.DeletePost(ctx, tx, p.Post.ThreadID, p.Post.ID)
+	_, err = tx.Exec(ctx,
+		`
+		DELETE FROM discord_forum_post
+		WHERE post_id = $1
+		`,
+		p.Post.ID,
+	)
+	if err != nil {
+		return oops.New(err, "failed to delete bridged post")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return oops.New(err, "failed to delete post from Discord")
+	}
+	return nil
+}
+
+// Stops syncing a thread whose Discord post was deleted.
+func HandleBridgedThreadDelete(ctx context.Context, dbConn db.ConnOrTx, discordThreadID string) error {
+	_, err := dbConn.Exec(ctx,
+		`
+		UPDATE discord_forum_thread
+		SET discord_deleted = TRUE
+		WHERE discord_thread_id = $1
+		`,
+		discordThreadID,
+	)
+	if err != nil {
+		return oops.New(err, "failed to mark bridged thread as deleted on Discord")
+	}
+	return nil
+}
+
+func bridgedPostContent(ctx context.Context, content string, attachments string) string {
+	return strings.TrimSpace(strings.TrimSpace(CleanUpMarkdown(ctx, content)) + "\n\n" + attachments)
+}
+
+// Saves a message's attachments as assets and returns markdown that shows
+// them: images inline, and links for anything else.
+func saveBridgedAttachments(ctx context.Context, dbConn db.ConnOrTx, msg *Message, hmnUserID int) (string, error) {
+	var lines []string
+	for i := range msg.Attachments {
+		asset, err := saveAttachmentAsset(ctx, dbConn, &msg.Attachments[i], hmnUserID)
+		if err != nil {
+			return "", err
+		}
+		url := hmnurl.BuildAssetObject(asset, asset.S3Key)
+		if strings.HasPrefix(asset.MimeType, "image/") {
+			lines = append(lines, fmt.Sprintf("![%s](%s)", asset.AltText, url))
+		} else {
+			lines = append(lines, fmt.Sprintf("[%s](%s)", asset.Filename, url))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
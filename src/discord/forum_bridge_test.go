@@ -0,0 +1,41 @@
+package discord
+
+import (
+	"strings"
+	"testing"
+
+	"git.handmade.network/hmn/hmn/src/hmndata"
+	"git.handmade.network/hmn/hmn/src/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForumBridgeRequest(t *testing.T) {
+	const url = "https://handmade.network/forums/t/1/p/2"
+	post := &hmndata.PostAndStuff{
+		Author:         &models.User{Username: "casey", Name: "Casey"},
+		CurrentVersion: models.PostVersion{TextRaw: "  Hello @everyone  "},
+	}
+
+	reply := forumBridgeRequest(post, url, false)
+	assert.Equal(t, "Hello @everyone", reply.Content)
+	assert.Equal(t, "Casey", reply.Username)
+	require.NotNil(t, reply.AllowedMentions)
+	assert.Empty(t, reply.AllowedMentions.Parse)
+
+	first := forumBridgeRequest(post, url, true)
+	assert.True(t, strings.HasPrefix(first.Content, "Hello @everyone"))
+	assert.Contains(t, first.Content, url)
+
+	post.Author = nil
+	post.CurrentVersion.TextRaw = strings.Repeat("a", 3000)
+	long := forumBridgeRequest(post, url, false)
+	assert.Equal(t, "Deleted user", long.Username)
+	assert.LessOrEqual(t, len([]rune(long.Content)), maxMessageLength)
+	assert.True(t, strings.HasSuffix(long.Content, "<"+url+">"))
+
+	post.Author = &models.User{Username: "clyde", Name: "Discord DiscDISCORDord Fan"}
+	assert.Equal(t, "Fan", forumBridgeRequest(post, url, false).Username)
+	post.Author.Name = ""
+	assert.Equal(t, "Handmade Network user", forumBridgeRequest(post, url, false).Username)
+}
@@ -66,6 +66,11 @@ func RunDiscordBot(dbConn *pgxpool.Pool) *jobs.Job {
 			job.Finish()
 		}()
 
+		err := EnsureDefaultChannels(job.Ctx, dbConn)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to set up default Discord channels")
+		}
+
 		boff := backoff.Backoff{
 			Min: 1 * time.Second,
 			Max: 5 * time.Minute,
@@ -328,7 +333,7 @@ func (bot *botInstance) connect(ctx context.Context) error {
 					Browser: BotName,
 					Device:  BotName,
 				},
-				Intents: IntentGuilds | IntentGuildMessages,
+				Intents: IntentGuilds | IntentGuildMessages | IntentGuildMessageReactions,
 			},
 		})
 		if err != nil {
@@ -360,6 +365,13 @@ func (bot *botInstance) connect(ctx context.Context) error {
 		if err != nil {
 			return oops.New(err, "failed to save new bot session in the database")
 		}
+
+		// Discord won't replay anything we missed before this session, so
+		// go and get it ourselves.
+		err = queueGatewayGapBackfills(ctx, bot.dbConn)
+		if err != nil {
+			logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to queue backfills for the new session")
+		}
 	}
 
 	return nil
@@ -609,6 +621,12 @@ func (bot *botInstance) processEventMsg(ctx context.Context, msg *GatewayMessage
 		}
 	case "MESSAGE_DELETE":
 		bot.messageDelete(ctx, MessageDeleteFromMap(msg.Data))
+	case "THREAD_DELETE":
+		thread := ChannelFromMap(msg.Data, "")
+		err := HandleBridgedThreadDelete(ctx, bot.dbConn, thread.ID)
+		if err != nil {
+			logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to handle deleted thread")
+		}
 	case "MESSAGE_BULK_DELETE":
 		bulkDelete := MessageBulkDeleteFromMap(msg.Data)
 		for _, id := range bulkDelete.IDs {
@@ -618,6 +636,12 @@ func (bot *botInstance) processEventMsg(ctx context.Context, msg *GatewayMessage
 				GuildID:   bulkDelete.GuildID,
 			})
 		}
+	case "MESSAGE_REACTION_ADD":
+		bot.messageReactionAdd(ctx, MessageReactionFromMap(msg.Data))
+	case "MESSAGE_REACTION_REMOVE":
+		bot.messageReactionRemove(ctx, MessageReactionFromMap(msg.Data))
+	case "MESSAGE_REACTION_REMOVE_ALL", "MESSAGE_REACTION_REMOVE_EMOJI":
+		bot.messageReactionRemoveAll(ctx, MessageReactionRemoveAllFromMap(msg.Data))
 	case "GUILD_CREATE":
 		guild := *GuildFromMap(msg.Data, "")
 		if guild.ID != config.Config.Discord.GuildID {
@@ -639,19 +663,35 @@ func (bot *botInstance) messageCreateOrUpdate(ctx context.Context, msg *Message)
 		return nil
 	}
 
-	err := HandleIncomingMessage(ctx, bot.dbConn, msg, true)
-	if err != nil {
-		logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to handle incoming message")
-	}
+	handleMessage(ctx, bot.dbConn, msg, true)
 
 	// NOTE(asaf): Since any error from HandleIncomingMessage is an internal error and not a discord
 	//             error, we only want to log it and not restart the bot. So we're not returning the error.
 	return nil
 }
 
+// Everything we do with a new or edited message, whether it came through the
+// gateway or was backfilled after the gateway missed it.
+func handleMessage(ctx context.Context, dbConn db.ConnOrTx, msg *Message, notifyUser bool) {
+	err := HandleIncomingMessage(ctx, dbConn, msg, notifyUser)
+	if err != nil {
+		logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to handle incoming message")
+	}
+
+	err = HandleBridgedMessage(ctx, dbConn, msg)
+	if err != nil {
+		logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to handle message in bridged forum thread")
+	}
+}
+
 func (bot *botInstance) messageDelete(ctx context.Context, msgDelete MessageDelete) {
 	log := logging.ExtractLogger(ctx)
 
+	err := HandleBridgedMessageDelete(ctx, bot.dbConn, msgDelete.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to handle deleted message in bridged forum thread")
+	}
+
 	interned, err := FetchInternedMessage(ctx, bot.dbConn, msgDelete.ID)
 	if err != nil {
 		if !errors.Is(err, db.NotFound) {
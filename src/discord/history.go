@@ -1,18 +1,33 @@
 package discord
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"slices"
+	"strconv"
 	"time"
 
 	"git.handmade.network/hmn/hmn/src/config"
 	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/jobs"
 	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/oops"
 	"git.handmade.network/hmn/hmn/src/utils"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+/*
+The history watcher fills in whatever the gateway missed. Each channel's
+backlog is a list of message ranges kept in a persistent var, which the
+watcher walks forward a page at a time, saving its place after every page. If
+the website restarts, it picks up where it left off.
+
+Ranges are queued whenever the bot has to start a new gateway session, since
+Discord doesn't replay what happened in the meantime, and by hand with the
+"discord backfill" command.
+*/
 func RunHistoryWatcher(dbConn *pgxpool.Pool) *jobs.Job {
 	job := jobs.New("discord history watcher")
 	log := job.Logger
@@ -32,20 +47,14 @@ func RunHistoryWatcher(dbConn *pgxpool.Pool) *jobs.Job {
 
 		backfillFirstRun := make(chan struct{}, 1)
 		backfillFirstRun <- struct{}{}
-		backfillTicker := time.NewTicker(1 * time.Hour)
-
-		lastBackfillTime := time.Now().Add(-3 * time.Hour)
+		// Backfills queued from the command line can't wake us up, so check
+		// for them every so often.
+		backfillTicker := time.NewTicker(1 * time.Minute)
 
 		runBackfill := func() {
-			log.Info().Msg("Running backfill")
-			// Run a backfill to patch up places where the Discord bot missed (does create snippets)
-			now := time.Now()
-			done := ScrapeRecents(job.Ctx, dbConn,
-				config.Config.Discord.ShowcaseChannelID,
-				lastBackfillTime,
-			)
-			if done {
-				lastBackfillTime = now
+			err := RunBackfills(job.Ctx, dbConn)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to backfill Discord history")
 			}
 		}
 
@@ -61,6 +70,8 @@ func RunHistoryWatcher(dbConn *pgxpool.Pool) *jobs.Job {
 					runBackfill()
 				case <-backfillTicker.C:
 					runBackfill()
+				case <-backfillQueued:
+					runBackfill()
 				}
 				return false, nil
 			}()
@@ -128,115 +139,302 @@ func fetchMissingContentOnLinkAccount(ctx context.Context, dbConn *pgxpool.Pool)
 	}
 }
 
-// NOTE(asaf): Behaves like we're receiving the messages from the gateway. Should only be used to catch up.
-func ScrapeRecents(ctx context.Context, dbConn *pgxpool.Pool, channelID string, earliestMessageTime time.Time) bool {
-	log := logging.ExtractLogger(ctx)
+const backfillPageSize = 100
 
-	log.Info().Msg("Starting scrape")
-	defer log.Info().Msg("Done with scrape!")
+var backfillQueued = make(chan struct{}, 1)
 
-	before := ""
-	for {
-		msgs, err := GetChannelMessages(ctx, channelID, GetChannelMessagesInput{
-			Limit:  100,
+/*
+Queues a range of a channel's history to be fetched by the history watcher.
+An empty After starts from the beginning of the channel, and an empty Before
+goes all the way to the present.
+*/
+func QueueBackfill(ctx context.Context, dbConn db.ConnOrTx, channelID string, r hmndata.DiscordBackfillRange) error {
+	if r.After == "" {
+		r.After = "0"
+	}
+	r.Queued = time.Now()
+	err := updateBackfill(ctx, dbConn, channelID, func(backfill *hmndata.DiscordBackfill) {
+		backfill.Ranges = append(backfill.Ranges, r)
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case backfillQueued <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+/*
+Queues backfills for whatever the gateway missed while we didn't have a
+session. Each channel that stores messages picks up from the newest message we
+have for it. Channels without any stored messages are skipped, since there's no
+telling where to start.
+*/
+func queueGatewayGapBackfills(ctx context.Context, dbConn db.ConnOrTx) error {
+	channels, err := FetchChannels(ctx, dbConn)
+	if err != nil {
+		return err
+	}
+
+	before := SnowflakeForTime(time.Now())
+	for _, channel := range channels {
+		if !channel.StoreMessages {
+			continue
+		}
+
+		latestID, err := db.QueryOneScalar[string](ctx, dbConn,
+			`
+			SELECT id
+			FROM discord_message
+			WHERE channel_id = $1
+			ORDER BY sent_at DESC
+			LIMIT 1
+			`,
+			channel.ChannelID,
+		)
+		if errors.Is(err, db.NotFound) {
+			continue
+		} else if err != nil {
+			return oops.New(err, "failed to fetch latest message in channel")
+		}
+
+		err = QueueBackfill(ctx, dbConn, channel.ChannelID, hmndata.DiscordBackfillRange{
+			After:  latestID,
 			Before: before,
+			Live:   true,
+			Reason: "New gateway session",
 		})
 		if err != nil {
-			logging.Error().Err(err).Msg("failed to get messages while scraping")
-			return false
+			return err
 		}
+	}
 
-		if len(msgs) == 0 {
-			logging.Debug().Msg("out of messages, stopping scrape")
-			return true
+	// Bridged forum posts aren't stored channels, but replies in them still
+	// need to reach the site. They pick up from the newest message we bridged,
+	// or the post's starting message, whose ID is the same as the post's.
+	type bridgedThread struct {
+		DiscordThreadID string `db:"discord_thread_id"`
+		LatestID        string `db:"latest_id"`
+	}
+	bridgedThreads, err := db.Query[bridgedThread](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM (
+			SELECT
+				bridge.discord_thread_id,
+				COALESCE(MAX(dpost.message_id::BIGINT), bridge.discord_thread_id::BIGINT)::TEXT AS latest_id
+			FROM
+				discord_forum_thread AS bridge
+				LEFT JOIN post ON post.thread_id = bridge.thread_id
+				LEFT JOIN discord_forum_post AS dpost ON dpost.post_id = post.id
+			WHERE NOT bridge.discord_deleted
+			GROUP BY bridge.discord_thread_id
+		) AS latest
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to fetch bridged forum threads")
+	}
+	for _, thread := range bridgedThreads {
+		err = QueueBackfill(ctx, dbConn, thread.DiscordThreadID, hmndata.DiscordBackfillRange{
+			After:  thread.LatestID,
+			Before: before,
+			Live:   true,
+			Reason: "New gateway session",
+		})
+		if err != nil {
+			return err
 		}
+	}
 
-		for _, msg := range msgs {
-			select {
-			case <-ctx.Done():
-				log.Info().Msg("Scrape was canceled")
-				return false
-			default:
-			}
+	return nil
+}
 
-			log.Info().Str("msg", msg.ShortString()).Msg("")
+func FetchBackfills(ctx context.Context, dbConn db.ConnOrTx) ([]*hmndata.DiscordBackfill, error) {
+	return hmndata.FetchPersistentVarsWithPrefix[hmndata.DiscordBackfill](ctx, dbConn, hmndata.VarNameDiscordBackfillPrefix)
+}
 
-			if !earliestMessageTime.IsZero() && msg.Time().Before(earliestMessageTime) {
-				logging.ExtractLogger(ctx).Info().Time("earliest", earliestMessageTime).Msg("Saw a message before the specified earliest time; exiting")
-				return true
-			}
+/*
+Works through every queued backfill until they're all done. Channels take
+turns a page at a time, so one long backfill doesn't hold up the rest. A
+channel that fails is skipped until the next run.
+*/
+func RunBackfills(ctx context.Context, dbConn db.ConnOrTx) error {
+	log := logging.ExtractLogger(ctx)
 
-			guildMember, err := GetGuildMember(ctx, config.Config.Discord.GuildID, msg.Author.ID) // NOTE(asaf): We assume we're only working with one guild, because the discord API sucks and doesn't provide the guild in the message payload.
-			if err != nil {
-				logging.Error().Err(err).Msg("failed to get guild member for message while scraping")
-				return false
-			}
-			msg.Member = guildMember
+	failed := make(map[string]bool)
+	for {
+		backfills, err := FetchBackfills(ctx, dbConn)
+		if err != nil {
+			return err
+		}
 
-			msg.Backfilled = true
-			err = HandleIncomingMessage(ctx, dbConn, &msg, false)
+		progressed := false
+		for _, backfill := range backfills {
+			if len(backfill.Ranges) == 0 || failed[backfill.ChannelID] {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
 
+			err := backfillPage(ctx, dbConn, backfill.ChannelID, backfill.Ranges[0])
 			if err != nil {
-				errLog := logging.ExtractLogger(ctx).Error()
-				errLog.Err(err).Msg("failed to process Discord message")
+				log.Error().Err(err).Str("channel", backfill.ChannelID).Msg("failed to backfill channel")
+				failed[backfill.ChannelID] = true
+				continue
 			}
+			progressed = true
+		}
 
-			before = msg.ID
+		if !progressed {
+			return nil
 		}
 	}
 }
 
-func ScrapeAll(ctx context.Context, dbConn *pgxpool.Pool, channelID string, earliestMessageTime time.Time) bool {
-	log := logging.ExtractLogger(ctx)
-
-	log.Info().Msg("Starting scrape")
-	defer log.Info().Msg("Done with scrape!")
-
-	before := ""
-	for {
-		msgs, err := GetChannelMessages(ctx, channelID, GetChannelMessagesInput{
-			Limit:  100,
-			Before: before,
+// Handles one page of a channel's first backfill range and saves the
+// progress.
+func backfillPage(ctx context.Context, dbConn db.ConnOrTx, channelID string, r hmndata.DiscordBackfillRange) error {
+	log := logging.ExtractLogger(ctx).With().Str("channel", channelID).Logger()
+
+	msgs, err := GetChannelMessages(ctx, channelID, GetChannelMessagesInput{
+		After: r.After,
+		Limit: backfillPageSize,
+	})
+	caughtUp := false   // ran out of messages
+	reachedEnd := false // hit the end of the range
+	if errors.Is(err, NotFound) {
+		log.Warn().Msg("Discord channel no longer exists; dropping its backfill")
+		caughtUp = true
+	} else if err != nil {
+		return oops.New(err, "failed to fetch messages to backfill")
+	} else {
+		slices.SortFunc(msgs, func(a, b Message) int {
+			return compareSnowflakes(a.ID, b.ID)
 		})
-		if err != nil {
-			logging.Error().Err(err).Msg("failed to get messages while scraping")
-			return false
-		}
+		caughtUp = len(msgs) < backfillPageSize
 
-		if len(msgs) == 0 {
-			logging.Debug().Msg("out of messages, stopping scrape")
-			return true
+		members := make(map[string]*GuildMember)
+		for _, msg := range msgs {
+			if r.Before != "" && compareSnowflakes(msg.ID, r.Before) >= 0 {
+				reachedEnd = true
+				break
+			}
+			backfillMessage(ctx, dbConn, &msg, r.Live, members)
+			r.After = msg.ID
+			r.Handled++
 		}
+	}
 
-		for _, msg := range msgs {
-			select {
-			case <-ctx.Done():
-				log.Info().Msg("Scrape was canceled")
-				return false
-			default:
+	return updateBackfill(ctx, dbConn, channelID, func(backfill *hmndata.DiscordBackfill) {
+		// Ranges only ever get added to the end, so ours is still first.
+		if len(backfill.Ranges) == 0 {
+			return
+		}
+		if caughtUp || (reachedEnd && backfill.Ranges[0].Before == r.Before) {
+			backfill.Ranges = backfill.Ranges[1:]
+			if len(backfill.Ranges) == 0 {
+				now := time.Now()
+				backfill.LastFinished = &now
 			}
+		} else {
+			backfill.Ranges[0].After = r.After
+			backfill.Ranges[0].Handled = r.Handled
+		}
+	})
+}
 
-			log.Info().Str("msg", msg.ShortString()).Msg("")
+func backfillMessage(ctx context.Context, dbConn db.ConnOrTx, msg *Message, live bool, members map[string]*GuildMember) {
+	log := logging.ExtractLogger(ctx)
 
-			if !earliestMessageTime.IsZero() && msg.Time().Before(earliestMessageTime) {
-				logging.ExtractLogger(ctx).Info().
-					Time("earliest", earliestMessageTime).
-					Msg("Saw a message before the specified earliest time; exiting")
-				return true
+	msg.Backfilled = true
+	var err error
+	if live {
+		// Messages from the REST API don't come with the author's roles,
+		// which the channel rules need.
+		if msg.Author != nil {
+			member, ok := members[msg.Author.ID]
+			if !ok {
+				// NOTE(asaf): We assume we're only working with one guild, because the discord API sucks and doesn't provide the guild in the message payload.
+				member, err = GetGuildMember(ctx, config.Config.Discord.GuildID, msg.Author.ID)
+				if err != nil && !errors.Is(err, NotFound) {
+					log.Warn().Err(err).Msg("failed to get guild member for backfilled message")
+				}
+				members[msg.Author.ID] = member
 			}
+			msg.Member = member
+		}
+		handleMessage(ctx, dbConn, msg, false)
+	} else {
+		err = TrackMessage(ctx, dbConn, msg)
+		if err == nil {
+			err = UpdateInternedMessage(ctx, dbConn, msg, false, false, false)
+		}
+	}
+	if err != nil {
+		log.Error().Err(err).Str("msg", msg.ShortString()).Msg("failed to backfill Discord message")
+	}
+}
 
-			msg.Backfilled = true
-			err := TrackMessage(ctx, dbConn, &msg)
-			if err == nil {
-				err = UpdateInternedMessage(ctx, dbConn, &msg, false, false, false)
-			}
+// Changes a channel's backfill under a lock, since the bot and the command
+// line both queue ranges while the history watcher works through them.
+func updateBackfill(ctx context.Context, dbConn db.ConnOrTx, channelID string, update func(backfill *hmndata.DiscordBackfill)) error {
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return oops.New(err, "failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
 
-			if err != nil {
-				errLog := logging.ExtractLogger(ctx).Error()
-				errLog.Err(err).Msg("failed to process Discord message")
-			}
+	name := hmndata.VarNameDiscordBackfill(channelID)
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, name)
+	if err != nil {
+		return oops.New(err, "failed to lock Discord backfill")
+	}
 
-			before = msg.ID
-		}
+	backfill, err := hmndata.FetchPersistentVar[hmndata.DiscordBackfill](ctx, tx, name)
+	if errors.Is(err, db.NotFound) {
+		backfill = &hmndata.DiscordBackfill{ChannelID: channelID}
+	} else if err != nil {
+		return oops.New(err, "failed to fetch Discord backfill")
 	}
+
+	update(backfill)
+
+	err = hmndata.StorePersistentVar(ctx, tx, name, backfill)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return oops.New(err, "failed to save Discord backfill")
+	}
+	return nil
+}
+
+// Discord's epoch, the first second of 2015.
+const discordEpochMs = 1420070400000
+
+// The smallest snowflake for the given time, for fetching messages before or
+// after it.
+func SnowflakeForTime(t time.Time) string {
+	ms := max(t.UnixMilli()-discordEpochMs, 0)
+	return strconv.FormatUint(uint64(ms)<<22, 10)
+}
+
+// When a snowflake was created, e.g. when a message was sent.
+func SnowflakeTime(id string) time.Time {
+	n, _ := strconv.ParseUint(id, 10, 64)
+	return time.UnixMilli(int64(n>>22) + discordEpochMs)
+}
+
+func compareSnowflakes(a, b string) int {
+	aNum, _ := strconv.ParseUint(a, 10, 64)
+	bNum, _ := strconv.ParseUint(b, 10, 64)
+	return cmp.Compare(aNum, bNum)
 }
@@ -0,0 +1,22 @@
+package discord
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnowflakes(t *testing.T) {
+	const id = "1174072372396216360"
+	sent := time.Date(2023, 11, 14, 19, 44, 26, 789_000_000, time.UTC)
+
+	assert.True(t, sent.Equal(SnowflakeTime(id)))
+	assert.Equal(t, -1, compareSnowflakes(SnowflakeForTime(sent.Add(-time.Second)), id))
+	assert.Equal(t, 1, compareSnowflakes(SnowflakeForTime(sent.Add(time.Second)), id))
+	assert.Equal(t, 0, compareSnowflakes(id, id))
+
+	// Numerically, not alphabetically
+	assert.Equal(t, -1, compareSnowflakes("999", "1000"))
+	assert.Equal(t, "0", SnowflakeForTime(time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
@@ -1,6 +1,7 @@
 package discord
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"slices"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"git.handmade.network/hmn/hmn/src/assets"
 	"git.handmade.network/hmn/hmn/src/config"
@@ -25,59 +27,56 @@ import (
 	"github.com/google/uuid"
 )
 
-// Channels for which we will automatically intern messages and their contents.
-// Whether we create snippets is up to shouldAutomaticallyCreateSnippet.
-var autostoreChannels = []string{
-	config.Config.Discord.ShowcaseChannelID,
-	config.Config.Discord.JamChannelID,
-}
-
 var trackedTypes = []MessageType{
 	MessageTypeDefault,
 	MessageTypeReply,
 }
 
-func shouldAutomaticallyCreateSnippet(interned *InternedMessage) bool {
+func shouldAutomaticallyCreateSnippet(ctx context.Context, dbConn db.ConnOrTx, interned *InternedMessage) (bool, error) {
 	// Never create snippets for unlinked users, or users who have turned off the snippet pref.
 	if interned.HMNUser == nil || !interned.HMNUser.DiscordSaveShowcase {
-		return false
+		return false, nil
+	}
+
+	cached, err := fetchCachedChannel(ctx, dbConn, interned.Message.ChannelID)
+	if err != nil {
+		return false, err
+	}
+	channel := cached.channel
+	if channel == nil {
+		return false, nil
 	}
 
-	switch interned.Message.ChannelID {
-	case config.Config.Discord.ShowcaseChannelID:
+	switch channel.AutoSnippet {
+	case models.DiscordChannelAutoSnippetAlways:
 		// Create a snippet for any message that does not get cleaned up.
-		return true
-	case config.Config.Discord.JamChannelID:
+		return true, nil
+	case models.DiscordChannelAutoSnippetTaggedDuringJam:
 		// Create a snippet for any message that has an explicit project tag.
 		hasTags := len(parseTags(interned.MessageContent.LastContent)) > 0
 		jamIsHappeningMoreOrLessRightNow := hmndata.LatestJam.WithinGrace(time.Now(), hmndata.JamProjectCreateGracePeriod, time.Hour*24)
-		return hasTags && jamIsHappeningMoreOrLessRightNow
+		return hasTags && jamIsHappeningMoreOrLessRightNow, nil
 	default:
-		return false
+		return false, nil
 	}
 }
 
 func HandleIncomingMessage(ctx context.Context, dbConn db.ConnOrTx, msg *Message, notifyUser bool) error {
-	var deleted bool
-	var err error
-
-	// NOTE(asaf): All functions called here should verify that the message applies to them.
-
-	if !deleted {
-		deleted, err = cleanUpLibrary(ctx, dbConn, msg)
-		if err != nil {
-			return err
-		}
+	cached, err := fetchCachedChannel(ctx, dbConn, msg.ChannelID)
+	if err != nil {
+		return err
 	}
+	channel := cached.channel
 
-	if !deleted {
-		deleted, err = cleanUpShowcase(ctx, dbConn, msg)
+	deleted := false
+	if channel != nil {
+		deleted, err = applyChannelRules(ctx, dbConn, msg, cached.rules)
 		if err != nil {
 			return err
 		}
 	}
 
-	autostore := slices.Contains(autostoreChannels, msg.ChannelID)
+	autostore := channel != nil && channel.StoreMessages
 	validType := slices.Contains(trackedTypes, msg.Type)
 	if !deleted && autostore && validType {
 		if err := TrackMessage(ctx, dbConn, msg); err != nil {
@@ -92,92 +91,6 @@ func HandleIncomingMessage(ctx context.Context, dbConn db.ConnOrTx, msg *Message
 	return nil
 }
 
-var githubRegex = regexp.MustCompile(`^(https:\/\/)?(www\.|gist\.)?github.com`)
-var steampoweredRegex = regexp.MustCompile(`steampowered\.com`)
-var steamCutoffDate = time.Date(2026, 5, 10, 0, 0, 0, 0, time.UTC)
-
-func cleanUpShowcase(ctx context.Context, dbConn db.ConnOrTx, msg *Message) (bool, error) {
-	if msg.ChannelID != config.Config.Discord.ShowcaseChannelID {
-		return false, nil
-	}
-
-	// Ignore messages that are of unusual types.
-	switch msg.Type {
-	case MessageTypeDefault, MessageTypeReply, MessageTypeApplicationCommand:
-	default:
-		return false, nil
-	}
-
-	if !messageIsSnippetable(msg) {
-		return RebukeMessage(ctx, dbConn, msg,
-			"Posts in #project-showcase are required to have an image, video, or link. Please create a thread if you wish to discuss a #project-showcase post.",
-		)
-	} else {
-		// Check steam link
-		whitelistedUser := (msg.Member != nil && config.Config.Discord.ShowcaseWhitelistRoleID != "" && slices.Contains(msg.Member.Roles, config.Config.Discord.ShowcaseWhitelistRoleID))
-		if !whitelistedUser {
-			steamStoreLink := false
-			for _, e := range msg.Embeds {
-				if e.Url != nil && steampoweredRegex.MatchString(*e.Url) {
-					steamStoreLink = true
-					break
-				}
-			}
-			if !steamStoreLink && steampoweredRegex.MatchString(msg.Content) {
-				steamStoreLink = true
-			}
-
-			if steamStoreLink && msg.Time().After(steamCutoffDate) {
-				return RebukeMessage(ctx, dbConn, msg, "We do not allow Steam links or marketing material in #project-showcase.")
-			}
-		}
-
-		// Remove github embeds
-		if len(msg.Embeds) > 0 {
-			// NOTE(asaf): Remove embeds if all of them are github
-			allGithub := true
-			for _, e := range msg.Embeds {
-				if !(e.Url != nil && githubRegex.Match([]byte(*e.Url))) {
-					allGithub = false
-					break
-				}
-			}
-
-			if allGithub {
-				_, err := EditMessage(ctx, msg.ChannelID, msg.ID, `{ "flags": 4 }`) // 4 = SUPPRESS_EMBEDS
-				if err != nil {
-					return false, oops.New(err, "Failed to remove embeds from showcase message")
-				}
-			}
-		}
-	}
-
-	return false, nil
-}
-
-func cleanUpLibrary(ctx context.Context, dbConn db.ConnOrTx, msg *Message) (bool, error) {
-	if msg.ChannelID != config.Config.Discord.LibraryChannelID {
-		return false, nil
-	}
-
-	// Ignore messages that are of unusual types.
-	switch msg.Type {
-	case MessageTypeDefault, MessageTypeReply, MessageTypeApplicationCommand:
-	default:
-		return false, nil
-	}
-
-	if !msg.OriginalHasFields("content") {
-		return false, nil
-	}
-
-	if !messageHasLinks(msg.Content) {
-		return RebukeMessage(ctx, dbConn, msg, "Posts in #the-library are required to have a link. Please discuss library content in other relevant channels.")
-	}
-
-	return false, nil
-}
-
 var errNotEnoughInfo = errors.New("Discord didn't send enough info in this event for us to do this")
 
 /*
@@ -286,7 +199,13 @@ func UpdateInternedMessage(
 			return err
 		}
 
-		createSnippet := canCreateSnippet && shouldAutomaticallyCreateSnippet(interned)
+		createSnippet := false
+		if canCreateSnippet {
+			createSnippet, err = shouldAutomaticallyCreateSnippet(ctx, tx, interned)
+			if err != nil {
+				return err
+			}
+		}
 		err = UpdateSnippetForInternedMessage(ctx, tx, interned, createSnippet, notifyUser && contentChanged)
 		if err != nil {
 			return err
@@ -505,6 +424,33 @@ func DownloadDiscordResource(ctx context.Context, url string) ([]byte, string, e
 	return content, res.Header.Get("Content-Type"), nil
 }
 
+// Saves a Discord user's avatar as an asset.
+func SaveAvatar(ctx context.Context, conn db.ConnOrTx, userID, avatarHash string) (*models.Asset, error) {
+	const size = 256
+
+	filename := fmt.Sprintf("%s.png", avatarHash)
+	url := fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s?size=%d", userID, filename, size)
+
+	content, _, err := DownloadDiscordResource(ctx, url)
+	if err != nil {
+		return nil, oops.New(err, "failed to download Discord avatar")
+	}
+
+	asset, err := assets.Create(ctx, conn, assets.CreateInput{
+		Content:     bytes.NewReader(content),
+		Filename:    filename,
+		ContentType: "image/png",
+
+		Width:  size,
+		Height: size,
+	})
+	if err != nil {
+		return nil, oops.New(err, "failed to save asset for Discord avatar")
+	}
+
+	return asset, nil
+}
+
 /*
 Saves a Discord attachment as an HMN asset. Idempotent; will not create an attachment
 that already exists
@@ -532,36 +478,9 @@ func saveAttachment(
 		return nil, oops.New(err, "failed to check for existing attachment")
 	}
 
-	width := 0
-	height := 0
-	if attachment.Width != nil {
-		width = *attachment.Width
-	}
-	if attachment.Height != nil {
-		height = *attachment.Height
-	}
-
-	content, _, err := DownloadDiscordResource(ctx, attachment.Url)
+	asset, err := saveAttachmentAsset(ctx, tx, attachment, hmnUserID)
 	if err != nil {
-		return nil, oops.New(err, "failed to download Discord attachment")
-	}
-
-	contentType := "application/octet-stream"
-	if attachment.ContentType != nil {
-		contentType = *attachment.ContentType
-	}
-
-	asset, err := assets.Create(ctx, tx, assets.CreateInput{
-		Content:     content,
-		Filename:    attachment.Filename,
-		ContentType: contentType,
-
-		UploaderID: &hmnUserID,
-		Width:      width,
-		Height:     height,
-	})
-	if err != nil {
-		return nil, oops.New(err, "failed to save asset for Discord attachment")
+		return nil, err
 	}
 
 	// TODO(db): RETURNING plz thanks
@@ -593,6 +512,49 @@ func saveAttachment(
 	return discordAttachment, nil
 }
 
+// Downloads a Discord attachment and saves it as an asset.
+func saveAttachmentAsset(ctx context.Context, tx db.ConnOrTx, attachment *Attachment, uploaderID int) (*models.Asset, error) {
+	width := 0
+	height := 0
+	if attachment.Width != nil {
+		width = *attachment.Width
+	}
+	if attachment.Height != nil {
+		height = *attachment.Height
+	}
+
+	content, _, err := DownloadDiscordResource(ctx, attachment.Url)
+	if err != nil {
+		return nil, oops.New(err, "failed to download Discord attachment")
+	}
+
+	contentType := "application/octet-stream"
+	if attachment.ContentType != nil {
+		contentType = *attachment.ContentType
+	}
+
+	altText := ""
+	if attachment.Description != nil {
+		altText = *attachment.Description
+	}
+
+	asset, err := assets.Create(ctx, tx, assets.CreateInput{
+		Content:     bytes.NewReader(content),
+		Filename:    attachment.Filename,
+		ContentType: contentType,
+
+		UploaderID: &uploaderID,
+		Width:      width,
+		Height:     height,
+		AltText:    altText,
+	})
+	if err != nil {
+		return nil, oops.New(err, "failed to save asset for Discord attachment")
+	}
+
+	return asset, nil
+}
+
 // Saves an embed from Discord. NOTE: This is _not_ idempotent, so only call it
 // if you do not have any embeds saved for this message yet.
 func saveEmbed(
@@ -622,7 +584,7 @@ func saveEmbed(
 		}
 		if contentTypeCheck(contentType) {
 			in := assets.CreateInput{
-				Content:     content,
+				Content:     bytes.NewReader(content),
 				Filename:    "embed",
 				ContentType: contentType,
 				UploaderID:  &hmnUserID,
@@ -780,12 +742,26 @@ func UpdateSnippetForInternedMessage(
 			}
 			existingSnippet.Description = contentMarkdown
 			existingSnippet.DescriptionHtml = contentHTML
+
+			// Attachments can be removed when editing a message on Discord. If
+			// there are none left, keep whatever the snippet already had, since
+			// it may have come from an embed instead.
+			attachmentIDs, err := getMessageAttachmentAssetIDs(ctx, tx, &interned.Message)
+			if err != nil {
+				return err
+			}
+			if len(attachmentIDs) > 0 {
+				err = hmndata.SetSnippetAssets(ctx, tx, existingSnippet.ID, attachmentIDs)
+				if err != nil {
+					return oops.New(err, "failed to update snippet attachments on message edit")
+				}
+			}
 		}
 	} else {
 		shouldCreate := canCreateSnippets && !interned.Message.SnippetCreated
 		if shouldCreate {
-			// Get an asset ID or URL to make a snippet from
-			assetId, url, err := getSnippetAssetOrUrl(ctx, tx, &interned.Message)
+			// Get assets or a URL to make a snippet from
+			assetIDs, url, err := getSnippetAssetsOrUrl(ctx, tx, &interned.Message)
 			if err != nil {
 				return oops.New(err, "failed to fetch assets for snippet creation")
 			}
@@ -795,14 +771,13 @@ func UpdateSnippetForInternedMessage(
 
 			_, err = tx.Exec(ctx,
 				`
-				INSERT INTO snippet (url, "when", description, _description_html, asset_id, discord_message_id, owner_id)
-				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				INSERT INTO snippet (url, "when", description, _description_html, discord_message_id, owner_id)
+				VALUES ($1, $2, $3, $4, $5, $6)
 				`,
 				url,
 				interned.Message.SentAt,
 				contentMarkdown,
 				contentHTML,
-				assetId,
 				interned.Message.ID,
 				interned.HMNUser.ID,
 			)
@@ -815,6 +790,11 @@ func UpdateSnippetForInternedMessage(
 				return oops.New(err, "failed to fetch newly-created snippet")
 			}
 
+			err = hmndata.SetSnippetAssets(ctx, tx, existingSnippet.ID, assetIDs)
+			if err != nil {
+				return oops.New(err, "failed to attach assets to snippet")
+			}
+
 			_, err = tx.Exec(ctx,
 				`
 				UPDATE discord_message
@@ -882,6 +862,88 @@ func UpdateSnippetForInternedMessage(
 	return nil
 }
 
+/*
+Creates a snippet for a message because its author asked for one, regardless
+of the channel or their settings. Any extra tags are associated as if they were
+picked on the website, so that editing the message on Discord won't remove
+them. Returns the snippet and any extra tags that didn't match the author's
+projects.
+*/
+func SaveSnippetFromMessage(
+	ctx context.Context,
+	dbConn db.ConnOrTx,
+	msg *Message,
+	extraTags []string,
+) (*models.Snippet, []string, error) {
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return nil, nil, oops.New(err, "failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	err = TrackMessage(ctx, tx, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Saves the message contents without creating a snippet, since whether
+	// that happens here is not up to the user's settings.
+	err = UpdateInternedMessage(ctx, tx, msg, false, false, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	interned, err := FetchInternedMessage(ctx, tx, msg.ID)
+	if err != nil {
+		return nil, nil, oops.New(err, "failed to fetch interned message")
+	}
+	err = UpdateSnippetForInternedMessage(ctx, tx, interned, true, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	snippet, err := FetchSnippetForMessage(ctx, tx, msg.ID)
+	if err != nil {
+		return nil, nil, err
+	} else if snippet == nil {
+		return nil, nil, oops.New(nil, "no snippet was created for message %s; is the author's account linked?", msg.ID)
+	}
+
+	tags, err := HandleIncomingMessageTags(ctx, tx, interned, extraTags, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	var unknownTags []string
+	for _, tag := range extraTags {
+		if !slices.ContainsFunc(tags, func(t *TagProject) bool { return t.Tag == tag }) {
+			unknownTags = append(unknownTags, tag)
+		}
+	}
+	for _, t := range tags {
+		_, err = tx.Exec(ctx,
+			`
+			INSERT INTO snippet_project (project_id, snippet_id, kind)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+			`,
+			t.ProjectID,
+			snippet.ID,
+			models.SnippetProjectKindWebsite,
+		)
+		if err != nil {
+			return nil, nil, oops.New(err, "failed to associate snippet with project")
+		}
+	}
+	if len(tags) > 0 {
+		hmndata.UpdateSnippetLastPostedForAllProjects(ctx, tx)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, nil, oops.New(err, "failed to commit snippet")
+	}
+
+	return snippet, unknownTags, nil
+}
+
 type TagProject struct {
 	Tag       string `db:"tag.text"`
 	ProjectID int    `db:"project.id"`
@@ -955,21 +1017,32 @@ func HandleIncomingMessageTags(
 // TODO(asaf): Centralize this
 var RESnippetableUrl = regexp.MustCompile(`^https?://(youtu\.be|(www\.)?youtube\.com/watch)`)
 
-func getSnippetAssetOrUrl(ctx context.Context, tx db.ConnOrTx, msg *models.DiscordMessage) (*uuid.UUID, *string, error) {
-	// Check attachments
-	attachments, err := db.Query[models.DiscordMessageAttachment](ctx, tx,
+// Returns the assets for all of a message's attachments, in the order they
+// appear on Discord.
+func getMessageAttachmentAssetIDs(ctx context.Context, tx db.ConnOrTx, msg *models.DiscordMessage) ([]uuid.UUID, error) {
+	assetIDs, err := db.QueryScalar[uuid.UUID](ctx, tx,
 		`
-		SELECT $columns
+		SELECT asset_id
 		FROM discord_message_attachment
 		WHERE message_id = $1
+		ORDER BY length(id), id -- attachment IDs are snowflakes
 		`,
 		msg.ID,
 	)
 	if err != nil {
-		return nil, nil, oops.New(err, "failed to fetch message attachments")
+		return nil, oops.New(err, "failed to fetch message attachments")
 	}
-	for _, attachment := range attachments {
-		return &attachment.AssetID, nil, nil
+	return assetIDs, nil
+}
+
+func getSnippetAssetsOrUrl(ctx context.Context, tx db.ConnOrTx, msg *models.DiscordMessage) ([]uuid.UUID, *string, error) {
+	// Check attachments
+	attachmentIDs, err := getMessageAttachmentAssetIDs(ctx, tx, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(attachmentIDs) > 0 {
+		return attachmentIDs, nil, nil
 	}
 
 	// Check embeds
@@ -986,9 +1059,9 @@ func getSnippetAssetOrUrl(ctx context.Context, tx db.ConnOrTx, msg *models.Disco
 	}
 	for _, embed := range embeds {
 		if embed.VideoID != nil {
-			return embed.VideoID, nil, nil
+			return []uuid.UUID{*embed.VideoID}, nil, nil
 		} else if embed.ImageID != nil {
-			return embed.ImageID, nil, nil
+			return []uuid.UUID{*embed.ImageID}, nil, nil
 		} else if embed.URL != nil {
 			if RESnippetableUrl.MatchString(*embed.URL) {
 				return nil, embed.URL, nil
@@ -1062,6 +1135,44 @@ func SendDM(ctx context.Context, dbConn db.ConnOrTx, authorID string, text strin
 	return nil
 }
 
+// Posts a snippet that was created on the website to the showcase channel,
+// so that people who don't use Discord still show up there. The author is
+// mentioned by ID but not pinged.
+func CrosspostSnippet(ctx context.Context, dbConn db.ConnOrTx, authorID string, text string, snippetUrl string) error {
+	if config.Config.Discord.ShowcaseChannelID == "" {
+		return nil
+	}
+
+	header := fmt.Sprintf("<@%s> posted a snippet on the website:", authorID)
+	footer := snippetUrl
+
+	// Discord messages are limited to 2000 characters.
+	const maxLength = 2000
+	maxTextLength := maxLength - utf8.RuneCountInString(header) - utf8.RuneCountInString(footer) - 2
+	if utf8.RuneCountInString(text) > maxTextLength {
+		text = string([]rune(text)[:maxTextLength-1]) + "…"
+	}
+
+	content := header + "\n"
+	if text != "" {
+		content += text + "\n"
+	}
+	content += footer
+
+	err := SendMessages(ctx, dbConn, MessageToSend{
+		ChannelID: config.Config.Discord.ShowcaseChannelID,
+		Req: CreateMessageRequest{
+			Content:         content,
+			AllowedMentions: &MessageAllowedMentions{Parse: []MentionType{}},
+		},
+	})
+	if err != nil {
+		return oops.New(err, "failed to cross-post snippet to showcase")
+	}
+
+	return nil
+}
+
 func RebukeMessage(ctx context.Context, dbConn db.ConnOrTx, msg *Message, noteToUser string) (deleted bool, err error) {
 	err = DeleteMessage(ctx, msg.ChannelID, msg.ID)
 	if err != nil {
@@ -146,6 +146,69 @@ func MessageBulkDeleteFromMap(m any) MessageBulkDelete {
 	}
 }
 
+// https://discord.com/developers/docs/resources/emoji#emoji-object
+type Emoji struct {
+	ID       *string `json:"id"`   // null for standard Unicode emoji
+	Name     string  `json:"name"` // the emoji itself for Unicode emoji
+	Animated bool    `json:"animated"`
+}
+
+func EmojiFromMap(m any) Emoji {
+	mmap := m.(map[string]any)
+
+	return Emoji{
+		ID:       maybeStringP(mmap, "id"),
+		Name:     maybeString(mmap, "name"),
+		Animated: maybeBool(mmap, "animated"),
+	}
+}
+
+// https://discord.com/developers/docs/topics/gateway-events#message-reaction-add
+// (MESSAGE_REACTION_REMOVE has the same fields, minus a few we don't use.)
+type MessageReaction struct {
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id"`
+	MessageID string `json:"message_id"`
+	GuildID   string `json:"guild_id"`
+	Emoji     Emoji  `json:"emoji"`
+}
+
+func MessageReactionFromMap(m any) MessageReaction {
+	mmap := m.(map[string]any)
+
+	return MessageReaction{
+		UserID:    mmap["user_id"].(string),
+		ChannelID: mmap["channel_id"].(string),
+		MessageID: mmap["message_id"].(string),
+		GuildID:   maybeString(mmap, "guild_id"),
+		Emoji:     EmojiFromMap(mmap["emoji"]),
+	}
+}
+
+// https://discord.com/developers/docs/topics/gateway-events#message-reaction-remove-all
+// Also used for MESSAGE_REACTION_REMOVE_EMOJI, in which case Emoji is set.
+type MessageReactionRemoveAll struct {
+	ChannelID string `json:"channel_id"`
+	MessageID string `json:"message_id"`
+	GuildID   string `json:"guild_id"`
+	Emoji     *Emoji `json:"emoji"`
+}
+
+func MessageReactionRemoveAllFromMap(m any) MessageReactionRemoveAll {
+	mmap := m.(map[string]any)
+
+	removeAll := MessageReactionRemoveAll{
+		ChannelID: mmap["channel_id"].(string),
+		MessageID: mmap["message_id"].(string),
+		GuildID:   maybeString(mmap, "guild_id"),
+	}
+	if iemoji, ok := mmap["emoji"]; ok {
+		emoji := EmojiFromMap(iemoji)
+		removeAll.Emoji = &emoji
+	}
+	return removeAll
+}
+
 type ChannelType int
 
 // https://discord.com/developers/docs/resources/channel#channel-object-channel-types
@@ -161,6 +224,7 @@ const (
 	ChannelTypeGuildPublicThread  ChannelType = 11
 	ChannelTypeGuildPrivateThread ChannelType = 12
 	ChannelTypeGuildStageVoice    ChannelType = 13
+	ChannelTypeGuildForum         ChannelType = 15
 )
 
 // https://discord.com/developers/docs/topics/permissions#role-object
@@ -190,6 +254,8 @@ type Channel struct {
 	Type    ChannelType `json:"type"`
 	GuildID string      `json:"guild_id"`
 	Name    string      `json:"name"`
+	// The category of a guild channel, or the channel a thread is in
+	ParentID string `json:"parent_id"`
 	// More fields not yet present
 }
 
@@ -204,11 +270,45 @@ func ChannelFromMap(m any, k string) *Channel {
 		Type:    ChannelType(mmap["type"].(float64)),
 		GuildID: maybeString(mmap, "guild_id"),
 		Name:    maybeString(mmap, "name"),
+
+		ParentID: maybeString(mmap, "parent_id"),
 	}
 
 	return c
 }
 
+type GuildScheduledEventEntityType int
+
+// https://discord.com/developers/docs/resources/guild-scheduled-event#guild-scheduled-event-object-guild-scheduled-event-entity-types
+const (
+	GuildScheduledEventEntityTypeStageInstance GuildScheduledEventEntityType = 1
+	GuildScheduledEventEntityTypeVoice         GuildScheduledEventEntityType = 2
+	GuildScheduledEventEntityTypeExternal      GuildScheduledEventEntityType = 3
+)
+
+// https://discord.com/developers/docs/resources/guild-scheduled-event#guild-scheduled-event-object-guild-scheduled-event-privacy-level
+const GuildScheduledEventPrivacyLevelGuildOnly = 2
+
+// https://discord.com/developers/docs/resources/guild-scheduled-event#guild-scheduled-event-object
+type GuildScheduledEvent struct {
+	ID                 string                             `json:"id"`
+	GuildID            string                             `json:"guild_id"`
+	ChannelID          *string                            `json:"channel_id"`
+	Name               string                             `json:"name"`
+	Description        *string                            `json:"description"`
+	ScheduledStartTime string                             `json:"scheduled_start_time"`
+	ScheduledEndTime   *string                            `json:"scheduled_end_time"`
+	PrivacyLevel       int                                `json:"privacy_level"`
+	Status             int                                `json:"status"`
+	EntityType         GuildScheduledEventEntityType      `json:"entity_type"`
+	EntityMetadata     *GuildScheduledEventEntityMetadata `json:"entity_metadata"`
+	// More fields not yet present
+}
+
+type GuildScheduledEventEntityMetadata struct {
+	Location string `json:"location,omitempty"` // 1-100 characters, required for external events
+}
+
 type MessageType int
 
 // https://discord.com/developers/docs/resources/channel#message-object-message-types
@@ -271,6 +371,7 @@ type Message struct {
 	Timestamp string       `json:"timestamp"`
 	Type      MessageType  `json:"type"`
 	Flags     MessageFlags `json:"flags"`
+	WebhookID *string      `json:"webhook_id"` // Set if a webhook sent this, in which case Author is fake
 
 	Attachments []Attachment `json:"attachments"`
 	Embeds      []Embed      `json:"embeds"`
@@ -341,6 +442,7 @@ func MessageFromMap(m any, k string) *Message {
 		Timestamp: maybeString(mmap, "timestamp"),
 		Type:      MessageType(maybeInt(mmap, "type")),
 		Flags:     MessageFlags(maybeInt(mmap, "flags")),
+		WebhookID: maybeStringP(mmap, "webhook_id"),
 
 		originalMap: mmap,
 	}
@@ -455,6 +557,7 @@ type Attachment struct {
 	ProxyUrl    string  `json:"proxy_url"`
 	Height      *int    `json:"height"`
 	Width       *int    `json:"width"`
+	Description *string `json:"description"` // Alt text
 }
 
 func AttachmentFromMap(m any, k string) *Attachment {
@@ -472,6 +575,7 @@ func AttachmentFromMap(m any, k string) *Attachment {
 		ProxyUrl:    mmap["proxy_url"].(string),
 		Height:      maybeIntP(mmap, "height"),
 		Width:       maybeIntP(mmap, "width"),
+		Description: maybeStringP(mmap, "description"),
 	}
 
 	return &a
@@ -724,6 +828,8 @@ const (
 	InteractionTypePing               InteractionType = 1
 	InteractionTypeApplicationCommand InteractionType = 2
 	InteractionTypeMessageComponent   InteractionType = 3
+	InteractionTypeAutocomplete       InteractionType = 4
+	InteractionTypeModalSubmit        InteractionType = 5
 )
 
 // See https://discord.com/developers/docs/interactions/receiving-and-responding#interaction-object-interaction-data-structure
@@ -737,8 +843,9 @@ type InteractionData struct {
 	Resolved *ResolvedData                             `json:"resolved"` // converted users + roles + channels
 	Options  []ApplicationCommandInteractionDataOption `json:"options"`  // the params + values from the user
 
-	// Fields for Components
-	// TODO
+	// Fields for Components and Modal Submits
+	CustomID   string      `json:"custom_id"`  // the custom_id of the component or modal
+	Components []Component `json:"components"` // for modal submits, the values the user entered
 
 	// Fields for User Command and Message Command
 	TargetID string `json:"target_id"` // id the of user or message targetted by a user or message command
@@ -768,6 +875,8 @@ const (
 	InteractionCallbackTypeDeferredChannelMessageWithSource InteractionCallbackType = 5 // ACK an interaction and edit a response later, the user sees a loading state
 	InteractionCallbackTypeDeferredUpdateMessage            InteractionCallbackType = 6 // for components, ACK an interaction and edit the original message later; the user does not see a loading state
 	InteractionCallbackTypeUpdateMessage                    InteractionCallbackType = 7 // for components, edit the message the component was attached to
+	InteractionCallbackTypeAutocompleteResult               InteractionCallbackType = 8 // respond to an autocomplete interaction with suggested choices
+	InteractionCallbackTypeModal                            InteractionCallbackType = 9 // respond to an interaction with a popup modal
 )
 
 type InteractionCallbackData struct {
@@ -776,7 +885,14 @@ type InteractionCallbackData struct {
 	Embeds  []Embed `json:"embeds,omitempty"`
 	// TODO: Allowed mentions
 	Flags InteractionCallbackDataFlags `json:"flags,omitempty"`
-	// TODO: Components
+
+	// Fields for modals
+	CustomID   string      `json:"custom_id,omitempty"`
+	Title      string      `json:"title,omitempty"`
+	Components []Component `json:"components,omitempty"`
+
+	// Fields for autocomplete results
+	Choices []ApplicationCommandOptionChoice `json:"choices,omitzero"` // max 25; must be non-nil for autocomplete results, even if empty
 }
 
 type InteractionCallbackDataFlags int
@@ -788,19 +904,20 @@ const (
 type ApplicationCommandType int
 
 const (
-	ApplicationCommandTypeChatInput ApplicationCommandType       = 1 // Slash commands; a text-based command that shows up when a user types `/`
-	ApplicationCommandTypeUser      ApplicationCommandType       = 2 // A UI-based command that shows up when you right click or tap on a user
-	ApplicationCommandTypeMessage   ApplicationCommandOptionType = 3 // A UI-based command that shows up when you right click or tap on a message
+	ApplicationCommandTypeChatInput ApplicationCommandType = 1 // Slash commands; a text-based command that shows up when a user types `/`
+	ApplicationCommandTypeUser      ApplicationCommandType = 2 // A UI-based command that shows up when you right click or tap on a user
+	ApplicationCommandTypeMessage   ApplicationCommandType = 3 // A UI-based command that shows up when you right click or tap on a message
 )
 
 // Required `options` must be listed before optional options
 type ApplicationCommandOption struct {
-	Type        ApplicationCommandOptionType     `json:"type"`        // the type of option
-	Name        string                           `json:"name"`        // 1-32 character name
-	Description string                           `json:"description"` // 1-100 character description
-	Required    bool                             `json:"required"`    // if the parameter is required or optional--default false
-	Choices     []ApplicationCommandOptionChoice `json:"choices"`     // choices for STRING, INTEGER, and NUMBER types for the user to pick from, max 25
-	Options     []ApplicationCommandOption       `json:"options"`     // if the option is a subcommand or subcommand group type, this nested options will be the parameters
+	Type         ApplicationCommandOptionType     `json:"type"`                   // the type of option
+	Name         string                           `json:"name"`                   // 1-32 character name
+	Description  string                           `json:"description"`            // 1-100 character description
+	Required     bool                             `json:"required"`               // if the parameter is required or optional--default false
+	Choices      []ApplicationCommandOptionChoice `json:"choices"`                // choices for STRING, INTEGER, and NUMBER types for the user to pick from, max 25
+	Options      []ApplicationCommandOption       `json:"options"`                // if the option is a subcommand or subcommand group type, this nested options will be the parameters
+	Autocomplete bool                             `json:"autocomplete,omitempty"` // for STRING, INTEGER, and NUMBER types, whether to ask the bot for choices as the user types; cannot be combined with Choices
 }
 
 type ApplicationCommandOptionType int
@@ -835,6 +952,7 @@ type ApplicationCommandInteractionDataOption struct {
 	Type    ApplicationCommandOptionType              `json:"type"`
 	Value   any                                       `json:"value"`   // the value of the pair
 	Options []ApplicationCommandInteractionDataOption `json:"options"` // present if this option is a group or subcommand
+	Focused bool                                      `json:"focused"` // for autocomplete, true if this is the option the user is typing in
 }
 
 func InteractionFromMap(m any, k string) *Interaction {
@@ -866,12 +984,19 @@ func InteractionDataFromMap(m any, k string) *InteractionData {
 		return nil
 	}
 
+	// Modal submits have no command, so none of the command fields are
+	// guaranteed.
 	d := &InteractionData{
-		ID:       mmap["id"].(string),
-		Name:     mmap["name"].(string),
-		Type:     ApplicationCommandType(mmap["type"].(float64)),
+		ID:       maybeString(mmap, "id"),
+		Name:     maybeString(mmap, "name"),
+		Type:     ApplicationCommandType(maybeInt(mmap, "type")),
 		Resolved: ResolvedDataFromMap(mmap, "resolved"),
 		TargetID: maybeString(mmap, "target_id"),
+		CustomID: maybeString(mmap, "custom_id"),
+	}
+
+	for _, icomponent := range maybeArray(mmap, "components") {
+		d.Components = append(d.Components, *ComponentFromMap(icomponent, ""))
 	}
 
 	if ioptions, ok := mmap["options"]; ok {
@@ -945,9 +1070,10 @@ func ApplicationCommandInteractionDataOptionFromMap(m any, k string) *Applicatio
 	}
 
 	o := &ApplicationCommandInteractionDataOption{
-		Name:  mmap["name"].(string),
-		Type:  ApplicationCommandOptionType(mmap["type"].(float64)),
-		Value: mmap["value"],
+		Name:    mmap["name"].(string),
+		Type:    ApplicationCommandOptionType(mmap["type"].(float64)),
+		Value:   mmap["value"],
+		Focused: maybeBool(mmap, "focused"),
 	}
 
 	if ioptions, ok := mmap["options"]; ok {
@@ -960,6 +1086,74 @@ func ApplicationCommandInteractionDataOptionFromMap(m any, k string) *Applicatio
 	return o
 }
 
+// See https://discord.com/developers/docs/interactions/message-components#component-object-component-types
+type ComponentType int
+
+const (
+	ComponentTypeActionRow ComponentType = 1
+	ComponentTypeTextInput ComponentType = 4
+)
+
+// See https://discord.com/developers/docs/interactions/message-components#text-input-object-text-input-styles
+type TextInputStyle int
+
+const (
+	TextInputStyleShort     TextInputStyle = 1
+	TextInputStyleParagraph TextInputStyle = 2
+)
+
+// A message or modal component. Only action rows and text inputs are supported
+// so far, and each only uses the fields that apply to its type.
+//
+// See https://discord.com/developers/docs/interactions/message-components
+type Component struct {
+	Type ComponentType `json:"type"`
+
+	// Action rows
+	Components []Component `json:"components,omitempty"`
+
+	// Text inputs
+	CustomID    string         `json:"custom_id,omitempty"`
+	Style       TextInputStyle `json:"style,omitempty"`
+	Label       string         `json:"label,omitempty"`
+	Placeholder string         `json:"placeholder,omitempty"`
+	Value       string         `json:"value,omitempty"`
+	Required    *bool          `json:"required,omitempty"` // defaults to true
+	MaxLength   int            `json:"max_length,omitempty"`
+}
+
+func ComponentFromMap(m any, k string) *Component {
+	mmap := maybeGetKey(m, k)
+	if mmap == nil {
+		return nil
+	}
+
+	c := &Component{
+		Type:     ComponentType(maybeInt(mmap, "type")),
+		CustomID: maybeString(mmap, "custom_id"),
+		Value:    maybeString(mmap, "value"),
+	}
+	for _, icomponent := range maybeArray(mmap, "components") {
+		c.Components = append(c.Components, *ComponentFromMap(icomponent, ""))
+	}
+
+	return c
+}
+
+// Finds the value of a text input in a modal submit, however it was nested in
+// action rows.
+func GetComponentValue(components []Component, customID string) (string, bool) {
+	for _, c := range components {
+		if c.Type == ComponentTypeTextInput && c.CustomID == customID {
+			return c.Value, true
+		}
+		if value, ok := GetComponentValue(c.Components, customID); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
 // If called without a key, returns m. Otherwise, returns m[k].
 // If m[k] does not exist, returns nil.
 //
@@ -95,6 +95,58 @@ func TestInteractionFromMap(t *testing.T) {
 		assert.Equal(t, "132715550571888640", i.Data.Resolved.Users[i.Data.TargetID].ID)
 		assert.Equal(t, "132715550571888640", i.Data.Resolved.Members[i.Data.TargetID].User.ID)
 	})
+	t.Run("modal submit", func(t *testing.T) {
+		var m any
+		assert.Nil(t, json.Unmarshal([]byte(testInteractionCreate_ModalSubmit), &m))
+
+		i := InteractionFromMap(m, "")
+		assert.Equal(t, InteractionTypeModalSubmit, i.Type)
+		assert.Equal(t, "save_snippet/605598627141910556/891863146447413288", i.Data.CustomID)
+		tags, ok := GetComponentValue(i.Data.Components, SaveSnippetInputTags)
+		assert.True(t, ok)
+		assert.Equal(t, "&hmn, orca", tags)
+		assert.Equal(t, []string{"hmn", "orca"}, parseTagList(tags))
+	})
+	t.Run("autocomplete", func(t *testing.T) {
+		var m any
+		assert.Nil(t, json.Unmarshal([]byte(testInteractionCreate_Autocomplete), &m))
+
+		i := InteractionFromMap(m, "")
+		assert.Equal(t, InteractionTypeAutocomplete, i.Type)
+		assert.Equal(t, "project", i.Data.Name)
+		opt := mustGetInteractionOption(i.Data.Options, ProjectOptionName)
+		assert.True(t, opt.Focused)
+		assert.Equal(t, "orc", opt.Value)
+	})
+}
+
+func TestMessageReactionFromMap(t *testing.T) {
+	t.Run("unicode emoji", func(t *testing.T) {
+		var m any
+		assert.Nil(t, json.Unmarshal([]byte(testMessageReactionAdd_Unicode), &m))
+
+		r := MessageReactionFromMap(m)
+		assert.Equal(t, "132715550571888640", r.UserID)
+		assert.Equal(t, "891866615103254569", r.MessageID)
+		assert.Nil(t, r.Emoji.ID)
+		assert.Equal(t, "🔥", snippetReactionEmoji(r.Emoji))
+	})
+	t.Run("custom emoji", func(t *testing.T) {
+		var m any
+		assert.Nil(t, json.Unmarshal([]byte(testMessageReactionAdd_Custom), &m))
+
+		r := MessageReactionFromMap(m)
+		assert.Equal(t, "865957487026765864", *r.Emoji.ID)
+		assert.Equal(t, "confusedparrot:865957487026765864", snippetReactionEmoji(r.Emoji))
+	})
+	t.Run("remove all", func(t *testing.T) {
+		var m any
+		assert.Nil(t, json.Unmarshal([]byte(testMessageReactionRemoveAll), &m))
+
+		r := MessageReactionRemoveAllFromMap(m)
+		assert.Equal(t, "891866615103254569", r.MessageID)
+		assert.Nil(t, r.Emoji)
+	})
 }
 
 const testMessageCreate = `{
@@ -510,3 +562,115 @@ const testInteractionCreate_User = `{
 	"type": 2,
 	"version": 1
 }`
+
+const testMessageReactionAdd_Unicode = `{
+	"user_id": "132715550571888640",
+	"type": 0,
+	"message_id": "891866615103254569",
+	"message_author_id": "132715550571888640",
+	"emoji": {
+		"name": "🔥",
+		"id": null
+	},
+	"channel_id": "404399251276169217",
+	"burst": false,
+	"guild_id": "404399251276169217"
+}`
+
+const testMessageReactionAdd_Custom = `{
+	"user_id": "132715550571888640",
+	"type": 0,
+	"message_id": "891866615103254569",
+	"emoji": {
+		"name": "confusedparrot",
+		"id": "865957487026765864",
+		"animated": true
+	},
+	"channel_id": "404399251276169217",
+	"burst": false,
+	"guild_id": "404399251276169217"
+}`
+
+const testMessageReactionRemoveAll = `{
+	"message_id": "891866615103254569",
+	"channel_id": "404399251276169217",
+	"guild_id": "404399251276169217"
+}`
+
+const testInteractionCreate_ModalSubmit = `{
+	"application_id": "745036422834028565",
+	"channel_id": "605598627141910556",
+	"data": {
+		"components": [
+			{
+				"components": [
+					{
+						"custom_id": "tags",
+						"type": 4,
+						"value": "&hmn, orca"
+					}
+				],
+				"type": 1
+			}
+		],
+		"custom_id": "save_snippet/605598627141910556/891863146447413288"
+	},
+	"guild_id": "164936220651028480",
+	"id": "891863190520201246",
+	"member": {
+		"avatar": null,
+		"joined_at": "2016-03-31T03:17:39.375000+00:00",
+		"nick": null,
+		"roles": [
+			"876685379770646538"
+		],
+		"user": {
+			"avatar": "1963eacbf364164efce1c597dc66aeab",
+			"discriminator": "3719",
+			"id": "132715550571888640",
+			"public_flags": 0,
+			"username": "bvisness"
+		}
+	},
+	"token": "<redacted>",
+	"type": 5,
+	"version": 1
+}`
+
+const testInteractionCreate_Autocomplete = `{
+	"application_id": "745036422834028565",
+	"channel_id": "605598627141910556",
+	"data": {
+		"id": "891773437335462050",
+		"name": "project",
+		"options": [
+			{
+				"focused": true,
+				"name": "name",
+				"type": 3,
+				"value": "orc"
+			}
+		],
+		"type": 1
+	},
+	"guild_id": "164936220651028480",
+	"id": "891863190520201247",
+	"member": {
+		"avatar": null,
+		"joined_at": "2016-03-31T03:17:39.375000+00:00",
+		"nick": null,
+		"roles": [
+			"876685379770646538"
+		],
+		"user": {
+			"avatar": "1963eacbf364164efce1c597dc66aeab",
+			"discriminator": "3719",
+			"id": "132715550571888640",
+			"public_flags": 0,
+			"username": "bvisness"
+		}
+	},
+	"token": "<redacted>",
+	"type": 4,
+	"version": 1
+}`
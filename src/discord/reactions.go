@@ -0,0 +1,129 @@
+package discord
+
+import (
+	"context"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/logging"
+)
+
+/*
+Reactions on Discord messages that have become snippets are mirrored onto the
+snippet, so that the counts shown on the website include them. We only track
+reactions that arrive while the bot is connected; reactions left before a
+snippet existed are not backfilled.
+*/
+
+// The key we store for an emoji in snippet_reaction.emoji. Unicode emoji are
+// stored as-is, custom emoji as "name:id".
+func snippetReactionEmoji(emoji Emoji) string {
+	if emoji.ID != nil {
+		return emoji.Name + ":" + *emoji.ID
+	}
+	return emoji.Name
+}
+
+func (bot *botInstance) messageReactionAdd(ctx context.Context, reaction MessageReaction) {
+	log := logging.ExtractLogger(ctx)
+
+	if reaction.UserID == config.Config.Discord.BotUserID {
+		return
+	}
+	if reaction.Emoji.Name == "" {
+		// Custom emoji that have since been deleted come through without a name.
+		return
+	}
+
+	snippet, err := FetchSnippetForMessage(ctx, bot.dbConn, reaction.MessageID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to fetch snippet for reaction")
+		return
+	}
+	if snippet == nil {
+		return
+	}
+
+	_, err = bot.dbConn.Exec(ctx,
+		`
+		INSERT INTO snippet_reaction (snippet_id, emoji, discord_user_id, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT DO NOTHING
+		`,
+		snippet.ID,
+		snippetReactionEmoji(reaction.Emoji),
+		reaction.UserID,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to save snippet reaction from Discord")
+	}
+}
+
+func (bot *botInstance) messageReactionRemove(ctx context.Context, reaction MessageReaction) {
+	log := logging.ExtractLogger(ctx)
+
+	snippet, err := FetchSnippetForMessage(ctx, bot.dbConn, reaction.MessageID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to fetch snippet for reaction")
+		return
+	}
+	if snippet == nil {
+		return
+	}
+
+	_, err = bot.dbConn.Exec(ctx,
+		`
+		DELETE FROM snippet_reaction
+		WHERE
+			snippet_id = $1
+			AND discord_user_id = $2
+			AND emoji = $3
+		`,
+		snippet.ID,
+		reaction.UserID,
+		snippetReactionEmoji(reaction.Emoji),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to remove snippet reaction from Discord")
+	}
+}
+
+func (bot *botInstance) messageReactionRemoveAll(ctx context.Context, removeAll MessageReactionRemoveAll) {
+	log := logging.ExtractLogger(ctx)
+
+	snippet, err := FetchSnippetForMessage(ctx, bot.dbConn, removeAll.MessageID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to fetch snippet for reaction")
+		return
+	}
+	if snippet == nil {
+		return
+	}
+
+	// Reactions left on the website are not affected by moderation on Discord.
+	if removeAll.Emoji != nil {
+		_, err = bot.dbConn.Exec(ctx,
+			`
+			DELETE FROM snippet_reaction
+			WHERE
+				snippet_id = $1
+				AND discord_user_id IS NOT NULL
+				AND emoji = $2
+			`,
+			snippet.ID,
+			snippetReactionEmoji(*removeAll.Emoji),
+		)
+	} else {
+		_, err = bot.dbConn.Exec(ctx,
+			`
+			DELETE FROM snippet_reaction
+			WHERE
+				snippet_id = $1
+				AND discord_user_id IS NOT NULL
+			`,
+			snippet.ID,
+		)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to remove snippet reactions from Discord")
+	}
+}
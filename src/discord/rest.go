@@ -36,7 +36,11 @@ var NotFound = errors.New("not found")
 var httpClient = &http.Client{}
 
 func buildUrl(path string) string {
-	return fmt.Sprintf("%s%s", BaseUrl, path)
+	base := BaseUrl
+	if config.Config.Discord.ApiUrl != "" {
+		base = config.Config.Discord.ApiUrl
+	}
+	return fmt.Sprintf("%s%s", base, path)
 }
 
 func makeRequest(ctx context.Context, method string, path string, body []byte) *http.Request {
@@ -355,7 +359,9 @@ func DeleteMessage(ctx context.Context, channelID string, messageID string) erro
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusNoContent {
+	if res.StatusCode == http.StatusNotFound {
+		return NotFound
+	} else if res.StatusCode != http.StatusNoContent {
 		logErrorResponse(ctx, name, res, "")
 		return oops.New(nil, "got unexpected status code when deleting message")
 	}
@@ -652,6 +658,407 @@ func CreateGuildApplicationCommand(ctx context.Context, in CreateGuildApplicatio
 	return nil
 }
 
+// The fields of a scheduled event the bot sets, for both creating and
+// modifying. Voice and stage events need a channel; external events need a
+// location and an end time.
+//
+// See https://discord.com/developers/docs/resources/guild-scheduled-event#create-guild-scheduled-event-json-params
+type GuildScheduledEventRequest struct {
+	ChannelID          *string                            `json:"channel_id"` // must be null for external events
+	EntityMetadata     *GuildScheduledEventEntityMetadata `json:"entity_metadata"`
+	Name               string                             `json:"name"` // 1-100 characters
+	PrivacyLevel       int                                `json:"privacy_level"`
+	ScheduledStartTime string                             `json:"scheduled_start_time"` // ISO8601
+	ScheduledEndTime   *string                            `json:"scheduled_end_time"`
+	Description        *string                            `json:"description"` // 1-1000 characters
+	EntityType         GuildScheduledEventEntityType      `json:"entity_type"`
+}
+
+// See https://discord.com/developers/docs/resources/guild-scheduled-event#list-scheduled-events-for-guild
+func ListGuildScheduledEvents(ctx context.Context, guildID string) ([]GuildScheduledEvent, error) {
+	const name = "List Scheduled Events for Guild"
+
+	path := fmt.Sprintf("/guilds/%s/scheduled-events", guildID)
+	res, err := doWithRateLimiting(ctx, name, func(ctx context.Context) *http.Request {
+		return makeRequest(ctx, http.MethodGet, path, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		logErrorResponse(ctx, name, res, "")
+		return nil, oops.New(nil, "received error from Discord")
+	}
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	var events []GuildScheduledEvent
+	err = json.Unmarshal(bodyBytes, &events)
+	if err != nil {
+		return nil, oops.New(err, "failed to unmarshal Discord scheduled events")
+	}
+
+	return events, nil
+}
+
+// See https://discord.com/developers/docs/resources/guild-scheduled-event#create-guild-scheduled-event
+func CreateGuildScheduledEvent(ctx context.Context, guildID string, in GuildScheduledEventRequest) (*GuildScheduledEvent, error) {
+	const name = "Create Guild Scheduled Event"
+	path := fmt.Sprintf("/guilds/%s/scheduled-events", guildID)
+	return sendGuildScheduledEvent(ctx, name, http.MethodPost, path, in)
+}
+
+// Returns NotFound if the event was deleted, e.g. by a moderator.
+//
+// See https://discord.com/developers/docs/resources/guild-scheduled-event#modify-guild-scheduled-event
+func ModifyGuildScheduledEvent(ctx context.Context, guildID, eventID string, in GuildScheduledEventRequest) (*GuildScheduledEvent, error) {
+	const name = "Modify Guild Scheduled Event"
+	path := fmt.Sprintf("/guilds/%s/scheduled-events/%s", guildID, eventID)
+	return sendGuildScheduledEvent(ctx, name, http.MethodPatch, path, in)
+}
+
+func sendGuildScheduledEvent(ctx context.Context, name, method, path string, in GuildScheduledEventRequest) (*GuildScheduledEvent, error) {
+	payloadJSON, err := json.Marshal(in)
+	if err != nil {
+		return nil, oops.New(nil, "failed to marshal request body")
+	}
+
+	res, err := doWithRateLimiting(ctx, name, func(ctx context.Context) *http.Request {
+		req := makeRequest(ctx, method, path, payloadJSON)
+		req.Header.Add("Content-Type", "application/json")
+		return req
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, NotFound
+	} else if res.StatusCode >= 400 {
+		logErrorResponse(ctx, name, res, "")
+		return nil, oops.New(nil, "received error from Discord")
+	}
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	var event GuildScheduledEvent
+	err = json.Unmarshal(bodyBytes, &event)
+	if err != nil {
+		return nil, oops.New(err, "failed to unmarshal Discord scheduled event")
+	}
+
+	return &event, nil
+}
+
+// Returns NotFound if the event was already deleted.
+//
+// See https://discord.com/developers/docs/resources/guild-scheduled-event#delete-guild-scheduled-event
+func DeleteGuildScheduledEvent(ctx context.Context, guildID, eventID string) error {
+	const name = "Delete Guild Scheduled Event"
+
+	path := fmt.Sprintf("/guilds/%s/scheduled-events/%s", guildID, eventID)
+	res, err := doWithRateLimiting(ctx, name, func(ctx context.Context) *http.Request {
+		return makeRequest(ctx, http.MethodDelete, path, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return NotFound
+	} else if res.StatusCode != http.StatusNoContent {
+		logErrorResponse(ctx, name, res, "")
+		return oops.New(nil, "got unexpected status code when deleting scheduled event")
+	}
+
+	return nil
+}
+
+// Returns NotFound if the channel doesn't exist, or the bot can't see it.
+//
+// See https://discord.com/developers/docs/resources/channel#get-channel
+func GetChannel(ctx context.Context, channelID string) (*Channel, error) {
+	const name = "Get Channel"
+
+	path := fmt.Sprintf("/channels/%s", channelID)
+	res, err := doWithRateLimiting(ctx, name, func(ctx context.Context) *http.Request {
+		return makeRequest(ctx, http.MethodGet, path, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, NotFound
+	} else if res.StatusCode >= 400 {
+		logErrorResponse(ctx, name, res, "")
+		return nil, oops.New(nil, "received error from Discord")
+	}
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	var channel Channel
+	err = json.Unmarshal(bodyBytes, &channel)
+	if err != nil {
+		return nil, oops.New(err, "failed to unmarshal Discord channel")
+	}
+
+	return &channel, nil
+}
+
+// See https://discord.com/developers/docs/resources/channel#modify-channel-json-params-thread
+type ModifyChannelRequest struct {
+	Name *string `json:"name,omitempty"`
+}
+
+// Returns NotFound if the channel is gone.
+//
+// See https://discord.com/developers/docs/resources/channel#modify-channel
+func ModifyChannel(ctx context.Context, channelID string, in ModifyChannelRequest) (*Channel, error) {
+	const name = "Modify Channel"
+
+	payloadJSON, err := json.Marshal(in)
+	if err != nil {
+		return nil, oops.New(nil, "failed to marshal request body")
+	}
+
+	path := fmt.Sprintf("/channels/%s", channelID)
+	res, err := doWithRateLimiting(ctx, name, func(ctx context.Context) *http.Request {
+		req := makeRequest(ctx, http.MethodPatch, path, payloadJSON)
+		req.Header.Add("Content-Type", "application/json")
+		return req
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, NotFound
+	} else if res.StatusCode >= 400 {
+		logErrorResponse(ctx, name, res, "")
+		return nil, oops.New(nil, "received error from Discord")
+	}
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	var channel Channel
+	err = json.Unmarshal(bodyBytes, &channel)
+	if err != nil {
+		return nil, oops.New(err, "failed to unmarshal Discord channel")
+	}
+
+	return &channel, nil
+}
+
+// Deletes a channel, or closes a DM. Returns NotFound if the channel is
+// already gone.
+//
+// See https://discord.com/developers/docs/resources/channel#deleteclose-channel
+func DeleteChannel(ctx context.Context, channelID string) error {
+	const name = "Delete Channel"
+
+	path := fmt.Sprintf("/channels/%s", channelID)
+	res, err := doWithRateLimiting(ctx, name, func(ctx context.Context) *http.Request {
+		return makeRequest(ctx, http.MethodDelete, path, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return NotFound
+	} else if res.StatusCode >= 400 {
+		logErrorResponse(ctx, name, res, "")
+		return oops.New(nil, "received error from Discord")
+	}
+
+	return nil
+}
+
+// https://discord.com/developers/docs/resources/webhook#webhook-object
+type Webhook struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	Name      string `json:"name"`
+	Token     string `json:"token"`
+	// More fields not yet present
+}
+
+// See https://discord.com/developers/docs/resources/webhook#create-webhook
+func CreateWebhook(ctx context.Context, channelID string, webhookName string) (*Webhook, error) {
+	const name = "Create Webhook"
+
+	payloadJSON, err := json.Marshal(map[string]any{"name": webhookName})
+	if err != nil {
+		return nil, oops.New(nil, "failed to marshal request body")
+	}
+
+	path := fmt.Sprintf("/channels/%s/webhooks", channelID)
+	res, err := doWithRateLimiting(ctx, name, func(ctx context.Context) *http.Request {
+		req := makeRequest(ctx, http.MethodPost, path, payloadJSON)
+		req.Header.Add("Content-Type", "application/json")
+		return req
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		logErrorResponse(ctx, name, res, "")
+		return nil, oops.New(nil, "received error from Discord")
+	}
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	var webhook Webhook
+	err = json.Unmarshal(bodyBytes, &webhook)
+	if err != nil {
+		return nil, oops.New(err, "failed to unmarshal Discord webhook")
+	}
+
+	return &webhook, nil
+}
+
+// Username and AvatarUrl override the webhook's own for this message. In a
+// forum channel, either pass a thread ID to post in an existing post, or set
+// ThreadName to start a new one.
+//
+// See https://discord.com/developers/docs/resources/webhook#execute-webhook-jsonform-params
+type ExecuteWebhookRequest struct {
+	Content         string                  `json:"content"`
+	Username        string                  `json:"username,omitempty"` // 1-80 characters
+	AvatarUrl       string                  `json:"avatar_url,omitempty"`
+	ThreadName      string                  `json:"thread_name,omitempty"` // 1-100 characters
+	AllowedMentions *MessageAllowedMentions `json:"allowed_mentions,omitempty"`
+}
+
+// Waits for the message to be sent and returns it. When a message starts a
+// new forum post, its channel ID is the new thread's ID.
+//
+// See https://discord.com/developers/docs/resources/webhook#execute-webhook
+func ExecuteWebhook(ctx context.Context, webhook *Webhook, threadID string, in ExecuteWebhookRequest) (*Message, error) {
+	const name = "Execute Webhook"
+
+	payloadJSON, err := json.Marshal(in)
+	if err != nil {
+		return nil, oops.New(nil, "failed to marshal request body")
+	}
+
+	path := fmt.Sprintf("/webhooks/%s/%s", webhook.ID, webhook.Token)
+	return sendWebhookMessage(ctx, name, http.MethodPost, path, threadID, payloadJSON)
+}
+
+// Returns NotFound if the message was deleted.
+//
+// See https://discord.com/developers/docs/resources/webhook#edit-webhook-message
+func EditWebhookMessage(ctx context.Context, webhook *Webhook, threadID, messageID string, in ExecuteWebhookRequest) (*Message, error) {
+	const name = "Edit Webhook Message"
+
+	// Only some fields can be edited.
+	payloadJSON, err := json.Marshal(map[string]any{
+		"content":          in.Content,
+		"allowed_mentions": in.AllowedMentions,
+	})
+	if err != nil {
+		return nil, oops.New(nil, "failed to marshal request body")
+	}
+
+	path := fmt.Sprintf("/webhooks/%s/%s/messages/%s", webhook.ID, webhook.Token, messageID)
+	return sendWebhookMessage(ctx, name, http.MethodPatch, path, threadID, payloadJSON)
+}
+
+func sendWebhookMessage(ctx context.Context, name, method, path, threadID string, payloadJSON []byte) (*Message, error) {
+	res, err := doWithRateLimiting(ctx, name, func(ctx context.Context) *http.Request {
+		req := makeRequest(ctx, method, path, payloadJSON)
+		req.Header.Add("Content-Type", "application/json")
+		q := req.URL.Query()
+		q.Add("wait", "true")
+		if threadID != "" {
+			q.Add("thread_id", threadID)
+		}
+		req.URL.RawQuery = q.Encode()
+		return req
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, NotFound
+	} else if res.StatusCode >= 400 {
+		logErrorResponse(ctx, name, res, "")
+		return nil, oops.New(nil, "received error from Discord")
+	}
+
+	bodyBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	var msg Message
+	err = json.Unmarshal(bodyBytes, &msg)
+	if err != nil {
+		return nil, oops.New(err, "failed to unmarshal Discord message")
+	}
+
+	return &msg, nil
+}
+
+// Returns NotFound if the message was already deleted.
+//
+// See https://discord.com/developers/docs/resources/webhook#delete-webhook-message
+func DeleteWebhookMessage(ctx context.Context, webhook *Webhook, threadID, messageID string) error {
+	const name = "Delete Webhook Message"
+
+	path := fmt.Sprintf("/webhooks/%s/%s/messages/%s", webhook.ID, webhook.Token, messageID)
+	res, err := doWithRateLimiting(ctx, name, func(ctx context.Context) *http.Request {
+		req := makeRequest(ctx, http.MethodDelete, path, nil)
+		if threadID != "" {
+			q := req.URL.Query()
+			q.Add("thread_id", threadID)
+			req.URL.RawQuery = q.Encode()
+		}
+		return req
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return NotFound
+	} else if res.StatusCode != http.StatusNoContent {
+		logErrorResponse(ctx, name, res, "")
+		return oops.New(nil, "got unexpected status code when deleting webhook message")
+	}
+
+	return nil
+}
+
 func CreateInteractionResponse(ctx context.Context, interactionID, interactionToken string, in InteractionResponse) error {
 	const name = "Create Interaction Response"
 
@@ -0,0 +1,420 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/hmndata"
+	"git.handmade.network/hmn/hmn/src/jobs"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/utils"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+The website owns a handful of Discord roles and keeps them in line with what
+people are on HMN: linked members, approved users, staff, owners of featured
+projects, and jam participants. Roles are only ever managed if they are
+configured, and any other roles are left alone.
+
+Everything is reconciled the same way: we compute the roles each guild member
+should have from the database, compare against the roles they actually have,
+and add or remove the difference. A full sync runs every hour, and changes on
+the website queue a sync for just the affected people.
+*/
+
+// A Discord role that the website hands out.
+type ManagedRole struct {
+	Name   string
+	RoleID string
+
+	// Add-only roles are given out by the sync but never taken away. Jam
+	// roles work this way, since people also get them from /joinjam before
+	// they have a project.
+	AddOnly bool
+
+	applies func(u *roleSyncUser) bool
+}
+
+// A role the sync wants to add to or remove from someone.
+type RoleChange struct {
+	DiscordUserID string
+	DiscordName   string
+	HMNUsername   string // Empty if the Discord account is not linked
+	Role          ManagedRole
+	Add           bool
+}
+
+type roleSyncUser struct {
+	DiscordUserID       string            `db:"duser.userid"`
+	Username            string            `db:"hmn_user.username"`
+	Status              models.UserStatus `db:"hmn_user.status"`
+	IsStaff             bool              `db:"hmn_user.is_staff"`
+	OwnsFeaturedProject bool              `db:"extra.owns_featured_project"`
+	JamSlugs            []string          `db:"extra.jam_slugs"`
+}
+
+// The roles the sync manages, according to the current config. Roles without
+// an ID for this environment are skipped.
+func ManagedRoles() []ManagedRole {
+	var roles []ManagedRole
+	add := func(role ManagedRole) {
+		if role.RoleID != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	add(ManagedRole{
+		Name:    "Member",
+		RoleID:  config.Config.Discord.MemberRoleID,
+		applies: func(u *roleSyncUser) bool { return true },
+	})
+	add(ManagedRole{
+		Name:    "Approved",
+		RoleID:  config.Config.Discord.ApprovedRoleID,
+		applies: func(u *roleSyncUser) bool { return u.Status == models.UserStatusApproved },
+	})
+	add(ManagedRole{
+		Name:    "Staff",
+		RoleID:  config.Config.Discord.StaffRoleID,
+		applies: func(u *roleSyncUser) bool { return u.IsStaff },
+	})
+	add(ManagedRole{
+		Name:    "Featured project owner",
+		RoleID:  config.Config.Discord.FeaturedProjectOwnerRoleID,
+		applies: func(u *roleSyncUser) bool { return u.OwnsFeaturedProject },
+	})
+	for _, jam := range hmndata.AllJams {
+		slug := jam.Slug
+		add(ManagedRole{
+			Name:    jam.Name,
+			RoleID:  jam.DiscordRoleIDs[config.Config.Env],
+			AddOnly: true,
+			applies: func(u *roleSyncUser) bool { return slices.Contains(u.JamSlugs, slug) },
+		})
+	}
+
+	return roles
+}
+
+/*
+Works out which roles need to change for the given guild members, without
+changing anything. Members without a linked account, or whose account is
+banned, lose every managed role that isn't add-only.
+*/
+func PlanRoleChanges(ctx context.Context, dbConn db.ConnOrTx, members []GuildMember) ([]RoleChange, error) {
+	roles := ManagedRoles()
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	var discordIDs []string
+	for _, member := range members {
+		if member.User != nil {
+			discordIDs = append(discordIDs, member.User.ID)
+		}
+	}
+	users, err := db.Query[roleSyncUser](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM
+			discord_user AS duser
+			JOIN hmn_user ON hmn_user.id = duser.hmn_user_id
+			JOIN LATERAL (
+				SELECT
+					EXISTS (
+						SELECT 1
+						FROM
+							user_project AS uproj
+							JOIN project ON project.id = uproj.project_id
+						WHERE
+							uproj.user_id = hmn_user.id
+							AND project.featured
+							AND NOT project.hidden
+							AND project.lifecycle = ANY ($2)
+					) AS owns_featured_project,
+					ARRAY (
+						SELECT DISTINCT jam_project.jam_slug
+						FROM
+							user_project AS uproj
+							JOIN jam_project ON jam_project.project_id = uproj.project_id
+						WHERE
+							uproj.user_id = hmn_user.id
+							AND jam_project.participating
+					) AS jam_slugs
+			) AS extra ON TRUE
+		WHERE duser.userid = ANY ($1)
+		`,
+		discordIDs,
+		models.VisibleProjectLifecycles,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch linked users for role sync")
+	}
+	usersByDiscordID := make(map[string]*roleSyncUser, len(users))
+	for _, u := range users {
+		usersByDiscordID[u.DiscordUserID] = u
+	}
+
+	var changes []RoleChange
+	for _, member := range members {
+		if member.User == nil || member.User.IsBot {
+			continue
+		}
+		u := usersByDiscordID[member.User.ID]
+		for _, role := range roles {
+			want := u != nil && u.Status != models.UserStatusBanned && role.applies(u)
+			has := slices.Contains(member.Roles, role.RoleID)
+			if want == has || (has && role.AddOnly) {
+				continue
+			}
+
+			change := RoleChange{
+				DiscordUserID: member.User.ID,
+				DiscordName:   member.DisplayName(),
+				Role:          role,
+				Add:           want,
+			}
+			if u != nil {
+				change.HMNUsername = u.Username
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	return changes, nil
+}
+
+// Works out the role changes for the whole guild. This lists every member of
+// the guild, so it is slow.
+func PlanGuildRoleChanges(ctx context.Context, dbConn db.ConnOrTx) ([]RoleChange, error) {
+	if len(ManagedRoles()) == 0 {
+		return nil, nil
+	}
+	members, err := ListGuildMembers(ctx, config.Config.Discord.GuildID)
+	if err != nil {
+		return nil, oops.New(err, "failed to list guild members")
+	}
+	return PlanRoleChanges(ctx, dbConn, members)
+}
+
+// Applies role changes through the API. Failures are logged and skipped so
+// one missing member doesn't hold up everyone else; the number of changes
+// that failed is returned.
+func ApplyRoleChanges(ctx context.Context, changes []RoleChange) int {
+	log := logging.ExtractLogger(ctx)
+
+	failed := 0
+	for _, change := range changes {
+		var err error
+		if change.Add {
+			err = AddGuildMemberRole(ctx, change.DiscordUserID, change.Role.RoleID)
+		} else {
+			err = RemoveGuildMemberRole(ctx, change.DiscordUserID, change.Role.RoleID)
+		}
+		if err != nil {
+			log.Error().Err(err).
+				Str("user", change.DiscordUserID).
+				Str("role", change.Role.Name).
+				Bool("add", change.Add).
+				Msg("failed to change Discord role")
+			failed++
+		}
+	}
+	return failed
+}
+
+// A summary of the last sync the job ran, for the admin page.
+type RoleSyncReport struct {
+	Time    time.Time
+	Full    bool
+	Changes []RoleChange
+	Failed  int
+	Err     error
+}
+
+var lastRoleSync struct {
+	sync.Mutex
+	report *RoleSyncReport
+}
+
+// Returns the report from the last sync, or nil if there hasn't been one
+// since the website started.
+func LastRoleSync() *RoleSyncReport {
+	lastRoleSync.Lock()
+	defer lastRoleSync.Unlock()
+	return lastRoleSync.report
+}
+
+func recordRoleSync(report RoleSyncReport) {
+	lastRoleSync.Lock()
+	defer lastRoleSync.Unlock()
+	lastRoleSync.report = &report
+}
+
+// Syncs the roles for the whole guild and records the result for the admin
+// page.
+func SyncGuildRoles(ctx context.Context, dbConn db.ConnOrTx) RoleSyncReport {
+	report := RoleSyncReport{Time: time.Now(), Full: true}
+	report.Changes, report.Err = PlanGuildRoleChanges(ctx, dbConn)
+	if report.Err == nil {
+		report.Failed = ApplyRoleChanges(ctx, report.Changes)
+	}
+	recordRoleSync(report)
+	return report
+}
+
+// Syncs the roles for specific Discord users. Users who aren't in the guild
+// are skipped.
+func SyncMemberRoles(ctx context.Context, dbConn db.ConnOrTx, discordUserIDs []string) RoleSyncReport {
+	report := RoleSyncReport{Time: time.Now()}
+	report.Changes, report.Err = func() ([]RoleChange, error) {
+		if len(ManagedRoles()) == 0 {
+			return nil, nil
+		}
+		var members []GuildMember
+		for _, id := range discordUserIDs {
+			member, err := GetGuildMember(ctx, config.Config.Discord.GuildID, id)
+			if errors.Is(err, NotFound) {
+				continue
+			} else if err != nil {
+				return nil, oops.New(err, "failed to fetch guild member")
+			}
+			members = append(members, *member)
+		}
+		return PlanRoleChanges(ctx, dbConn, members)
+	}()
+	if report.Err == nil {
+		report.Failed = ApplyRoleChanges(ctx, report.Changes)
+	}
+	recordRoleSync(report)
+	return report
+}
+
+var roleSyncQueue struct {
+	sync.Mutex
+	discordUserIDs map[string]bool
+}
+var roleSyncQueued = make(chan struct{}, 1)
+
+/*
+Asks the role sync job to look at some Discord users soon. Call this after
+changing anything the roles depend on. It's fine to queue users who have since
+unlinked their account; that is how their roles get cleaned up.
+*/
+func QueueRoleSync(discordUserIDs ...string) {
+	if config.Config.Discord.BotToken == "" || len(discordUserIDs) == 0 {
+		return
+	}
+
+	roleSyncQueue.Lock()
+	if roleSyncQueue.discordUserIDs == nil {
+		roleSyncQueue.discordUserIDs = make(map[string]bool)
+	}
+	for _, id := range discordUserIDs {
+		roleSyncQueue.discordUserIDs[id] = true
+	}
+	roleSyncQueue.Unlock()
+
+	select {
+	case roleSyncQueued <- struct{}{}:
+	default:
+	}
+}
+
+// Queues a role sync for the Discord accounts linked to some HMN users. Users
+// without a linked account are ignored.
+func QueueRoleSyncForUsers(ctx context.Context, dbConn db.ConnOrTx, hmnUserIDs ...int) {
+	if config.Config.Discord.BotToken == "" || len(hmnUserIDs) == 0 {
+		return
+	}
+
+	discordUserIDs, err := db.QueryScalar[string](ctx, dbConn,
+		`
+		SELECT userid
+		FROM discord_user
+		WHERE hmn_user_id = ANY ($1)
+		`,
+		hmnUserIDs,
+	)
+	if err != nil {
+		logging.ExtractLogger(ctx).Error().Err(err).Msg("failed to look up Discord users for role sync")
+		return
+	}
+	QueueRoleSync(discordUserIDs...)
+}
+
+func takeQueuedRoleSyncs() []string {
+	roleSyncQueue.Lock()
+	defer roleSyncQueue.Unlock()
+
+	var ids []string
+	for id := range roleSyncQueue.discordUserIDs {
+		ids = append(ids, id)
+	}
+	roleSyncQueue.discordUserIDs = nil
+	return ids
+}
+
+func RunRoleSync(dbConn *pgxpool.Pool) *jobs.Job {
+	job := jobs.New("discord role sync")
+	log := job.Logger
+
+	if config.Config.Discord.BotToken == "" {
+		log.Warn().Msg("No Discord bot token was provided, so Discord roles will not be synced.")
+		return job.Finish()
+	}
+
+	go func() {
+		defer func() {
+			log.Debug().Msg("shut down Discord role sync")
+			job.Finish()
+		}()
+
+		fullSyncTicker := time.NewTicker(1 * time.Hour)
+		defer fullSyncTicker.Stop()
+
+		logReport := func(report RoleSyncReport) {
+			if report.Err != nil {
+				log.Error().Err(report.Err).Msg("failed to sync Discord roles")
+			} else if len(report.Changes) > 0 {
+				log.Info().
+					Int("changes", len(report.Changes)).
+					Int("failed", report.Failed).
+					Bool("full", report.Full).
+					Msg("Synced Discord roles")
+			}
+		}
+
+		for {
+			done, err := func() (done bool, err error) {
+				defer utils.RecoverPanicAsError(&err)
+				select {
+				case <-job.Canceled():
+					return true, nil
+				case <-fullSyncTicker.C:
+					logReport(SyncGuildRoles(job.Ctx, dbConn))
+				case <-roleSyncQueued:
+					if ids := takeQueuedRoleSyncs(); len(ids) > 0 {
+						logReport(SyncMemberRoles(job.Ctx, dbConn, ids))
+					}
+				}
+				return false, nil
+			}()
+			if err != nil {
+				log.Error().Err(err).Msg("Panicked in RunRoleSync")
+			} else if done {
+				return
+			}
+		}
+	}()
+
+	return job
+}
@@ -0,0 +1,389 @@
+package discord
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/calendar"
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/hmndata"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/jobs"
+	"git.handmade.network/hmn/hmn/src/logging"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/utils"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+/*
+Upcoming events from the calendars, along with jams and expos, are mirrored to
+the Discord server's scheduled events so they show up in its Events tab. Each
+calendar says where its events happen in its config; calendars that don't are
+left out.
+
+The sync works like the role sync: we work out the events Discord should have,
+compare against the events we made last time (tracked in
+discord_scheduled_event, keyed by iCal UID), and create, update, or delete the
+difference. Events nobody made through the sync are left alone. Once an event
+starts, Discord takes over, and we forget about it.
+
+The bot needs the Manage Events permission.
+*/
+
+// How far ahead events are mirrored. A guild can only have 100 scheduled
+// events, and nobody needs to see next year's meetups in Discord yet.
+const scheduledEventWindow = 60 * 24 * time.Hour
+
+const maxScheduledEvents = 100
+
+// The source of the events that come from hmndata instead of a calendar.
+const scheduledEventSourceHMN = "hmn"
+
+type wantedScheduledEvent struct {
+	Key       string
+	Source    string
+	StartTime time.Time
+	Request   GuildScheduledEventRequest
+}
+
+// Works out the scheduled events Discord should have, soonest first. Events
+// from calendars whose Discord channel is missing are skipped.
+func wantedScheduledEvents(ctx context.Context, calEvents []calendar.CalendarEvent, channels []Channel, now time.Time) []wantedScheduledEvent {
+	log := logging.ExtractLogger(ctx)
+
+	var wanted []wantedScheduledEvent
+	seenKeys := make(map[string]bool)
+	add := func(ev wantedScheduledEvent) {
+		if seenKeys[ev.Key] || !ev.StartTime.After(now) || ev.StartTime.After(now.Add(scheduledEventWindow)) {
+			return
+		}
+		seenKeys[ev.Key] = true
+		wanted = append(wanted, ev)
+	}
+
+	for _, ev := range calEvents {
+		idx := slices.IndexFunc(config.Config.Calendars, func(c config.CalendarSource) bool { return c.Name == ev.CalName })
+		if idx < 0 {
+			continue
+		}
+		source := config.Config.Calendars[idx]
+
+		key := ev.ID
+		if ev.Recurring {
+			key = ev.ID + "/" + ev.StartTime.UTC().Format(time.RFC3339)
+		}
+
+		var req GuildScheduledEventRequest
+		if source.DiscordChannelID != "" {
+			channelIdx := slices.IndexFunc(channels, func(c Channel) bool { return c.ID == source.DiscordChannelID })
+			if channelIdx < 0 {
+				log.Warn().Str("calendar", source.Name).Str("channel", source.DiscordChannelID).Msg("calendar's Discord channel doesn't exist")
+				continue
+			}
+			switch channels[channelIdx].Type {
+			case ChannelTypeGuildVoice:
+				req = voiceScheduledEvent(source.DiscordChannelID, GuildScheduledEventEntityTypeVoice, ev.Name, ev.Desc, ev.StartTime, ev.EndTime)
+			case ChannelTypeGuildStageVoice:
+				req = voiceScheduledEvent(source.DiscordChannelID, GuildScheduledEventEntityTypeStageInstance, ev.Name, ev.Desc, ev.StartTime, ev.EndTime)
+			default:
+				log.Warn().Str("calendar", source.Name).Str("channel", source.DiscordChannelID).Msg("calendar's Discord channel isn't a voice or stage channel")
+				continue
+			}
+		} else if source.DiscordLocation != "" {
+			req = externalScheduledEvent(source.DiscordLocation, ev.Name, ev.Desc, ev.StartTime, ev.EndTime)
+		} else {
+			continue
+		}
+
+		add(wantedScheduledEvent{
+			Key:       key,
+			Source:    source.Name,
+			StartTime: ev.StartTime,
+			Request:   req,
+		})
+	}
+
+	for _, jam := range hmndata.AllJams {
+		add(wantedScheduledEvent{
+			Key:       "hmn:jam:" + jam.Slug,
+			Source:    scheduledEventSourceHMN,
+			StartTime: jam.StartTime,
+			Request:   externalScheduledEvent(hmnurl.BuildJamGenericIndex(jam.UrlSlug), jam.Name, jam.Description, jam.StartTime, jam.EndTime),
+		})
+	}
+	for _, expo := range hmndata.AllExpos {
+		add(wantedScheduledEvent{
+			Key:       "hmn:expo:" + expo.Slug,
+			Source:    scheduledEventSourceHMN,
+			StartTime: expo.StartTime,
+			Request:   externalScheduledEvent(expo.IndexUrl, expo.Name, expo.Description, expo.StartTime, expo.EndTime),
+		})
+	}
+
+	sort.SliceStable(wanted, func(i, j int) bool {
+		return wanted[i].StartTime.Before(wanted[j].StartTime)
+	})
+	if len(wanted) > maxScheduledEvents {
+		wanted = wanted[:maxScheduledEvents]
+	}
+	return wanted
+}
+
+func voiceScheduledEvent(channelID string, entityType GuildScheduledEventEntityType, name, desc string, start, end time.Time) GuildScheduledEventRequest {
+	req := GuildScheduledEventRequest{
+		ChannelID:          &channelID,
+		Name:               truncateRunes(name, 100),
+		PrivacyLevel:       GuildScheduledEventPrivacyLevelGuildOnly,
+		ScheduledStartTime: start.UTC().Format(time.RFC3339),
+		EntityType:         entityType,
+	}
+	if desc != "" {
+		desc = truncateRunes(desc, 1000)
+		req.Description = &desc
+	}
+	if end.After(start) {
+		endStr := end.UTC().Format(time.RFC3339)
+		req.ScheduledEndTime = &endStr
+	}
+	return req
+}
+
+// External events must have an end time, so events without one are given an
+// hour.
+func externalScheduledEvent(location, name, desc string, start, end time.Time) GuildScheduledEventRequest {
+	if !end.After(start) {
+		end = start.Add(time.Hour)
+	}
+	endStr := end.UTC().Format(time.RFC3339)
+	req := GuildScheduledEventRequest{
+		EntityMetadata:     &GuildScheduledEventEntityMetadata{Location: truncateRunes(location, 100)},
+		Name:               truncateRunes(name, 100),
+		PrivacyLevel:       GuildScheduledEventPrivacyLevelGuildOnly,
+		ScheduledStartTime: start.UTC().Format(time.RFC3339),
+		ScheduledEndTime:   &endStr,
+		EntityType:         GuildScheduledEventEntityTypeExternal,
+	}
+	if desc != "" {
+		desc = truncateRunes(desc, 1000)
+		req.Description = &desc
+	}
+	return req
+}
+
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+func hashScheduledEventRequest(req GuildScheduledEventRequest) string {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		panic(err)
+	}
+	hash := sha256.Sum256(payload)
+	return hex.EncodeToString(hash[:])
+}
+
+type ScheduledEventSyncReport struct {
+	Created, Updated, Deleted, Failed int
+}
+
+/*
+Brings the guild's scheduled events in line with the calendars. Events from a
+calendar that failed to download are left as they are, rather than deleted, so
+a flaky calendar host doesn't empty the Events tab.
+*/
+func SyncScheduledEvents(ctx context.Context, dbConn db.ConnOrTx) (ScheduledEventSyncReport, error) {
+	log := logging.ExtractLogger(ctx)
+	guildID := config.Config.Discord.GuildID
+	now := time.Now()
+
+	var report ScheduledEventSyncReport
+
+	channels, err := GetGuildChannels(ctx, guildID)
+	if err != nil {
+		return report, oops.New(err, "failed to fetch guild channels")
+	}
+	wanted := wantedScheduledEvents(ctx, calendar.GetFutureEvents(), channels, now)
+
+	discordEvents, err := ListGuildScheduledEvents(ctx, guildID)
+	if err != nil {
+		return report, oops.New(err, "failed to fetch guild scheduled events")
+	}
+	onDiscord := make(map[string]bool, len(discordEvents))
+	for _, ev := range discordEvents {
+		onDiscord[ev.ID] = true
+	}
+
+	existing, err := db.Query[models.DiscordScheduledEvent](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM discord_scheduled_event
+		`,
+	)
+	if err != nil {
+		return report, oops.New(err, "failed to fetch mirrored scheduled events")
+	}
+	existingByKey := make(map[string]*models.DiscordScheduledEvent, len(existing))
+	for _, ev := range existing {
+		existingByKey[ev.Key] = ev
+	}
+
+	loadedCalendars := calendar.LoadedCalendars()
+	sourceLoaded := func(source string) bool {
+		if source == scheduledEventSourceHMN || slices.Contains(loadedCalendars, source) {
+			return true
+		}
+		// Calendars that were removed from the config aren't coming back.
+		return !slices.ContainsFunc(config.Config.Calendars, func(c config.CalendarSource) bool { return c.Name == source })
+	}
+
+	wantedKeys := make(map[string]bool, len(wanted))
+	for _, ev := range wanted {
+		wantedKeys[ev.Key] = true
+	}
+	for _, ev := range existing {
+		if wantedKeys[ev.Key] {
+			continue
+		}
+		if ev.StartTime.After(now) {
+			if !sourceLoaded(ev.Source) {
+				continue
+			}
+			err := DeleteGuildScheduledEvent(ctx, guildID, ev.EventID)
+			if err != nil && !errors.Is(err, NotFound) {
+				log.Error().Err(err).Str("key", ev.Key).Msg("failed to delete Discord scheduled event")
+				report.Failed++
+				continue
+			}
+			report.Deleted++
+		}
+		// Events that have started are Discord's problem now.
+		_, err := dbConn.Exec(ctx,
+			`
+			DELETE FROM discord_scheduled_event
+			WHERE key = $1
+			`,
+			ev.Key,
+		)
+		if err != nil {
+			return report, oops.New(err, "failed to forget Discord scheduled event")
+		}
+	}
+
+	for _, ev := range wanted {
+		hash := hashScheduledEventRequest(ev.Request)
+		prev := existingByKey[ev.Key]
+		if prev != nil && !onDiscord[prev.EventID] {
+			// Someone deleted it in Discord. It's still on the calendar, so
+			// it comes back.
+			prev = nil
+		}
+		if prev != nil && prev.PayloadHash == hash {
+			continue
+		}
+
+		var created *GuildScheduledEvent
+		if prev != nil {
+			created, err = ModifyGuildScheduledEvent(ctx, guildID, prev.EventID, ev.Request)
+			if errors.Is(err, NotFound) {
+				prev = nil
+			}
+		}
+		if prev == nil {
+			created, err = CreateGuildScheduledEvent(ctx, guildID, ev.Request)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("key", ev.Key).Msg("failed to send Discord scheduled event")
+			report.Failed++
+			continue
+		}
+		if prev == nil {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+
+		_, err = dbConn.Exec(ctx,
+			`
+			INSERT INTO discord_scheduled_event (key, source, event_id, start_time, payload_hash)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (key) DO UPDATE
+				SET source = $2, event_id = $3, start_time = $4, payload_hash = $5
+			`,
+			ev.Key,
+			ev.Source,
+			created.ID,
+			ev.StartTime,
+			hash,
+		)
+		if err != nil {
+			return report, oops.New(err, "failed to save Discord scheduled event")
+		}
+	}
+
+	return report, nil
+}
+
+func RunScheduledEventSync(dbConn *pgxpool.Pool) *jobs.Job {
+	job := jobs.New("discord scheduled event sync")
+	log := job.Logger
+
+	if config.Config.Discord.BotToken == "" {
+		log.Warn().Msg("No Discord bot token was provided, so Discord scheduled events will not be synced.")
+		return job.Finish()
+	}
+
+	go func() {
+		defer func() {
+			log.Debug().Msg("shut down Discord scheduled event sync")
+			job.Finish()
+		}()
+
+		// Give the calendars a chance to download first.
+		timer := time.NewTimer(1 * time.Minute)
+		defer timer.Stop()
+
+		for {
+			done, err := func() (done bool, err error) {
+				defer utils.RecoverPanicAsError(&err)
+				select {
+				case <-job.Canceled():
+					return true, nil
+				case <-timer.C:
+					timer.Reset(15 * time.Minute)
+					report, err := SyncScheduledEvents(job.Ctx, dbConn)
+					if err != nil {
+						log.Error().Err(err).Msg("failed to sync Discord scheduled events")
+					} else if report != (ScheduledEventSyncReport{}) {
+						log.Info().
+							Int("created", report.Created).
+							Int("updated", report.Updated).
+							Int("deleted", report.Deleted).
+							Int("failed", report.Failed).
+							Msg("Synced Discord scheduled events")
+					}
+				}
+				return false, nil
+			}()
+			if err != nil {
+				log.Error().Err(err).Msg("Panicked in RunScheduledEventSync")
+			} else if done {
+				return
+			}
+		}
+	}()
+
+	return job
+}
@@ -0,0 +1,93 @@
+package discord
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/calendar"
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/hmndata"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWantedScheduledEvents(t *testing.T) {
+	oldCalendars := config.Config.Calendars
+	config.Config.Calendars = []config.CalendarSource{
+		{Name: "Streams", DiscordChannelID: "100"},
+		{Name: "Meetups", DiscordLocation: "Vancouver"},
+		{Name: "Stage", DiscordChannelID: "200"},
+		{Name: "Missing", DiscordChannelID: "300"},
+		{Name: "Private"},
+	}
+	t.Cleanup(func() { config.Config.Calendars = oldCalendars })
+
+	channels := []Channel{
+		{ID: "100", Type: ChannelTypeGuildVoice},
+		{ID: "200", Type: ChannelTypeGuildStageVoice},
+	}
+
+	jam := hmndata.Essentials2026
+	now := jam.StartTime.Add(-7 * 24 * time.Hour)
+	hour := func(n int) time.Time { return now.Add(time.Duration(n) * time.Hour) }
+
+	calEvents := []calendar.CalendarEvent{
+		{ID: "stream", Name: "Stream", StartTime: hour(1), EndTime: hour(3), CalName: "Streams"},
+		{ID: "weekly", Name: "Weekly", StartTime: hour(2), EndTime: hour(2), CalName: "Meetups", Recurring: true},
+		{ID: "weekly", Name: "Weekly", StartTime: hour(2 + 24*7), EndTime: hour(2 + 24*7), CalName: "Meetups", Recurring: true},
+		{ID: "talk", Name: strings.Repeat("a", 150), Desc: "Talk", StartTime: hour(4), CalName: "Stage"},
+		{ID: "missing", Name: "Missing", StartTime: hour(5), CalName: "Missing"},
+		{ID: "private", Name: "Private", StartTime: hour(6), CalName: "Private"},
+		{ID: "later", Name: "Later", StartTime: now.Add(scheduledEventWindow + time.Hour), CalName: "Streams"},
+	}
+
+	wanted := wantedScheduledEvents(context.Background(), calEvents, channels, now)
+	var keys []string
+	for _, ev := range wanted {
+		keys = append(keys, ev.Key)
+	}
+	assert.Equal(t, []string{
+		"stream",
+		"weekly/" + hour(2).UTC().Format(time.RFC3339),
+		"talk",
+		"hmn:jam:" + jam.Slug,
+		"weekly/" + hour(2+24*7).UTC().Format(time.RFC3339),
+	}, keys)
+
+	stream := wanted[0].Request
+	assert.Equal(t, GuildScheduledEventEntityTypeVoice, stream.EntityType)
+	require.NotNil(t, stream.ChannelID)
+	assert.Equal(t, "100", *stream.ChannelID)
+	assert.Nil(t, stream.Description)
+	require.NotNil(t, stream.ScheduledEndTime)
+	assert.Equal(t, hour(3).UTC().Format(time.RFC3339), *stream.ScheduledEndTime)
+
+	// External events get an end time even if the calendar has none.
+	weekly := wanted[1].Request
+	assert.Equal(t, GuildScheduledEventEntityTypeExternal, weekly.EntityType)
+	assert.Nil(t, weekly.ChannelID)
+	assert.Equal(t, "Vancouver", weekly.EntityMetadata.Location)
+	require.NotNil(t, weekly.ScheduledEndTime)
+	assert.Equal(t, hour(3).UTC().Format(time.RFC3339), *weekly.ScheduledEndTime)
+
+	talk := wanted[2].Request
+	assert.Equal(t, GuildScheduledEventEntityTypeStageInstance, talk.EntityType)
+	assert.Len(t, []rune(talk.Name), 100)
+	assert.Nil(t, talk.ScheduledEndTime)
+
+	jamEvent := wanted[3]
+	assert.Equal(t, scheduledEventSourceHMN, jamEvent.Source)
+	assert.Equal(t, hmnurl.BuildJamGenericIndex(jam.UrlSlug), jamEvent.Request.EntityMetadata.Location)
+}
+
+func TestScheduledEventRequestHash(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := externalScheduledEvent("Vancouver", "Meetup", "", start, start)
+	b := externalScheduledEvent("Vancouver", "Meetup", "", start, start)
+	c := externalScheduledEvent("Seattle", "Meetup", "", start, start)
+	assert.Equal(t, hashScheduledEventRequest(a), hashScheduledEventRequest(b))
+	assert.NotEqual(t, hashScheduledEventRequest(a), hashScheduledEventRequest(c))
+}
@@ -3,6 +3,7 @@ package email
 import (
 	"bytes"
 	"fmt"
+	"html/template"
 	"mime"
 	"mime/quotedprintable"
 	"net/smtp"
@@ -167,6 +168,36 @@ func SendTimeMachineEmail(profileUrl, username, userEmail, discordUsername strin
 	return nil
 }
 
+type SnippetCommentEmailData struct {
+	Name          string
+	CommenterName string
+	SnippetUrl    string
+	Comment       template.HTML
+	SettingsUrl   string
+}
+
+func SendSnippetCommentEmail(toAddress string, toName string, commenterName string, snippetUrl string, commentHTML string, perf *perf.RequestPerf) error {
+	defer perf.StartBlock("EMAIL", "Snippet comment email").End()
+
+	contents, err := renderTemplate("email_snippet_comment.html", SnippetCommentEmailData{
+		Name:          toName,
+		CommenterName: commenterName,
+		SnippetUrl:    snippetUrl,
+		Comment:       template.HTML(commentHTML),
+		SettingsUrl:   hmnurl.BuildUserSettings("account"),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = sendMail(toAddress, toName, fmt.Sprintf("[Handmade Network] %s commented on your snippet", commenterName), contents)
+	if err != nil {
+		return oops.New(err, "Failed to send email")
+	}
+
+	return nil
+}
+
 func SendExpoTicketPurchaseEmail(toAddress string, toName string, ticket *models.Ticket) error {
 	event, ok := hmndata.FindTicketEventBySlug(ticket.EventSlug)
 	if !ok {
@@ -14,8 +14,13 @@ type PersistentVarName string
 
 const (
 	VarNameDiscordLivestreamMessage PersistentVarName = "discord_livestream_message"
+	VarNameDiscordBackfillPrefix    PersistentVarName = "discord_backfill:" // followed by the channel ID
 )
 
+func VarNameDiscordBackfill(channelID string) PersistentVarName {
+	return VarNameDiscordBackfillPrefix + PersistentVarName(channelID)
+}
+
 type StreamDetails struct {
 	Username  string    `json:"username"`
 	StartTime time.Time `json:"start_time"`
@@ -27,6 +32,23 @@ type DiscordLivestreamMessage struct {
 	Streamers []StreamDetails `json:"streamers"`
 }
 
+// The history of a Discord channel that still needs to be fetched. The
+// backfill works through the ranges in order, saving its progress as it goes.
+type DiscordBackfill struct {
+	ChannelID    string                 `json:"channel_id"`
+	Ranges       []DiscordBackfillRange `json:"ranges"`
+	LastFinished *time.Time             `json:"last_finished,omitempty"`
+}
+
+type DiscordBackfillRange struct {
+	After   string    `json:"after"`  // Messages after this ID are still to do. It moves forward as the backfill progresses.
+	Before  string    `json:"before"` // Stop at this ID. Empty to go all the way to the present.
+	Live    bool      `json:"live"`   // Handle messages as if they came from the gateway, applying rules and making snippets.
+	Reason  string    `json:"reason"`
+	Queued  time.Time `json:"queued"`
+	Handled int       `json:"handled"`
+}
+
 // NOTE(asaf): Returns db.NotFound if the variable isn't in the db.
 func FetchPersistentVar[T any](
 	ctx context.Context,
@@ -56,6 +78,39 @@ func FetchPersistentVar[T any](
 	return &result, nil
 }
 
+// Fetches every variable whose name starts with the prefix, e.g. one per
+// Discord channel.
+func FetchPersistentVarsWithPrefix[T any](
+	ctx context.Context,
+	dbConn db.ConnOrTx,
+	prefix PersistentVarName,
+) ([]*T, error) {
+	persistentVars, err := db.Query[models.PersistentVar](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM persistent_var
+		WHERE starts_with(name, $1)
+		ORDER BY name
+		`,
+		prefix,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch persistent vars")
+	}
+
+	var result []*T
+	for _, persistentVar := range persistentVars {
+		var value T
+		err = json.Unmarshal([]byte(persistentVar.Value), &value)
+		if err != nil {
+			return nil, oops.New(err, "failed to unmarshal persistent var value")
+		}
+		result = append(result, &value)
+	}
+
+	return result, nil
+}
+
 func StorePersistentVar[T any](
 	ctx context.Context,
 	dbConn db.ConnOrTx,
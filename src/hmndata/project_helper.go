@@ -3,6 +3,7 @@ package hmndata
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"git.handmade.network/hmn/hmn/src/db"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
@@ -31,6 +32,8 @@ type ProjectsQuery struct {
 	Slugs         []string // if empty, all projects
 	OwnerIDs      []int    // if empty, all projects
 	JamSlugs      []string // if empty, all projects
+	TopicIDs      []int    // if empty, all projects
+	NameQuery     string   // if empty, all projects; otherwise matches anywhere in the name or slug, ignoring case
 	ShowJamHidden bool
 
 	// Ignored when using CountProjects
@@ -54,6 +57,8 @@ func (p *ProjectAndStuff) TagText() string {
 	}
 }
 
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func FetchProjects(
 	ctx context.Context,
 	dbConn db.ConnOrTx,
@@ -143,6 +148,13 @@ func FetchProjects(
 	if len(q.Slugs) > 0 {
 		qb.Add(`AND (project.slug != '' AND (project.slug = ANY ($?) OR $? && project.slug_aliases))`, q.Slugs, q.Slugs)
 	}
+	if len(q.TopicIDs) > 0 {
+		qb.Add(`AND project.id IN (SELECT project_id FROM project_topic WHERE topic_id = ANY ($?))`, q.TopicIDs)
+	}
+	if q.NameQuery != "" {
+		pattern := "%" + likeEscaper.Replace(q.NameQuery) + "%"
+		qb.Add(`AND (project.name ILIKE $? OR project.slug ILIKE $?)`, pattern, pattern)
+	}
 	if len(q.JamSlugs) > 0 {
 		qb.Add(`AND (jam_project.jam_slug = ANY ($?) AND jam_project.participating = TRUE AND (project.jam_hidden = FALSE OR $?))`, q.JamSlugs, q.ShowJamHidden)
 	}
@@ -7,6 +7,7 @@ import (
 	"git.handmade.network/hmn/hmn/src/models"
 	"git.handmade.network/hmn/hmn/src/oops"
 	"git.handmade.network/hmn/hmn/src/perf"
+	"github.com/google/uuid"
 )
 
 type SnippetQuery struct {
@@ -14,6 +15,7 @@ type SnippetQuery struct {
 	OwnerIDs          []int
 	ProjectIDs        []int
 	Tags              []int
+	TopicIDs          []int
 	DiscordMessageIDs []string
 
 	FeaturedOnly bool
@@ -24,10 +26,14 @@ type SnippetQuery struct {
 type SnippetAndStuff struct {
 	Snippet        models.Snippet
 	Owner          *models.User
-	Asset          *models.Asset          `db:"asset"`
+	Asset          *models.Asset          `db:"asset"` // the first of Assets, if any
 	DiscordMessage *models.DiscordMessage `db:"discord_message"`
+	Assets         []*models.Asset
 	Tags           []*models.Tag
+	Topics         []*models.Topic
 	Projects       []*ProjectAndStuff
+	Reactions      []SnippetReactionCount
+	CommentCount   int
 }
 
 func FetchSnippets(
@@ -44,7 +50,7 @@ func FetchSnippets(
 	}
 	defer tx.Rollback(ctx)
 
-	isFiltering := len(q.IDs) > 0 || len(q.Tags) > 0 || len(q.ProjectIDs) > 0
+	isFiltering := len(q.IDs) > 0 || len(q.Tags) > 0 || len(q.TopicIDs) > 0 || len(q.ProjectIDs) > 0
 
 	var tagSnippetIDs []int
 	if len(q.Tags) > 0 {
@@ -68,6 +74,25 @@ func FetchSnippets(
 		tagSnippetIDs = snippetIDs
 	}
 
+	var topicSnippetIDs []int
+	if len(q.TopicIDs) > 0 {
+		// Get snippet IDs with these topics, then use that in the main query
+		snippetIDs, err := db.QueryScalar[int](ctx, tx,
+			`
+			---- Get snippet IDs for topics
+			SELECT DISTINCT snippet_id
+			FROM snippet_topic
+			WHERE topic_id = ANY ($1)
+			`,
+			q.TopicIDs,
+		)
+		if err != nil {
+			return nil, oops.New(err, "failed to get snippet IDs for topics")
+		}
+
+		topicSnippetIDs = snippetIDs
+	}
+
 	var projectSnippetIDs []int
 	if len(q.ProjectIDs) > 0 {
 		// Get snippet IDs for these projects, then use that in the main query
@@ -104,9 +129,10 @@ func FetchSnippets(
 			TRUE
 		`,
 	)
-	allSnippetIDs := make([]int, 0, len(q.IDs)+len(tagSnippetIDs)+len(projectSnippetIDs))
+	allSnippetIDs := make([]int, 0, len(q.IDs)+len(tagSnippetIDs)+len(topicSnippetIDs)+len(projectSnippetIDs))
 	allSnippetIDs = append(allSnippetIDs, q.IDs...)
 	allSnippetIDs = append(allSnippetIDs, tagSnippetIDs...)
+	allSnippetIDs = append(allSnippetIDs, topicSnippetIDs...)
 	allSnippetIDs = append(allSnippetIDs, projectSnippetIDs...)
 	if isFiltering && len(allSnippetIDs) == 0 {
 		// We already managed to filter out all snippets, and all further
@@ -208,6 +234,32 @@ func FetchSnippets(
 		item.Tags = append(item.Tags, snippetTag.Tag)
 	}
 
+	// Fetch topics
+	type snippetTopicRow struct {
+		SnippetID int           `db:"snippet_topic.snippet_id"`
+		Topic     *models.Topic `db:"topic"`
+	}
+	snippetTopics, err := db.Query[snippetTopicRow](ctx, tx,
+		`
+		---- Get topics for snippets
+		SELECT $columns
+		FROM
+			snippet_topic
+			JOIN topic ON snippet_topic.topic_id = topic.id
+		WHERE
+			snippet_topic.snippet_id = ANY($1)
+		ORDER BY topic.name ASC
+		`,
+		snippetIDs,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch topics for snippets")
+	}
+	for _, snippetTopic := range snippetTopics {
+		item := resultBySnippetId[snippetTopic.SnippetID]
+		item.Topics = append(item.Topics, snippetTopic.Topic)
+	}
+
 	// Fetch projects
 	type snippetProjectRow struct {
 		SnippetID int `db:"snippet_id"`
@@ -245,6 +297,32 @@ func FetchSnippets(
 		}
 	}
 
+	// Fetch attachments
+	snippetAssets, err := FetchSnippetAssets(ctx, tx, snippetIDs)
+	if err != nil {
+		return nil, err
+	}
+	for id, snip := range resultBySnippetId {
+		snip.Assets = snippetAssets[id]
+		if len(snip.Assets) == 0 && snip.Asset != nil {
+			snip.Assets = []*models.Asset{snip.Asset}
+		}
+	}
+
+	// Fetch reactions and comment counts
+	reactions, err := FetchSnippetReactionCounts(ctx, tx, currentUser, snippetIDs)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch reactions for snippets")
+	}
+	commentCounts, err := FetchSnippetCommentCounts(ctx, tx, snippetIDs)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch comment counts for snippets")
+	}
+	for id, snip := range resultBySnippetId {
+		snip.Reactions = reactions[id]
+		snip.CommentCount = commentCounts[id]
+	}
+
 	err = tx.Commit(ctx)
 	if err != nil {
 		return nil, oops.New(err, "failed to commit transaction")
@@ -302,3 +380,78 @@ func FetchSnippetForDiscordMessage(
 
 	return res[0], nil
 }
+
+// Fetches the attachments for each of the given snippets, in order.
+func FetchSnippetAssets(ctx context.Context, dbConn db.ConnOrTx, snippetIDs []int) (map[int][]*models.Asset, error) {
+	type snippetAssetRow struct {
+		SnippetID int           `db:"snippet_asset.snippet_id"`
+		Asset     *models.Asset `db:"asset"`
+	}
+	rows, err := db.Query[snippetAssetRow](ctx, dbConn,
+		`
+		---- Fetch snippet assets
+		SELECT $columns
+		FROM
+			snippet_asset
+			JOIN asset ON snippet_asset.asset_id = asset.id
+		WHERE snippet_asset.snippet_id = ANY ($1)
+		ORDER BY snippet_asset.snippet_id, snippet_asset.ordering
+		`,
+		snippetIDs,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch snippet assets")
+	}
+
+	result := make(map[int][]*models.Asset)
+	for _, row := range rows {
+		result[row.SnippetID] = append(result[row.SnippetID], row.Asset)
+	}
+	return result, nil
+}
+
+/*
+Replaces the attachments of a snippet with the given assets, in order. The
+first asset also becomes the snippet's asset_id, which is still what showcases,
+jam pages, and OpenGraph tags use.
+*/
+func SetSnippetAssets(ctx context.Context, dbConn db.ConnOrTx, snippetID int, assetIDs []uuid.UUID) error {
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return oops.New(err, "failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DELETE FROM snippet_asset WHERE snippet_id = $1`, snippetID)
+	if err != nil {
+		return oops.New(err, "failed to clear snippet assets")
+	}
+	_, err = tx.Exec(ctx,
+		`
+		INSERT INTO snippet_asset (snippet_id, asset_id, ordering)
+		SELECT $1, asset_id, ordering - 1
+		FROM unnest($2::UUID[]) WITH ORDINALITY AS a (asset_id, ordering)
+		ON CONFLICT DO NOTHING
+		`,
+		snippetID,
+		assetIDs,
+	)
+	if err != nil {
+		return oops.New(err, "failed to add snippet assets")
+	}
+
+	var primaryAssetID *uuid.UUID
+	if len(assetIDs) > 0 {
+		primaryAssetID = &assetIDs[0]
+	}
+	_, err = tx.Exec(ctx, `UPDATE snippet SET asset_id = $2 WHERE id = $1`, snippetID, primaryAssetID)
+	if err != nil {
+		return oops.New(err, "failed to update primary snippet asset")
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return oops.New(err, "failed to commit transaction")
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package hmndata
+
+import (
+	"context"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/perf"
+)
+
+type SnippetReactionCount struct {
+	SnippetID int    `db:"snippet_id"`
+	Emoji     string `db:"emoji"`
+	Count     int    `db:"count"`
+
+	// Whether the current user has left this reaction on the website.
+	Reacted bool `db:"reacted"`
+}
+
+// Fetches the reactions on each of the given snippets, grouped by emoji and
+// ordered by when each emoji was first used. Someone who reacted both on the
+// website and from their linked Discord account is only counted once.
+func FetchSnippetReactionCounts(
+	ctx context.Context,
+	dbConn db.ConnOrTx,
+	currentUser *models.User,
+	snippetIDs []int,
+) (map[int][]SnippetReactionCount, error) {
+	defer perf.StartBlock(ctx, "SNIPPET", "Fetch reaction counts").End()
+
+	currentUserID := -1
+	if currentUser != nil {
+		currentUserID = currentUser.ID
+	}
+
+	counts, err := db.Query[SnippetReactionCount](ctx, dbConn,
+		`
+		---- Fetch snippet reaction counts
+		SELECT $columns
+		FROM (
+			SELECT
+				r.snippet_id,
+				r.emoji,
+				count(DISTINCT COALESCE(
+					'hmn:' || COALESCE(r.user_id, linked.hmn_user_id),
+					'discord:' || r.discord_user_id
+				)) AS count,
+				bool_or(r.user_id IS NOT NULL AND r.user_id = $2) AS reacted,
+				min(r.created_at) AS first_reacted_at
+			FROM
+				snippet_reaction AS r
+				LEFT JOIN discord_user AS linked ON linked.userid = r.discord_user_id
+			WHERE r.snippet_id = ANY ($1)
+			GROUP BY r.snippet_id, r.emoji
+		) AS counts
+		ORDER BY first_reacted_at ASC
+		`,
+		snippetIDs,
+		currentUserID,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch snippet reaction counts")
+	}
+
+	result := make(map[int][]SnippetReactionCount)
+	for _, count := range counts {
+		result[count.SnippetID] = append(result[count.SnippetID], *count)
+	}
+	return result, nil
+}
+
+// Adds the user's reaction to a snippet, or removes it if they had already
+// left it. Returns whether the reaction is now present.
+func ToggleSnippetReaction(ctx context.Context, dbConn db.ConnOrTx, snippetID int, userID int, emoji string) (bool, error) {
+	tag, err := dbConn.Exec(ctx,
+		`
+		DELETE FROM snippet_reaction
+		WHERE snippet_id = $1 AND user_id = $2 AND emoji = $3
+		`,
+		snippetID,
+		userID,
+		emoji,
+	)
+	if err != nil {
+		return false, oops.New(err, "failed to remove snippet reaction")
+	}
+	if tag.RowsAffected() > 0 {
+		return false, nil
+	}
+
+	_, err = dbConn.Exec(ctx,
+		`
+		INSERT INTO snippet_reaction (snippet_id, emoji, user_id, created_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT DO NOTHING
+		`,
+		snippetID,
+		emoji,
+		userID,
+	)
+	if err != nil {
+		return false, oops.New(err, "failed to add snippet reaction")
+	}
+	return true, nil
+}
+
+type SnippetCommentAndStuff struct {
+	Comment      models.SnippetComment `db:"snippet_comment"`
+	Author       *models.User          `db:"author"`
+	AuthorAvatar *models.Asset         `db:"avatar"`
+}
+
+func FetchSnippetComments(ctx context.Context, dbConn db.ConnOrTx, snippetID int) ([]*SnippetCommentAndStuff, error) {
+	defer perf.StartBlock(ctx, "SNIPPET", "Fetch comments").End()
+
+	comments, err := db.Query[SnippetCommentAndStuff](ctx, dbConn,
+		`
+		---- Fetch snippet comments
+		SELECT $columns
+		FROM
+			snippet_comment
+			JOIN hmn_user AS author ON snippet_comment.author_id = author.id
+			LEFT JOIN asset AS avatar ON avatar.id = author.avatar_asset_id
+		WHERE
+			snippet_comment.snippet_id = $1
+			AND author.status != $2
+		ORDER BY snippet_comment.created_at ASC
+		`,
+		snippetID,
+		models.UserStatusBanned,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch snippet comments")
+	}
+
+	for _, comment := range comments {
+		comment.Author.AvatarAsset = comment.AuthorAvatar
+	}
+	return comments, nil
+}
+
+func FetchSnippetCommentCounts(ctx context.Context, dbConn db.ConnOrTx, snippetIDs []int) (map[int]int, error) {
+	type commentCountRow struct {
+		SnippetID int `db:"snippet_id"`
+		Count     int `db:"count"`
+	}
+	rows, err := db.Query[commentCountRow](ctx, dbConn,
+		`
+		---- Fetch snippet comment counts
+		SELECT $columns
+		FROM (
+			SELECT snippet_id, count(*) AS count
+			FROM
+				snippet_comment
+				JOIN hmn_user AS author ON snippet_comment.author_id = author.id
+			WHERE
+				snippet_id = ANY ($1)
+				AND author.status != $2
+			GROUP BY snippet_id
+		) AS counts
+		`,
+		snippetIDs,
+		models.UserStatusBanned,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch snippet comment counts")
+	}
+
+	result := make(map[int]int, len(rows))
+	for _, row := range rows {
+		result[row.SnippetID] = row.Count
+	}
+	return result, nil
+}
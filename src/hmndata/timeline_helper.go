@@ -30,9 +30,13 @@ type TimelineItemAndStuff struct {
 	Owner          *models.User           `db:"owner"`
 	ThreadOwner    *models.User           `db:"thread_owner"`
 	AvatarAsset    *models.Asset          `db:"avatar"`
-	Asset          *models.Asset          `db:"asset"`
+	Asset          *models.Asset          `db:"asset"` // the first of Assets, if any
 	DiscordMessage *models.DiscordMessage `db:"discord_message"`
+	Assets         []*models.Asset
 	Projects       []*ProjectAndStuff
+	Topics         []*models.Topic
+	Reactions      []SnippetReactionCount
+	CommentCount   int
 }
 
 func FetchTimeline(
@@ -313,6 +317,54 @@ func FetchTimeline(
 		projectTargets[sp.ProjectID] = append(projectTargets[sp.ProjectID], snippetItems[sp.SnippetID])
 	}
 
+	type snippetTopicRow struct {
+		SnippetID int           `db:"snippet_topic.snippet_id"`
+		Topic     *models.Topic `db:"topic"`
+	}
+	snippetTopics, err := db.Query[snippetTopicRow](ctx, dbConn,
+		`
+		---- Fetch snippet topics
+		SELECT $columns
+		FROM
+			snippet_topic
+			JOIN topic ON snippet_topic.topic_id = topic.id
+		WHERE snippet_topic.snippet_id = ANY($1)
+		ORDER BY topic.name ASC
+		`,
+		snippetIds,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch topics for timeline")
+	}
+	for _, st := range snippetTopics {
+		item := snippetItems[st.SnippetID]
+		item.Topics = append(item.Topics, st.Topic)
+	}
+
+	snippetAssets, err := FetchSnippetAssets(ctx, dbConn, snippetIds)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch assets for timeline")
+	}
+	for id, item := range snippetItems {
+		item.Assets = snippetAssets[id]
+		if len(item.Assets) == 0 && item.Asset != nil {
+			item.Assets = []*models.Asset{item.Asset}
+		}
+	}
+
+	reactions, err := FetchSnippetReactionCounts(ctx, dbConn, currentUser, snippetIds)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch reactions for timeline")
+	}
+	commentCounts, err := FetchSnippetCommentCounts(ctx, dbConn, snippetIds)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch comment counts for timeline")
+	}
+	for id, item := range snippetItems {
+		item.Reactions = reactions[id]
+		item.CommentCount = commentCounts[id]
+	}
+
 	projects, err := FetchProjects(ctx, dbConn, currentUser, ProjectsQuery{
 		ProjectIDs:    projectIds,
 		IncludeHidden: true,
@@ -0,0 +1,250 @@
+package hmndata
+
+import (
+	"context"
+	"strings"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/perf"
+)
+
+type TopicQuery struct {
+	IDs       []int
+	Slugs     []string // matches either the topic's slug or one of its aliases
+	Names     []string // matched ignoring case
+	ParentIDs []int
+
+	Limit, Offset int
+}
+
+func FetchTopics(ctx context.Context, dbConn db.ConnOrTx, q TopicQuery) ([]*models.Topic, error) {
+	defer perf.StartBlock(ctx, "TOPIC", "Fetch topics").End()
+
+	var qb db.QueryBuilder
+	qb.Add(
+		`
+		---- Fetch topics
+		SELECT $columns
+		FROM topic
+		WHERE
+			TRUE
+		`,
+	)
+	if len(q.IDs) > 0 {
+		qb.Add(`AND id = ANY ($?)`, q.IDs)
+	}
+	if len(q.Slugs) > 0 {
+		qb.Add(`AND (slug = ANY ($?) OR $? && aliases)`, q.Slugs, q.Slugs)
+	}
+	if len(q.Names) > 0 {
+		var names []string
+		for _, name := range q.Names {
+			names = append(names, strings.ToLower(name))
+		}
+		qb.Add(`AND LOWER(name) = ANY ($?)`, names)
+	}
+	if len(q.ParentIDs) > 0 {
+		qb.Add(`AND parent_id = ANY ($?)`, q.ParentIDs)
+	}
+	qb.Add(`ORDER BY name ASC`)
+	if q.Limit > 0 {
+		qb.Add(`LIMIT $? OFFSET $?`, q.Limit, q.Offset)
+	}
+
+	topics, err := db.Query[models.Topic](ctx, dbConn, qb.String(), qb.Args()...)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch topics")
+	}
+	return topics, nil
+}
+
+func FetchTopic(ctx context.Context, dbConn db.ConnOrTx, q TopicQuery) (*models.Topic, error) {
+	topics, err := FetchTopics(ctx, dbConn, q)
+	if err != nil {
+		return nil, err
+	}
+	if len(topics) == 0 {
+		return nil, db.NotFound
+	}
+	return topics[0], nil
+}
+
+// Returns the IDs of the given topic and all topics nested beneath it, at any
+// depth. Topic landing pages use this so that a parent topic also shows
+// everything filed under its children.
+func FetchTopicDescendantIDs(ctx context.Context, dbConn db.ConnOrTx, topicID int) ([]int, error) {
+	defer perf.StartBlock(ctx, "TOPIC", "Fetch topic descendants").End()
+
+	ids, err := db.QueryScalar[int](ctx, dbConn,
+		`
+		---- Fetch topic descendants
+		WITH RECURSIVE descendants (id) AS (
+			SELECT id FROM topic WHERE id = $1
+			UNION
+			SELECT topic.id
+			FROM
+				topic
+				JOIN descendants ON topic.parent_id = descendants.id
+		)
+		SELECT id FROM descendants
+		`,
+		topicID,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch topic descendants")
+	}
+	return ids, nil
+}
+
+// Returns the given topic followed by each of its ancestors, nearest first.
+func FetchTopicLineage(ctx context.Context, dbConn db.ConnOrTx, topic *models.Topic) ([]*models.Topic, error) {
+	lineage := []*models.Topic{topic}
+	seen := map[int]bool{topic.ID: true}
+	for current := topic; current.ParentID != nil; {
+		if seen[*current.ParentID] {
+			break // Staff should never create a cycle, but let's not loop forever if they do.
+		}
+		parent, err := FetchTopic(ctx, dbConn, TopicQuery{IDs: []int{*current.ParentID}})
+		if err != nil {
+			return nil, err
+		}
+		lineage = append(lineage, parent)
+		seen[parent.ID] = true
+		current = parent
+	}
+	return lineage, nil
+}
+
+// Splits a comma-separated list of topic slugs as entered in a form.
+func ParseTopicSlugs(s string) []string {
+	var slugs []string
+	for _, slug := range strings.Split(s, ",") {
+		slug = strings.ToLower(strings.TrimSpace(slug))
+		if slug != "" {
+			slugs = append(slugs, slug)
+		}
+	}
+	return slugs
+}
+
+// Looks up topics by slug or alias. Any slugs that don't correspond to a
+// topic are returned in `unknown` so the caller can reject the request.
+func ResolveTopicSlugs(ctx context.Context, dbConn db.ConnOrTx, slugs []string) (topics []*models.Topic, unknown []string, err error) {
+	if len(slugs) == 0 {
+		return nil, nil, nil
+	}
+
+	topics, err = FetchTopics(ctx, dbConn, TopicQuery{Slugs: slugs})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, slug := range slugs {
+		found := false
+		for _, topic := range topics {
+			if topic.Slug == slug {
+				found = true
+				break
+			}
+			for _, alias := range topic.Aliases {
+				if alias == slug {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			unknown = append(unknown, slug)
+		}
+	}
+
+	return topics, unknown, nil
+}
+
+func FetchProjectTopics(ctx context.Context, dbConn db.ConnOrTx, projectID int) ([]*models.Topic, error) {
+	topics, err := db.Query[models.Topic](ctx, dbConn,
+		`
+		---- Fetch project topics
+		SELECT $columns{topic}
+		FROM
+			project_topic
+			JOIN topic ON project_topic.topic_id = topic.id
+		WHERE project_topic.project_id = $1
+		ORDER BY topic.name ASC
+		`,
+		projectID,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch project topics")
+	}
+	return topics, nil
+}
+
+func FetchEduArticleTopics(ctx context.Context, dbConn db.ConnOrTx, articleID int) ([]*models.Topic, error) {
+	topics, err := db.Query[models.Topic](ctx, dbConn,
+		`
+		---- Fetch education article topics
+		SELECT $columns{topic}
+		FROM
+			education_article_topic
+			JOIN topic ON education_article_topic.topic_id = topic.id
+		WHERE education_article_topic.article_id = $1
+		ORDER BY topic.name ASC
+		`,
+		articleID,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch education article topics")
+	}
+	return topics, nil
+}
+
+func SetProjectTopics(ctx context.Context, dbConn db.ConnOrTx, projectID int, topics []*models.Topic) error {
+	return setTopics(ctx, dbConn, "project_topic", "project_id", projectID, topics)
+}
+
+func SetSnippetTopics(ctx context.Context, dbConn db.ConnOrTx, snippetID int, topics []*models.Topic) error {
+	return setTopics(ctx, dbConn, "snippet_topic", "snippet_id", snippetID, topics)
+}
+
+func SetEduArticleTopics(ctx context.Context, dbConn db.ConnOrTx, articleID int, topics []*models.Topic) error {
+	return setTopics(ctx, dbConn, "education_article_topic", "article_id", articleID, topics)
+}
+
+func setTopics(ctx context.Context, dbConn db.ConnOrTx, table, column string, id int, topics []*models.Topic) error {
+	tx, err := dbConn.Begin(ctx)
+	if err != nil {
+		return oops.New(err, "failed to start transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	topicIDs := make([]int, 0, len(topics))
+	for _, topic := range topics {
+		topicIDs = append(topicIDs, topic.ID)
+	}
+
+	// table and column are never user input; see the Set*Topics functions above.
+	_, err = tx.Exec(ctx, `DELETE FROM `+table+` WHERE `+column+` = $1`, id)
+	if err != nil {
+		return oops.New(err, "failed to clear topics from %s", table)
+	}
+	_, err = tx.Exec(ctx,
+		`
+		INSERT INTO `+table+` (`+column+`, topic_id)
+		SELECT $1, unnest($2::INT[])
+		ON CONFLICT DO NOTHING
+		`,
+		id, topicIDs,
+	)
+	if err != nil {
+		return oops.New(err, "failed to add topics to %s", table)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return oops.New(err, "failed to commit transaction")
+	}
+	return nil
+}
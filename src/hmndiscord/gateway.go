@@ -0,0 +1,378 @@
+package hmndiscord
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// https://discord.com/developers/docs/topics/opcodes-and-status-codes#gateway-gateway-opcodes
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opResume         = 6
+	opReconnect      = 7
+	opInvalidSession = 9
+	opHello          = 10
+	opHeartbeatACK   = 11
+)
+
+// https://discord.com/developers/docs/topics/opcodes-and-status-codes#gateway-gateway-close-event-codes
+const (
+	closeAuthenticationFailed = 4004
+	closeAlreadyAuthenticated = 4005
+)
+
+// How many events a session keeps for resuming. Discord doesn't say, but it's
+// not forever.
+const maxSessionEvents = 1000
+
+// How many messages can wait to be written to a connection before we decide
+// the client is stuck and hang up.
+const maxQueuedMessages = 1000
+
+type gatewayMessage struct {
+	Op        int     `json:"op"`
+	Data      any     `json:"d"`
+	Sequence  *int    `json:"s"`
+	EventName *string `json:"t"`
+}
+
+type incomingGatewayMessage struct {
+	Op   int             `json:"op"`
+	Data json.RawMessage `json:"d"`
+}
+
+type session struct {
+	id     string
+	seq    int
+	events []sessionEvent
+	conn   *gatewayConn // nil while the client is disconnected
+}
+
+type sessionEvent struct {
+	seq int
+	msg []byte
+}
+
+type gatewayConn struct {
+	ws      *websocket.Conn
+	out     chan []byte
+	session *session
+
+	heartbeats int
+}
+
+var upgrader = websocket.Upgrader{}
+
+func (s *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.log.Error().Err(err).Msg("failed to upgrade gateway connection")
+		return
+	}
+
+	conn := &gatewayConn{
+		ws:  ws,
+		out: make(chan []byte, maxQueuedMessages),
+	}
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for msg := range conn.out {
+			err := ws.WriteMessage(websocket.TextMessage, msg)
+			if err != nil {
+				ws.Close()
+				return
+			}
+		}
+	}()
+
+	s.mu.Lock()
+	s.connections[conn] = struct{}{}
+	conn.send(gatewayMessage{
+		Op:   opHello,
+		Data: map[string]any{"heartbeat_interval": s.HeartbeatInterval.Milliseconds()},
+	})
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.connections, conn)
+		if conn.session != nil && conn.session.conn == conn {
+			conn.session.conn = nil
+		}
+		close(conn.out)
+		s.mu.Unlock()
+
+		<-writerDone
+		ws.Close()
+	}()
+
+	for {
+		_, msgBytes, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg incomingGatewayMessage
+		err = json.Unmarshal(msgBytes, &msg)
+		if err != nil {
+			s.log.Error().Err(err).Msg("received bad gateway message")
+			return
+		}
+
+		if !s.handleGatewayMessage(conn, msg) {
+			return
+		}
+	}
+}
+
+// Returns false if the connection should be closed.
+func (s *Server) handleGatewayMessage(conn *gatewayConn, msg incomingGatewayMessage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.Op {
+	case opHeartbeat:
+		conn.heartbeats++
+		conn.send(gatewayMessage{Op: opHeartbeatACK})
+	case opIdentify:
+		var identify struct {
+			Token string `json:"token"`
+		}
+		json.Unmarshal(msg.Data, &identify)
+		if conn.session != nil {
+			conn.close(closeAlreadyAuthenticated, "Already authenticated.")
+			return false
+		}
+		if s.Token != "" && identify.Token != s.Token {
+			conn.close(closeAuthenticationFailed, "Authentication failed.")
+			return false
+		}
+
+		sess := &session{id: randomToken()}
+		s.sessions[sess.id] = sess
+		s.attach(conn, sess)
+
+		s.dispatchTo(sess, "READY", map[string]any{
+			"v":                  apiVersion,
+			"user":               s.BotUser,
+			"session_id":         sess.id,
+			"resume_gateway_url": s.gatewayUrl(),
+			"guilds":             []any{map[string]any{"id": s.GuildID, "unavailable": true}},
+			"application":        map[string]any{"id": s.BotUser.ID, "flags": 0},
+		})
+		s.dispatchTo(sess, "GUILD_CREATE", s.guildCreateEvent())
+	case opResume:
+		var resume struct {
+			Token     string `json:"token"`
+			SessionID string `json:"session_id"`
+			Sequence  int    `json:"seq"`
+		}
+		json.Unmarshal(msg.Data, &resume)
+		if s.Token != "" && resume.Token != s.Token {
+			conn.close(closeAuthenticationFailed, "Authentication failed.")
+			return false
+		}
+
+		sess, ok := s.sessions[resume.SessionID]
+		if !ok {
+			conn.send(gatewayMessage{Op: opInvalidSession, Data: false})
+			return true
+		}
+		s.attach(conn, sess)
+
+		for _, event := range sess.events {
+			if event.seq > resume.Sequence {
+				conn.sendRaw(event.msg)
+			}
+		}
+		s.dispatchTo(sess, "RESUMED", map[string]any{})
+	}
+
+	return true
+}
+
+// Must be called with the lock held.
+func (s *Server) attach(conn *gatewayConn, sess *session) {
+	if sess.conn != nil && sess.conn != conn {
+		// Discord kicks the old connection when a session is resumed
+		// elsewhere.
+		sess.conn.ws.Close()
+	}
+	sess.conn = conn
+	conn.session = sess
+}
+
+// Sends an event to every session. Must be called with the lock held.
+func (s *Server) dispatch(eventName string, data any) {
+	for _, sess := range s.sessions {
+		s.dispatchTo(sess, eventName, data)
+	}
+}
+
+// Must be called with the lock held.
+func (s *Server) dispatchTo(sess *session, eventName string, data any) {
+	sess.seq++
+	seq := sess.seq
+	msg, err := json.Marshal(gatewayMessage{
+		Op:        opDispatch,
+		Data:      data,
+		Sequence:  &seq,
+		EventName: &eventName,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	sess.events = append(sess.events, sessionEvent{seq: seq, msg: msg})
+	if len(sess.events) > maxSessionEvents {
+		sess.events = sess.events[len(sess.events)-maxSessionEvents:]
+	}
+	if sess.conn != nil {
+		sess.conn.sendRaw(msg)
+	}
+}
+
+func (s *Server) gatewayUrl() string {
+	return "ws" + s.URL[len("http"):] + "/gateway"
+}
+
+func (s *Server) guildCreateEvent() map[string]any {
+	var channels, threads []*Channel
+	for _, c := range s.channels {
+		if c.Type == ChannelTypeGuildPublicThread {
+			threads = append(threads, c)
+		} else if c.Type != ChannelTypeDM {
+			channels = append(channels, c)
+		}
+	}
+	return map[string]any{
+		"id":           s.GuildID,
+		"name":         "Handmade Network",
+		"unavailable":  false,
+		"channels":     channels,
+		"threads":      threads,
+		"roles":        s.roles,
+		"members":      s.members,
+		"member_count": len(s.members),
+	}
+}
+
+func (conn *gatewayConn) send(msg gatewayMessage) {
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	conn.sendRaw(msgBytes)
+}
+
+func (conn *gatewayConn) sendRaw(msg []byte) {
+	select {
+	case conn.out <- msg:
+	default:
+		// The client isn't keeping up. Hang up and let it resume.
+		conn.ws.Close()
+	}
+}
+
+func (conn *gatewayConn) close(code int, text string) {
+	conn.ws.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, text),
+		time.Now().Add(time.Second),
+	)
+}
+
+/*
+ * Scripting the gateway
+ */
+
+// Asks every connected client to reconnect and resume.
+func (s *Server) Reconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.connections {
+		conn.send(gatewayMessage{Op: opReconnect})
+	}
+}
+
+// Drops every connection without warning, as if the network went down.
+// Sessions are kept, so clients can resume.
+func (s *Server) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.connections {
+		conn.ws.Close()
+	}
+}
+
+// Forgets every session, so that clients have to identify again. Connected
+// clients are told their session is no longer valid.
+func (s *Server) InvalidateSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, sess := range s.sessions {
+		if sess.conn != nil {
+			sess.conn.send(gatewayMessage{Op: opInvalidSession, Data: false})
+			sess.conn.session = nil
+		}
+	}
+	clear(s.sessions)
+}
+
+// Asks every connected client to heartbeat right away.
+func (s *Server) RequestHeartbeat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.connections {
+		conn.send(gatewayMessage{Op: opHeartbeat})
+	}
+}
+
+// The number of heartbeats received on current connections.
+func (s *Server) Heartbeats() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	for conn := range s.connections {
+		total += conn.heartbeats
+	}
+	return total
+}
+
+// Waits until the bot has a session that is connected and ready to receive
+// events.
+func (s *Server) WaitForConnection(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		connected := false
+		for conn := range s.connections {
+			if conn.session != nil {
+				connected = true
+			}
+		}
+		s.mu.Unlock()
+		if connected {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
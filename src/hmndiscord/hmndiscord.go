@@ -0,0 +1,949 @@
+package hmndiscord
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+/*
+A fake Discord for integration tests. It runs in-process and speaks enough of
+the gateway protocol and REST API for everything the bot does: the bot
+connects, identifies or resumes, heartbeats, and receives dispatches over a
+websocket, and sends and edits messages, manages roles and scheduled events,
+posts to forums through webhooks, registers commands, and answers interactions
+over HTTP.
+
+The server hosts a single guild. Tests set up channels, roles, and users, then
+script what happens in the guild: users post, edit, and delete messages and
+invoke commands, and everything the bot does in response is recorded for the
+test to inspect.
+
+Like the real thing, dispatches are sent to sessions, not connections. Events
+that happen while the bot is disconnected are replayed when it resumes.
+
+Rate limit headers are never sent, and permissions are never checked.
+*/
+
+// The version of the API the bot uses. REST requests go to /api/v<version>.
+const apiVersion = 9
+
+// Discord's epoch, used for snowflake timestamps.
+var discordEpoch = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type Server struct {
+	// The token the bot must use, in the gateway and in the Authorization
+	// header. Leave blank to accept anything.
+	Token string
+
+	// How often clients are asked to heartbeat.
+	HeartbeatInterval time.Duration
+
+	GuildID string
+	BotUser User
+
+	URL string // e.g. http://127.0.0.1:1234
+
+	log  zerolog.Logger
+	http *httptest.Server
+
+	mu                   sync.Mutex
+	lastSnowflake        uint64
+	channels             []*Channel
+	roles                []*Role
+	members              []*Member
+	messages             map[string][]*Message // By channel ID, oldest first
+	files                map[string][]byte     // Attachment data by path
+	commands             []*ApplicationCommand
+	scheduledEvents      []*ScheduledEvent
+	webhooks             []*Webhook
+	interactions         map[string]*Interaction
+	interactionResponses map[string]*InteractionResponse // By interaction ID
+	oauthCodes           map[string]string               // User ID by code
+	oauthTokens          map[string]string               // User ID by access token
+	sessions             map[string]*session
+	connections          map[*gatewayConn]struct{}
+}
+
+// Starts a fake Discord on a random local port. Call Close when done.
+func NewServer(log zerolog.Logger) *Server {
+	s := &Server{
+		HeartbeatInterval: 41250 * time.Millisecond,
+
+		log:                  log,
+		messages:             make(map[string][]*Message),
+		files:                make(map[string][]byte),
+		interactions:         make(map[string]*Interaction),
+		interactionResponses: make(map[string]*InteractionResponse),
+		oauthCodes:           make(map[string]string),
+		oauthTokens:          make(map[string]string),
+		sessions:             make(map[string]*session),
+		connections:          make(map[*gatewayConn]struct{}),
+	}
+	s.GuildID = s.newSnowflake()
+	s.BotUser = User{
+		ID:            s.newSnowflake(),
+		Username:      "HandmadeNetwork",
+		Discriminator: "0000",
+		Bot:           true,
+	}
+
+	s.http = httptest.NewServer(s)
+	s.URL = s.http.URL
+	return s
+}
+
+// The base URL for REST requests, e.g. http://127.0.0.1:1234/api/v9.
+func (s *Server) ApiUrl() string {
+	return fmt.Sprintf("%s/api/v%d", s.URL, apiVersion)
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	for conn := range s.connections {
+		conn.ws.Close()
+	}
+	s.mu.Unlock()
+	s.http.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.log.Debug().Str("method", r.Method).Str("path", r.URL.Path).Msg("fake Discord request")
+
+	if r.URL.Path == "/gateway" || strings.HasPrefix(r.URL.Path, "/gateway/") {
+		s.serveGateway(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/attachments/") {
+		s.serveAttachment(w, r)
+		return
+	}
+
+	prefix := fmt.Sprintf("/api/v%d/", apiVersion)
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	s.serveRest(w, r, strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/"))
+}
+
+/*
+ * Payloads
+ *
+ * These are what Discord sends, trimmed down to what the bot reads.
+ */
+
+type User struct {
+	ID            string  `json:"id"`
+	Username      string  `json:"username"`
+	Discriminator string  `json:"discriminator"`
+	Avatar        *string `json:"avatar"`
+	Bot           bool    `json:"bot,omitempty"`
+}
+
+type Role struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+const (
+	ChannelTypeGuildText         = 0
+	ChannelTypeDM                = 1
+	ChannelTypeGuildVoice        = 2
+	ChannelTypeGuildPublicThread = 11
+	ChannelTypeGuildStageVoice   = 13
+	ChannelTypeGuildForum        = 15
+)
+
+type Channel struct {
+	ID         string `json:"id"`
+	Type       int    `json:"type"`
+	GuildID    string `json:"guild_id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	ParentID   string `json:"parent_id,omitempty"` // The forum a thread is in
+	Recipients []User `json:"recipients,omitempty"`
+}
+
+type Member struct {
+	User     *User    `json:"user,omitempty"`
+	Nick     *string  `json:"nick"`
+	Avatar   *string  `json:"avatar"`
+	Roles    []string `json:"roles"`
+	JoinedAt string   `json:"joined_at"`
+}
+
+type Attachment struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int    `json:"size"`
+	Url         string `json:"url"`
+	ProxyUrl    string `json:"proxy_url"`
+	Width       *int   `json:"width,omitempty"`
+	Height      *int   `json:"height,omitempty"`
+}
+
+const (
+	MessageTypeDefault            = 0
+	MessageTypeApplicationCommand = 20
+)
+
+type Message struct {
+	ID              string       `json:"id"`
+	ChannelID       string       `json:"channel_id"`
+	GuildID         string       `json:"guild_id,omitempty"`
+	Author          User         `json:"author"`
+	Member          *Member      `json:"member,omitempty"`
+	Content         string       `json:"content"`
+	Timestamp       string       `json:"timestamp"`
+	EditedTimestamp *string      `json:"edited_timestamp"`
+	Type            int          `json:"type"`
+	Flags           int          `json:"flags"`
+	Attachments     []Attachment `json:"attachments"`
+	Embeds          []any        `json:"embeds"`
+	WebhookID       *string      `json:"webhook_id,omitempty"`
+	Deleted         bool         `json:"-"`
+
+	// The avatar a webhook message was sent with. Discord would turn this
+	// into an avatar hash on the author, but tests want the URL.
+	WebhookAvatarUrl string `json:"-"`
+}
+
+type ApplicationCommand struct {
+	ID            string `json:"id"`
+	ApplicationID string `json:"application_id"`
+	GuildID       string `json:"guild_id"`
+	Name          string `json:"name"`
+	Type          int    `json:"type"`
+	Description   string `json:"description"`
+	Options       []any  `json:"options"`
+}
+
+const (
+	InteractionTypeApplicationCommand = 2
+	InteractionTypeAutocomplete       = 4
+	InteractionTypeModalSubmit        = 5
+)
+
+const (
+	ApplicationCommandTypeChatInput = 1
+	ApplicationCommandTypeUser      = 2
+	ApplicationCommandTypeMessage   = 3
+)
+
+type Interaction struct {
+	ID            string           `json:"id"`
+	ApplicationID string           `json:"application_id"`
+	Type          int              `json:"type"`
+	Data          *InteractionData `json:"data,omitempty"`
+	GuildID       string           `json:"guild_id,omitempty"`
+	ChannelID     string           `json:"channel_id"`
+	Member        *Member          `json:"member,omitempty"`
+	User          *User            `json:"user,omitempty"`
+	Token         string           `json:"token"`
+	Version       int              `json:"version"`
+}
+
+type InteractionData struct {
+	ID       string              `json:"id,omitempty"`
+	Name     string              `json:"name,omitempty"`
+	Type     int                 `json:"type,omitempty"`
+	Options  []InteractionOption `json:"options,omitempty"`
+	Resolved map[string]any      `json:"resolved,omitempty"`
+	TargetID string              `json:"target_id,omitempty"`
+
+	// Modal submits
+	CustomID   string           `json:"custom_id,omitempty"`
+	Components []map[string]any `json:"components,omitempty"`
+}
+
+type InteractionOption struct {
+	Name    string              `json:"name"`
+	Type    int                 `json:"type"`
+	Value   any                 `json:"value,omitempty"`
+	Options []InteractionOption `json:"options,omitempty"`
+	Focused bool                `json:"focused,omitempty"` // for autocomplete
+}
+
+const (
+	ScheduledEventEntityTypeStageInstance = 1
+	ScheduledEventEntityTypeVoice         = 2
+	ScheduledEventEntityTypeExternal      = 3
+)
+
+type ScheduledEvent struct {
+	ID                 string                        `json:"id"`
+	GuildID            string                        `json:"guild_id"`
+	ChannelID          *string                       `json:"channel_id"`
+	CreatorID          string                        `json:"creator_id"`
+	Name               string                        `json:"name"`
+	Description        *string                       `json:"description"`
+	ScheduledStartTime string                        `json:"scheduled_start_time"`
+	ScheduledEndTime   *string                       `json:"scheduled_end_time"`
+	PrivacyLevel       int                           `json:"privacy_level"`
+	Status             int                           `json:"status"`
+	EntityType         int                           `json:"entity_type"`
+	EntityMetadata     *ScheduledEventEntityMetadata `json:"entity_metadata"`
+}
+
+type ScheduledEventEntityMetadata struct {
+	Location string `json:"location,omitempty"`
+}
+
+type Webhook struct {
+	ID        string `json:"id"`
+	Type      int    `json:"type"`
+	GuildID   string `json:"guild_id"`
+	ChannelID string `json:"channel_id"`
+	Name      string `json:"name"`
+	Token     string `json:"token"`
+}
+
+// What the bot sent back for an interaction. The original response message,
+// if any, is also posted to the interaction's channel.
+type InteractionResponse struct {
+	Type     int            `json:"type"`
+	Data     map[string]any `json:"data"`
+	Original *Message       `json:"-"`
+}
+
+/*
+ * Setting up the guild
+ */
+
+func (s *Server) AddChannel(name string) *Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channel := &Channel{
+		ID:      s.newSnowflake(),
+		Type:    ChannelTypeGuildText,
+		GuildID: s.GuildID,
+		Name:    name,
+	}
+	s.channels = append(s.channels, channel)
+	return channel
+}
+
+// Adds a voice channel, or a stage channel if stage is set.
+func (s *Server) AddVoiceChannel(name string, stage bool) *Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channel := &Channel{
+		ID:      s.newSnowflake(),
+		Type:    ChannelTypeGuildVoice,
+		GuildID: s.GuildID,
+		Name:    name,
+	}
+	if stage {
+		channel.Type = ChannelTypeGuildStageVoice
+	}
+	s.channels = append(s.channels, channel)
+	return channel
+}
+
+// Adds a forum channel. Posts in it are threads, made by users with
+// StartThread or by the bot through a webhook.
+func (s *Server) AddForumChannel(name string) *Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channel := &Channel{
+		ID:      s.newSnowflake(),
+		Type:    ChannelTypeGuildForum,
+		GuildID: s.GuildID,
+		Name:    name,
+	}
+	s.channels = append(s.channels, channel)
+	return channel
+}
+
+func (s *Server) AddRole(name string) *Role {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	role := &Role{
+		ID:   s.newSnowflake(),
+		Name: name,
+	}
+	s.roles = append(s.roles, role)
+	return role
+}
+
+// Adds a user to the guild with the given roles.
+func (s *Server) AddUser(username string, roleIDs ...string) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := User{
+		ID:            s.newSnowflake(),
+		Username:      username,
+		Discriminator: "0",
+	}
+	s.members = append(s.members, &Member{
+		User:     &user,
+		Roles:    append([]string{}, roleIDs...),
+		JoinedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	s.dispatch("GUILD_MEMBER_ADD", s.memberEvent(s.members[len(s.members)-1]))
+	return user
+}
+
+// Returns a copy of a guild member, or nil if the user isn't in the guild.
+func (s *Server) Member(userID string) *Member {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if member := s.findMember(userID); member != nil {
+		return copyMember(member)
+	}
+	return nil
+}
+
+/*
+ * Scripting what users do
+ */
+
+// Data for a file attached to a message. The bot can download it from the
+// attachment's URL.
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Posts a message as a guild member and dispatches MESSAGE_CREATE.
+func (s *Server) PostMessage(channelID, authorID, content string, files ...File) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	channel := s.findChannel(channelID)
+	if channel == nil {
+		return nil, fmt.Errorf("no channel with ID %s", channelID)
+	}
+	member := s.findMember(authorID)
+	if member == nil {
+		return nil, fmt.Errorf("no guild member with ID %s", authorID)
+	}
+
+	msg := s.createMessage(channel, *member.User, content, files)
+	msg.Member = copyMember(member)
+	msg.Member.User = nil
+	s.dispatch("MESSAGE_CREATE", msg)
+	return copyMessage(msg), nil
+}
+
+// Starts a post in a forum as a guild member, and dispatches THREAD_CREATE and
+// MESSAGE_CREATE for its first message.
+func (s *Server) StartThread(forumID, authorID, name, content string) (*Channel, *Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	forum := s.findChannel(forumID)
+	if forum == nil || forum.Type != ChannelTypeGuildForum {
+		return nil, nil, fmt.Errorf("no forum channel with ID %s", forumID)
+	}
+	member := s.findMember(authorID)
+	if member == nil {
+		return nil, nil, fmt.Errorf("no guild member with ID %s", authorID)
+	}
+
+	thread, msg := s.createThread(forum, name, *member.User, content)
+	msg.Member = copyMember(member)
+	msg.Member.User = nil
+	s.dispatch("MESSAGE_CREATE", msg)
+	result := *thread
+	return &result, copyMessage(msg), nil
+}
+
+// Edits a message's content and dispatches MESSAGE_UPDATE.
+func (s *Server) EditMessage(channelID, messageID, content string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := s.findMessage(channelID, messageID)
+	if msg == nil {
+		return nil, fmt.Errorf("no message with ID %s in channel %s", messageID, channelID)
+	}
+	s.editMessage(msg, content)
+	return copyMessage(msg), nil
+}
+
+// Deletes a message and dispatches MESSAGE_DELETE.
+func (s *Server) DeleteMessage(channelID, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := s.findMessage(channelID, messageID)
+	if msg == nil {
+		return fmt.Errorf("no message with ID %s in channel %s", messageID, channelID)
+	}
+	s.deleteMessage(msg)
+	return nil
+}
+
+// Deletes a scheduled event as a moderator would and dispatches
+// GUILD_SCHEDULED_EVENT_DELETE.
+func (s *Server) DeleteScheduledEvent(eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.deleteScheduledEvent(eventID) {
+		return fmt.Errorf("no scheduled event with ID %s", eventID)
+	}
+	return nil
+}
+
+// Invokes an application command as a guild member and dispatches
+// INTERACTION_CREATE. The command must have been registered by the bot. For
+// message commands, the target message is resolved automatically.
+func (s *Server) Interact(channelID, userID string, data InteractionData) (*Interaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findChannel(channelID) == nil {
+		return nil, fmt.Errorf("no channel with ID %s", channelID)
+	}
+	member := s.findMember(userID)
+	if member == nil {
+		return nil, fmt.Errorf("no guild member with ID %s", userID)
+	}
+	command := s.findCommand(data.Name)
+	if command == nil {
+		return nil, fmt.Errorf("the bot has not registered a command named %s", data.Name)
+	}
+
+	data.ID = command.ID
+	if data.Type == 0 {
+		data.Type = command.Type
+	}
+	if data.Type == ApplicationCommandTypeMessage && data.Resolved == nil {
+		msg := s.findMessage(channelID, data.TargetID)
+		if msg == nil || msg.Deleted {
+			return nil, fmt.Errorf("no message with ID %s in channel %s", data.TargetID, channelID)
+		}
+		target := copyMessage(msg)
+		target.Member = nil
+		data.Resolved = map[string]any{
+			"messages": map[string]any{msg.ID: target},
+		}
+	}
+
+	return s.createInteraction(InteractionTypeApplicationCommand, channelID, member, data), nil
+}
+
+// Asks the bot for autocomplete choices as a guild member types into a
+// command's options, and dispatches INTERACTION_CREATE. The option being typed
+// in should be marked Focused. The choices come back as the interaction
+// response's data.
+func (s *Server) Autocomplete(channelID, userID string, data InteractionData) (*Interaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findChannel(channelID) == nil {
+		return nil, fmt.Errorf("no channel with ID %s", channelID)
+	}
+	member := s.findMember(userID)
+	if member == nil {
+		return nil, fmt.Errorf("no guild member with ID %s", userID)
+	}
+	command := s.findCommand(data.Name)
+	if command == nil {
+		return nil, fmt.Errorf("the bot has not registered a command named %s", data.Name)
+	}
+
+	data.ID = command.ID
+	data.Type = command.Type
+	return s.createInteraction(InteractionTypeAutocomplete, channelID, member, data), nil
+}
+
+// Submits a modal the bot showed a user, with the values they entered by text
+// input ID, and dispatches INTERACTION_CREATE.
+func (s *Server) SubmitModal(channelID, userID, customID string, values map[string]string) (*Interaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.findChannel(channelID) == nil {
+		return nil, fmt.Errorf("no channel with ID %s", channelID)
+	}
+	member := s.findMember(userID)
+	if member == nil {
+		return nil, fmt.Errorf("no guild member with ID %s", userID)
+	}
+
+	// Every text input is in its own action row, as in any sensible modal.
+	const (
+		componentTypeActionRow = 1
+		componentTypeTextInput = 4
+	)
+	data := InteractionData{CustomID: customID}
+	for id, value := range values {
+		data.Components = append(data.Components, map[string]any{
+			"type": componentTypeActionRow,
+			"components": []any{map[string]any{
+				"type":      componentTypeTextInput,
+				"custom_id": id,
+				"value":     value,
+			}},
+		})
+	}
+
+	return s.createInteraction(InteractionTypeModalSubmit, channelID, member, data), nil
+}
+
+// Lets a user log in with Discord. Returns an OAuth code, as if Discord had
+// redirected back to the site with it.
+func (s *Server) AuthorizeUser(userID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code := randomToken()
+	s.oauthCodes[code] = userID
+	return code
+}
+
+/*
+ * Inspecting what the bot did
+ */
+
+// Returns copies of a channel's messages, oldest first, including deleted
+// ones.
+func (s *Server) Messages(channelID string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []Message
+	for _, msg := range s.messages[channelID] {
+		result = append(result, *copyMessage(msg))
+	}
+	return result
+}
+
+// Returns a copy of a message, or nil if it never existed.
+func (s *Server) Message(channelID, messageID string) *Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg := s.findMessage(channelID, messageID); msg != nil {
+		return copyMessage(msg)
+	}
+	return nil
+}
+
+// Returns the DMs the bot has sent to a user.
+func (s *Server) DirectMessages(userID string) []Message {
+	s.mu.Lock()
+	var channelID string
+	for _, c := range s.channels {
+		if c.Type == ChannelTypeDM && c.Recipients[0].ID == userID {
+			channelID = c.ID
+		}
+	}
+	s.mu.Unlock()
+
+	if channelID == "" {
+		return nil
+	}
+	return s.Messages(channelID)
+}
+
+// Returns copies of the threads in a forum, in the order they were created,
+// not including deleted ones.
+func (s *Server) Threads(forumID string) []Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []Channel
+	for _, c := range s.channels {
+		if c.Type == ChannelTypeGuildPublicThread && c.ParentID == forumID {
+			result = append(result, *c)
+		}
+	}
+	return result
+}
+
+// Returns a copy of a channel, or nil if it doesn't exist or was deleted.
+func (s *Server) Channel(channelID string) *Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c := s.findChannel(channelID); c != nil {
+		result := *c
+		return &result
+	}
+	return nil
+}
+
+// Returns the commands the bot has registered in the guild.
+func (s *Server) Commands() []ApplicationCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []ApplicationCommand
+	for _, c := range s.commands {
+		result = append(result, *c)
+	}
+	return result
+}
+
+// Returns copies of the guild's scheduled events, in the order they were
+// created.
+func (s *Server) ScheduledEvents() []ScheduledEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []ScheduledEvent
+	for _, ev := range s.scheduledEvents {
+		result = append(result, *ev)
+	}
+	return result
+}
+
+// Returns how the bot responded to an interaction, or nil if it hasn't yet.
+func (s *Server) InteractionResponse(interactionID string) *InteractionResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, ok := s.interactionResponses[interactionID]
+	if !ok {
+		return nil
+	}
+	result := *res
+	if res.Original != nil {
+		result.Original = copyMessage(res.Original)
+	}
+	return &result
+}
+
+/*
+ * Helpers
+ */
+
+// Must be called with the lock held.
+func (s *Server) createInteraction(interactionType int, channelID string, member *Member, data InteractionData) *Interaction {
+	interaction := &Interaction{
+		ID:            s.newSnowflake(),
+		ApplicationID: s.BotUser.ID,
+		Type:          interactionType,
+		Data:          &data,
+		GuildID:       s.GuildID,
+		ChannelID:     channelID,
+		Member:        copyMember(member),
+		Token:         randomToken(),
+		Version:       1,
+	}
+	s.interactions[interaction.ID] = interaction
+	s.dispatch("INTERACTION_CREATE", interaction)
+	return interaction
+}
+
+// Must be called with the lock held.
+func (s *Server) newSnowflake() string {
+	ms := uint64(time.Since(discordEpoch).Milliseconds())
+	id := max(ms<<22, s.lastSnowflake+1)
+	s.lastSnowflake = id
+	return strconv.FormatUint(id, 10)
+}
+
+func snowflakeLess(a, b string) bool {
+	aNum, _ := strconv.ParseUint(a, 10, 64)
+	bNum, _ := strconv.ParseUint(b, 10, 64)
+	return aNum < bNum
+}
+
+func randomToken() string {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func (s *Server) findChannel(id string) *Channel {
+	for _, c := range s.channels {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Server) findRole(id string) *Role {
+	for _, r := range s.roles {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *Server) findMember(userID string) *Member {
+	for _, m := range s.members {
+		if m.User.ID == userID {
+			return m
+		}
+	}
+	return nil
+}
+
+func (s *Server) findUser(userID string) *User {
+	if userID == s.BotUser.ID {
+		return &s.BotUser
+	}
+	if member := s.findMember(userID); member != nil {
+		return member.User
+	}
+	return nil
+}
+
+func (s *Server) findCommand(name string) *ApplicationCommand {
+	for _, c := range s.commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Server) findScheduledEvent(id string) *ScheduledEvent {
+	for _, ev := range s.scheduledEvents {
+		if ev.ID == id {
+			return ev
+		}
+	}
+	return nil
+}
+
+func (s *Server) deleteScheduledEvent(id string) bool {
+	for i, ev := range s.scheduledEvents {
+		if ev.ID == id {
+			s.scheduledEvents = slices.Delete(s.scheduledEvents, i, i+1)
+			s.dispatch("GUILD_SCHEDULED_EVENT_DELETE", ev)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) findWebhook(id, token string) *Webhook {
+	for _, wh := range s.webhooks {
+		if wh.ID == id && wh.Token == token {
+			return wh
+		}
+	}
+	return nil
+}
+
+// Finds a message, including deleted ones.
+func (s *Server) findMessage(channelID, messageID string) *Message {
+	for _, msg := range s.messages[channelID] {
+		if msg.ID == messageID {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (s *Server) createMessage(channel *Channel, author User, content string, files []File) *Message {
+	msg := &Message{
+		ID:          s.newSnowflake(),
+		ChannelID:   channel.ID,
+		GuildID:     channel.GuildID,
+		Author:      author,
+		Content:     content,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Attachments: []Attachment{},
+		Embeds:      []any{},
+	}
+	for _, f := range files {
+		id := s.newSnowflake()
+		path := fmt.Sprintf("/attachments/%s/%s/%s", channel.ID, id, f.Name)
+		s.files[path] = f.Data
+		msg.Attachments = append(msg.Attachments, Attachment{
+			ID:          id,
+			Filename:    f.Name,
+			ContentType: f.ContentType,
+			Size:        len(f.Data),
+			Url:         s.URL + path,
+			ProxyUrl:    s.URL + path,
+		})
+	}
+	s.messages[channel.ID] = append(s.messages[channel.ID], msg)
+	return msg
+}
+
+// Creates a thread in a forum along with its first message, and dispatches
+// THREAD_CREATE. Like Discord, the first message has the same ID as the
+// thread.
+func (s *Server) createThread(forum *Channel, name string, author User, content string) (*Channel, *Message) {
+	thread := &Channel{
+		ID:       s.newSnowflake(),
+		Type:     ChannelTypeGuildPublicThread,
+		GuildID:  forum.GuildID,
+		Name:     name,
+		ParentID: forum.ID,
+	}
+	s.channels = append(s.channels, thread)
+	s.dispatch("THREAD_CREATE", thread)
+
+	msg := s.createMessage(thread, author, content, nil)
+	msg.ID = thread.ID
+	return thread, msg
+}
+
+func (s *Server) editMessage(msg *Message, content string) {
+	msg.Content = content
+	edited := time.Now().UTC().Format(time.RFC3339Nano)
+	msg.EditedTimestamp = &edited
+	s.dispatch("MESSAGE_UPDATE", msg)
+}
+
+func (s *Server) deleteMessage(msg *Message) {
+	msg.Deleted = true
+	s.dispatch("MESSAGE_DELETE", map[string]any{
+		"id":         msg.ID,
+		"channel_id": msg.ChannelID,
+		"guild_id":   msg.GuildID,
+	})
+}
+
+func (s *Server) memberEvent(member *Member) map[string]any {
+	return map[string]any{
+		"guild_id":  s.GuildID,
+		"user":      member.User,
+		"nick":      member.Nick,
+		"roles":     member.Roles,
+		"joined_at": member.JoinedAt,
+	}
+}
+
+func copyMember(m *Member) *Member {
+	result := *m
+	result.Roles = slices.Clone(m.Roles)
+	if m.User != nil {
+		user := *m.User
+		result.User = &user
+	}
+	return &result
+}
+
+func copyMessage(msg *Message) *Message {
+	result := *msg
+	result.Attachments = slices.Clone(msg.Attachments)
+	result.Embeds = slices.Clone(msg.Embeds)
+	if msg.Member != nil {
+		result.Member = copyMember(msg.Member)
+	}
+	return &result
+}
@@ -0,0 +1,524 @@
+package hmndiscord
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/config"
+	"git.handmade.network/hmn/hmn/src/discord"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "test-token"
+
+// Starts a fake Discord and points the discord package's REST client at it.
+func startTestServer(t *testing.T) *Server {
+	s := NewServer(zerolog.Nop())
+	s.Token = testToken
+	t.Cleanup(s.Close)
+
+	oldConfig := config.Config.Discord
+	config.Config.Discord.ApiUrl = s.ApiUrl()
+	config.Config.Discord.BotToken = testToken
+	config.Config.Discord.BotUserID = s.BotUser.ID
+	config.Config.Discord.GuildID = s.GuildID
+	t.Cleanup(func() { config.Config.Discord = oldConfig })
+
+	return s
+}
+
+type testClient struct {
+	t  *testing.T
+	ws *websocket.Conn
+}
+
+func dialGateway(t *testing.T) *testClient {
+	res, err := discord.GetGatewayBot(context.Background())
+	require.Nil(t, err)
+
+	ws, _, err := websocket.DefaultDialer.Dial(res.URL+"/?v=9&encoding=json", nil)
+	require.Nil(t, err)
+	t.Cleanup(func() { ws.Close() })
+
+	c := &testClient{t: t, ws: ws}
+	hello := c.receive()
+	require.Equal(t, opHello, hello.Op)
+	return c
+}
+
+type testGatewayMessage struct {
+	Op        int             `json:"op"`
+	RawData   json.RawMessage `json:"d"`
+	Sequence  *int            `json:"s"`
+	EventName *string         `json:"t"`
+
+	Data map[string]any `json:"-"` // For dispatches
+}
+
+func (c *testClient) send(op int, data any) {
+	err := c.ws.WriteJSON(map[string]any{"op": op, "d": data})
+	require.Nil(c.t, err)
+}
+
+func (c *testClient) receive() testGatewayMessage {
+	c.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg testGatewayMessage
+	err := c.ws.ReadJSON(&msg)
+	require.Nil(c.t, err)
+	if msg.Op == opDispatch {
+		require.Nil(c.t, json.Unmarshal(msg.RawData, &msg.Data))
+	}
+	return msg
+}
+
+func (c *testClient) receiveEvent(name string) testGatewayMessage {
+	msg := c.receive()
+	require.Equal(c.t, opDispatch, msg.Op)
+	require.NotNil(c.t, msg.EventName)
+	require.Equal(c.t, name, *msg.EventName)
+	return msg
+}
+
+func (c *testClient) identify() (sessionID string) {
+	c.send(opIdentify, map[string]any{"token": testToken, "intents": 0})
+	ready := c.receiveEvent("READY")
+	c.receiveEvent("GUILD_CREATE")
+	return ready.Data["session_id"].(string)
+}
+
+func TestIdentify(t *testing.T) {
+	s := startTestServer(t)
+	channel := s.AddChannel("general")
+	c := dialGateway(t)
+
+	c.send(opIdentify, map[string]any{"token": testToken, "intents": 0})
+
+	ready := c.receiveEvent("READY")
+	assert.Equal(t, 1, *ready.Sequence)
+	assert.NotEmpty(t, ready.Data["session_id"])
+	assert.Equal(t, s.BotUser.ID, ready.Data["user"].(map[string]any)["id"])
+
+	guild := c.receiveEvent("GUILD_CREATE")
+	assert.Equal(t, 2, *guild.Sequence)
+	assert.Equal(t, s.GuildID, guild.Data["id"])
+	channels := guild.Data["channels"].([]any)
+	require.Len(t, channels, 1)
+	assert.Equal(t, channel.ID, channels[0].(map[string]any)["id"])
+}
+
+func TestIdentifyBadToken(t *testing.T) {
+	startTestServer(t)
+	c := dialGateway(t)
+
+	c.send(opIdentify, map[string]any{"token": "wrong", "intents": 0})
+
+	c.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := c.ws.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, closeAuthenticationFailed), "expected close code %d, got %v", closeAuthenticationFailed, err)
+}
+
+func TestHeartbeat(t *testing.T) {
+	s := startTestServer(t)
+	c := dialGateway(t)
+
+	c.send(opHeartbeat, nil)
+	assert.Equal(t, opHeartbeatACK, c.receive().Op)
+	assert.Equal(t, 1, s.Heartbeats())
+
+	s.RequestHeartbeat()
+	assert.Equal(t, opHeartbeat, c.receive().Op)
+}
+
+func TestResume(t *testing.T) {
+	s := startTestServer(t)
+	channel := s.AddChannel("general")
+	user := s.AddUser("casey")
+	c := dialGateway(t)
+	sessionID := c.identify()
+
+	first, err := s.PostMessage(channel.ID, user.ID, "first")
+	require.Nil(t, err)
+	created := c.receiveEvent("MESSAGE_CREATE")
+	assert.Equal(t, first.ID, created.Data["id"])
+	lastSeq := *created.Sequence
+
+	// Events that happen while the bot is away are replayed when it comes
+	// back, followed by RESUMED.
+	s.Disconnect()
+	second, err := s.PostMessage(channel.ID, user.ID, "second")
+	require.Nil(t, err)
+	require.Nil(t, s.DeleteMessage(channel.ID, first.ID))
+
+	c = dialGateway(t)
+	c.send(opResume, map[string]any{"token": testToken, "session_id": sessionID, "seq": lastSeq})
+	created = c.receiveEvent("MESSAGE_CREATE")
+	assert.Equal(t, second.ID, created.Data["id"])
+	assert.Equal(t, lastSeq+1, *created.Sequence)
+	deleted := c.receiveEvent("MESSAGE_DELETE")
+	assert.Equal(t, first.ID, deleted.Data["id"])
+	c.receiveEvent("RESUMED")
+}
+
+func TestResumeInvalidSession(t *testing.T) {
+	s := startTestServer(t)
+	c := dialGateway(t)
+	sessionID := c.identify()
+
+	s.InvalidateSessions()
+	msg := c.receive()
+	assert.Equal(t, opInvalidSession, msg.Op)
+
+	c = dialGateway(t)
+	c.send(opResume, map[string]any{"token": testToken, "session_id": sessionID, "seq": 2})
+	assert.Equal(t, opInvalidSession, c.receive().Op)
+}
+
+func TestReconnect(t *testing.T) {
+	s := startTestServer(t)
+	c := dialGateway(t)
+	c.identify()
+
+	s.Reconnect()
+	assert.Equal(t, opReconnect, c.receive().Op)
+}
+
+func TestMessages(t *testing.T) {
+	s := startTestServer(t)
+	channel := s.AddChannel("general")
+	user := s.AddUser("casey")
+	ctx := context.Background()
+
+	posted, err := s.PostMessage(channel.ID, user.ID, "hello", File{Name: "hello.txt", ContentType: "text/plain", Data: []byte("hi")})
+	require.Nil(t, err)
+
+	msg, err := discord.GetChannelMessage(ctx, channel.ID, posted.ID)
+	require.Nil(t, err)
+	assert.Equal(t, "hello", msg.Content)
+	assert.Equal(t, user.ID, msg.Author.ID)
+	require.Len(t, msg.Attachments, 1)
+	assert.Equal(t, "hello.txt", msg.Attachments[0].Filename)
+
+	reply, err := discord.CreateMessage(ctx, channel.ID, `{"content":"hi yourself"}`, discord.FileUpload{Name: "reply.txt", Data: []byte("hey")})
+	require.Nil(t, err)
+	assert.Equal(t, s.BotUser.ID, reply.Author.ID)
+	require.Len(t, reply.Attachments, 1)
+	assert.Equal(t, "reply.txt", reply.Attachments[0].Filename)
+
+	edited, err := discord.EditMessage(ctx, channel.ID, reply.ID, `{"content":"howdy"}`)
+	require.Nil(t, err)
+	assert.Equal(t, "howdy", edited.Content)
+
+	// Bots can't edit other people's words, but can suppress their embeds.
+	edited, err = discord.EditMessage(ctx, channel.ID, posted.ID, `{"content":"bye","flags":4}`)
+	require.Nil(t, err)
+	assert.Equal(t, "hello", edited.Content)
+	assert.Equal(t, discord.MessageFlagSuppressEmbeds, edited.Flags)
+
+	msgs, err := discord.GetChannelMessages(ctx, channel.ID, discord.GetChannelMessagesInput{Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, reply.ID, msgs[0].ID)
+	assert.Equal(t, posted.ID, msgs[1].ID)
+
+	msgs, err = discord.GetChannelMessages(ctx, channel.ID, discord.GetChannelMessagesInput{Before: reply.ID})
+	require.Nil(t, err)
+	require.Len(t, msgs, 1)
+	assert.Equal(t, posted.ID, msgs[0].ID)
+
+	err = discord.DeleteMessage(ctx, channel.ID, posted.ID)
+	require.Nil(t, err)
+	_, err = discord.GetChannelMessage(ctx, channel.ID, posted.ID)
+	assert.ErrorIs(t, err, discord.NotFound)
+	assert.True(t, s.Message(channel.ID, posted.ID).Deleted)
+}
+
+func TestDirectMessages(t *testing.T) {
+	s := startTestServer(t)
+	user := s.AddUser("casey")
+	ctx := context.Background()
+
+	dm, err := discord.CreateDM(ctx, user.ID)
+	require.Nil(t, err)
+	_, err = discord.CreateMessage(ctx, dm.ID, `{"content":"psst"}`)
+	require.Nil(t, err)
+
+	// The same DM channel comes back every time.
+	again, err := discord.CreateDM(ctx, user.ID)
+	require.Nil(t, err)
+	assert.Equal(t, dm.ID, again.ID)
+
+	dms := s.DirectMessages(user.ID)
+	require.Len(t, dms, 1)
+	assert.Equal(t, "psst", dms[0].Content)
+}
+
+func TestRoles(t *testing.T) {
+	s := startTestServer(t)
+	role := s.AddRole("member")
+	user := s.AddUser("casey")
+	c := dialGateway(t)
+	c.identify()
+	ctx := context.Background()
+
+	require.Nil(t, discord.AddGuildMemberRole(ctx, user.ID, role.ID))
+	update := c.receiveEvent("GUILD_MEMBER_UPDATE")
+	assert.Equal(t, []any{role.ID}, update.Data["roles"])
+
+	member, err := discord.GetGuildMember(ctx, s.GuildID, user.ID)
+	require.Nil(t, err)
+	assert.Equal(t, []string{role.ID}, member.Roles)
+
+	require.Nil(t, discord.RemoveGuildMemberRole(ctx, user.ID, role.ID))
+	c.receiveEvent("GUILD_MEMBER_UPDATE")
+	assert.Empty(t, s.Member(user.ID).Roles)
+}
+
+func TestScheduledEvents(t *testing.T) {
+	s := startTestServer(t)
+	voice := s.AddVoiceChannel("Voice", false)
+	text := s.AddChannel("general")
+	ctx := context.Background()
+
+	start := time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	end := time.Now().Add(25 * time.Hour).UTC().Format(time.RFC3339)
+	req := discord.GuildScheduledEventRequest{
+		ChannelID:          &voice.ID,
+		Name:               "Hangout",
+		PrivacyLevel:       discord.GuildScheduledEventPrivacyLevelGuildOnly,
+		ScheduledStartTime: start,
+		EntityType:         discord.GuildScheduledEventEntityTypeVoice,
+	}
+	ev, err := discord.CreateGuildScheduledEvent(ctx, s.GuildID, req)
+	require.Nil(t, err)
+	assert.Equal(t, "Hangout", ev.Name)
+	require.Len(t, s.ScheduledEvents(), 1)
+
+	// Voice events have to be in voice channels.
+	badReq := req
+	badReq.ChannelID = &text.ID
+	_, err = discord.CreateGuildScheduledEvent(ctx, s.GuildID, badReq)
+	assert.NotNil(t, err)
+
+	// External events need a location and an end time, and no channel.
+	req.ChannelID = nil
+	req.EntityType = discord.GuildScheduledEventEntityTypeExternal
+	req.EntityMetadata = &discord.GuildScheduledEventEntityMetadata{Location: "https://handmade.network"}
+	_, err = discord.ModifyGuildScheduledEvent(ctx, s.GuildID, ev.ID, req)
+	assert.NotNil(t, err)
+	req.ScheduledEndTime = &end
+	ev, err = discord.ModifyGuildScheduledEvent(ctx, s.GuildID, ev.ID, req)
+	require.Nil(t, err)
+	assert.Nil(t, ev.ChannelID)
+	assert.Equal(t, "https://handmade.network", s.ScheduledEvents()[0].EntityMetadata.Location)
+
+	events, err := discord.ListGuildScheduledEvents(ctx, s.GuildID)
+	require.Nil(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, discord.GuildScheduledEventEntityTypeExternal, events[0].EntityType)
+
+	require.Nil(t, s.DeleteScheduledEvent(ev.ID))
+	_, err = discord.ModifyGuildScheduledEvent(ctx, s.GuildID, ev.ID, req)
+	assert.ErrorIs(t, err, discord.NotFound)
+	assert.ErrorIs(t, discord.DeleteGuildScheduledEvent(ctx, s.GuildID, ev.ID), discord.NotFound)
+	assert.Empty(t, s.ScheduledEvents())
+}
+
+func TestForumWebhooks(t *testing.T) {
+	s := startTestServer(t)
+	forum := s.AddForumChannel("forums")
+	user := s.AddUser("casey")
+	c := dialGateway(t)
+	c.identify()
+	ctx := context.Background()
+
+	webhook, err := discord.CreateWebhook(ctx, forum.ID, "Handmade Network")
+	require.Nil(t, err)
+
+	// Posting in a forum without a thread fails, and naming one starts it.
+	_, err = discord.ExecuteWebhook(ctx, webhook, "", discord.ExecuteWebhookRequest{Content: "hello"})
+	assert.NotNil(t, err)
+	op, err := discord.ExecuteWebhook(ctx, webhook, "", discord.ExecuteWebhookRequest{
+		Content:    "hello",
+		Username:   "Casey",
+		AvatarUrl:  "https://example.com/casey.png",
+		ThreadName: "A thread",
+	})
+	require.Nil(t, err)
+	c.receiveEvent("THREAD_CREATE")
+	create := c.receiveEvent("MESSAGE_CREATE")
+	assert.Equal(t, webhook.ID, create.Data["webhook_id"])
+	assert.Equal(t, "Casey", op.Author.Username)
+	require.NotNil(t, op.WebhookID)
+	assert.Equal(t, webhook.ID, *op.WebhookID)
+
+	threads := s.Threads(forum.ID)
+	require.Len(t, threads, 1)
+	thread := threads[0]
+	assert.Equal(t, "A thread", thread.Name)
+	assert.Equal(t, thread.ID, op.ChannelID)
+	assert.Equal(t, thread.ID, op.ID)
+	assert.Equal(t, "https://example.com/casey.png", s.Message(thread.ID, op.ID).WebhookAvatarUrl)
+
+	reply, err := discord.ExecuteWebhook(ctx, webhook, thread.ID, discord.ExecuteWebhookRequest{Content: "again"})
+	require.Nil(t, err)
+	assert.Equal(t, thread.ID, reply.ChannelID)
+	c.receiveEvent("MESSAGE_CREATE")
+
+	edited, err := discord.EditWebhookMessage(ctx, webhook, thread.ID, reply.ID, discord.ExecuteWebhookRequest{Content: "once more"})
+	require.Nil(t, err)
+	assert.Equal(t, "once more", edited.Content)
+	c.receiveEvent("MESSAGE_UPDATE")
+
+	// Webhooks can't touch anyone else's messages.
+	posted, err := s.PostMessage(thread.ID, user.ID, "nice thread")
+	require.Nil(t, err)
+	c.receiveEvent("MESSAGE_CREATE")
+	_, err = discord.EditWebhookMessage(ctx, webhook, thread.ID, posted.ID, discord.ExecuteWebhookRequest{Content: "rude"})
+	assert.ErrorIs(t, err, discord.NotFound)
+
+	require.Nil(t, discord.DeleteWebhookMessage(ctx, webhook, thread.ID, reply.ID))
+	c.receiveEvent("MESSAGE_DELETE")
+	assert.ErrorIs(t, discord.DeleteWebhookMessage(ctx, webhook, thread.ID, reply.ID), discord.NotFound)
+
+	name := "A renamed thread"
+	renamed, err := discord.ModifyChannel(ctx, thread.ID, discord.ModifyChannelRequest{Name: &name})
+	require.Nil(t, err)
+	assert.Equal(t, name, renamed.Name)
+	assert.Equal(t, forum.ID, renamed.ParentID)
+	c.receiveEvent("THREAD_UPDATE")
+
+	require.Nil(t, discord.DeleteChannel(ctx, thread.ID))
+	c.receiveEvent("THREAD_DELETE")
+	assert.Empty(t, s.Threads(forum.ID))
+	assert.True(t, s.Message(thread.ID, posted.ID).Deleted)
+	assert.ErrorIs(t, discord.DeleteChannel(ctx, thread.ID), discord.NotFound)
+	_, err = discord.GetChannel(ctx, thread.ID)
+	assert.ErrorIs(t, err, discord.NotFound)
+	got, err := discord.GetChannel(ctx, forum.ID)
+	require.Nil(t, err)
+	assert.Equal(t, discord.ChannelTypeGuildForum, got.Type)
+
+	// Users can start threads too.
+	userThread, first, err := s.StartThread(forum.ID, user.ID, "Help", "how do I")
+	require.Nil(t, err)
+	c.receiveEvent("THREAD_CREATE")
+	assert.Equal(t, userThread.ID, first.ChannelID)
+	assert.Nil(t, first.WebhookID)
+	c.receiveEvent("MESSAGE_CREATE")
+}
+
+func TestInteractions(t *testing.T) {
+	s := startTestServer(t)
+	channel := s.AddChannel("general")
+	user := s.AddUser("casey")
+	c := dialGateway(t)
+	c.identify()
+	ctx := context.Background()
+
+	_, err := s.Interact(channel.ID, user.ID, InteractionData{Name: "manifesto"})
+	assert.NotNil(t, err, "commands must be registered before they can be used")
+
+	err = discord.CreateGuildApplicationCommand(ctx, discord.CreateGuildApplicationCommandRequest{
+		Name:        "manifesto",
+		Description: "Read the manifesto",
+	})
+	require.Nil(t, err)
+	require.Len(t, s.Commands(), 1)
+
+	interaction, err := s.Interact(channel.ID, user.ID, InteractionData{Name: "manifesto"})
+	require.Nil(t, err)
+	event := c.receiveEvent("INTERACTION_CREATE")
+	assert.Equal(t, interaction.ID, event.Data["id"])
+
+	err = discord.CreateInteractionResponse(ctx, interaction.ID, interaction.Token, discord.InteractionResponse{
+		Type: discord.InteractionCallbackTypeDeferredChannelMessageWithSource,
+	})
+	require.Nil(t, err)
+	_, err = discord.EditOriginalInteractionResponse(ctx, interaction.Token, `{"content":"Read it!"}`)
+	require.Nil(t, err)
+
+	res := s.InteractionResponse(interaction.ID)
+	require.NotNil(t, res)
+	require.NotNil(t, res.Original)
+	assert.Equal(t, "Read it!", res.Original.Content)
+	assert.Equal(t, channel.ID, res.Original.ChannelID)
+
+	c.receiveEvent("MESSAGE_CREATE")
+	c.receiveEvent("MESSAGE_UPDATE")
+
+	// Autocomplete results are just data; no message is posted.
+	interaction, err = s.Autocomplete(channel.ID, user.ID, InteractionData{
+		Name:    "manifesto",
+		Options: []InteractionOption{{Name: "section", Type: 3, Value: "pri", Focused: true}},
+	})
+	require.Nil(t, err)
+	event = c.receiveEvent("INTERACTION_CREATE")
+	assert.EqualValues(t, InteractionTypeAutocomplete, event.Data["type"])
+
+	err = discord.CreateInteractionResponse(ctx, interaction.ID, interaction.Token, discord.InteractionResponse{
+		Type: discord.InteractionCallbackTypeAutocompleteResult,
+		Data: &discord.InteractionCallbackData{
+			Choices: []discord.ApplicationCommandOptionChoice{{Name: "Principles", Value: "principles"}},
+		},
+	})
+	require.Nil(t, err)
+	res = s.InteractionResponse(interaction.ID)
+	require.NotNil(t, res)
+	assert.Nil(t, res.Original)
+	assert.Len(t, res.Data["choices"], 1)
+}
+
+func TestOAuth(t *testing.T) {
+	s := startTestServer(t)
+	user := s.AddUser("casey")
+	ctx := context.Background()
+
+	code := s.AuthorizeUser(user.ID)
+	token, err := discord.ExchangeOAuthCode(ctx, code, "http://handmade.test/_discord_callback")
+	require.Nil(t, err)
+
+	me, err := discord.GetCurrentUserAsOAuth(ctx, token.AccessToken)
+	require.Nil(t, err)
+	assert.Equal(t, user.ID, me.ID)
+	assert.Equal(t, "casey", me.Username)
+
+	// Codes only work once.
+	_, err = discord.ExchangeOAuthCode(ctx, code, "http://handmade.test/_discord_callback")
+	assert.NotNil(t, err)
+}
+
+func TestRestAuthorization(t *testing.T) {
+	s := startTestServer(t)
+	config.Config.Discord.BotToken = "wrong"
+
+	_, err := discord.GetGuildRoles(context.Background(), s.GuildID)
+	assert.NotNil(t, err)
+}
+
+// Makes sure that payloads survive the trip through the discord package's
+// parsing, since it reads raw maps rather than unmarshaling into structs.
+func TestMessagePayload(t *testing.T) {
+	s := startTestServer(t)
+	channel := s.AddChannel("showcase")
+	user := s.AddUser("casey")
+
+	posted, err := s.PostMessage(channel.ID, user.ID, "look at this")
+	require.Nil(t, err)
+
+	data, err := json.Marshal(posted)
+	require.Nil(t, err)
+	var m map[string]any
+	require.Nil(t, json.Unmarshal(data, &m))
+
+	msg := discord.MessageFromMap(m, "")
+	assert.Equal(t, posted.ID, msg.ID)
+	assert.Equal(t, channel.ID, msg.ChannelID)
+	assert.Equal(t, "look at this", msg.Content)
+	assert.Equal(t, user.ID, msg.Author.ID)
+	assert.True(t, msg.OriginalHasFields("author", "timestamp", "content"))
+}
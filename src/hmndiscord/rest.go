@@ -0,0 +1,880 @@
+package hmndiscord
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// https://discord.com/developers/docs/topics/opcodes-and-status-codes#json-json-error-codes
+const (
+	errUnknownChannel     = 10003
+	errUnknownMember      = 10007
+	errUnknownMessage     = 10008
+	errUnknownRole        = 10011
+	errUnknownUser        = 10013
+	errUnknownWebhook     = 10015
+	errUnknownInteraction = 10062
+	errUnknownEvent       = 10070
+	errInvalidFormBody    = 50035
+)
+
+func (s *Server) serveRest(w http.ResponseWriter, r *http.Request, path []string) {
+	route := func(method string, pattern ...string) bool {
+		if r.Method != method || len(path) != len(pattern) {
+			return false
+		}
+		for i, p := range pattern {
+			if p != "*" && p != path[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// OAuth requests are made with the user's credentials, not the bot's.
+	switch {
+	case route(http.MethodPost, "oauth2", "token"):
+		s.exchangeOAuthCode(w, r)
+		return
+	case route(http.MethodGet, "users", "@me"):
+		s.getCurrentUser(w, r)
+		return
+	}
+
+	if s.Token != "" && r.Header.Get("Authorization") != "Bot "+s.Token {
+		writeJson(w, http.StatusUnauthorized, map[string]any{"message": "401: Unauthorized", "code": 0})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Guild routes all need the guild to be ours.
+	if len(path) >= 2 && path[0] == "guilds" && path[1] != s.GuildID {
+		writeError(w, http.StatusNotFound, 10004, "Unknown Guild")
+		return
+	}
+
+	switch {
+	case route(http.MethodGet, "gateway", "bot"):
+		writeJson(w, http.StatusOK, map[string]any{
+			"url":    s.gatewayUrl(),
+			"shards": 1,
+			"session_start_limit": map[string]any{
+				"total":           1000,
+				"remaining":       1000,
+				"reset_after":     0,
+				"max_concurrency": 1,
+			},
+		})
+	case route(http.MethodGet, "guilds", "*", "roles"):
+		writeJson(w, http.StatusOK, s.roles)
+	case route(http.MethodGet, "guilds", "*", "channels"):
+		// Threads are listed separately, like DMs.
+		channels := []*Channel{}
+		for _, c := range s.channels {
+			if c.Type != ChannelTypeDM && c.Type != ChannelTypeGuildPublicThread {
+				channels = append(channels, c)
+			}
+		}
+		writeJson(w, http.StatusOK, channels)
+	case route(http.MethodGet, "guilds", "*", "members"):
+		s.listMembers(w, r)
+	case route(http.MethodGet, "guilds", "*", "members", "*"):
+		member := s.findMember(path[3])
+		if member == nil {
+			writeError(w, http.StatusNotFound, errUnknownMember, "Unknown Member")
+			return
+		}
+		writeJson(w, http.StatusOK, member)
+	case route(http.MethodPut, "guilds", "*", "members", "*", "roles", "*"),
+		route(http.MethodDelete, "guilds", "*", "members", "*", "roles", "*"):
+		s.setMemberRole(w, path[3], path[5], r.Method == http.MethodPut)
+	case route(http.MethodGet, "guilds", "*", "scheduled-events"):
+		writeJson(w, http.StatusOK, append([]*ScheduledEvent{}, s.scheduledEvents...))
+	case route(http.MethodPost, "guilds", "*", "scheduled-events"):
+		s.createScheduledEvent(w, r)
+	case route(http.MethodPatch, "guilds", "*", "scheduled-events", "*"):
+		s.modifyScheduledEvent(w, r, path[3])
+	case route(http.MethodDelete, "guilds", "*", "scheduled-events", "*"):
+		if !s.deleteScheduledEvent(path[3]) {
+			writeError(w, http.StatusNotFound, errUnknownEvent, "Unknown Guild Scheduled Event")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case route(http.MethodPost, "users", "@me", "channels"):
+		s.createDM(w, r)
+	case route(http.MethodGet, "channels", "*"):
+		channel := s.findChannel(path[1])
+		if channel == nil {
+			writeError(w, http.StatusNotFound, errUnknownChannel, "Unknown Channel")
+			return
+		}
+		writeJson(w, http.StatusOK, channel)
+	case route(http.MethodPatch, "channels", "*"):
+		s.modifyChannel(w, r, path[1])
+	case route(http.MethodDelete, "channels", "*"):
+		s.deleteChannel(w, path[1])
+	case route(http.MethodPost, "channels", "*", "webhooks"):
+		s.createWebhook(w, r, path[1])
+	case route(http.MethodGet, "channels", "*", "messages"):
+		s.getMessages(w, r, path[1])
+	case route(http.MethodPost, "channels", "*", "messages"):
+		channel := s.findChannel(path[1])
+		if channel == nil {
+			writeError(w, http.StatusNotFound, errUnknownChannel, "Unknown Channel")
+			return
+		}
+		payload, files, ok := readMessagePayload(w, r)
+		if !ok {
+			return
+		}
+		msg := s.createMessage(channel, s.BotUser, "", files)
+		applyMessagePayload(msg, payload)
+		s.dispatch("MESSAGE_CREATE", msg)
+		writeJson(w, http.StatusOK, msg)
+	case route(http.MethodGet, "channels", "*", "messages", "*"):
+		msg := s.findMessage(path[1], path[3])
+		if msg == nil || msg.Deleted {
+			writeError(w, http.StatusNotFound, errUnknownMessage, "Unknown Message")
+			return
+		}
+		writeJson(w, http.StatusOK, msg)
+	case route(http.MethodPatch, "channels", "*", "messages", "*"):
+		msg := s.findMessage(path[1], path[3])
+		if msg == nil || msg.Deleted {
+			writeError(w, http.StatusNotFound, errUnknownMessage, "Unknown Message")
+			return
+		}
+		payload, _, ok := readMessagePayload(w, r)
+		if !ok {
+			return
+		}
+		// Bots can change the flags on anyone's messages, e.g. to suppress
+		// embeds, but can only edit their own content.
+		if msg.Author.ID != s.BotUser.ID {
+			delete(payload, "content")
+		}
+		applyMessagePayload(msg, payload)
+		s.dispatch("MESSAGE_UPDATE", msg)
+		writeJson(w, http.StatusOK, msg)
+	case route(http.MethodDelete, "channels", "*", "messages", "*"):
+		msg := s.findMessage(path[1], path[3])
+		if msg == nil || msg.Deleted {
+			writeError(w, http.StatusNotFound, errUnknownMessage, "Unknown Message")
+			return
+		}
+		s.deleteMessage(msg)
+		w.WriteHeader(http.StatusNoContent)
+	case route(http.MethodPost, "applications", "*", "guilds", "*", "commands"):
+		s.createCommand(w, r, path[3])
+	case route(http.MethodPost, "interactions", "*", "*", "callback"):
+		s.createInteractionResponse(w, r, path[1], path[2])
+	case route(http.MethodPatch, "webhooks", "*", "*", "messages", "@original"):
+		s.editOriginalInteractionResponse(w, r, path[2])
+	case route(http.MethodPost, "webhooks", "*", "*"):
+		s.executeWebhook(w, r, path[1], path[2])
+	case route(http.MethodPatch, "webhooks", "*", "*", "messages", "*"),
+		route(http.MethodDelete, "webhooks", "*", "*", "messages", "*"):
+		s.editWebhookMessage(w, r, path[1], path[2], path[4])
+	default:
+		writeError(w, http.StatusNotFound, 0, fmt.Sprintf("404: Not Found (%s %s)", r.Method, r.URL.Path))
+	}
+}
+
+func (s *Server) serveAttachment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, ok := s.files[r.URL.Path]
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Write(data)
+}
+
+func (s *Server) listMembers(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 || limit > 1000 {
+		limit = 1
+	}
+	after := r.URL.Query().Get("after")
+
+	members := slices.Clone(s.members)
+	slices.SortFunc(members, func(a, b *Member) int {
+		if snowflakeLess(a.User.ID, b.User.ID) {
+			return -1
+		}
+		return 1
+	})
+	result := []*Member{}
+	for _, m := range members {
+		if after != "" && !snowflakeLess(after, m.User.ID) {
+			continue
+		}
+		if len(result) < limit {
+			result = append(result, m)
+		}
+	}
+	writeJson(w, http.StatusOK, result)
+}
+
+func (s *Server) setMemberRole(w http.ResponseWriter, userID, roleID string, add bool) {
+	member := s.findMember(userID)
+	if member == nil {
+		writeError(w, http.StatusNotFound, errUnknownMember, "Unknown Member")
+		return
+	}
+	if s.findRole(roleID) == nil {
+		writeError(w, http.StatusNotFound, errUnknownRole, "Unknown Role")
+		return
+	}
+
+	has := slices.Contains(member.Roles, roleID)
+	if add && !has {
+		member.Roles = append(member.Roles, roleID)
+		s.dispatch("GUILD_MEMBER_UPDATE", s.memberEvent(member))
+	} else if !add && has {
+		member.Roles = slices.DeleteFunc(member.Roles, func(id string) bool { return id == roleID })
+		s.dispatch("GUILD_MEMBER_UPDATE", s.memberEvent(member))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) createDM(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RecipientID string `json:"recipient_id"`
+	}
+	if !readJson(w, r, &body) {
+		return
+	}
+
+	user := s.findUser(body.RecipientID)
+	if user == nil {
+		writeError(w, http.StatusNotFound, errUnknownUser, "Unknown User")
+		return
+	}
+
+	for _, c := range s.channels {
+		if c.Type == ChannelTypeDM && c.Recipients[0].ID == user.ID {
+			writeJson(w, http.StatusOK, c)
+			return
+		}
+	}
+	channel := &Channel{
+		ID:         s.newSnowflake(),
+		Type:       ChannelTypeDM,
+		Recipients: []User{*user},
+	}
+	s.channels = append(s.channels, channel)
+	writeJson(w, http.StatusOK, channel)
+}
+
+// Returns messages newest first, like Discord.
+func (s *Server) getMessages(w http.ResponseWriter, r *http.Request, channelID string) {
+	if s.findChannel(channelID) == nil {
+		writeError(w, http.StatusNotFound, errUnknownChannel, "Unknown Channel")
+		return
+	}
+
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil {
+		limit = 50
+	}
+	limit = min(max(limit, 1), 100)
+
+	var msgs []*Message
+	for _, msg := range s.messages[channelID] {
+		if !msg.Deleted {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	result := []*Message{}
+	if after := q.Get("after"); after != "" {
+		// The messages right after the given one, still newest first
+		for _, msg := range msgs {
+			if snowflakeLess(after, msg.ID) && len(result) < limit {
+				result = append(result, msg)
+			}
+		}
+		slices.Reverse(result)
+	} else if around := q.Get("around"); around != "" {
+		idx := slices.IndexFunc(msgs, func(msg *Message) bool { return !snowflakeLess(msg.ID, around) })
+		if idx < 0 {
+			idx = len(msgs)
+		}
+		start := max(idx-limit/2, 0)
+		end := min(start+limit, len(msgs))
+		result = append(result, msgs[start:end]...)
+		slices.Reverse(result)
+	} else {
+		before := q.Get("before")
+		for i := len(msgs) - 1; i >= 0 && len(result) < limit; i-- {
+			if before == "" || snowflakeLess(msgs[i].ID, before) {
+				result = append(result, msgs[i])
+			}
+		}
+	}
+	writeJson(w, http.StatusOK, result)
+}
+
+// Only renaming is supported.
+func (s *Server) modifyChannel(w http.ResponseWriter, r *http.Request, channelID string) {
+	channel := s.findChannel(channelID)
+	if channel == nil {
+		writeError(w, http.StatusNotFound, errUnknownChannel, "Unknown Channel")
+		return
+	}
+
+	var body struct {
+		Name *string `json:"name"`
+	}
+	if !readJson(w, r, &body) {
+		return
+	}
+	if body.Name != nil {
+		if *body.Name == "" || len([]rune(*body.Name)) > 100 {
+			writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+			return
+		}
+		channel.Name = *body.Name
+	}
+
+	if channel.Type == ChannelTypeGuildPublicThread {
+		s.dispatch("THREAD_UPDATE", channel)
+	} else {
+		s.dispatch("CHANNEL_UPDATE", channel)
+	}
+	writeJson(w, http.StatusOK, channel)
+}
+
+// Deleting a forum deletes its threads too, and deleting a thread deletes its
+// messages, without a MESSAGE_DELETE for each.
+func (s *Server) deleteChannel(w http.ResponseWriter, channelID string) {
+	channel := s.findChannel(channelID)
+	if channel == nil {
+		writeError(w, http.StatusNotFound, errUnknownChannel, "Unknown Channel")
+		return
+	}
+
+	s.channels = slices.DeleteFunc(s.channels, func(c *Channel) bool {
+		if c.ID != channelID && c.ParentID != channelID {
+			return false
+		}
+		for _, msg := range s.messages[c.ID] {
+			msg.Deleted = true
+		}
+		return true
+	})
+	s.webhooks = slices.DeleteFunc(s.webhooks, func(wh *Webhook) bool { return wh.ChannelID == channelID })
+
+	if channel.Type == ChannelTypeGuildPublicThread {
+		s.dispatch("THREAD_DELETE", map[string]any{
+			"id":        channel.ID,
+			"guild_id":  channel.GuildID,
+			"parent_id": channel.ParentID,
+			"type":      channel.Type,
+		})
+	} else {
+		s.dispatch("CHANNEL_DELETE", channel)
+	}
+	writeJson(w, http.StatusOK, channel)
+}
+
+func (s *Server) createWebhook(w http.ResponseWriter, r *http.Request, channelID string) {
+	channel := s.findChannel(channelID)
+	if channel == nil {
+		writeError(w, http.StatusNotFound, errUnknownChannel, "Unknown Channel")
+		return
+	}
+	if channel.Type != ChannelTypeGuildText && channel.Type != ChannelTypeGuildForum {
+		writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if !readJson(w, r, &body) {
+		return
+	}
+	if body.Name == "" || len([]rune(body.Name)) > 80 {
+		writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+		return
+	}
+
+	webhook := &Webhook{
+		ID:        s.newSnowflake(),
+		Type:      1, // incoming
+		GuildID:   s.GuildID,
+		ChannelID: channel.ID,
+		Name:      body.Name,
+		Token:     randomToken(),
+	}
+	s.webhooks = append(s.webhooks, webhook)
+	writeJson(w, http.StatusOK, webhook)
+}
+
+// Webhooks in forums must either post in one of the forum's threads, given by
+// the thread_id query parameter, or start a new one with thread_name.
+func (s *Server) executeWebhook(w http.ResponseWriter, r *http.Request, webhookID, token string) {
+	webhook := s.findWebhook(webhookID, token)
+	if webhook == nil {
+		writeError(w, http.StatusNotFound, errUnknownWebhook, "Unknown Webhook")
+		return
+	}
+
+	payload, files, ok := readMessagePayload(w, r)
+	if !ok {
+		return
+	}
+	content, _ := payload["content"].(string)
+	username, _ := payload["username"].(string)
+	avatarUrl, _ := payload["avatar_url"].(string)
+	threadName, _ := payload["thread_name"].(string)
+	if content == "" && len(files) == 0 {
+		writeError(w, http.StatusBadRequest, 50006, "Cannot send an empty message")
+		return
+	}
+	if len([]rune(username)) > 80 || len([]rune(threadName)) > 100 {
+		writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+		return
+	}
+	if username == "" {
+		username = webhook.Name
+	}
+	author := User{
+		ID:            webhook.ID,
+		Username:      username,
+		Discriminator: "0000",
+		Bot:           true,
+	}
+
+	channel := s.findChannel(webhook.ChannelID)
+	var msg *Message
+	if threadID := r.URL.Query().Get("thread_id"); threadID != "" {
+		thread := s.findChannel(threadID)
+		if thread == nil || thread.ParentID != channel.ID {
+			writeError(w, http.StatusNotFound, errUnknownChannel, "Unknown Channel")
+			return
+		}
+		msg = s.createMessage(thread, author, content, files)
+	} else if channel.Type == ChannelTypeGuildForum {
+		if threadName == "" {
+			writeError(w, http.StatusBadRequest, 220001, "Webhooks posted to forum channels must have a thread_name or thread_id")
+			return
+		}
+		_, msg = s.createThread(channel, threadName, author, content)
+		if len(files) > 0 {
+			// Attach the files by making a throwaway message to hold them.
+			thread := s.findChannel(msg.ChannelID)
+			holder := s.createMessage(thread, author, "", files)
+			msg.Attachments = holder.Attachments
+			s.messages[thread.ID] = s.messages[thread.ID][:len(s.messages[thread.ID])-1]
+		}
+	} else {
+		msg = s.createMessage(channel, author, content, files)
+	}
+	msg.WebhookID = &webhook.ID
+	msg.WebhookAvatarUrl = avatarUrl
+	if flags, ok := payload["flags"].(float64); ok {
+		msg.Flags = int(flags)
+	}
+	s.dispatch("MESSAGE_CREATE", msg)
+
+	if r.URL.Query().Get("wait") == "true" {
+		writeJson(w, http.StatusOK, msg)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Webhooks can only edit and delete their own messages. Messages in threads
+// must be looked up with the thread_id query parameter.
+func (s *Server) editWebhookMessage(w http.ResponseWriter, r *http.Request, webhookID, token, messageID string) {
+	webhook := s.findWebhook(webhookID, token)
+	if webhook == nil {
+		writeError(w, http.StatusNotFound, errUnknownWebhook, "Unknown Webhook")
+		return
+	}
+
+	channelID := webhook.ChannelID
+	if threadID := r.URL.Query().Get("thread_id"); threadID != "" {
+		channelID = threadID
+	}
+	msg := s.findMessage(channelID, messageID)
+	if msg == nil || msg.Deleted || msg.WebhookID == nil || *msg.WebhookID != webhook.ID {
+		writeError(w, http.StatusNotFound, errUnknownMessage, "Unknown Message")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		s.deleteMessage(msg)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	payload, _, ok := readMessagePayload(w, r)
+	if !ok {
+		return
+	}
+	applyMessagePayload(msg, payload)
+	edited := time.Now().UTC().Format(time.RFC3339Nano)
+	msg.EditedTimestamp = &edited
+	s.dispatch("MESSAGE_UPDATE", msg)
+	writeJson(w, http.StatusOK, msg)
+}
+
+func (s *Server) createCommand(w http.ResponseWriter, r *http.Request, guildID string) {
+	var command ApplicationCommand
+	if !readJson(w, r, &command) {
+		return
+	}
+	if command.Name == "" {
+		writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+		return
+	}
+	if command.Type == 0 {
+		command.Type = 1
+	}
+	command.ApplicationID = s.BotUser.ID
+	command.GuildID = guildID
+
+	// Creating a command with the same name as an existing one replaces it.
+	status := http.StatusCreated
+	for i, existing := range s.commands {
+		if existing.Name == command.Name && existing.Type == command.Type {
+			command.ID = existing.ID
+			s.commands[i] = &command
+			status = http.StatusOK
+		}
+	}
+	if command.ID == "" {
+		command.ID = s.newSnowflake()
+		s.commands = append(s.commands, &command)
+	}
+	writeJson(w, status, command)
+}
+
+func (s *Server) createScheduledEvent(w http.ResponseWriter, r *http.Request) {
+	var ev ScheduledEvent
+	if !readJson(w, r, &ev) {
+		return
+	}
+	ev.ID = s.newSnowflake()
+	ev.GuildID = s.GuildID
+	ev.CreatorID = s.BotUser.ID
+	ev.Status = 1 // scheduled
+	if !s.validateScheduledEvent(w, &ev, true) {
+		return
+	}
+
+	s.scheduledEvents = append(s.scheduledEvents, &ev)
+	s.dispatch("GUILD_SCHEDULED_EVENT_CREATE", &ev)
+	writeJson(w, http.StatusOK, &ev)
+}
+
+// Only the fields in the request are changed, like Discord does.
+func (s *Server) modifyScheduledEvent(w http.ResponseWriter, r *http.Request, eventID string) {
+	ev := s.findScheduledEvent(eventID)
+	if ev == nil {
+		writeError(w, http.StatusNotFound, errUnknownEvent, "Unknown Guild Scheduled Event")
+		return
+	}
+
+	var patch map[string]json.RawMessage
+	if !readJson(w, r, &patch) {
+		return
+	}
+	fields := make(map[string]json.RawMessage)
+	existing, _ := json.Marshal(ev)
+	json.Unmarshal(existing, &fields)
+	for k, v := range patch {
+		switch k {
+		case "id", "guild_id", "creator_id", "status":
+			// Not settable this way
+		default:
+			fields[k] = v
+		}
+	}
+	merged, _ := json.Marshal(fields)
+	var updated ScheduledEvent
+	err := json.Unmarshal(merged, &updated)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+		return
+	}
+	if !s.validateScheduledEvent(w, &updated, updated.ScheduledStartTime != ev.ScheduledStartTime) {
+		return
+	}
+
+	*ev = updated
+	s.dispatch("GUILD_SCHEDULED_EVENT_UPDATE", ev)
+	writeJson(w, http.StatusOK, ev)
+}
+
+// Checks the rules Discord enforces on what an event's fields can be.
+func (s *Server) validateScheduledEvent(w http.ResponseWriter, ev *ScheduledEvent, checkStartInFuture bool) bool {
+	invalid := func() bool {
+		writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+		return false
+	}
+
+	if ev.Name == "" || len([]rune(ev.Name)) > 100 {
+		return invalid()
+	}
+	if ev.Description != nil && len([]rune(*ev.Description)) > 1000 {
+		return invalid()
+	}
+	if ev.PrivacyLevel != 2 {
+		return invalid()
+	}
+
+	start, err := time.Parse(time.RFC3339, ev.ScheduledStartTime)
+	if err != nil || (checkStartInFuture && !start.After(time.Now())) {
+		return invalid()
+	}
+	if ev.ScheduledEndTime != nil {
+		end, err := time.Parse(time.RFC3339, *ev.ScheduledEndTime)
+		if err != nil || !end.After(start) {
+			return invalid()
+		}
+	}
+
+	switch ev.EntityType {
+	case ScheduledEventEntityTypeStageInstance, ScheduledEventEntityTypeVoice:
+		if ev.ChannelID == nil {
+			return invalid()
+		}
+		channel := s.findChannel(*ev.ChannelID)
+		if channel == nil {
+			writeError(w, http.StatusNotFound, errUnknownChannel, "Unknown Channel")
+			return false
+		}
+		wantType := ChannelTypeGuildVoice
+		if ev.EntityType == ScheduledEventEntityTypeStageInstance {
+			wantType = ChannelTypeGuildStageVoice
+		}
+		if channel.Type != wantType {
+			return invalid()
+		}
+		ev.EntityMetadata = nil
+	case ScheduledEventEntityTypeExternal:
+		if ev.ChannelID != nil || ev.ScheduledEndTime == nil ||
+			ev.EntityMetadata == nil || ev.EntityMetadata.Location == "" || len([]rune(ev.EntityMetadata.Location)) > 100 {
+			return invalid()
+		}
+	default:
+		return invalid()
+	}
+
+	return true
+}
+
+func (s *Server) createInteractionResponse(w http.ResponseWriter, r *http.Request, interactionID, token string) {
+	interaction, ok := s.interactions[interactionID]
+	if !ok || interaction.Token != token {
+		writeError(w, http.StatusNotFound, errUnknownInteraction, "Unknown interaction")
+		return
+	}
+	if _, responded := s.interactionResponses[interactionID]; responded {
+		writeError(w, http.StatusBadRequest, 40060, "Interaction has already been acknowledged.")
+		return
+	}
+
+	var res InteractionResponse
+	if !readJson(w, r, &res) {
+		return
+	}
+
+	// Replies with a message, including deferred ones, get an original
+	// response message that can be edited later. Ephemeral replies are only
+	// shown to the user who invoked the command, so bots never hear about
+	// them.
+	const (
+		callbackChannelMessageWithSource         = 4
+		callbackDeferredChannelMessageWithSource = 5
+		flagEphemeral                            = 1 << 6
+	)
+	if res.Type == callbackChannelMessageWithSource || res.Type == callbackDeferredChannelMessageWithSource {
+		channel := s.findChannel(interaction.ChannelID)
+		res.Original = s.createMessage(channel, s.BotUser, "", nil)
+		res.Original.Type = MessageTypeApplicationCommand
+		if res.Type == callbackChannelMessageWithSource {
+			applyMessagePayload(res.Original, res.Data)
+		} else if flags, ok := res.Data["flags"].(float64); ok {
+			res.Original.Flags = int(flags)
+		}
+		if res.Original.Flags&flagEphemeral == 0 {
+			s.dispatch("MESSAGE_CREATE", res.Original)
+		}
+	}
+	s.interactionResponses[interactionID] = &res
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) editOriginalInteractionResponse(w http.ResponseWriter, r *http.Request, token string) {
+	var res *InteractionResponse
+	for id, interaction := range s.interactions {
+		if interaction.Token == token {
+			res = s.interactionResponses[id]
+		}
+	}
+	if res == nil || res.Original == nil {
+		writeError(w, http.StatusNotFound, errUnknownMessage, "Unknown Message")
+		return
+	}
+
+	payload, files, ok := readMessagePayload(w, r)
+	if !ok {
+		return
+	}
+	if len(files) > 0 {
+		// Attach the files by making a throwaway message to hold them.
+		channel := s.findChannel(res.Original.ChannelID)
+		holder := s.createMessage(channel, s.BotUser, "", files)
+		res.Original.Attachments = append(res.Original.Attachments, holder.Attachments...)
+		s.messages[channel.ID] = s.messages[channel.ID][:len(s.messages[channel.ID])-1]
+	}
+	applyMessagePayload(res.Original, payload)
+	s.dispatch("MESSAGE_UPDATE", res.Original)
+	writeJson(w, http.StatusOK, res.Original)
+}
+
+func (s *Server) exchangeOAuthCode(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]any{"error": "invalid_request"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userID, ok := s.oauthCodes[r.PostForm.Get("code")]
+	if !ok {
+		writeJson(w, http.StatusBadRequest, map[string]any{"error": "invalid_grant"})
+		return
+	}
+	delete(s.oauthCodes, r.PostForm.Get("code"))
+
+	accessToken := randomToken()
+	s.oauthTokens[accessToken] = userID
+	writeJson(w, http.StatusOK, map[string]any{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int((7 * 24 * time.Hour).Seconds()),
+		"refresh_token": randomToken(),
+		"scope":         "identify",
+	})
+}
+
+func (s *Server) getCurrentUser(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userID, ok := s.oauthTokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	if !ok {
+		writeJson(w, http.StatusUnauthorized, map[string]any{"message": "401: Unauthorized", "code": 0})
+		return
+	}
+	user := s.findUser(userID)
+	if user == nil {
+		writeError(w, http.StatusNotFound, errUnknownUser, "Unknown User")
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]any{
+		"id":            user.ID,
+		"username":      user.Username,
+		"discriminator": user.Discriminator,
+		"avatar":        user.Avatar,
+		"locale":        "en-US",
+	})
+}
+
+// Reads a message body, which is either JSON or multipart form data with the
+// JSON in payload_json and files alongside.
+func readMessagePayload(w http.ResponseWriter, r *http.Request) (map[string]any, []File, bool) {
+	payload := make(map[string]any)
+	var files []File
+
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+				return nil, nil, false
+			}
+
+			data, err := io.ReadAll(part)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+				return nil, nil, false
+			}
+			if part.FormName() == "payload_json" {
+				err = json.Unmarshal(data, &payload)
+				if err != nil {
+					writeError(w, http.StatusBadRequest, errInvalidFormBody, "Invalid Form Body")
+					return nil, nil, false
+				}
+			} else if part.FileName() != "" {
+				files = append(files, File{
+					Name:        part.FileName(),
+					ContentType: part.Header.Get("Content-Type"),
+					Data:        data,
+				})
+			}
+		}
+	} else if !readJson(w, r, &payload) {
+		return nil, nil, false
+	}
+
+	return payload, files, true
+}
+
+func applyMessagePayload(msg *Message, payload map[string]any) {
+	if content, ok := payload["content"].(string); ok {
+		msg.Content = content
+	}
+	if flags, ok := payload["flags"].(float64); ok {
+		msg.Flags = int(flags)
+	}
+	if embeds, ok := payload["embeds"].([]any); ok {
+		msg.Embeds = embeds
+	}
+}
+
+func readJson(w http.ResponseWriter, r *http.Request, dest any) bool {
+	err := json.NewDecoder(r.Body).Decode(dest)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 50109, "The request body contains invalid JSON.")
+		return false
+	}
+	return true
+}
+
+func writeJson(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code int, message string) {
+	writeJson(w, status, map[string]any{"code": code, "message": message})
+}
@@ -2,14 +2,21 @@ package hmns3
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"git.handmade.network/hmn/hmn/src/config"
 	"git.handmade.network/hmn/hmn/src/jobs"
@@ -18,9 +25,27 @@ import (
 	"github.com/rs/zerolog"
 )
 
+/*
+A fake S3 server for local development. It speaks enough of the S3 API for
+everything the site does with assets: objects can be put, fetched (including
+ranges), inspected, copied, listed, and deleted, and big files can be sent with
+multipart uploads.
+
+Objects live in tmp/s3/<bucket>/<key>, with slashes in keys replaced by
+tildes. Content types and ETags are kept alongside in tmp/s3/.meta, and
+multipart uploads in progress are kept in tmp/s3/.uploads.
+
+Buckets are created on the fly by anything that writes to them. Requests are
+not authenticated, so ACLs are accepted but ignored; private objects can be
+fetched by anyone.
+*/
+
 const dir = "./tmp/s3"
 
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
 type server struct {
+	dir string
 	log zerolog.Logger
 }
 
@@ -33,23 +58,16 @@ func StartServer() *jobs.Job {
 
 	utils.Must(os.MkdirAll(dir, fs.ModePerm))
 
-	s := server{
+	s := &server{
+		dir: dir,
 		log: logging.ExtractLogger(job.Ctx).With().
 			Str("module", "S3 server").
 			Logger(),
 	}
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodGet {
-			s.getObject(w, r)
-		} else if r.Method == http.MethodPut {
-			s.putObject(w, r)
-		} else {
-			panic("Unimplemented method!")
-		}
-	})
 
 	srv := http.Server{
-		Addr: config.Config.DigitalOcean.FakeAddr,
+		Addr:    config.Config.DigitalOcean.FakeAddr,
+		Handler: s,
 	}
 
 	s.log.Info().Msg("Starting local S3 server")
@@ -74,30 +92,488 @@ func StartServer() *jobs.Job {
 	return job
 }
 
-func (s *server) getObject(w http.ResponseWriter, r *http.Request) {
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	bucket, key := bucketKey(r)
+	q := r.URL.Query()
+
+	if bucket == "" {
+		s.error(w, r, http.StatusNotImplemented, "NotImplemented", "Listing buckets is not supported")
+		return
+	}
+
+	if strings.HasPrefix(bucket, ".") {
+		s.error(w, r, http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid")
+		return
+	}
+	if key == "." || key == ".." {
+		s.error(w, r, http.StatusBadRequest, "InvalidArgument", "The specified key is not valid")
+		return
+	}
 
-	file, err := os.Open(filepath.Join(dir, bucket, key))
+	if key == "" {
+		switch {
+		case r.Method == http.MethodPut:
+			s.createBucket(w, r, bucket)
+		case r.Method == http.MethodGet:
+			s.listObjects(w, r, bucket)
+		case r.Method == http.MethodPost && q.Has("delete"):
+			s.deleteObjects(w, r, bucket)
+		default:
+			s.error(w, r, http.StatusNotImplemented, "NotImplemented", fmt.Sprintf("%s on a bucket is not supported", r.Method))
+		}
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		s.completeMultipartUpload(w, r, bucket, key, q.Get("uploadId"))
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		s.uploadPart(w, r, q.Get("uploadId"), q.Get("partNumber"))
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		s.abortMultipartUpload(w, r, q.Get("uploadId"))
+	case r.Method == http.MethodPut && q.Has("acl"):
+		s.putObjectACL(w, r, bucket, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, bucket, key)
+	default:
+		s.error(w, r, http.StatusNotImplemented, "NotImplemented", fmt.Sprintf("%s on an object is not supported", r.Method))
+	}
+}
+
+type objectMeta struct {
+	ContentType string
+	ETag        string
+}
+
+func (s *server) createBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	w.Header().Set("Location", fmt.Sprintf("/%s", bucket))
+	utils.Must(os.MkdirAll(filepath.Join(s.dir, bucket), fs.ModePerm))
+}
+
+func (s *server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !s.bucketExists(bucket) {
+		s.error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	file, err := os.Open(s.objectPath(bucket, key))
 	if errors.Is(err, os.ErrNotExist) {
-		w.WriteHeader(http.StatusNotFound)
+		s.error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
 		return
 	} else if err != nil {
 		s.log.Err(err).Msg("failed to open S3 file")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	io.Copy(w, file)
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		s.log.Err(err).Msg("failed to stat S3 file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	meta := s.readMeta(bucket, key)
+	if meta.ContentType != "" {
+		w.Header().Set("Content-Type", meta.ContentType)
+	}
+	w.Header().Set("ETag", meta.ETag)
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	// Handles HEAD, ranges, and conditional requests.
+	http.ServeContent(w, r, key, info.ModTime(), file)
 }
 
-func (s *server) putObject(w http.ResponseWriter, r *http.Request) {
-	bucket, key := bucketKey(r)
+func (s *server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	meta, err := s.writeObject(bucket, key, r.Body, r.Header.Get("Content-Type"))
+	if err != nil {
+		s.log.Err(err).Msg("failed to write S3 file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", meta.ETag)
+}
 
-	w.Header().Set("Location", fmt.Sprintf("/%s", bucket))
-	utils.Must(os.MkdirAll(filepath.Join(dir, bucket), fs.ModePerm))
-	if key != "" {
-		file := utils.Must1(os.Create(filepath.Join(dir, bucket, key)))
-		io.Copy(file, r.Body)
+func (s *server) putObjectACL(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !s.bucketExists(bucket) {
+		s.error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
 	}
+	if _, err := os.Stat(s.objectPath(bucket, key)); err != nil {
+		s.error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+}
+
+func (s *server) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	srcBucket, srcKey, err := parseCopySource(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, "InvalidArgument", "Copy Source must mention the source bucket and key")
+		return
+	}
+	if !s.bucketExists(srcBucket) {
+		s.error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	src, err := os.Open(s.objectPath(srcBucket, srcKey))
+	if errors.Is(err, os.ErrNotExist) {
+		s.error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	} else if err != nil {
+		s.log.Err(err).Msg("failed to open S3 file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer src.Close()
+
+	contentType := s.readMeta(srcBucket, srcKey).ContentType
+	if strings.EqualFold(r.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		contentType = r.Header.Get("Content-Type")
+	}
+
+	meta, err := s.writeObject(bucket, key, src, contentType)
+	if err != nil {
+		s.log.Err(err).Msg("failed to copy S3 file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s.writeXML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		Xmlns        string   `xml:"xmlns,attr"`
+		LastModified string
+		ETag         string
+	}{
+		Xmlns:        s3Namespace,
+		LastModified: formatTime(time.Now()),
+		ETag:         meta.ETag,
+	})
+}
+
+func (s *server) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	if !s.bucketExists(bucket) {
+		s.error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	err := s.removeObject(bucket, key)
+	if err != nil {
+		s.log.Err(err).Msg("failed to delete S3 file")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Like S3, deleting something that isn't there is fine.
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !s.bucketExists(bucket) {
+		s.error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	var req struct {
+		Quiet  bool
+		Object []struct {
+			Key string
+		}
+	}
+	err := xml.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		s.error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed")
+		return
+	}
+
+	type deleted struct {
+		Key string
+	}
+	type deleteError struct {
+		Key     string
+		Code    string
+		Message string
+	}
+	var res struct {
+		XMLName xml.Name      `xml:"DeleteResult"`
+		Xmlns   string        `xml:"xmlns,attr"`
+		Deleted []deleted     `xml:"Deleted"`
+		Error   []deleteError `xml:"Error"`
+	}
+	res.Xmlns = s3Namespace
+	for _, obj := range req.Object {
+		err := s.removeObject(bucket, obj.Key)
+		if err != nil {
+			s.log.Err(err).Str("key", obj.Key).Msg("failed to delete S3 file")
+			res.Error = append(res.Error, deleteError{Key: obj.Key, Code: "InternalError", Message: err.Error()})
+		} else if !req.Quiet {
+			res.Deleted = append(res.Deleted, deleted{Key: obj.Key})
+		}
+	}
+
+	s.writeXML(w, res)
+}
+
+func (s *server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		s.error(w, r, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is supported")
+		return
+	}
+	if !s.bucketExists(bucket) {
+		s.error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	maxKeys := 1000
+	if q.Has("max-keys") {
+		n, err := strconv.Atoi(q.Get("max-keys"))
+		if err != nil || n < 0 {
+			s.error(w, r, http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer")
+			return
+		}
+		maxKeys = min(n, 1000)
+	}
+	// Continuation tokens are just the last key we returned.
+	after := q.Get("start-after")
+	if token := q.Get("continuation-token"); token != "" {
+		after = token
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, bucket))
+	if err != nil {
+		s.log.Err(err).Msg("failed to list S3 bucket")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var keys []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		key := strings.ReplaceAll(entry.Name(), "~", "/")
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	type object struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+		StorageClass string
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	var res struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Xmlns                 string   `xml:"xmlns,attr"`
+		Name                  string
+		Prefix                string
+		Delimiter             string `xml:",omitempty"`
+		StartAfter            string `xml:",omitempty"`
+		ContinuationToken     string `xml:",omitempty"`
+		NextContinuationToken string `xml:",omitempty"`
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		Contents              []object       `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}
+	res.Xmlns = s3Namespace
+	res.Name = bucket
+	res.Prefix = prefix
+	res.Delimiter = delimiter
+	res.StartAfter = q.Get("start-after")
+	res.ContinuationToken = q.Get("continuation-token")
+	res.MaxKeys = maxKeys
+
+	last := ""
+	for _, key := range keys {
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if len(res.CommonPrefixes) > 0 && res.CommonPrefixes[len(res.CommonPrefixes)-1].Prefix == common {
+					// Rolled into the prefix we already returned.
+					last = key
+					continue
+				}
+				if res.KeyCount == maxKeys {
+					res.IsTruncated = true
+					break
+				}
+				res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: common})
+				res.KeyCount++
+				last = key
+				continue
+			}
+		}
+
+		if res.KeyCount == maxKeys {
+			res.IsTruncated = true
+			break
+		}
+		info, err := os.Stat(s.objectPath(bucket, key))
+		if err != nil {
+			// Deleted while we were listing
+			continue
+		}
+		res.Contents = append(res.Contents, object{
+			Key:          key,
+			LastModified: formatTime(info.ModTime()),
+			ETag:         s.readMeta(bucket, key).ETag,
+			Size:         info.Size(),
+			StorageClass: "STANDARD",
+		})
+		res.KeyCount++
+		last = key
+	}
+	if res.IsTruncated {
+		res.NextContinuationToken = last
+	}
+
+	s.writeXML(w, res)
+}
+
+func (s *server) bucketExists(bucket string) bool {
+	info, err := os.Stat(filepath.Join(s.dir, bucket))
+	return err == nil && info.IsDir()
+}
+
+func (s *server) objectPath(bucket, key string) string {
+	return filepath.Join(s.dir, bucket, strings.ReplaceAll(key, "/", "~"))
+}
+
+func (s *server) metaPath(bucket, key string) string {
+	return filepath.Join(s.dir, ".meta", bucket, strings.ReplaceAll(key, "/", "~"))
+}
+
+// Writes an object atomically, so that nobody ever reads half of one.
+func (s *server) writeObject(bucket, key string, content io.Reader, contentType string) (objectMeta, error) {
+	err := os.MkdirAll(filepath.Join(s.dir, bucket), fs.ModePerm)
+	if err != nil {
+		return objectMeta{}, err
+	}
+
+	tmp, err := s.tempFile()
+	if err != nil {
+		return objectMeta{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), content)
+	tmp.Close()
+	if err != nil {
+		return objectMeta{}, err
+	}
+
+	meta := objectMeta{
+		ContentType: contentType,
+		ETag:        fmt.Sprintf(`"%x"`, hash.Sum(nil)),
+	}
+	err = s.commitObject(bucket, key, tmp.Name(), meta)
+	if err != nil {
+		return objectMeta{}, err
+	}
+	return meta, nil
+}
+
+func (s *server) commitObject(bucket, key, tmpPath string, meta objectMeta) error {
+	err := os.MkdirAll(filepath.Dir(s.metaPath(bucket, key)), fs.ModePerm)
+	if err != nil {
+		return err
+	}
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(s.metaPath(bucket, key), metaJSON, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.objectPath(bucket, key))
+}
+
+// Objects written before we kept metadata don't have any, so their ETags are
+// worked out on demand.
+func (s *server) readMeta(bucket, key string) objectMeta {
+	var meta objectMeta
+	if metaJSON, err := os.ReadFile(s.metaPath(bucket, key)); err == nil {
+		json.Unmarshal(metaJSON, &meta)
+	}
+	if meta.ETag == "" {
+		if file, err := os.Open(s.objectPath(bucket, key)); err == nil {
+			hash := md5.New()
+			io.Copy(hash, file)
+			file.Close()
+			meta.ETag = fmt.Sprintf(`"%x"`, hash.Sum(nil))
+		}
+	}
+	return meta
+}
+
+func (s *server) removeObject(bucket, key string) error {
+	err := os.Remove(s.objectPath(bucket, key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = os.Remove(s.metaPath(bucket, key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *server) tempFile() (*os.File, error) {
+	tmpDir := filepath.Join(s.dir, ".tmp")
+	err := os.MkdirAll(tmpDir, fs.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(tmpDir, "object*")
+}
+
+func (s *server) writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	err := xml.NewEncoder(w).Encode(v)
+	if err != nil {
+		s.log.Err(err).Msg("failed to write S3 response")
+	}
+}
+
+func (s *server) error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		// HEAD responses have no body, so the status code is all the client gets.
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName  xml.Name `xml:"Error"`
+		Code     string
+		Message  string
+		Resource string
+	}{
+		Code:     code,
+		Message:  message,
+		Resource: r.URL.Path,
+	})
 }
 
 func bucketKey(r *http.Request) (string, string) {
@@ -105,6 +581,25 @@ func bucketKey(r *http.Request) (string, string) {
 	if slashIdx == -1 {
 		return r.URL.Path[1:], ""
 	} else {
-		return r.URL.Path[1 : 1+slashIdx], strings.ReplaceAll(r.URL.Path[2+slashIdx:], "/", "~")
+		return r.URL.Path[1 : 1+slashIdx], r.URL.Path[2+slashIdx:]
+	}
+}
+
+// Parses an X-Amz-Copy-Source header, which looks like "/bucket/key" or
+// "bucket/key", URL-encoded, optionally with a version ID on the end.
+func parseCopySource(source string) (string, string, error) {
+	source, _, _ = strings.Cut(source, "?versionId=")
+	source, err := url.PathUnescape(source)
+	if err != nil {
+		return "", "", err
+	}
+	bucket, key, found := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !found || bucket == "" || strings.HasPrefix(bucket, ".") || key == "" || key == "." || key == ".." {
+		return "", "", errors.New("invalid copy source")
 	}
+	return bucket, key, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
 }
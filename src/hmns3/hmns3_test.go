@@ -0,0 +1,408 @@
+package hmns3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBucket = "hmn-test"
+
+// Starts a fake S3 server in a temp directory and returns a client configured
+// the same way as the one in the assets package.
+func startTestServer(t *testing.T) *s3.Client {
+	ts := httptest.NewServer(&server{
+		dir: t.TempDir(),
+		log: zerolog.Nop(),
+	})
+	t.Cleanup(ts.Close)
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(ts.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		UsePathStyle: true,
+	})
+
+	_, err := client.CreateBucket(context.Background(), &s3.CreateBucketInput{
+		Bucket: aws.String(testBucket),
+	})
+	require.Nil(t, err)
+
+	return client
+}
+
+func putTestObject(t *testing.T, client *s3.Client, key, content string) {
+	_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(testBucket),
+		Key:         aws.String(key),
+		Body:        strings.NewReader(content),
+		ContentType: aws.String("text/plain"),
+		ACL:         types.ObjectCannedACLPublicRead,
+	})
+	require.Nil(t, err)
+}
+
+func getTestObject(t *testing.T, client *s3.Client, key string) string {
+	res, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(key),
+	})
+	require.Nil(t, err)
+	defer res.Body.Close()
+	content, err := io.ReadAll(res.Body)
+	require.Nil(t, err)
+	return string(content)
+}
+
+func assertErrorCode(t *testing.T, code string, err error) {
+	var apiError smithy.APIError
+	if assert.True(t, errors.As(err, &apiError), "expected an API error, got %v", err) {
+		assert.Equal(t, code, apiError.ErrorCode())
+	}
+}
+
+func TestPutGetObject(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	putTestObject(t, client, "dir/hello.txt", "Hello, world!")
+	assert.Equal(t, "Hello, world!", getTestObject(t, client, "dir/hello.txt"))
+
+	t.Run("range", func(t *testing.T) {
+		res, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(testBucket),
+			Key:    aws.String("dir/hello.txt"),
+			Range:  aws.String("bytes=7-11"),
+		})
+		require.Nil(t, err)
+		defer res.Body.Close()
+		content, _ := io.ReadAll(res.Body)
+		assert.Equal(t, "world", string(content))
+		assert.Equal(t, "bytes 7-11/13", aws.ToString(res.ContentRange))
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		_, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(testBucket),
+			Key:    aws.String("nope.txt"),
+		})
+		assertErrorCode(t, "NoSuchKey", err)
+	})
+
+	t.Run("missing bucket", func(t *testing.T) {
+		_, err := client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String("nope"),
+			Key:    aws.String("dir/hello.txt"),
+		})
+		assertErrorCode(t, "NoSuchBucket", err)
+	})
+}
+
+func TestHeadObject(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	putTestObject(t, client, "hello.txt", "Hello, world!")
+
+	res, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("hello.txt"),
+	})
+	require.Nil(t, err)
+	assert.Equal(t, int64(13), aws.ToInt64(res.ContentLength))
+	assert.Equal(t, "text/plain", aws.ToString(res.ContentType))
+	assert.Equal(t, `"6cd3556deb0da54bca060b4c39479839"`, aws.ToString(res.ETag))
+	assert.NotNil(t, res.LastModified)
+
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("nope.txt"),
+	})
+	var notFound *types.NotFound
+	assert.True(t, errors.As(err, &notFound), "expected NotFound, got %v", err)
+}
+
+func TestPutObjectACL(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	putTestObject(t, client, "hello.txt", "Hello, world!")
+
+	_, err := client.PutObjectAcl(ctx, &s3.PutObjectAclInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("hello.txt"),
+		ACL:    types.ObjectCannedACLPrivate,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "Hello, world!", getTestObject(t, client, "hello.txt"))
+
+	_, err = client.PutObjectAcl(ctx, &s3.PutObjectAclInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("nope.txt"),
+		ACL:    types.ObjectCannedACLPrivate,
+	})
+	assertErrorCode(t, "NoSuchKey", err)
+}
+
+func TestDeleteObject(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	putTestObject(t, client, "a/1.txt", "1")
+	putTestObject(t, client, "a/2.txt", "2")
+	putTestObject(t, client, "a/3.txt", "3")
+
+	_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("a/1.txt"),
+	})
+	require.Nil(t, err)
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("a/1.txt"),
+	})
+	assert.NotNil(t, err)
+
+	// Deleting something that's already gone is not an error.
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("a/1.txt"),
+	})
+	assert.Nil(t, err)
+
+	res, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(testBucket),
+		Delete: &types.Delete{
+			Objects: []types.ObjectIdentifier{
+				{Key: aws.String("a/2.txt")},
+				{Key: aws.String("a/3.txt")},
+			},
+		},
+	})
+	require.Nil(t, err)
+	assert.Len(t, res.Deleted, 2)
+	assert.Empty(t, res.Errors)
+
+	list, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(testBucket),
+	})
+	require.Nil(t, err)
+	assert.Empty(t, list.Contents)
+}
+
+func TestCopyObject(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	putTestObject(t, client, "src/file.txt", "copy me")
+
+	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(testBucket),
+		Key:        aws.String("dst/file copy.txt"),
+		CopySource: aws.String(testBucket + "/src/file.txt"),
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "copy me", getTestObject(t, client, "dst/file copy.txt"))
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("dst/file copy.txt"),
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "text/plain", aws.ToString(head.ContentType))
+
+	_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(testBucket),
+		Key:        aws.String("dst/other.txt"),
+		CopySource: aws.String(testBucket + "/src/nope.txt"),
+	})
+	assertErrorCode(t, "NoSuchKey", err)
+}
+
+func TestListObjects(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"a/1", "a/2", "a/3", "b/1", "b/sub/1", "c"} {
+		putTestObject(t, client, key, key)
+	}
+
+	listKeys := func(input s3.ListObjectsV2Input) ([]string, []string, *s3.ListObjectsV2Output) {
+		input.Bucket = aws.String(testBucket)
+		res, err := client.ListObjectsV2(ctx, &input)
+		require.Nil(t, err)
+		var keys, prefixes []string
+		for _, obj := range res.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+		for _, prefix := range res.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(prefix.Prefix))
+		}
+		return keys, prefixes, res
+	}
+
+	keys, _, res := listKeys(s3.ListObjectsV2Input{})
+	assert.Equal(t, []string{"a/1", "a/2", "a/3", "b/1", "b/sub/1", "c"}, keys)
+	assert.False(t, aws.ToBool(res.IsTruncated))
+	assert.Equal(t, int64(3), aws.ToInt64(res.Contents[0].Size))
+
+	keys, _, _ = listKeys(s3.ListObjectsV2Input{Prefix: aws.String("b/")})
+	assert.Equal(t, []string{"b/1", "b/sub/1"}, keys)
+
+	keys, prefixes, _ := listKeys(s3.ListObjectsV2Input{Delimiter: aws.String("/")})
+	assert.Equal(t, []string{"c"}, keys)
+	assert.Equal(t, []string{"a/", "b/"}, prefixes)
+
+	keys, prefixes, _ = listKeys(s3.ListObjectsV2Input{Prefix: aws.String("b/"), Delimiter: aws.String("/")})
+	assert.Equal(t, []string{"b/1"}, keys)
+	assert.Equal(t, []string{"b/sub/"}, prefixes)
+
+	t.Run("pagination", func(t *testing.T) {
+		paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(testBucket),
+			MaxKeys: aws.Int32(4),
+		})
+		var pages [][]string
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			require.Nil(t, err)
+			var keys []string
+			for _, obj := range page.Contents {
+				keys = append(keys, aws.ToString(obj.Key))
+			}
+			pages = append(pages, keys)
+		}
+		assert.Equal(t, [][]string{{"a/1", "a/2", "a/3", "b/1"}, {"b/sub/1", "c"}}, pages)
+	})
+}
+
+func TestMultipartUpload(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	upload, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(testBucket),
+		Key:         aws.String("big/file.bin"),
+		ContentType: aws.String("application/octet-stream"),
+		ACL:         types.ObjectCannedACLPublicRead,
+	})
+	require.Nil(t, err)
+
+	parts := [][]byte{
+		bytes.Repeat([]byte("a"), 5*1024*1024),
+		bytes.Repeat([]byte("b"), 1234),
+	}
+	var completed []types.CompletedPart
+	for i, part := range parts {
+		res, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(testBucket),
+			Key:        aws.String("big/file.bin"),
+			UploadId:   upload.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+			Body:       bytes.NewReader(part),
+		})
+		require.Nil(t, err)
+		completed = append(completed, types.CompletedPart{
+			ETag:       res.ETag,
+			PartNumber: aws.Int32(int32(i + 1)),
+		})
+	}
+
+	t.Run("wrong etag", func(t *testing.T) {
+		_, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:   aws.String(testBucket),
+			Key:      aws.String("big/file.bin"),
+			UploadId: upload.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{
+				Parts: []types.CompletedPart{{ETag: aws.String(`"nope"`), PartNumber: aws.Int32(1)}},
+			},
+		})
+		assertErrorCode(t, "InvalidPart", err)
+	})
+
+	res, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(testBucket),
+		Key:             aws.String("big/file.bin"),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	require.Nil(t, err)
+	assert.True(t, strings.HasSuffix(aws.ToString(res.ETag), `-2"`))
+
+	content := getTestObject(t, client, "big/file.bin")
+	assert.Equal(t, string(bytes.Join(parts, nil)), content)
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("big/file.bin"),
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "application/octet-stream", aws.ToString(head.ContentType))
+
+	// The upload is gone once it's complete.
+	_, err = client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(testBucket),
+		Key:        aws.String("big/file.bin"),
+		UploadId:   upload.UploadId,
+		PartNumber: aws.Int32(3),
+		Body:       strings.NewReader("more"),
+	})
+	assertErrorCode(t, "NoSuchUpload", err)
+}
+
+func TestAbortMultipartUpload(t *testing.T) {
+	client := startTestServer(t)
+	ctx := context.Background()
+
+	upload, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("aborted.bin"),
+	})
+	require.Nil(t, err)
+
+	_, err = client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(testBucket),
+		Key:        aws.String("aborted.bin"),
+		UploadId:   upload.UploadId,
+		PartNumber: aws.Int32(1),
+		Body:       strings.NewReader("partial"),
+	})
+	require.Nil(t, err)
+
+	_, err = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(testBucket),
+		Key:      aws.String("aborted.bin"),
+		UploadId: upload.UploadId,
+	})
+	require.Nil(t, err)
+
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(testBucket),
+		Key:      aws.String("aborted.bin"),
+		UploadId: upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{
+			Parts: []types.CompletedPart{{ETag: aws.String(`"x"`), PartNumber: aws.Int32(1)}},
+		},
+	})
+	assertErrorCode(t, "NoSuchUpload", err)
+
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("aborted.bin"),
+	})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,231 @@
+package hmns3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Multipart uploads are kept in tmp/s3/.uploads/<upload id>, with one file per
+// part, until they are completed or aborted.
+
+type multipartUpload struct {
+	Bucket      string
+	Key         string
+	ContentType string
+}
+
+func (s *server) uploadDir(uploadID string) string {
+	return filepath.Join(s.dir, ".uploads", uploadID)
+}
+
+func (s *server) partPath(uploadID string, partNumber int) string {
+	return filepath.Join(s.uploadDir(uploadID), fmt.Sprintf("part%05d", partNumber))
+}
+
+func (s *server) readUpload(uploadID string) (*multipartUpload, error) {
+	if uuid.Validate(uploadID) != nil {
+		return nil, os.ErrNotExist
+	}
+	infoJSON, err := os.ReadFile(filepath.Join(s.uploadDir(uploadID), "upload.json"))
+	if err != nil {
+		return nil, err
+	}
+	var upload multipartUpload
+	err = json.Unmarshal(infoJSON, &upload)
+	if err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (s *server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	uploadID := uuid.New().String()
+	upload := multipartUpload{
+		Bucket:      bucket,
+		Key:         key,
+		ContentType: r.Header.Get("Content-Type"),
+	}
+
+	err := os.MkdirAll(filepath.Join(s.dir, bucket), fs.ModePerm)
+	if err == nil {
+		err = os.MkdirAll(s.uploadDir(uploadID), fs.ModePerm)
+	}
+	if err == nil {
+		infoJSON, _ := json.Marshal(upload)
+		err = os.WriteFile(filepath.Join(s.uploadDir(uploadID), "upload.json"), infoJSON, 0o644)
+	}
+	if err != nil {
+		s.log.Err(err).Msg("failed to start multipart upload")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	s.writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{
+		Xmlns:    s3Namespace,
+		Bucket:   bucket,
+		Key:      key,
+		UploadId: uploadID,
+	})
+}
+
+func (s *server) uploadPart(w http.ResponseWriter, r *http.Request, uploadID, partNumberStr string) {
+	partNumber, err := strconv.Atoi(partNumberStr)
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		s.error(w, r, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000")
+		return
+	}
+	if _, err := s.readUpload(uploadID); err != nil {
+		s.error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist")
+		return
+	}
+
+	tmp, err := s.tempFile()
+	if err != nil {
+		s.log.Err(err).Msg("failed to create temp file for part")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), r.Body)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.partPath(uploadID, partNumber))
+	}
+	if err != nil {
+		s.log.Err(err).Msg("failed to write part")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, hash.Sum(nil)))
+}
+
+func (s *server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, uploadID string) {
+	upload, err := s.readUpload(uploadID)
+	if err != nil || upload.Bucket != bucket || upload.Key != key {
+		s.error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist")
+		return
+	}
+
+	var req struct {
+		Part []struct {
+			PartNumber int
+			ETag       string
+		}
+	}
+	err = xml.NewDecoder(r.Body).Decode(&req)
+	if err != nil || len(req.Part) == 0 {
+		s.error(w, r, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed")
+		return
+	}
+
+	tmp, err := s.tempFile()
+	if err != nil {
+		s.log.Err(err).Msg("failed to create temp file for upload")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// Like S3, the ETag of a multipart object is the MD5 of its parts' MD5s.
+	etagHash := md5.New()
+	lastPart := 0
+	for _, part := range req.Part {
+		if part.PartNumber <= lastPart {
+			s.error(w, r, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order")
+			return
+		}
+		lastPart = part.PartNumber
+
+		partMD5, err := s.copyPart(tmp, uploadID, part.PartNumber)
+		if errors.Is(err, os.ErrNotExist) || (err == nil && strings.Trim(part.ETag, `"`) != hex.EncodeToString(partMD5)) {
+			s.error(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d could not be found or its ETag did not match", part.PartNumber))
+			return
+		} else if err != nil {
+			s.log.Err(err).Msg("failed to assemble multipart upload")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		etagHash.Write(partMD5)
+	}
+	tmp.Close()
+
+	meta := objectMeta{
+		ContentType: upload.ContentType,
+		ETag:        fmt.Sprintf(`"%x-%d"`, etagHash.Sum(nil), len(req.Part)),
+	}
+	err = s.commitObject(bucket, key, tmp.Name(), meta)
+	if err != nil {
+		s.log.Err(err).Msg("failed to save multipart upload")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	os.RemoveAll(s.uploadDir(uploadID))
+
+	s.writeXML(w, struct {
+		XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+		Xmlns    string   `xml:"xmlns,attr"`
+		Location string
+		Bucket   string
+		Key      string
+		ETag     string
+	}{
+		Xmlns:    s3Namespace,
+		Location: fmt.Sprintf("/%s/%s", bucket, key),
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     meta.ETag,
+	})
+}
+
+// Appends a part to dst and returns the part's MD5.
+func (s *server) copyPart(dst io.Writer, uploadID string, partNumber int) ([]byte, error) {
+	part, err := os.Open(s.partPath(uploadID, partNumber))
+	if err != nil {
+		return nil, err
+	}
+	defer part.Close()
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), part)
+	if err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+func (s *server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, uploadID string) {
+	if _, err := s.readUpload(uploadID); err != nil {
+		s.error(w, r, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist")
+		return
+	}
+	err := os.RemoveAll(s.uploadDir(uploadID))
+	if err != nil {
+		s.log.Err(err).Msg("failed to abort multipart upload")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -37,7 +37,11 @@ var isTest bool
 func init() {
 	SetGlobalBaseUrl(config.Config.BaseUrl)
 	SetCacheBustVersion(fmt.Sprint(time.Now().Unix()))
-	SetS3BaseUrl(config.Config.DigitalOcean.AssetsPublicUrlRoot)
+	if config.Config.AssetStorage.Backend == config.AssetStorageLocal {
+		SetS3BaseUrl(BuildLocalAsset(""))
+	} else {
+		SetS3BaseUrl(config.Config.DigitalOcean.AssetsPublicUrlRoot)
+	}
 }
 
 func SetGlobalBaseUrl(fullBaseUrl string) {
@@ -105,6 +105,19 @@ func TestAdmin(t *testing.T) {
 	AssertRegexMatch(t, BuildAdminApprovalQueue(), RegexAdminApprovalQueue, nil)
 	AssertRegexMatch(t, BuildAdminSetUserOptions(), RegexAdminSetUserOptions, nil)
 	AssertRegexMatch(t, BuildAdminNukeUser(), RegexAdminNukeUser, nil)
+	AssertRegexMatch(t, BuildAdminDiscordChannels(), RegexAdminDiscordChannels, nil)
+	AssertRegexMatch(t, BuildAdminDiscordChannel("1234"), RegexAdminDiscordChannel, map[string]string{"channelid": "1234"})
+	AssertRegexMatch(t, BuildAdminDiscordChannelRuleNew("1234"), RegexAdminDiscordChannelRuleNew, map[string]string{"channelid": "1234"})
+	AssertRegexMatch(t, BuildAdminDiscordChannelRule("1234", 5), RegexAdminDiscordChannelRule, map[string]string{"channelid": "1234", "ruleid": "5"})
+	AssertRegexMatch(t, BuildAdminDiscordChannelRuleTest("1234", 5), RegexAdminDiscordChannelRuleTest, map[string]string{"channelid": "1234", "ruleid": "5"})
+	AssertRegexMatch(t, BuildAdminDiscordRoles(), RegexAdminDiscordRoles, nil)
+	AssertRegexMatch(t, BuildAdminTwitch(), RegexAdminTwitch, nil)
+	AssertRegexMatch(t, BuildAdminTwitchRuleNew(), RegexAdminTwitchRuleNew, nil)
+	AssertRegexMatch(t, BuildAdminTwitchRule(5), RegexAdminTwitchRule, map[string]string{"ruleid": "5"})
+	AssertRegexMatch(t, BuildAdminTwitchStreamers(), RegexAdminTwitchStreamers, nil)
+	AssertRegexMatch(t, BuildAdminFishbowls(), RegexAdminFishbowls, nil)
+	AssertRegexMatch(t, BuildAdminFishbowl("testing"), RegexAdminFishbowl, map[string]string{"slug": "testing"})
+	AssertRegexMatch(t, BuildAdminFishbowlImport("testing"), RegexAdminFishbowlImport, map[string]string{"slug": "testing"})
 }
 
 func TestSnippet(t *testing.T) {
@@ -115,6 +128,22 @@ func TestSnippetSubmit(t *testing.T) {
 	AssertRegexMatch(t, BuildSnippetSubmit(), RegexSnippetSubmit, nil)
 }
 
+func TestSnippetComments(t *testing.T) {
+	AssertRegexMatch(t, BuildSnippetComments(15), RegexSnippet, map[string]string{"snippetid": "15"})
+}
+
+func TestSnippetReact(t *testing.T) {
+	AssertRegexMatch(t, BuildSnippetReact(15), RegexSnippetReact, map[string]string{"snippetid": "15"})
+}
+
+func TestSnippetComment(t *testing.T) {
+	AssertRegexMatch(t, BuildSnippetComment(15), RegexSnippetComment, map[string]string{"snippetid": "15"})
+}
+
+func TestSnippetCommentDelete(t *testing.T) {
+	AssertRegexMatch(t, BuildSnippetCommentDelete(15, 3), RegexSnippetCommentDelete, map[string]string{"snippetid": "15", "commentid": "3"})
+}
+
 func TestFeed(t *testing.T) {
 	AssertRegexMatch(t, BuildFeed(), RegexFeed, nil)
 	assert.Equal(t, BuildFeed(), BuildFeedWithPage(1))
@@ -204,6 +233,24 @@ func TestEducationRerender(t *testing.T) {
 	AssertRegexMatch(t, BuildEducationRerender(), RegexEducationRerender, nil)
 }
 
+func TestTopicIndex(t *testing.T) {
+	AssertRegexMatch(t, BuildTopicIndex(), RegexTopicIndex, nil)
+	AssertRegexNoMatch(t, BuildTopic("audio"), RegexTopicIndex)
+}
+
+func TestTopicNew(t *testing.T) {
+	AssertRegexMatch(t, BuildTopicNew(), RegexTopicNew, nil)
+}
+
+func TestTopic(t *testing.T) {
+	AssertRegexMatch(t, BuildTopic("audio"), RegexTopic, map[string]string{"slug": "audio"})
+	AssertRegexNoMatch(t, BuildTopicEdit("audio"), RegexTopic)
+}
+
+func TestTopicEdit(t *testing.T) {
+	AssertRegexMatch(t, BuildTopicEdit("audio"), RegexTopicEdit, map[string]string{"slug": "audio"})
+}
+
 func TestForum(t *testing.T) {
 	AssertRegexMatch(t, hmn.BuildForum(nil, 1), RegexForum, nil)
 	AssertRegexMatch(t, hmn.BuildForum([]string{"wip"}, 2), RegexForum, map[string]string{"subforums": "wip", "page": "2"})
@@ -326,6 +373,12 @@ func TestEpisodeGuide(t *testing.T) {
 func TestAssetUpload(t *testing.T) {
 	AssertRegexMatch(t, hero.BuildAssetUpload(), RegexAssetUpload, nil)
 	AssertSubdomain(t, hero.BuildAssetUpload(), "hero")
+	AssertRegexMatch(t, hero.BuildPrivateAssetUpload(), RegexAssetUpload, nil)
+}
+
+func TestAssetUploadChunk(t *testing.T) {
+	AssertRegexMatch(t, hero.BuildAssetUploadChunk("b122c7be-dc6d-41fe-a5ed-033fe991927e"), RegexAssetUploadChunk, map[string]string{"uploadid": "b122c7be-dc6d-41fe-a5ed-033fe991927e"})
+	AssertSubdomain(t, hero.BuildAssetUploadChunk("b122c7be-dc6d-41fe-a5ed-033fe991927e"), "hero")
 }
 
 func TestMarkdownWorkerJS(t *testing.T) {
@@ -364,6 +417,16 @@ func TestS3Asset(t *testing.T) {
 	AssertRegexMatchFull(t, BuildS3Asset("hello"), RegexS3Asset, map[string]string{"key": "hello"})
 }
 
+func TestAssetFile(t *testing.T) {
+	AssertRegexMatch(t, BuildAssetFile("b122c7be-dc6d-41fe-a5ed-033fe991927e", ""), RegexAssetFile, map[string]string{"assetid": "b122c7be-dc6d-41fe-a5ed-033fe991927e"})
+	AssertRegexMatch(t, BuildAssetFile("b122c7be-dc6d-41fe-a5ed-033fe991927e", "b122c7be-dc6d-41fe-a5ed-033fe991927e/thumb.jpg"), RegexAssetFile, map[string]string{"assetid": "b122c7be-dc6d-41fe-a5ed-033fe991927e"})
+	AssertSubdomain(t, BuildAssetFile("b122c7be-dc6d-41fe-a5ed-033fe991927e", ""), "")
+}
+
+func TestLocalAsset(t *testing.T) {
+	AssertRegexMatch(t, BuildLocalAsset("b122c7be-dc6d-41fe-a5ed-033fe991927e/hello.png"), RegexLocalAsset, map[string]string{"key": "b122c7be-dc6d-41fe-a5ed-033fe991927e/hello.png"})
+}
+
 func TestJamsIndex(t *testing.T) {
 	AssertRegexMatch(t, BuildJamsIndex(), RegexJamsIndex, nil)
 }
@@ -467,6 +467,97 @@ func BuildAdminNukeUser() string {
 	return Url("/admin/nukeuser", nil)
 }
 
+var RegexAdminDiscordChannels = regexp.MustCompile(`^/admin/discord/channels$`)
+
+func BuildAdminDiscordChannels() string {
+	defer CatchPanic()
+	return Url("/admin/discord/channels", nil)
+}
+
+var RegexAdminDiscordChannel = regexp.MustCompile(`^/admin/discord/channels/(?P<channelid>[0-9]+)$`)
+
+func BuildAdminDiscordChannel(channelID string) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/admin/discord/channels/%s", channelID), nil)
+}
+
+var RegexAdminDiscordChannelRuleNew = regexp.MustCompile(`^/admin/discord/channels/(?P<channelid>[0-9]+)/rules/new$`)
+
+func BuildAdminDiscordChannelRuleNew(channelID string) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/admin/discord/channels/%s/rules/new", channelID), nil)
+}
+
+var RegexAdminDiscordChannelRule = regexp.MustCompile(`^/admin/discord/channels/(?P<channelid>[0-9]+)/rules/(?P<ruleid>[0-9]+)$`)
+
+func BuildAdminDiscordChannelRule(channelID string, ruleID int) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/admin/discord/channels/%s/rules/%d", channelID, ruleID), nil)
+}
+
+var RegexAdminDiscordChannelRuleTest = regexp.MustCompile(`^/admin/discord/channels/(?P<channelid>[0-9]+)/rules/(?P<ruleid>[0-9]+)/test$`)
+
+func BuildAdminDiscordChannelRuleTest(channelID string, ruleID int) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/admin/discord/channels/%s/rules/%d/test", channelID, ruleID), nil)
+}
+
+var RegexAdminDiscordRoles = regexp.MustCompile(`^/admin/discord/roles$`)
+
+func BuildAdminDiscordRoles() string {
+	defer CatchPanic()
+	return Url("/admin/discord/roles", nil)
+}
+
+var RegexAdminTwitch = regexp.MustCompile(`^/admin/twitch$`)
+
+func BuildAdminTwitch() string {
+	defer CatchPanic()
+	return Url("/admin/twitch", nil)
+}
+
+var RegexAdminTwitchRuleNew = regexp.MustCompile(`^/admin/twitch/rules/new$`)
+
+func BuildAdminTwitchRuleNew() string {
+	defer CatchPanic()
+	return Url("/admin/twitch/rules/new", nil)
+}
+
+var RegexAdminTwitchRule = regexp.MustCompile(`^/admin/twitch/rules/(?P<ruleid>[0-9]+)$`)
+
+func BuildAdminTwitchRule(ruleID int) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/admin/twitch/rules/%d", ruleID), nil)
+}
+
+var RegexAdminTwitchStreamers = regexp.MustCompile(`^/admin/twitch/streamers$`)
+
+func BuildAdminTwitchStreamers() string {
+	defer CatchPanic()
+	return Url("/admin/twitch/streamers", nil)
+}
+
+var RegexAdminFishbowls = regexp.MustCompile(`^/admin/fishbowls$`)
+
+func BuildAdminFishbowls() string {
+	defer CatchPanic()
+	return Url("/admin/fishbowls", nil)
+}
+
+var RegexAdminFishbowl = regexp.MustCompile(`^/admin/fishbowls/(?P<slug>[^/]+)$`)
+
+func BuildAdminFishbowl(slug string) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/admin/fishbowls/%s", slug), nil)
+}
+
+var RegexAdminFishbowlImport = regexp.MustCompile(`^/admin/fishbowls/(?P<slug>[^/]+)/import$`)
+
+func BuildAdminFishbowlImport(slug string) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/admin/fishbowls/%s/import", slug), nil)
+}
+
 /*
 * Snippets
  */
@@ -485,6 +576,32 @@ func BuildSnippetSubmit() string {
 	return Url("/snippet", nil)
 }
 
+func BuildSnippetComments(snippetId int) string {
+	defer CatchPanic()
+	return UrlWithFragment("/snippet/"+strconv.Itoa(snippetId), nil, "comments")
+}
+
+var RegexSnippetReact = regexp.MustCompile(`^/snippet/(?P<snippetid>\d+)/react$`)
+
+func BuildSnippetReact(snippetId int) string {
+	defer CatchPanic()
+	return Url("/snippet/"+strconv.Itoa(snippetId)+"/react", nil)
+}
+
+var RegexSnippetComment = regexp.MustCompile(`^/snippet/(?P<snippetid>\d+)/comment$`)
+
+func BuildSnippetComment(snippetId int) string {
+	defer CatchPanic()
+	return Url("/snippet/"+strconv.Itoa(snippetId)+"/comment", nil)
+}
+
+var RegexSnippetCommentDelete = regexp.MustCompile(`^/snippet/(?P<snippetid>\d+)/comment/(?P<commentid>\d+)/delete$`)
+
+func BuildSnippetCommentDelete(snippetId int, commentId int) string {
+	defer CatchPanic()
+	return Url("/snippet/"+strconv.Itoa(snippetId)+"/comment/"+strconv.Itoa(commentId)+"/delete", nil)
+}
+
 /*
 * Feed
  */
@@ -688,6 +805,38 @@ func BuildEducationRerender() string {
 	return Url("/education/rerender", nil)
 }
 
+/*
+ * Topics
+ */
+
+var RegexTopicIndex = regexp.MustCompile(`^/topics$`)
+
+func BuildTopicIndex() string {
+	defer CatchPanic()
+	return Url("/topics", nil)
+}
+
+var RegexTopicNew = regexp.MustCompile(`^/topics/new$`)
+
+func BuildTopicNew() string {
+	defer CatchPanic()
+	return Url("/topics/new", nil)
+}
+
+var RegexTopic = regexp.MustCompile(`^/topics/(?P<slug>[^/]+)$`)
+
+func BuildTopic(slug string) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/topics/%s", slug), nil)
+}
+
+var RegexTopicEdit = regexp.MustCompile(`^/topics/(?P<slug>[^/]+)/edit$`)
+
+func BuildTopicEdit(slug string) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/topics/%s/edit", slug), nil)
+}
+
 /*
  * Style test
  */
@@ -1111,6 +1260,17 @@ func (c *UrlContext) BuildAssetUpload() string {
 	return c.Url("/upload_asset", nil)
 }
 
+// Uploads through this URL become private assets. See BuildAssetFile.
+func (c *UrlContext) BuildPrivateAssetUpload() string {
+	return c.Url("/upload_asset", []Q{{Name: "private", Value: "true"}})
+}
+
+var RegexAssetUploadChunk = regexp.MustCompile("^/upload_asset/(?P<uploadid>[0-9a-f-]+)$")
+
+func (c *UrlContext) BuildAssetUploadChunk(uploadID string) string {
+	return c.Url(fmt.Sprintf("/upload_asset/%s", uploadID), nil)
+}
+
 /*
 * Assets
  */
@@ -1124,12 +1284,48 @@ func BuildMarkdownWorkerJS() string {
 
 var RegexS3Asset *regexp.Regexp
 
+// Builds the public URL for an asset's storage key. When assets are stored
+// locally instead of in S3, this points at LocalAsset.
 func BuildS3Asset(s3key string) string {
 	defer CatchPanic()
 	res := fmt.Sprintf("%s%s", S3BaseUrl, s3key)
 	return res
 }
 
+// Builds the URL for one of an asset's stored files, given its key. Public
+// assets are fetched straight from storage, and private ones through
+// BuildAssetFile.
+func BuildAssetObject(asset *models.Asset, key string) string {
+	if asset.Private {
+		if key == asset.S3Key {
+			key = ""
+		}
+		return BuildAssetFile(asset.ID.String(), key)
+	}
+	return BuildS3Asset(key)
+}
+
+var RegexAssetFile = regexp.MustCompile("^/asset/(?P<assetid>[0-9a-f-]+)$")
+
+// Builds a permission-checked URL for one of a private asset's files, which
+// redirects to a short-lived presigned URL. An empty key means the asset's
+// main file.
+func BuildAssetFile(assetID string, key string) string {
+	defer CatchPanic()
+	var query []Q
+	if key != "" {
+		query = []Q{{Name: "file", Value: key}}
+	}
+	return Url(fmt.Sprintf("/asset/%s", assetID), query)
+}
+
+var RegexLocalAsset = regexp.MustCompile("^/storage/(?P<key>.+)$")
+
+func BuildLocalAsset(key string) string {
+	defer CatchPanic()
+	return Url(fmt.Sprintf("/storage/%s", key), nil)
+}
+
 var RegexEsBuild = regexp.MustCompile("^/esbuild$")
 
 func BuildEsBuild() string {
@@ -1,6 +1,7 @@
 package migrations
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -114,7 +115,7 @@ func (m ConvertImageFilesToAssets) Up(ctx context.Context, tx pgx.Tx) error {
 			}
 
 			asset, err := assets.Create(ctx, tx, assets.CreateInput{
-				Content:  contents,
+				Content:  bytes.NewReader(contents),
 				Filename: filepath.Base(file.File),
 
 				Width:  file.Width,
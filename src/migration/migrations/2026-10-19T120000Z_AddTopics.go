@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddTopics{})
+}
+
+type AddTopics struct{}
+
+func (m AddTopics) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC))
+}
+
+func (m AddTopics) Name() string {
+	return "AddTopics"
+}
+
+func (m AddTopics) Description() string {
+	return "Add curated topics for projects, snippets, and education articles"
+}
+
+func (m AddTopics) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		CREATE TABLE topic (
+			id SERIAL NOT NULL PRIMARY KEY,
+			slug VARCHAR(40) NOT NULL UNIQUE,
+			name VARCHAR(255) NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			description_html TEXT NOT NULL DEFAULT '',
+			aliases VARCHAR(40)[] NOT NULL DEFAULT '{}',
+			parent_id INT REFERENCES topic (id) ON DELETE SET NULL,
+			CONSTRAINT topic_slug_syntax CHECK (slug ~ '^[a-z0-9]+(-[a-z0-9]+)*$'),
+			CONSTRAINT topic_not_own_parent CHECK (parent_id IS NULL OR parent_id != id)
+		);
+
+		CREATE TABLE project_topic (
+			project_id INT NOT NULL REFERENCES project (id) ON DELETE CASCADE,
+			topic_id INT NOT NULL REFERENCES topic (id) ON DELETE CASCADE,
+			PRIMARY KEY (project_id, topic_id)
+		);
+
+		CREATE TABLE snippet_topic (
+			snippet_id INT NOT NULL REFERENCES snippet (id) ON DELETE CASCADE,
+			topic_id INT NOT NULL REFERENCES topic (id) ON DELETE CASCADE,
+			PRIMARY KEY (snippet_id, topic_id)
+		);
+
+		CREATE TABLE education_article_topic (
+			article_id INT NOT NULL REFERENCES education_article (id) ON DELETE CASCADE,
+			topic_id INT NOT NULL REFERENCES topic (id) ON DELETE CASCADE,
+			PRIMARY KEY (article_id, topic_id)
+		);
+
+		CREATE INDEX project_topic_topic_id ON project_topic (topic_id);
+		CREATE INDEX snippet_topic_topic_id ON snippet_topic (topic_id);
+		CREATE INDEX education_article_topic_topic_id ON education_article_topic (topic_id);
+		`,
+	)
+	return err
+}
+
+func (m AddTopics) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP TABLE education_article_topic;
+		DROP TABLE snippet_topic;
+		DROP TABLE project_topic;
+		DROP TABLE topic;
+		`,
+	)
+	return err
+}
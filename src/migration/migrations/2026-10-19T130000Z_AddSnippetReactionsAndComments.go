@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddSnippetReactionsAndComments{})
+}
+
+type AddSnippetReactionsAndComments struct{}
+
+func (m AddSnippetReactionsAndComments) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC))
+}
+
+func (m AddSnippetReactionsAndComments) Name() string {
+	return "AddSnippetReactionsAndComments"
+}
+
+func (m AddSnippetReactionsAndComments) Description() string {
+	return "Add emoji reactions and comments on snippets"
+}
+
+func (m AddSnippetReactionsAndComments) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		CREATE TABLE snippet_reaction (
+			id SERIAL NOT NULL PRIMARY KEY,
+			snippet_id INT NOT NULL REFERENCES snippet (id) ON DELETE CASCADE,
+			emoji VARCHAR(128) NOT NULL,
+			user_id INT REFERENCES hmn_user (id) ON DELETE CASCADE,
+			discord_user_id VARCHAR(64),
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			CONSTRAINT snippet_reaction_has_user CHECK ((user_id IS NULL) != (discord_user_id IS NULL))
+		);
+		CREATE UNIQUE INDEX snippet_reaction_website_unique ON snippet_reaction (snippet_id, user_id, emoji) WHERE user_id IS NOT NULL;
+		CREATE UNIQUE INDEX snippet_reaction_discord_unique ON snippet_reaction (snippet_id, discord_user_id, emoji) WHERE discord_user_id IS NOT NULL;
+
+		CREATE TABLE snippet_comment (
+			id SERIAL NOT NULL PRIMARY KEY,
+			snippet_id INT NOT NULL REFERENCES snippet (id) ON DELETE CASCADE,
+			author_id INT NOT NULL REFERENCES hmn_user (id) ON DELETE CASCADE,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			body TEXT NOT NULL,
+			body_html TEXT NOT NULL
+		);
+		CREATE INDEX snippet_comment_snippet_id ON snippet_comment (snippet_id, created_at);
+		`,
+	)
+	return err
+}
+
+func (m AddSnippetReactionsAndComments) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP TABLE snippet_comment;
+		DROP TABLE snippet_reaction;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddSnippetAssets{})
+}
+
+type AddSnippetAssets struct{}
+
+func (m AddSnippetAssets) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 14, 0, 0, 0, time.UTC))
+}
+
+func (m AddSnippetAssets) Name() string {
+	return "AddSnippetAssets"
+}
+
+func (m AddSnippetAssets) Description() string {
+	return "Allow snippets to have multiple attachments"
+}
+
+func (m AddSnippetAssets) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		CREATE TABLE snippet_asset (
+			snippet_id INT NOT NULL REFERENCES snippet (id) ON DELETE CASCADE,
+			asset_id UUID NOT NULL REFERENCES asset (id) ON DELETE CASCADE,
+			ordering INT NOT NULL,
+			PRIMARY KEY (snippet_id, asset_id)
+		);
+		CREATE INDEX snippet_asset_asset_id ON snippet_asset (asset_id);
+
+		-- Every snippet's existing asset becomes its first attachment.
+		INSERT INTO snippet_asset (snippet_id, asset_id, ordering)
+		SELECT id, asset_id, 0
+		FROM snippet
+		WHERE asset_id IS NOT NULL;
+
+		-- Snippets from Discord messages with several attachments previously
+		-- only showed the first one, so bring in the rest.
+		INSERT INTO snippet_asset (snippet_id, asset_id, ordering)
+		SELECT
+			snippet.id,
+			att.asset_id,
+			row_number() OVER (PARTITION BY snippet.id ORDER BY length(att.id), att.id)
+		FROM
+			snippet
+			JOIN discord_message_attachment AS att ON att.message_id = snippet.discord_message_id
+		WHERE NOT snippet.edited_on_website
+		ON CONFLICT DO NOTHING;
+		`,
+	)
+	return err
+}
+
+func (m AddSnippetAssets) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP TABLE snippet_asset;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddAssetGarbageCollection{})
+}
+
+type AddAssetGarbageCollection struct{}
+
+func (m AddAssetGarbageCollection) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC))
+}
+
+func (m AddAssetGarbageCollection) Name() string {
+	return "AddAssetGarbageCollection"
+}
+
+func (m AddAssetGarbageCollection) Description() string {
+	return "Track orphaned assets and index assets by content hash"
+}
+
+func (m AddAssetGarbageCollection) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			ADD COLUMN orphaned_at TIMESTAMP WITH TIME ZONE;
+
+		CREATE INDEX asset_sha1sum ON asset (sha1sum);
+		`,
+	)
+	return err
+}
+
+func (m AddAssetGarbageCollection) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP INDEX asset_sha1sum;
+
+		ALTER TABLE asset
+			DROP COLUMN orphaned_at;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddAssetVariants{})
+}
+
+type AddAssetVariants struct{}
+
+func (m AddAssetVariants) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 16, 0, 0, 0, time.UTC))
+}
+
+func (m AddAssetVariants) Name() string {
+	return "AddAssetVariants"
+}
+
+func (m AddAssetVariants) Description() string {
+	return "Track scaled-down variants of image assets"
+}
+
+func (m AddAssetVariants) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			ADD COLUMN variants_generated BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN variant_widths INT[] NOT NULL DEFAULT '{}',
+			ADD COLUMN variant_formats VARCHAR(16)[] NOT NULL DEFAULT '{}';
+		`,
+	)
+	return err
+}
+
+func (m AddAssetVariants) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			DROP COLUMN variants_generated,
+			DROP COLUMN variant_widths,
+			DROP COLUMN variant_formats;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddAssetSanitized{})
+}
+
+type AddAssetSanitized struct{}
+
+func (m AddAssetSanitized) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 17, 0, 0, 0, time.UTC))
+}
+
+func (m AddAssetSanitized) Name() string {
+	return "AddAssetSanitized"
+}
+
+func (m AddAssetSanitized) Description() string {
+	return "Track whether metadata has been stripped from image assets"
+}
+
+func (m AddAssetSanitized) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			ADD COLUMN sanitized BOOLEAN NOT NULL DEFAULT FALSE;
+		`,
+	)
+	return err
+}
+
+func (m AddAssetSanitized) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			DROP COLUMN sanitized;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,63 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddAssetUploads{})
+}
+
+type AddAssetUploads struct{}
+
+func (m AddAssetUploads) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 18, 0, 0, 0, time.UTC))
+}
+
+func (m AddAssetUploads) Name() string {
+	return "AddAssetUploads"
+}
+
+func (m AddAssetUploads) Description() string {
+	return "Allow assets over 2GB and track resumable uploads"
+}
+
+func (m AddAssetUploads) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			ALTER COLUMN size TYPE BIGINT;
+
+		CREATE TABLE asset_upload (
+			id UUID PRIMARY KEY,
+			uploader_id INT NOT NULL REFERENCES hmn_user (id) ON DELETE CASCADE,
+			filename VARCHAR(1000) NOT NULL,
+			size BIGINT NOT NULL,
+			content_type VARCHAR(100) NOT NULL DEFAULT '',
+			s3_key VARCHAR(2000) NOT NULL,
+			s3_upload_id VARCHAR(1000) NOT NULL DEFAULT '',
+			part_etags VARCHAR(255)[] NOT NULL DEFAULT '{}',
+			received BIGINT NOT NULL DEFAULT 0,
+			sha1_state BYTEA NOT NULL,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		`,
+	)
+	return err
+}
+
+func (m AddAssetUploads) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP TABLE asset_upload;
+
+		ALTER TABLE asset
+			ALTER COLUMN size TYPE INT;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddVideoTranscoding{})
+}
+
+type AddVideoTranscoding struct{}
+
+func (m AddVideoTranscoding) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 19, 0, 0, 0, time.UTC))
+}
+
+func (m AddVideoTranscoding) Name() string {
+	return "AddVideoTranscoding"
+}
+
+func (m AddVideoTranscoding) Description() string {
+	return "Track transcoding of video assets to web-friendly formats"
+}
+
+func (m AddVideoTranscoding) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			ADD COLUMN transcode_status INT NOT NULL DEFAULT 0,
+			ADD COLUMN transcode_attempts INT NOT NULL DEFAULT 0,
+			ADD COLUMN transcoded_s3_key VARCHAR(2000) NOT NULL DEFAULT '',
+			ADD COLUMN transcoded_low_s3_key VARCHAR(2000) NOT NULL DEFAULT '';
+
+		-- Queue up every existing video
+		UPDATE asset
+		SET transcode_status = 1
+		WHERE mime_type LIKE 'video%';
+
+		CREATE INDEX asset_transcode_status ON asset (transcode_status) WHERE transcode_status = 1;
+		`,
+	)
+	return err
+}
+
+func (m AddVideoTranscoding) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP INDEX asset_transcode_status;
+
+		ALTER TABLE asset
+			DROP COLUMN transcode_status,
+			DROP COLUMN transcode_attempts,
+			DROP COLUMN transcoded_s3_key,
+			DROP COLUMN transcoded_low_s3_key;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddAssetAltTextAndCaption{})
+}
+
+type AddAssetAltTextAndCaption struct{}
+
+func (m AddAssetAltTextAndCaption) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 20, 0, 0, 0, time.UTC))
+}
+
+func (m AddAssetAltTextAndCaption) Name() string {
+	return "AddAssetAltTextAndCaption"
+}
+
+func (m AddAssetAltTextAndCaption) Description() string {
+	return "Add alt text and captions to assets"
+}
+
+func (m AddAssetAltTextAndCaption) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			ADD COLUMN alt_text TEXT NOT NULL DEFAULT '',
+			ADD COLUMN caption TEXT NOT NULL DEFAULT '';
+		`,
+	)
+	return err
+}
+
+func (m AddAssetAltTextAndCaption) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			DROP COLUMN alt_text,
+			DROP COLUMN caption;
+		`,
+	)
+	return err
+}
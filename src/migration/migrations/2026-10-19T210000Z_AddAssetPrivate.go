@@ -0,0 +1,51 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddAssetPrivate{})
+}
+
+type AddAssetPrivate struct{}
+
+func (m AddAssetPrivate) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 21, 0, 0, 0, time.UTC))
+}
+
+func (m AddAssetPrivate) Name() string {
+	return "AddAssetPrivate"
+}
+
+func (m AddAssetPrivate) Description() string {
+	return "Add a private flag to assets"
+}
+
+func (m AddAssetPrivate) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			ADD COLUMN private BOOLEAN NOT NULL DEFAULT FALSE;
+		ALTER TABLE asset_upload
+			ADD COLUMN private BOOLEAN NOT NULL DEFAULT FALSE;
+		`,
+	)
+	return err
+}
+
+func (m AddAssetPrivate) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			DROP COLUMN private;
+		ALTER TABLE asset_upload
+			DROP COLUMN private;
+		`,
+	)
+	return err
+}
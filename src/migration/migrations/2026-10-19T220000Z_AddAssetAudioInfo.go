@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddAssetAudioInfo{})
+}
+
+type AddAssetAudioInfo struct{}
+
+func (m AddAssetAudioInfo) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 22, 0, 0, 0, time.UTC))
+}
+
+func (m AddAssetAudioInfo) Name() string {
+	return "AddAssetAudioInfo"
+}
+
+func (m AddAssetAudioInfo) Description() string {
+	return "Add duration, bitrate, and waveform to audio assets"
+}
+
+func (m AddAssetAudioInfo) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			ADD COLUMN audio_analyzed BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN duration DOUBLE PRECISION NOT NULL DEFAULT 0,
+			ADD COLUMN bitrate INT NOT NULL DEFAULT 0,
+			ADD COLUMN waveform BYTEA NOT NULL DEFAULT '';
+		`,
+	)
+	return err
+}
+
+func (m AddAssetAudioInfo) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			DROP COLUMN audio_analyzed,
+			DROP COLUMN duration,
+			DROP COLUMN bitrate,
+			DROP COLUMN waveform;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddPodcastEpisodeAudioAsset{})
+}
+
+type AddPodcastEpisodeAudioAsset struct{}
+
+func (m AddPodcastEpisodeAudioAsset) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC))
+}
+
+func (m AddPodcastEpisodeAudioAsset) Name() string {
+	return "AddPodcastEpisodeAudioAsset"
+}
+
+func (m AddPodcastEpisodeAudioAsset) Description() string {
+	return "Store podcast episode audio as assets"
+}
+
+func (m AddPodcastEpisodeAudioAsset) Up(ctx context.Context, tx pgx.Tx) error {
+	// Existing episodes keep their filename until their audio is uploaded
+	// with `admin podcast migrate-audio`, which needs access to the files.
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE podcast_episode
+			ADD COLUMN audio_asset UUID REFERENCES asset (id) ON DELETE SET NULL;
+		`,
+	)
+	return err
+}
+
+func (m AddPodcastEpisodeAudioAsset) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE podcast_episode
+			DROP COLUMN audio_asset;
+		`,
+	)
+	return err
+}
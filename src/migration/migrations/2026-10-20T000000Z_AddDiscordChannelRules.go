@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddDiscordChannelRules{})
+}
+
+type AddDiscordChannelRules struct{}
+
+func (m AddDiscordChannelRules) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC))
+}
+
+func (m AddDiscordChannelRules) Name() string {
+	return "AddDiscordChannelRules"
+}
+
+func (m AddDiscordChannelRules) Description() string {
+	return "Store Discord channel rules in the database"
+}
+
+func (m AddDiscordChannelRules) Up(ctx context.Context, tx pgx.Tx) error {
+	// The bot fills in the channels from the config the first time it runs,
+	// so there's nothing to migrate here.
+	_, err := tx.Exec(ctx,
+		`
+		CREATE TABLE discord_channel (
+			channel_id VARCHAR(64) PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			store_messages BOOLEAN NOT NULL DEFAULT FALSE,
+			auto_snippet INT NOT NULL DEFAULT 0
+		);
+
+		CREATE TABLE discord_channel_rule (
+			id SERIAL PRIMARY KEY,
+			channel_id VARCHAR(64) NOT NULL REFERENCES discord_channel (channel_id) ON DELETE CASCADE,
+			kind INT NOT NULL,
+			domains TEXT[] NOT NULL DEFAULT '{}',
+			rebuke_message TEXT NOT NULL DEFAULT '',
+			exempt_role_ids VARCHAR(64)[] NOT NULL DEFAULT '{}',
+			applies_after TIMESTAMP WITH TIME ZONE,
+			enabled BOOLEAN NOT NULL DEFAULT FALSE,
+			ordering INT NOT NULL DEFAULT 0
+		);
+		CREATE INDEX discord_channel_rule_channel_id ON discord_channel_rule (channel_id);
+		`,
+	)
+	return err
+}
+
+func (m AddDiscordChannelRules) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP TABLE discord_channel_rule;
+		DROP TABLE discord_channel;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,199 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddFishbowls{})
+}
+
+type AddFishbowls struct{}
+
+func (m AddFishbowls) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 20, 1, 0, 0, 0, time.UTC))
+}
+
+func (m AddFishbowls) Name() string {
+	return "AddFishbowls"
+}
+
+func (m AddFishbowls) Description() string {
+	return "Store fishbowls and their archived messages in the database"
+}
+
+type legacyFishbowl struct {
+	Slug, Title, Description string
+	Month                    time.Month
+	Year                     int
+	ContentsPath             string
+}
+
+// Copied from the old hardcoded list in website/fishbowl.go. Their contents
+// are still the hand-edited HTML files embedded with the templates.
+var legacyFishbowls = []legacyFishbowl{
+	{
+		Slug:        "internet-os",
+		Title:       "The future of operating systems in an Internet world",
+		Description: `Despite the web's technical problems, it dominates software development today, largely due to its cross-platform support and ease of distribution. At the same time, our discussions about the future of programming tend to involve new "operating systems", but those discussions rarely take the Internet into account. What could future operating systems look like in a world defined by the Internet?`,
+		Month:       time.May, Year: 2020,
+		ContentsPath: "internet-os/internet-os.html",
+	},
+	{
+		Slug:        "metaprogramming",
+		Title:       "Compile-time introspection and metaprogramming",
+		Description: `Thanks to new languages like Zig and Jai, compile-time execution and metaprogramming are a popular topic of discussion in the community. This fishbowl explores metaprogramming in more detail, and discusses to what extent it is actually necessary, or just a waste of time.`,
+		Month:       time.June, Year: 2020,
+		ContentsPath: "metaprogramming/metaprogramming.html",
+	},
+	{
+		Slug:        "lisp-jam",
+		Title:       "Lessons from the Lisp Jam",
+		Description: `In the summer of 2020 we held a Lisp jam, where many community members made exploratory Lisp-inspired projects. We held this fishbowl as a recap, as a time for the participants to share what they learned and explore how those lessons relate to our day-to-day programming.`,
+		Month:       time.August, Year: 2020,
+		ContentsPath: "lisp-jam/lisp-jam.html",
+	},
+	{
+		Slug:        "parallel-programming",
+		Title:       "Approaches to parallel programming",
+		Description: `A discussion of many aspects of parallelism and concurrency in programming, and the pros and cons of different programming methodologies.`,
+		Month:       time.November, Year: 2020,
+		ContentsPath: "parallel-programming/parallel-programming.html",
+	},
+	{
+		Slug:        "skimming",
+		Title:       "Code skimmability as the root cause for bad code structure decisions",
+		Description: `Programmers tend to care a lot about "readability". This usually means having small classes, small functions, small files. This code might be "readable" at a glance, but this doesn't really help you understand the program—it's just "skimmable". How can we think about "readability" in a more productive way?`,
+		Month:       time.January, Year: 2021,
+		ContentsPath: "skimming/skimming.html",
+	},
+	{
+		Slug:        "config",
+		Title:       "How to design to avoid configuration",
+		Description: `Configuration sucks. How can we avoid it, while still making software that supports a wide range of behaviors? What is the essence of "configuration", and how can we identify it? How can we identify what is "bad config", and design our software to avoid it?`,
+		Month:       time.March, Year: 2021,
+		ContentsPath: "config/config.html",
+	},
+	{
+		Slug:        "simplicity-performance",
+		Title:       "The relationship of simplicity and performance",
+		Description: "In the community, we talk a lot about performance. We also talk a lot about having simple code—and the two feel somewhat intertwined. What relationship is there between simplicity and performance? Are there better ways to reason about \"simplicity\" with this in mind?",
+		Month:       time.May, Year: 2021,
+		ContentsPath: "simplicity-performance/simplicity-performance.html",
+	},
+	{
+		Slug:        "teaching-software",
+		Title:       "How software development is taught",
+		Description: "The Handmade Network exists because we are unhappy with the software status quo. To a large extent, this is because of how software development is taught. What are the good parts of software education today, what are the flaws, and how might we change things to improve the state of software?",
+		Month:       time.June, Year: 2021,
+		ContentsPath: "teaching-software/teaching-software.html",
+	},
+	{
+		Slug:        "flexible-software",
+		Title:       "How to design flexible software",
+		Description: "We previously held a fishbowl about how to design to avoid configuration. But when you can't avoid configuration, how do you do it well? And if we want our software to be flexible, what other options do we have besides configuration? What other ways are there to make software flexible?",
+		Month:       time.December, Year: 2021,
+		ContentsPath: "flexible-software/flexible-software.html",
+	},
+	{
+		Slug:        "oop",
+		Title:       "What, if anything, is OOP?",
+		Description: "Is object-oriented programming bad? Is it good? What even is it, anyway? This fishbowl explores OOP more carefully—what is the essence of it, what are the good parts, why did it take over the world, and why do we criticize it so much?",
+		Month:       time.May, Year: 2022,
+		ContentsPath: "oop/OOP.html",
+	},
+	{
+		Slug:        "libraries",
+		Title:       "When do libraries go sour?",
+		Description: "The Handmade community is often opposed to using libraries. But let's get more specific about why that can be, and whether that's reasonable. What do we look for in a library? When do libraries go sour? How do we evaluate libraries before using them? How can the libraries we make avoid these problems?",
+		Month:       time.July, Year: 2022,
+		ContentsPath: "libraries/libraries.html",
+	},
+	{
+		Slug:        "entrepreneurship",
+		Title:       "Entrepreneurship and the Handmade ethos",
+		Description: "What does it look like to turn a Handmade project into a sustainable business? How can the Handmade ethos set our software apart from its competitors? And how might we sustain the development of important software, if we're not sure how to sell it?",
+		Month:       time.October, Year: 2022,
+		ContentsPath: "entrepreneurship/entrepreneurship.html",
+	},
+	{
+		Slug:        "testing",
+		Title:       "What even is testing?",
+		Description: "Everybody knows testing is important, but the software industry is overrun by terrible testing practices. Because of this, there has often been a negative sentiment against testing in the Handmade community. This fishbowl explores the kinds of testing the community has found most effective, the costs of testing, and the actual purpose behind testing techniques.",
+		Month:       time.May, Year: 2023,
+		ContentsPath: "testing/testing.html",
+	},
+}
+
+func (m AddFishbowls) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		CREATE TABLE fishbowl (
+			id SERIAL PRIMARY KEY,
+			slug VARCHAR(255) NOT NULL UNIQUE,
+			title VARCHAR(255) NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			month INT NOT NULL,
+			year INT NOT NULL,
+			thread_id VARCHAR(64),
+			contents_path VARCHAR(255) NOT NULL DEFAULT '',
+			published BOOLEAN NOT NULL DEFAULT FALSE
+		);
+
+		CREATE TABLE fishbowl_message (
+			id SERIAL PRIMARY KEY,
+			fishbowl_id INT NOT NULL REFERENCES fishbowl (id) ON DELETE CASCADE,
+			message_id VARCHAR(64) NOT NULL,
+			author_id VARCHAR(64) NOT NULL,
+			author_name VARCHAR(255) NOT NULL,
+			author_avatar_asset_id UUID REFERENCES asset (id) ON DELETE SET NULL,
+			content TEXT NOT NULL,
+			sent_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			ordering INT NOT NULL,
+			hidden BOOLEAN NOT NULL DEFAULT FALSE,
+			UNIQUE (fishbowl_id, message_id)
+		);
+
+		CREATE TABLE fishbowl_message_attachment (
+			message_id INT NOT NULL REFERENCES fishbowl_message (id) ON DELETE CASCADE,
+			asset_id UUID NOT NULL REFERENCES asset (id) ON DELETE CASCADE,
+			ordering INT NOT NULL,
+			PRIMARY KEY (message_id, asset_id)
+		);
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to create fishbowl tables")
+	}
+
+	for _, f := range legacyFishbowls {
+		_, err = tx.Exec(ctx,
+			`
+			INSERT INTO fishbowl (slug, title, description, month, year, contents_path, published)
+			VALUES ($1, $2, $3, $4, $5, $6, TRUE)
+			`,
+			f.Slug, f.Title, f.Description, int(f.Month), f.Year, f.ContentsPath,
+		)
+		if err != nil {
+			return oops.New(err, "failed to add fishbowl %s", f.Slug)
+		}
+	}
+
+	return nil
+}
+
+func (m AddFishbowls) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP TABLE fishbowl_message_attachment;
+		DROP TABLE fishbowl_message;
+		DROP TABLE fishbowl;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddDiscordScheduledEvents{})
+}
+
+type AddDiscordScheduledEvents struct{}
+
+func (m AddDiscordScheduledEvents) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 20, 2, 0, 0, 0, time.UTC))
+}
+
+func (m AddDiscordScheduledEvents) Name() string {
+	return "AddDiscordScheduledEvents"
+}
+
+func (m AddDiscordScheduledEvents) Description() string {
+	return "Track the Discord scheduled events mirrored from the calendars, jams, and expos"
+}
+
+func (m AddDiscordScheduledEvents) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		CREATE TABLE discord_scheduled_event (
+			key VARCHAR(512) PRIMARY KEY,
+			source VARCHAR(255) NOT NULL,
+			event_id VARCHAR(64) NOT NULL UNIQUE,
+			start_time TIMESTAMP WITH TIME ZONE NOT NULL,
+			payload_hash VARCHAR(64) NOT NULL
+		);
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to create discord_scheduled_event table")
+	}
+	return nil
+}
+
+func (m AddDiscordScheduledEvents) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP TABLE discord_scheduled_event;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,86 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddDiscordForumBridge{})
+}
+
+type AddDiscordForumBridge struct{}
+
+func (m AddDiscordForumBridge) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 20, 3, 0, 0, 0, time.UTC))
+}
+
+func (m AddDiscordForumBridge) Name() string {
+	return "AddDiscordForumBridge"
+}
+
+func (m AddDiscordForumBridge) Description() string {
+	return "Track forum threads and posts mirrored to Discord forum channels"
+}
+
+func (m AddDiscordForumBridge) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		CREATE TABLE discord_webhook (
+			channel_id VARCHAR(64) PRIMARY KEY,
+			webhook_id VARCHAR(64) NOT NULL,
+			token VARCHAR(255) NOT NULL
+		);
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to create discord_webhook table")
+	}
+
+	_, err = tx.Exec(ctx,
+		`
+		CREATE TABLE discord_forum_thread (
+			thread_id INT PRIMARY KEY REFERENCES thread (id) ON DELETE CASCADE,
+			channel_id VARCHAR(64) NOT NULL,
+			discord_thread_id VARCHAR(64) NOT NULL UNIQUE,
+			title VARCHAR(255) NOT NULL,
+			discord_deleted BOOLEAN NOT NULL DEFAULT FALSE
+		);
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to create discord_forum_thread table")
+	}
+
+	_, err = tx.Exec(ctx,
+		`
+		CREATE TABLE discord_forum_post (
+			post_id INT PRIMARY KEY REFERENCES post (id) ON DELETE CASCADE,
+			message_id VARCHAR(64) NOT NULL UNIQUE,
+			from_discord BOOLEAN NOT NULL,
+			version_id INT NOT NULL,
+			attachments TEXT NOT NULL DEFAULT ''
+		);
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to create discord_forum_post table")
+	}
+
+	return nil
+}
+
+func (m AddDiscordForumBridge) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DROP TABLE discord_forum_post;
+		DROP TABLE discord_forum_thread;
+		DROP TABLE discord_webhook;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,90 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddTwitchRelevanceRules{})
+}
+
+type AddTwitchRelevanceRules struct{}
+
+func (m AddTwitchRelevanceRules) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 20, 4, 0, 0, 0, time.UTC))
+}
+
+func (m AddTwitchRelevanceRules) Name() string {
+	return "AddTwitchRelevanceRules"
+}
+
+func (m AddTwitchRelevanceRules) Description() string {
+	return "Move livestream relevance rules into the database, with per-streamer overrides on the ignore list"
+}
+
+func (m AddTwitchRelevanceRules) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		CREATE TABLE twitch_relevance_rule (
+			id SERIAL PRIMARY KEY,
+			kind INT NOT NULL,
+			value TEXT NOT NULL,
+			label TEXT NOT NULL DEFAULT '',
+			relevant BOOLEAN NOT NULL,
+			UNIQUE (kind, value)
+		);
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to create twitch_relevance_rule table")
+	}
+
+	// The rules that used to be hardcoded in the twitch package.
+	_, err = tx.Exec(ctx,
+		`
+		INSERT INTO twitch_relevance_rule (kind, value, label, relevant)
+		VALUES
+			(1, '1469308723', 'Software and Game Development', TRUE),
+			(2, 'Programming', '', TRUE),
+			(2, 'Software Development', '', TRUE);
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to seed twitch relevance rules")
+	}
+
+	// Rows with a NULL banned column never banned anyone, so they keep doing
+	// nothing once the column is made NOT NULL.
+	_, err = tx.Exec(ctx,
+		`
+		UPDATE twitch_ignore_list SET banned = FALSE WHERE banned IS NULL;
+		ALTER TABLE twitch_ignore_list
+			ALTER COLUMN banned SET NOT NULL,
+			ADD COLUMN relevant BOOLEAN;
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to add relevance overrides to twitch_ignore_list")
+	}
+
+	return nil
+}
+
+func (m AddTwitchRelevanceRules) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		DELETE FROM twitch_ignore_list WHERE banned = FALSE AND relevant IS NOT NULL;
+		ALTER TABLE twitch_ignore_list
+			DROP COLUMN relevant,
+			ALTER COLUMN banned DROP NOT NULL;
+		UPDATE twitch_ignore_list SET banned = NULL WHERE banned = FALSE;
+		DROP TABLE twitch_relevance_rule;
+		`,
+	)
+	return err
+}
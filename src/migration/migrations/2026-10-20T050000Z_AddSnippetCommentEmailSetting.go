@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddSnippetCommentEmailSetting{})
+}
+
+type AddSnippetCommentEmailSetting struct{}
+
+func (m AddSnippetCommentEmailSetting) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 20, 5, 0, 0, 0, time.UTC))
+}
+
+func (m AddSnippetCommentEmailSetting) Name() string {
+	return "AddSnippetCommentEmailSetting"
+}
+
+func (m AddSnippetCommentEmailSetting) Description() string {
+	return "Let users turn off emails about comments on their snippets"
+}
+
+func (m AddSnippetCommentEmailSetting) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE hmn_user
+			ADD COLUMN email_snippet_comments BOOLEAN NOT NULL DEFAULT TRUE;
+		`,
+	)
+	return err
+}
+
+func (m AddSnippetCommentEmailSetting) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE hmn_user
+			DROP COLUMN email_snippet_comments;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(AddAssetPinned{})
+}
+
+type AddAssetPinned struct{}
+
+func (m AddAssetPinned) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 20, 6, 0, 0, 0, time.UTC))
+}
+
+func (m AddAssetPinned) Name() string {
+	return "AddAssetPinned"
+}
+
+func (m AddAssetPinned) Description() string {
+	return "Let assets opt out of garbage collection, and pin the ones our templates link to"
+}
+
+func (m AddAssetPinned) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT FALSE;
+		`,
+	)
+	if err != nil {
+		return oops.New(err, "failed to add pinned column to asset")
+	}
+
+	// Assets linked from templates and code by URL, which garbage collection
+	// can't see. Matched by the ID at the start of the key, which also covers
+	// thumbnails stored alongside them.
+	_, err = tx.Exec(ctx,
+		`
+		UPDATE asset
+		SET pinned = TRUE
+		WHERE split_part(s3_key, '/', 1) = ANY($1)
+		`,
+		[]string{
+			// jam_2023_wrj_index.html
+			"2c3ea96c-f246-4f03-9f2d-3538d7ffaf90",
+			"d7d0bbed-9b0e-4965-8f54-be1d8a945d39",
+			"b015f14e-b51a-4df9-b2d5-6c594be25373",
+			"62006e6b-eaa0-495a-a4c3-7ae077e27e46",
+			"d364f0a9-06b7-4e55-86c4-02f79ff92ded",
+			// jam_2023_vj_recap.html
+			"ec99f8e9-4fd4-4d96-a49e-a09287a06e77",
+			// time_machine.go
+			"a835cf47-9649-4738-bd58-252a6199863b",
+			"f8e5843a-0be6-4ea5-a980-cc470f2f708e",
+		},
+	)
+	if err != nil {
+		return oops.New(err, "failed to pin assets used by templates")
+	}
+
+	return nil
+}
+
+func (m AddAssetPinned) Down(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		ALTER TABLE asset
+			DROP COLUMN pinned;
+		`,
+	)
+	return err
+}
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/migration/types"
+	"github.com/jackc/pgx/v5"
+)
+
+func init() {
+	registerMigration(PinStyleTestAssets{})
+}
+
+type PinStyleTestAssets struct{}
+
+func (m PinStyleTestAssets) Version() types.MigrationVersion {
+	return types.MigrationVersion(time.Date(2026, 10, 20, 7, 0, 0, 0, time.UTC))
+}
+
+func (m PinStyleTestAssets) Name() string {
+	return "PinStyleTestAssets"
+}
+
+func (m PinStyleTestAssets) Description() string {
+	return "Pin the assets used by the style test page"
+}
+
+func (m PinStyleTestAssets) Up(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx,
+		`
+		UPDATE asset
+		SET pinned = TRUE
+		WHERE split_part(s3_key, '/', 1) = ANY($1)
+		`,
+		[]string{
+			// debug.go
+			"32ff3e7e-1d9c-4740-a062-1f8bec2e44cf",
+			"8c6a3b71-9e91-4bf6-80ef-bc8f3d21b30d",
+			"ea6f914a-ea00-4cbb-bbd7-586b82fdb484",
+			"b122c7be-dc6d-41fe-a5ed-033fe991927e",
+			"979d8850-f6b6-44b4-984e-93be82eb492b",
+			"4cd4335d-c977-464b-994c-bda5a9b44b09",
+			// style_test.html
+			"8d3e2469-e380-4a10-a4bb-8c7e6c10ccdd",
+		},
+	)
+	return err
+}
+
+func (m PinStyleTestAssets) Down(ctx context.Context, tx pgx.Tx) error {
+	// There's no telling which of these were pinned for other reasons too.
+	return nil
+}
@@ -1,9 +1,21 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
+type TranscodeStatus int
+
+const (
+	TranscodeStatusNone TranscodeStatus = iota // Not a video
+	TranscodeStatusPending
+	TranscodeStatusRunning
+	TranscodeStatusDone
+	TranscodeStatusFailed
+)
+
 type Asset struct {
 	ID         uuid.UUID `db:"id"`
 	UploaderID *int      `db:"uploader_id"`
@@ -16,4 +28,67 @@ type Asset struct {
 	Sha1Sum        string `db:"sha1sum"`
 	Width          int    `db:"width"`
 	Height         int    `db:"height"`
+
+	// Written by the uploader. Alt text describes the media for people who
+	// can't see it; captions are shown alongside it for everyone.
+	AltText string `db:"alt_text"`
+	Caption string `db:"caption"`
+
+	// Private assets are stored without public access. Their files are served
+	// through the website, which checks permissions and hands out short-lived
+	// URLs. See hmnurl.BuildAssetFile.
+	Private bool `db:"private"`
+
+	// Whether privacy-sensitive metadata (e.g. EXIF location) has been
+	// stripped from the file.
+	Sanitized bool `db:"sanitized"`
+
+	// Scaled-down copies of images, generated on upload. Every width exists in
+	// every format. See assets.VariantKey.
+	VariantsGenerated bool     `db:"variants_generated"`
+	VariantWidths     []int    `db:"variant_widths"`
+	VariantFormats    []string `db:"variant_formats"`
+
+	// Web-friendly MP4 encodings of videos, made in the background after
+	// upload. Use these instead of the original once transcoding is done.
+	TranscodeStatus    TranscodeStatus `db:"transcode_status"`
+	TranscodeAttempts  int             `db:"transcode_attempts"`
+	TranscodedS3Key    string          `db:"transcoded_s3_key"`
+	TranscodedLowS3Key string          `db:"transcoded_low_s3_key"` // Smaller, for slow connections and small screens
+
+	// Measured from audio files after upload. The waveform is a list of peak
+	// levels from 0 to 255, evenly spaced across the file.
+	AudioAnalyzed bool    `db:"audio_analyzed"`
+	Duration      float64 `db:"duration"` // Seconds
+	Bitrate       int     `db:"bitrate"`  // Average bits per second
+	Waveform      []byte  `db:"waveform"`
+
+	// Set by asset garbage collection when nothing references the asset.
+	OrphanedAt *time.Time `db:"orphaned_at"`
+
+	// Pinned assets are never garbage collected. This is for assets that are
+	// linked from places garbage collection can't see, like templates.
+	Pinned bool `db:"pinned"`
+}
+
+// A large upload that arrives in chunks over several requests, so that it can
+// be resumed after a dropped connection. Once every byte has arrived, it
+// becomes an Asset and this record is deleted.
+type AssetUpload struct {
+	ID         uuid.UUID `db:"id"`
+	UploaderID int       `db:"uploader_id"`
+
+	Filename    string `db:"filename"`
+	Size        int64  `db:"size"`
+	ContentType string `db:"content_type"` // Detected from the first chunk
+	Private     bool   `db:"private"`
+
+	S3Key      string   `db:"s3_key"`
+	S3UploadID string   `db:"s3_upload_id"` // S3 multipart upload ID, set with the first chunk
+	PartETags  []string `db:"part_etags"`
+
+	Received  int64  `db:"received"`
+	Sha1State []byte `db:"sha1_state"` // Marshaled hash of the bytes received so far
+
+	CreatedAt time.Time `db:"created_at"`
 }
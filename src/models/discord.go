@@ -72,3 +72,135 @@ type DiscordOutgoingMessage struct {
 	PayloadJSON string    `db:"payload_json"`
 	ExpiresAt   time.Time `db:"expires_at"`
 }
+
+type DiscordChannelAutoSnippet int
+
+const (
+	DiscordChannelAutoSnippetNever           DiscordChannelAutoSnippet = iota
+	DiscordChannelAutoSnippetAlways                                    // any message that passes the channel's rules
+	DiscordChannelAutoSnippetTaggedDuringJam                           // messages with a project tag, around the time of the latest jam
+)
+
+var AllDiscordChannelAutoSnippets = []DiscordChannelAutoSnippet{
+	DiscordChannelAutoSnippetNever,
+	DiscordChannelAutoSnippetAlways,
+	DiscordChannelAutoSnippetTaggedDuringJam,
+}
+
+func (p DiscordChannelAutoSnippet) Description() string {
+	switch p {
+	case DiscordChannelAutoSnippetAlways:
+		return "Every message"
+	case DiscordChannelAutoSnippetTaggedDuringJam:
+		return "Messages with a project tag, during a jam"
+	default:
+		return "Never"
+	}
+}
+
+/*
+How the bot treats a Discord channel. Channels without a row are left alone.
+*/
+type DiscordChannel struct {
+	ChannelID     string                    `db:"channel_id"`
+	Name          string                    `db:"name"`           // for staff's benefit; not kept in sync with Discord
+	StoreMessages bool                      `db:"store_messages"` // whether to intern messages and their contents
+	AutoSnippet   DiscordChannelAutoSnippet `db:"auto_snippet"`   // ignored unless messages are stored
+}
+
+type DiscordChannelRuleKind int
+
+const (
+	DiscordChannelRuleRequireLink             DiscordChannelRuleKind = iota + 1
+	DiscordChannelRuleRequireAttachment                              // e.g. an image or video
+	DiscordChannelRuleRequireLinkOrAttachment                        // what it takes to make a snippet
+	DiscordChannelRuleForbidDomains                                  // no links to the rule's domains, in the message or its embeds
+	DiscordChannelRuleSuppressEmbeds                                 // hides embeds if they're all from the rule's domains; never rebukes
+)
+
+var AllDiscordChannelRuleKinds = []DiscordChannelRuleKind{
+	DiscordChannelRuleRequireLink,
+	DiscordChannelRuleRequireAttachment,
+	DiscordChannelRuleRequireLinkOrAttachment,
+	DiscordChannelRuleForbidDomains,
+	DiscordChannelRuleSuppressEmbeds,
+}
+
+func (k DiscordChannelRuleKind) Description() string {
+	switch k {
+	case DiscordChannelRuleRequireLink:
+		return "Require a link"
+	case DiscordChannelRuleRequireAttachment:
+		return "Require an attachment"
+	case DiscordChannelRuleRequireLinkOrAttachment:
+		return "Require a link or attachment"
+	case DiscordChannelRuleForbidDomains:
+		return "Forbid links to domains"
+	case DiscordChannelRuleSuppressEmbeds:
+		return "Hide embeds from domains"
+	default:
+		return "Unknown"
+	}
+}
+
+// Whether the rule looks at its Domains.
+func (k DiscordChannelRuleKind) UsesDomains() bool {
+	return k == DiscordChannelRuleForbidDomains || k == DiscordChannelRuleSuppressEmbeds
+}
+
+/*
+A check the bot runs on every new or edited message in a channel. Messages that
+break a rule are deleted, and their authors are sent the rebuke message.
+*/
+type DiscordChannelRule struct {
+	ID            int                    `db:"id"`
+	ChannelID     string                 `db:"channel_id"`
+	Kind          DiscordChannelRuleKind `db:"kind"`
+	Domains       []string               `db:"domains"` // subdomains are included
+	RebukeMessage string                 `db:"rebuke_message"`
+	ExemptRoleIDs []string               `db:"exempt_role_ids"`
+	AppliesAfter  *time.Time             `db:"applies_after"` // older messages are left alone, e.g. when they're edited
+	Enabled       bool                   `db:"enabled"`       // disabled rules can still be tested against old messages
+	Ordering      int                    `db:"ordering"`
+}
+
+/*
+A Discord scheduled event the bot created to mirror one of ours. Source is the
+name of the calendar the event came from, or "hmn" for jams and expos.
+*/
+type DiscordScheduledEvent struct {
+	Key         string    `db:"key"` // the iCal UID, plus the start time for recurring events
+	Source      string    `db:"source"`
+	EventID     string    `db:"event_id"`
+	StartTime   time.Time `db:"start_time"`
+	PayloadHash string    `db:"payload_hash"` // of what was last sent, to skip no-op updates
+}
+
+// A webhook the bot made in a channel so it can post as other people.
+type DiscordWebhook struct {
+	ChannelID string `db:"channel_id"`
+	WebhookID string `db:"webhook_id"`
+	Token     string `db:"token"`
+}
+
+// The Discord forum post a bridged thread is mirrored to.
+type DiscordForumThread struct {
+	ThreadID        int    `db:"thread_id"`
+	ChannelID       string `db:"channel_id"` // the forum channel
+	DiscordThreadID string `db:"discord_thread_id"`
+	Title           string `db:"title"`           // what the Discord post was last named
+	DiscordDeleted  bool   `db:"discord_deleted"` // once the post is gone from Discord, the thread is no longer synced
+}
+
+/*
+A post in a bridged thread and its copy on the other side. Posts made on the
+site are copied to Discord by the bot; posts made on Discord are copied to the
+site. Either way, the copy is never copied back.
+*/
+type DiscordForumPost struct {
+	PostID      int    `db:"post_id"`
+	MessageID   string `db:"message_id"`
+	FromDiscord bool   `db:"from_discord"`
+	VersionID   int    `db:"version_id"`  // the post version the other side last saw
+	Attachments string `db:"attachments"` // markdown for a Discord message's attachments, kept when it's edited
+}
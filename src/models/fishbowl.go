@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Fishbowl struct {
+	ID          int        `db:"id"`
+	Slug        string     `db:"slug"`
+	Title       string     `db:"title"`
+	Description string     `db:"description"` // The description is used for OpenGraph, so it must be plain text, no HTML.
+	Month       time.Month `db:"month"`
+	Year        int        `db:"year"`
+	ThreadID    *string    `db:"thread_id"` // The Discord thread the fishbowl was held in, if it has been imported.
+
+	// Fishbowls from before we archived them automatically were hand-edited
+	// into HTML files, which live in templates/src/fishbowls. If this is set,
+	// it is the path to that file and the fishbowl has no stored messages.
+	ContentsPath string `db:"contents_path"`
+
+	Published bool `db:"published"`
+}
+
+// A message copied from a fishbowl's Discord thread. Messages are shown in
+// order, and staff can hide the noise.
+type FishbowlMessage struct {
+	ID                  int        `db:"id"`
+	FishbowlID          int        `db:"fishbowl_id"`
+	MessageID           string     `db:"message_id"`
+	AuthorID            string     `db:"author_id"` // Discord user ID
+	AuthorName          string     `db:"author_name"`
+	AuthorAvatarAssetID *uuid.UUID `db:"author_avatar_asset_id"`
+	Content             string     `db:"content"` // Discord markdown, cleaned up with CleanUpMarkdown
+	SentAt              time.Time  `db:"sent_at"`
+	Ordering            int        `db:"ordering"`
+	Hidden              bool       `db:"hidden"`
+}
+
+type FishbowlMessageAttachment struct {
+	MessageID int       `db:"message_id"`
+	AssetID   uuid.UUID `db:"asset_id"`
+	Ordering  int       `db:"ordering"`
+}
@@ -20,12 +20,13 @@ type PodcastEpisode struct {
 	GUID      uuid.UUID `db:"guid"`
 	PodcastID int       `db:"podcast_id"`
 
-	Title           string    `db:"title"`
-	Description     string    `db:"description"`
-	DescriptionHtml string    `db:"description_rendered"`
-	AudioFile       string    `db:"audio_filename"`
-	PublicationDate time.Time `db:"pub_date"`
-	Duration        int       `db:"duration"` // NOTE(asaf): In seconds
-	EpisodeNumber   int       `db:"episode_number"`
-	SeasonNumber    int       `db:"season_number"`
+	Title           string     `db:"title"`
+	Description     string     `db:"description"`
+	DescriptionHtml string     `db:"description_rendered"`
+	AudioAssetID    *uuid.UUID `db:"audio_asset"`
+	AudioFile       string     `db:"audio_filename"` // Only for episodes from before audio was stored as assets
+	PublicationDate time.Time  `db:"pub_date"`
+	Duration        int        `db:"duration"` // NOTE(asaf): In seconds
+	EpisodeNumber   int        `db:"episode_number"`
+	SeasonNumber    int        `db:"season_number"`
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -28,3 +29,54 @@ type Snippet struct {
 	EditedOnWebsite  bool    `db:"edited_on_website"`
 	DiscordMessageID *string `db:"discord_message_id"`
 }
+
+// Reactions that can be left on snippets from the website. Reactions mirrored
+// from Discord may use any emoji, including custom server emoji.
+var SnippetReactionEmojis = []string{"👍", "❤️", "😂", "😮", "🎉", "🔥", "👀"}
+
+func IsValidSnippetReactionEmoji(emoji string) bool {
+	for _, e := range SnippetReactionEmojis {
+		if e == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+type SnippetReaction struct {
+	ID        int `db:"id"`
+	SnippetID int `db:"snippet_id"`
+
+	// Either a Unicode emoji, or "name:id" for a custom Discord emoji.
+	Emoji string `db:"emoji"`
+
+	// Exactly one of these is set, depending on where the reaction came from.
+	UserID        *int    `db:"user_id"`
+	DiscordUserID *string `db:"discord_user_id"`
+
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Returns the ID of the custom Discord emoji used for this reaction, or the
+// empty string if it's a regular Unicode emoji.
+func SnippetReactionDiscordEmojiID(emoji string) string {
+	if _, id, found := strings.Cut(emoji, ":"); found {
+		return id
+	}
+	return ""
+}
+
+const SnippetCommentMaxLength = 2000
+
+type SnippetComment struct {
+	ID        int       `db:"id"`
+	SnippetID int       `db:"snippet_id"`
+	AuthorID  int       `db:"author_id"`
+	CreatedAt time.Time `db:"created_at"`
+
+	Body     string `db:"body"`
+	BodyHTML string `db:"body_html"`
+}
+
+// Matches the number of attachments Discord allows on a single message.
+const SnippetMaxAttachments = 10
@@ -0,0 +1,25 @@
+package models
+
+import "regexp"
+
+// A Topic is a staff-curated subject area, like "audio" or "rendering".
+// Unlike a project's Discord tag, topics are shared between many projects,
+// snippets, and education articles, and can be nested under a parent topic.
+type Topic struct {
+	ID              int      `db:"id"`
+	Slug            string   `db:"slug"`
+	Name            string   `db:"name"`
+	Description     string   `db:"description"`
+	DescriptionHTML string   `db:"description_html"`
+	Aliases         []string `db:"aliases"`
+	ParentID        *int     `db:"parent_id"`
+}
+
+var REValidTopicSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+func ValidateTopicSlug(slug string) bool {
+	if len(slug) == 0 || len(slug) > 40 {
+		return false
+	}
+	return REValidTopicSlug.MatchString(slug)
+}
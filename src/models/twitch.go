@@ -55,3 +55,49 @@ type TwitchLog struct {
 	Message  string        `db:"message"`
 	Payload  string        `db:"payload"`
 }
+
+type TwitchRelevanceRuleKind int
+
+const (
+	TwitchRelevanceRuleCategory TwitchRelevanceRuleKind = iota + 1 // matches Twitch's category ID
+	TwitchRelevanceRuleTag                                         // matches a stream tag, ignoring case
+)
+
+var AllTwitchRelevanceRuleKinds = []TwitchRelevanceRuleKind{
+	TwitchRelevanceRuleCategory,
+	TwitchRelevanceRuleTag,
+}
+
+func (k TwitchRelevanceRuleKind) Description() string {
+	switch k {
+	case TwitchRelevanceRuleCategory:
+		return "Category"
+	case TwitchRelevanceRuleTag:
+		return "Tag"
+	default:
+		return "Unknown"
+	}
+}
+
+/*
+Decides whether livestreams in a category or with a tag are announced in the
+Discord streams channel. Rules that hide streams win over rules that show them.
+*/
+type TwitchRelevanceRule struct {
+	ID       int                     `db:"id"`
+	Kind     TwitchRelevanceRuleKind `db:"kind"`
+	Value    string                  `db:"value"`
+	Label    string                  `db:"label"` // for staff's benefit, e.g. the category's name
+	Relevant bool                    `db:"relevant"`
+}
+
+/*
+A streamer the Twitch integration treats specially. Banned streamers are not
+tracked at all. Otherwise, Relevant overrides the relevance rules for the
+streamer's streams, and nil leaves them to the rules.
+*/
+type TwitchIgnoreListEntry struct {
+	TwitchLogin string `db:"twitch_login"`
+	Banned      bool   `db:"banned"`
+	Relevant    *bool  `db:"relevant"`
+}
@@ -41,7 +41,8 @@ type User struct {
 
 	Timezone string `db:"timezone"`
 
-	ShowEmail bool `db:"showemail"`
+	ShowEmail            bool `db:"showemail"`
+	EmailSnippetComments bool `db:"email_snippet_comments"`
 
 	DiscordSaveShowcase                 bool `db:"discord_save_showcase"`
 	DiscordDeleteSnippetOnMessageDelete bool `db:"discord_delete_snippet_on_message_delete"`
@@ -16,6 +16,9 @@ func main() {
 	js.Global().Set("parseMarkdownEdu", js.FuncOf(func(this js.Value, args []js.Value) any {
 		return parsing.ParseMarkdown(args[0].String(), parsing.EducationPreviewMarkdown)
 	}))
+	js.Global().Set("parseDiscordMarkdown", js.FuncOf(func(this js.Value, args []js.Value) any {
+		return parsing.ParseMarkdown(args[0].String(), parsing.DiscordMarkdown)
+	}))
 	js.Global().Set("parseKnownServicesForUrl", js.FuncOf(func(this js.Value, args []js.Value) any {
 		service, username := links.ParseKnownServicesForUrl(args[0].String())
 		return js.ValueOf(map[string]any{
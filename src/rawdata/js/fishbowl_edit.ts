@@ -0,0 +1,12 @@
+import { initReorderable } from "./lib/reorderable";
+import { must } from "./lib/utils";
+
+// Messages are submitted in the order their inputs appear on the page, so
+// moving the elements around is all it takes to reorder them.
+export function init() {
+  const container = must(document.querySelector("#fishbowl-messages")) as HTMLElement;
+  const { startDrag } = initReorderable(container);
+  for (const handle of container.querySelectorAll(".fishbowl-grab-handle")) {
+    handle.addEventListener("pointerdown", startDrag as EventListener);
+  }
+}
@@ -71,6 +71,12 @@ export function autosaveContent({
 }
 
 const markdownIds: string[] = [];
+const previewHandlers: { [elementID: string]: (html: string) => void } = {};
+
+previewWorker.onmessage = ({ data }) => {
+  const { elementID, html } = data;
+  previewHandlers[elementID]?.(html);
+};
 
 export type LiveMarkdownOptions = {
   inputEl: HTMLInputElement | HTMLTextAreaElement,
@@ -98,12 +104,9 @@ export function initLiveMarkdown({
   }
   markdownIds.push(inputEl.id);
 
-  previewWorker.onmessage = ({ data }) => {
-    const { elementID, html } = data;
-    if (elementID === inputEl.id) {
-      previewEl.innerHTML = html;
-      MathJax.typeset?.();
-    }
+  previewHandlers[inputEl.id] = html => {
+    previewEl.innerHTML = html;
+    MathJax.typeset?.();
   };
 
   function doMarkdown() {
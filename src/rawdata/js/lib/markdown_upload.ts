@@ -7,6 +7,17 @@ type Upload = {
 	file: File,
 };
 
+type UploadResult = {
+	url?: string,
+	mime?: string,
+	error?: string,
+};
+
+// Files bigger than this are uploaded in chunks, so that a dropped connection
+// doesn't mean starting over.
+const resumableUploadThreshold = 16 * 1024 * 1024;
+const maxResumeAttempts = 10;
+
 /**
  * Sets up file / image uploading for Markdown content.
  *
@@ -49,7 +60,6 @@ export function setupMarkdownUpload(
 	let enterCounter = 0;
 	let uploadQueue: Upload[] = [];
 	let currentUpload: Upload | null = null;
-	let currentXhr: XMLHttpRequest | null = null;
 	let currentBatchSize = 0;
 	let currentBatchDone = 0;
 
@@ -208,41 +218,118 @@ export function setupMarkdownUpload(
 		uploadProgressText.textContent = `Uploading files ${currentBatchDone + 1}/${currentBatchSize}`;
 	}
 
-	function uploadDone(ev: ProgressEvent<XMLHttpRequestEventTarget>) {
-		assert(currentXhr);
+	function uploadDone(result: UploadResult | null) {
 		assert(currentUpload);
-		try {
-			if (currentXhr.status == 200 && currentXhr.response) {
-				if (currentXhr.response.url) {
-					let url = currentXhr.response.url;
-					let newString = `[${currentUpload.file.name}](${url})`;
-					if (currentXhr.response.mime.startsWith("image")) {
-						newString = "!" + newString;
-					}
-
-					replaceUploadString(currentUpload, newString);
-				} else if (currentXhr.response.error) {
-					replaceUploadString(currentUpload, `Upload failed for \`${currentUpload.file.name}\`: ${currentXhr.response.error}.`);
-				} else {
-					replaceUploadStringError(currentUpload);
-				}
-			} else {
-				replaceUploadStringError(currentUpload);
+		if (result?.url) {
+			let newString = `[${currentUpload.file.name}](${result.url})`;
+			if (result.mime?.startsWith("image")) {
+				newString = "!" + newString;
 			}
-		} catch (err) {
-			console.error(err);
+
+			replaceUploadString(currentUpload, newString);
+		} else if (result?.error) {
+			replaceUploadString(currentUpload, `Upload failed for \`${currentUpload.file.name}\`: ${result.error}.`);
+		} else {
 			replaceUploadStringError(currentUpload);
 		}
 		currentUpload = null;
-		currentXhr = null;
 		currentBatchDone++;
 		uploadNext();
 	}
 
-	function updateUploadProgress(ev: ProgressEvent<XMLHttpRequestEventTarget>) {
-		if (ev.lengthComputable) {
-			let progress = ev.loaded / ev.total;
-			uploadProgressBarFill.style.width = Math.floor(progress * 100) + "%";
+	function updateUploadProgress(progress: number) {
+		uploadProgressBarFill.style.width = Math.floor(progress * 100) + "%";
+	}
+
+	// NOTE(asaf): We use XHR because fetch can't do upload progress reports. Womp womp. https://youtu.be/Pubd-spHN-0?t=2
+	function sendXhr(
+		method: string,
+		url: string,
+		headers: Record<string, string>,
+		body: Blob | null,
+		onProgress?: (loaded: number) => void,
+	): Promise<XMLHttpRequest> {
+		return new Promise(resolve => {
+			const xhr = new XMLHttpRequest();
+			if (onProgress) {
+				xhr.upload.addEventListener("progress", ev => onProgress(ev.loaded));
+			}
+			xhr.open(method, url, true);
+			for (const [name, value] of Object.entries(headers)) {
+				xhr.setRequestHeader(name, value);
+			}
+			xhr.responseType = "json";
+			// Network errors also end up here, with a status of 0.
+			xhr.addEventListener("loadend", () => resolve(xhr));
+			xhr.send(body);
+		});
+	}
+
+	async function uploadFile(file: File, filenameHeader: string): Promise<UploadResult | null> {
+		const xhr = await sendXhr("POST", uploadUrl, {
+			"Hmn-Upload-Filename": filenameHeader,
+		}, file, loaded => {
+			updateUploadProgress(loaded / file.size);
+		});
+		return xhr.status == 200 ? xhr.response : null;
+	}
+
+	async function uploadFileResumable(file: File, filenameHeader: string): Promise<UploadResult | null> {
+		const start = await sendXhr("POST", uploadUrl, {
+			"Hmn-Upload-Filename": filenameHeader,
+			"Hmn-Upload-Length": String(file.size),
+		}, null);
+		if (start.status != 200 || !start.response) {
+			return null;
+		}
+		if (start.response.error) {
+			return { error: start.response.error };
+		}
+		const chunkUrl: string = start.response.chunkUrl;
+		const chunkSize: number = start.response.chunkSize;
+
+		let offset = 0;
+		let failures = 0;
+		while (true) {
+			const chunk = file.slice(offset, offset + chunkSize);
+			const xhr = await sendXhr("PATCH", chunkUrl, {
+				"Hmn-Upload-Offset": String(offset),
+			}, chunk, loaded => {
+				updateUploadProgress((offset + loaded) / file.size);
+			});
+
+			if (xhr.status == 200 && xhr.response) {
+				if (xhr.response.url || xhr.response.error) {
+					return xhr.response;
+				}
+				offset = xhr.response.received;
+				failures = 0;
+			} else if (xhr.status == 409) {
+				// We disagree with the server about how much has been sent.
+				// The server is right.
+				failures++;
+				if (failures > maxResumeAttempts) {
+					return null;
+				}
+				offset = Number(xhr.getResponseHeader("Hmn-Upload-Offset"));
+			} else if (xhr.status == 0 || xhr.status >= 500) {
+				// The connection dropped or the server hiccuped. Wait a bit,
+				// then ask the server where to pick up from.
+				failures++;
+				if (failures > maxResumeAttempts) {
+					return null;
+				}
+				uploadProgressText.textContent = `Connection lost, retrying (${failures}/${maxResumeAttempts})...`;
+				await new Promise(resolve => setTimeout(resolve, Math.min(1000 * 2 ** (failures - 1), 30000)));
+				uploadProgressText.textContent = `Uploading files ${currentBatchDone + 1}/${currentBatchSize}`;
+
+				const status = await sendXhr("HEAD", chunkUrl, {}, null);
+				if (status.status == 204) {
+					offset = Number(status.getResponseHeader("Hmn-Upload-Offset"));
+				}
+			} else {
+				return xhr.response?.error ? { error: xhr.response.error } : null;
+			}
 		}
 	}
 
@@ -258,23 +345,18 @@ export function setupMarkdownUpload(
 					e.value = "Uploading files...";
 				}
 
-				try {
-					let utf8Filename = strToUTF8Arr(next.file.name);
-					let base64Filename = base64EncArr(utf8Filename);
-					// NOTE(asaf): We use XHR because fetch can't do upload progress reports. Womp womp. https://youtu.be/Pubd-spHN-0?t=2
-					currentXhr = new XMLHttpRequest();
-					currentXhr.upload.addEventListener("progress", updateUploadProgress);
-					currentXhr.open("POST", uploadUrl, true);
-					currentXhr.setRequestHeader("Hmn-Upload-Filename", base64Filename);
-					currentXhr.responseType = "json";
-					currentXhr.addEventListener("loadend", uploadDone);
-					currentXhr.send(next.file);
-					currentUpload = next;
-				} catch (err) {
-					replaceUploadStringError(next);
-					console.error(err);
-					uploadNext();
-				}
+				currentUpload = next;
+				let utf8Filename = strToUTF8Arr(next.file.name);
+				let base64Filename = base64EncArr(utf8Filename);
+				const upload = next.file.size > resumableUploadThreshold
+					? uploadFileResumable(next.file, base64Filename)
+					: uploadFile(next.file, base64Filename);
+				upload
+					.catch(err => {
+						console.error(err);
+						return null;
+					})
+					.then(uploadDone);
 			} else {
 				for (const [i, e] of Array.from(eSubmits).entries()) {
 					e.disabled = false;
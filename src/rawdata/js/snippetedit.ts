@@ -1,3 +1,4 @@
+import { initLiveMarkdown } from "./lib/markdown_previews";
 import { emptyElement, makeTemplateCloner } from "./lib/templates";
 import { HTMLFileInputElement } from "./lib/types";
 import { assert, must } from "./lib/utils";
@@ -14,16 +15,20 @@ const snippetEditTemplate = makeTemplateCloner<{
 	date: HTMLElement,
 	cancelLink: HTMLAnchorElement,
 	text: HTMLTextAreaElement,
+	textPreview: HTMLElement,
+	topics: HTMLInputElement,
 	uploadBox: HTMLElement,
 	uploadLink: HTMLAnchorElement,
 	uploadResetBox: HTMLElement,
 	uploadResetLink: HTMLAnchorElement,
 	previewBox: HTMLElement,
 	previewContent: HTMLElement,
+	attachmentMeta: HTMLElement,
 	removeLink: HTMLAnchorElement,
 	resetLink: HTMLAnchorElement,
 	replaceLink: HTMLAnchorElement,
 	errors: HTMLElement,
+	crosspostBox: HTMLElement,
 	projectList: HTMLElement,
 	deleteButton: HTMLInputElement,
 	saveButton: HTMLInputElement,
@@ -35,6 +40,13 @@ const snippetEditProjectTemplate = makeTemplateCloner<{
 	projectName: HTMLElement,
 	removeButton: HTMLAnchorElement,
 }>("snippet-edit-project");
+const snippetEditAttachmentMetaTemplate = makeTemplateCloner<{
+	root: HTMLElement,
+	assetId: HTMLInputElement,
+	label: HTMLElement,
+	altText: HTMLInputElement,
+	caption: HTMLInputElement,
+}>("snippet-edit-attachment-meta");
 
 function readableByteSize(numBytes: number) {
 	const scales = [
@@ -57,15 +69,24 @@ type AvailableProject = {
 	logo: string,
 };
 
+export type AttachmentMeta = {
+	assetId: string,
+	altText: string,
+	caption: string,
+};
+
 type SnippetEditOptions = {
 	maxFilesize: number,
+	maxAttachments: number,
 	availableProjects: AvailableProject[],
 	ownerName: string | undefined,
 	ownerAvatar: string | undefined,
 	ownerUrl: string | undefined,
 	date: Date,
 	text: string,
+	topics?: string,
 	attachmentElement: Element | undefined,
+	attachmentMeta?: AttachmentMeta[],
 	projectIds: number[],
 	stickyProjectId: number | undefined,
 	onDeleteRedirectUrl: string | undefined,
@@ -75,13 +96,16 @@ type SnippetEditOptions = {
 
 export function makeSnippetEdit({
 	maxFilesize,
+	maxAttachments,
 	availableProjects,
 	ownerName,
 	ownerAvatar,
 	ownerUrl,
 	date,
 	text,
+	topics,
 	attachmentElement,
+	attachmentMeta,
 	projectIds,
 	stickyProjectId,
 	onDeleteRedirectUrl,
@@ -92,7 +116,9 @@ export function makeSnippetEdit({
 	let projectSelector: HTMLSelectElement | null = null;
 	let originalAttachment: Element | null = null;
 	const originalText = text;
+	const originalTopics = topics ?? "";
 	let attachmentChanged = false;
+	let attachmentMetaChanged = false;
 	let hasAttachment = false;
 	snippetEdit.redirect.value = location.href;
 	if (ownerAvatar) {
@@ -107,15 +133,24 @@ export function makeSnippetEdit({
 	snippetEdit.username.href = ownerUrl ?? "";
 	snippetEdit.date.textContent = new Intl.DateTimeFormat([], { month: "2-digit", day: "2-digit", year: "numeric" }).format(date);
 	snippetEdit.text.value = text;
+	snippetEdit.topics.value = originalTopics;
 	if (attachmentElement) {
 		originalAttachment = attachmentElement.cloneNode(true) as Element;
 		clearAttachment(true);
 	}
 	if (snippetId !== undefined && snippetId !== null) {
 		snippetEdit.snippetId.value = snippetId;
+		// Only new snippets can be cross-posted to Discord.
+		snippetEdit.crosspostBox.remove();
 	} else {
 		snippetEdit.deleteButton.remove();
 	}
+	snippetEdit.text.id = `snippet-text-${snippetId ?? "new"}`;
+	initLiveMarkdown({
+		inputEl: snippetEdit.text,
+		previewEl: snippetEdit.textPreview,
+		parserName: "parseDiscordMarkdown",
+	});
 
 	for (let i = 0; i < projectIds.length; ++i) {
 		let proj = null;
@@ -226,37 +261,79 @@ export function makeSnippetEdit({
 
 	}
 
-	function setFile(file: File) {
+	function setFiles(files: File[]) {
 		let dt = new DataTransfer();
-		dt.items.add(file);
+		for (const file of files) {
+			dt.items.add(file);
+		}
 		snippetEdit.file.files = dt.files;
 
 		attachmentChanged = true;
 		snippetEdit.removeAttachment.value = "false";
-		hasAttachment = true;
+		hasAttachment = files.length > 0;
 
-		let el = null;
+		const el = document.createElement("div");
+		el.classList.add("flex", "flex-column", "g2");
+		for (const file of files) {
+			el.appendChild(makeFilePreview(file));
+		}
+		setPreview(el);
+
+		for (const file of files) {
+			const meta = snippetEditAttachmentMetaTemplate();
+			meta.assetId.remove();
+			meta.label.textContent = file.name;
+			meta.altText.name = "file_alt_text";
+			meta.caption.name = "file_caption";
+			snippetEdit.attachmentMeta.appendChild(meta.root);
+		}
+		validate();
+	}
+
+	function addExistingAttachmentMeta() {
+		const metas = attachmentMeta ?? [];
+		for (let i = 0; i < metas.length; ++i) {
+			const meta = snippetEditAttachmentMetaTemplate();
+			meta.assetId.name = "asset_id";
+			meta.assetId.value = metas[i].assetId;
+			meta.label.textContent = metas.length > 1 ? `Attachment ${i + 1}` : "";
+			meta.altText.name = "asset_alt_text";
+			meta.altText.value = metas[i].altText;
+			meta.caption.name = "asset_caption";
+			meta.caption.value = metas[i].caption;
+			for (const input of [meta.altText, meta.caption]) {
+				input.addEventListener("input", () => {
+					attachmentMetaChanged = true;
+				});
+			}
+			snippetEdit.attachmentMeta.appendChild(meta.root);
+		}
+	}
+
+	function makeFilePreview(file: File): Element {
 		if (file.type.startsWith("image/")) {
-			el = document.createElement("img");
+			const el = document.createElement("img");
 			el.src = URL.createObjectURL(file);
+			return el;
 		} else if (file.type.startsWith("video/")) {
-			el = document.createElement("video");
+			const el = document.createElement("video");
 			el.src = URL.createObjectURL(file);
 			el.controls = true;
+			return el;
 		} else if (file.type.startsWith("audio/")) {
-			el = document.createElement("audio");
+			const el = document.createElement("audio");
 			el.src = URL.createObjectURL(file);
+			return el;
 		} else {
-			el = document.createElement("div");
+			const el = document.createElement("div");
 			el.classList.add("project-card", "br2", "pv1", "ph2");
 			let anchor = document.createElement("a");
 			anchor.href = URL.createObjectURL(file);
 			anchor.setAttribute("target", "_blank");
 			anchor.textContent = file.name + " (" + readableByteSize(file.size) + ")";
 			el.appendChild(anchor);
+			return el;
 		}
-		setPreview(el);
-		validate();
 	}
 
 	function clearAttachment(restoreOriginal: boolean) {
@@ -275,10 +352,14 @@ export function makeSnippetEdit({
 			}
 		}
 		setPreview(el);
+		if (el && el == originalAttachment) {
+			addExistingAttachmentMeta();
+		}
 		validate();
 	}
 
 	function setPreview(el: Element | null) {
+		snippetEdit.attachmentMeta = emptyElement(snippetEdit.attachmentMeta);
 		if (el) {
 			snippetEdit.uploadBox.style.display = "none";
 			snippetEdit.previewBox.style.display = "block";
@@ -297,10 +378,14 @@ export function makeSnippetEdit({
 
 	function validate() {
 		let sizeGood = true;
-		if (snippetEdit.file.files.length > 0 && snippetEdit.file.files[0].size > maxFilesize) {
+		const tooBig = Array.from(snippetEdit.file.files).find(file => file.size > maxFilesize);
+		if (tooBig) {
 			// NOTE(asaf): Writing this out in bytes to make the limit exactly clear to the user.
 			let readableSize = new Intl.NumberFormat([], { useGrouping: true }).format(maxFilesize);
-			snippetEdit.errors.textContent = "File is too big! Max filesize is " + readableSize + " bytes.";
+			snippetEdit.errors.textContent = tooBig.name + " is too big! Max filesize is " + readableSize + " bytes.";
+			sizeGood = false;
+		} else if (snippetEdit.file.files.length > maxAttachments) {
+			snippetEdit.errors.textContent = "Too many files! You can attach at most " + maxAttachments + ".";
 			sizeGood = false;
 		} else {
 			snippetEdit.errors.textContent = "";
@@ -337,7 +422,7 @@ export function makeSnippetEdit({
 
 	snippetEdit.file.addEventListener("change", () => {
 		if (snippetEdit.file.files.length > 0) {
-			setFile(snippetEdit.file.files[0]);
+			setFiles(Array.from(snippetEdit.file.files));
 		}
 	});
 
@@ -379,7 +464,7 @@ export function makeSnippetEdit({
 		snippetEdit.root.classList.remove("drop");
 
 		if (ev.dataTransfer && ev.dataTransfer.files && ev.dataTransfer.files.length > 0) {
-			setFile(ev.dataTransfer.files[0]);
+			setFiles(Array.from(ev.dataTransfer.files));
 		}
 
 		ev.preventDefault();
@@ -389,7 +474,7 @@ export function makeSnippetEdit({
 		assert(ev.clipboardData);
 		const files = ev.clipboardData.files ?? [];
 		if (files.length > 0) {
-			setFile(files[0]);
+			setFiles(Array.from(files));
 		}
 	});
 
@@ -424,7 +509,9 @@ export function makeSnippetEdit({
 			}
 		}
 
-		if (originalSnippetEl && (!attachmentChanged && originalText == snippetEdit.text.value.trim() && !projectsChanged)) {
+		const topicsChanged = originalTopics != snippetEdit.topics.value.trim();
+
+		if (originalSnippetEl && (!attachmentChanged && !attachmentMetaChanged && originalText == snippetEdit.text.value.trim() && !projectsChanged && !topicsChanged)) {
 			// NOTE(asaf): We're in edit mode and nothing changed, so no need to submit to the server.
 			ev.preventDefault();
 			cancel();
@@ -448,6 +535,7 @@ export function makeSnippetEdit({
 
 export type EditTimelineSnippetOptions = {
 	maxFilesize: number,
+	maxAttachments: number,
 	availableProjects: AvailableProject[],
 	stickyProjectId?: number,
 	onDeleteRedirectUrl?: string,
@@ -455,6 +543,7 @@ export type EditTimelineSnippetOptions = {
 
 export function editTimelineSnippet(timelineItemEl: HTMLElement, {
 	maxFilesize,
+	maxAttachments,
 	availableProjects,
 	stickyProjectId,
 	onDeleteRedirectUrl,
@@ -465,7 +554,18 @@ export function editTimelineSnippet(timelineItemEl: HTMLElement, {
 	const ownerAvatar = timelineItemEl.querySelector<HTMLImageElement>(".avatar")?.src;
 	const creationDate = new Date(must(timelineItemEl.querySelector<HTMLTimeElement>("time")).dateTime);
 	const rawDesc = must(timelineItemEl.querySelector<HTMLElement>(".rawdesc")).textContent;
-	const attachment = timelineItemEl.querySelector<HTMLElement>(".timeline-media")?.children?.[0];
+	const topics = timelineItemEl.querySelector<HTMLElement>(".topic-list")?.textContent ?? "";
+	const attachment = timelineItemEl.querySelector<HTMLElement>(".timeline-gallery")
+		?? timelineItemEl.querySelector<HTMLElement>(".timeline-media")?.children?.[0];
+	const attachmentMeta: AttachmentMeta[] = [];
+	const assetMetaEls = timelineItemEl.querySelectorAll<HTMLInputElement>(".asset-meta-list > input");
+	for (let i = 0; i < assetMetaEls.length; ++i) {
+		attachmentMeta.push({
+			assetId: assetMetaEls[i].dataset.assetId ?? "",
+			altText: assetMetaEls[i].dataset.altText ?? "",
+			caption: assetMetaEls[i].dataset.caption ?? "",
+		});
+	}
 	const projectIds: number[] = [];
 	const projectEls = timelineItemEl.querySelectorAll<HTMLInputElement>(".project-id-list > input");
 	for (let i = 0; i < projectEls.length; ++i) {
@@ -476,13 +576,16 @@ export function editTimelineSnippet(timelineItemEl: HTMLElement, {
 	}
 	let snippetEdit = makeSnippetEdit({
 		maxFilesize,
+		maxAttachments,
 		availableProjects,
 		ownerName,
 		ownerAvatar,
 		ownerUrl,
 		date: creationDate,
 		text: rawDesc,
+		topics,
 		attachmentElement: attachment,
+		attachmentMeta,
 		projectIds,
 		stickyProjectId,
 		onDeleteRedirectUrl,
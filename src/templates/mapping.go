@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 
+	"git.handmade.network/hmn/hmn/src/assets"
 	"git.handmade.network/hmn/hmn/src/calendar"
 	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
@@ -64,9 +65,37 @@ var LifecycleBadgeStrings = map[models.ProjectLifecycle]string{
 	models.ProjectLifecycleLTS:              "Complete",
 }
 
+// Builds srcset attributes for an image asset's scaled-down variants. The
+// first is in a format every browser supports and includes the original
+// image. The second is empty if there are no WebP variants.
+func AssetSrcsets(a *models.Asset) (string, string) {
+	if a == nil || len(a.VariantWidths) == 0 {
+		return "", ""
+	}
+
+	var srcset, webpSrcset []string
+	for _, format := range a.VariantFormats {
+		var candidates []string
+		for _, width := range a.VariantWidths {
+			url := hmnurl.BuildAssetObject(a, assets.VariantKey(a.ID.String(), width, format))
+			candidates = append(candidates, fmt.Sprintf("%s %dw", url, width))
+		}
+		if format == "webp" {
+			webpSrcset = candidates
+		} else {
+			srcset = candidates
+		}
+	}
+	if a.Width > 0 {
+		srcset = append(srcset, fmt.Sprintf("%s %dw", hmnurl.BuildAssetObject(a, a.S3Key), a.Width))
+	}
+
+	return strings.Join(srcset, ", "), strings.Join(webpSrcset, ", ")
+}
+
 func ProjectLogoUrl(asset *models.Asset) string {
 	if asset != nil {
-		return hmnurl.BuildS3Asset(asset.S3Key)
+		return hmnurl.BuildAssetObject(asset, asset.S3Key)
 	}
 	return ""
 }
@@ -121,11 +150,13 @@ func ProjectToTemplate(
 func ProjectAndStuffToTemplate(p *hmndata.ProjectAndStuff) Project {
 	res := ProjectToTemplate(&p.Project)
 	res.Logo = ProjectLogoUrl(p.LogoAsset)
+	res.LogoSrcset, res.LogoWebpSrcset = AssetSrcsets(p.LogoAsset)
 	for _, o := range p.Owners {
 		res.Owners = append(res.Owners, UserToTemplate(o))
 	}
 	if p.HeaderImage != nil {
-		res.HeaderImage = hmnurl.BuildS3Asset(p.HeaderImage.S3Key)
+		res.HeaderImage = hmnurl.BuildAssetObject(p.HeaderImage, p.HeaderImage.S3Key)
+		res.HeaderImageAltText = p.HeaderImage.AltText
 	}
 	return res
 }
@@ -180,8 +211,11 @@ func AssetToTemplate(a *models.Asset) *Asset {
 		return nil
 	}
 
+	srcset, webpSrcset := AssetSrcsets(a)
 	return &Asset{
-		Url: hmnurl.BuildS3Asset(a.S3Key),
+		Url:        hmnurl.BuildAssetObject(a, a.S3Key),
+		Srcset:     srcset,
+		WebpSrcset: webpSrcset,
 
 		ID:       a.ID.String(),
 		Filename: a.Filename,
@@ -189,6 +223,8 @@ func AssetToTemplate(a *models.Asset) *Asset {
 		MimeType: a.MimeType,
 		Width:    a.Width,
 		Height:   a.Height,
+		AltText:  a.AltText,
+		Caption:  a.Caption,
 	}
 }
 
@@ -215,7 +251,7 @@ func UserAvatarDefaultUrl(theme string) string {
 func UserAvatarUrl(u *models.User) string {
 	avatar := UserAvatarDefaultUrl("light")
 	if u != nil && u.AvatarAsset != nil {
-		avatar = hmnurl.BuildS3Asset(u.AvatarAsset.S3Key)
+		avatar = hmnurl.BuildAssetObject(u.AvatarAsset, u.AvatarAsset.S3Key)
 	}
 	return avatar
 }
@@ -240,6 +276,8 @@ func UserToTemplate(u *models.User) User {
 		discordUser = &du
 	}
 
+	avatarSrcset, avatarWebpSrcset := AssetSrcsets(u.AvatarAsset)
+
 	return User{
 		ID:       u.ID,
 		Username: u.Username,
@@ -257,6 +295,9 @@ func UserToTemplate(u *models.User) User {
 		Avatar:     AssetToTemplate(u.AvatarAsset),
 		AvatarUrl:  UserAvatarUrl(u),
 
+		AvatarSrcset:     avatarSrcset,
+		AvatarWebpSrcset: avatarWebpSrcset,
+
 		Timezone: u.Timezone,
 
 		DiscordSaveShowcase:                 u.DiscordSaveShowcase,
@@ -433,7 +474,7 @@ func SnippetEditProjectsToJSON(projects []Project) string {
 func PodcastToTemplate(podcast *models.Podcast, image *models.Asset) Podcast {
 	var imageUrl string
 	if image != nil {
-		imageUrl = hmnurl.BuildS3Asset(image.S3Key)
+		imageUrl = hmnurl.BuildAssetObject(image, image.S3Key)
 	}
 	return Podcast{
 		Title:       podcast.Title,
@@ -450,10 +491,20 @@ func PodcastToTemplate(podcast *models.Podcast, image *models.Asset) Podcast {
 	}
 }
 
-func PodcastEpisodeToTemplate(episode *models.PodcastEpisode, image *models.Asset, audioFileSize int64) PodcastEpisode {
+// Episodes from before audio was stored as assets have no audio asset. Their
+// file size must be passed separately.
+func PodcastEpisodeToTemplate(episode *models.PodcastEpisode, image *models.Asset, audio *models.Asset, legacyFileSize int64) PodcastEpisode {
 	var imageUrl string
 	if image != nil {
-		imageUrl = hmnurl.BuildS3Asset(image.S3Key)
+		imageUrl = hmnurl.BuildAssetObject(image, image.S3Key)
+	}
+	fileUrl := hmnurl.BuildPodcastEpisodeFile(episode.AudioFile)
+	fileSize := legacyFileSize
+	fileMimeType := "audio/mpeg"
+	if audio != nil {
+		fileUrl = hmnurl.BuildAssetObject(audio, audio.S3Key)
+		fileSize = int64(audio.Size)
+		fileMimeType = audio.MimeType
 	}
 	return PodcastEpisode{
 		GUID:            episode.GUID.String(),
@@ -464,8 +515,9 @@ func PodcastEpisodeToTemplate(episode *models.PodcastEpisode, image *models.Asse
 		SeasonNumber:    episode.SeasonNumber,
 		Url:             hmnurl.BuildPodcastEpisode(episode.GUID.String()),
 		ImageUrl:        imageUrl,
-		FileUrl:         hmnurl.BuildPodcastEpisodeFile(episode.AudioFile),
-		FileSize:        audioFileSize,
+		FileUrl:         fileUrl,
+		FileSize:        fileSize,
+		FileMimeType:    fileMimeType,
 		PublicationDate: episode.PublicationDate,
 		Duration:        episode.Duration,
 	}
@@ -491,6 +543,64 @@ func TagToTemplate(t *models.Tag) Tag {
 	}
 }
 
+func TopicToTemplate(t *models.Topic) Topic {
+	return Topic{
+		ID:             t.ID,
+		Slug:           t.Slug,
+		Name:           t.Name,
+		Description:    template.HTML(t.DescriptionHTML),
+		RawDescription: t.Description,
+		Aliases:        strings.Join(t.Aliases, ", "),
+
+		Url:     hmnurl.BuildTopic(t.Slug),
+		EditUrl: hmnurl.BuildTopicEdit(t.Slug),
+	}
+}
+
+func TopicsToTemplate(topics []*models.Topic) []Topic {
+	res := make([]Topic, 0, len(topics))
+	for _, t := range topics {
+		res = append(res, TopicToTemplate(t))
+	}
+	return res
+}
+
+// Joins topic slugs for display in a comma-separated form field.
+func TopicSlugs(topics []Topic) string {
+	slugs := make([]string, 0, len(topics))
+	for _, t := range topics {
+		slugs = append(slugs, t.Slug)
+	}
+	return strings.Join(slugs, ", ")
+}
+
+func SnippetReactionsToTemplate(counts []hmndata.SnippetReactionCount) []SnippetReaction {
+	res := make([]SnippetReaction, 0, len(counts))
+	for _, c := range counts {
+		reaction := SnippetReaction{
+			Emoji:   c.Emoji,
+			Count:   c.Count,
+			Reacted: c.Reacted,
+		}
+		if emojiID := models.SnippetReactionDiscordEmojiID(c.Emoji); emojiID != "" {
+			name, _, _ := strings.Cut(c.Emoji, ":")
+			reaction.Emoji = name
+			reaction.ImageUrl = fmt.Sprintf("https://cdn.discordapp.com/emojis/%s.webp?size=48", emojiID)
+		}
+		res = append(res, reaction)
+	}
+	return res
+}
+
+func SnippetCommentToTemplate(c *hmndata.SnippetCommentAndStuff) SnippetComment {
+	return SnippetComment{
+		ID:      c.Comment.ID,
+		Author:  UserToTemplate(c.Author),
+		Date:    c.Comment.CreatedAt,
+		Content: template.HTML(c.Comment.BodyHTML),
+	}
+}
+
 func EducationArticleToTemplate(a *models.EduArticle) EduArticle {
 	res := EduArticle{
 		Title:       a.Title,
@@ -347,6 +347,8 @@ var HMNTemplateFuncs = template.FuncMap{
 		return asset.Filename
 	},
 
+	"topicslugs": TopicSlugs,
+
 	"mediaimage":   func() TimelineItemMediaType { return TimelineItemMediaTypeImage },
 	"mediavideo":   func() TimelineItemMediaType { return TimelineItemMediaTypeVideo },
 	"mediaaudio":   func() TimelineItemMediaType { return TimelineItemMediaTypeAudio },
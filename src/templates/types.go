@@ -169,9 +169,13 @@ type Project struct {
 	ParsedDescription template.HTML
 	Owners            []User
 
-	Logo        string
-	HeaderImage string
-	Flowsnake   Flowsnake
+	Logo           string
+	LogoSrcset     string
+	LogoWebpSrcset string
+	HeaderImage    string
+	Flowsnake      Flowsnake
+
+	HeaderImageAltText string
 
 	LifecycleBadgeClass string
 	LifecycleString     string
@@ -194,6 +198,7 @@ type ProjectSettings struct {
 	Personal         bool
 	Lifecycle        string
 	Tag              string
+	Topics           []Topic
 	JamParticipation []ProjectJamParticipation
 	JamHidden        bool
 	SortScore        int
@@ -220,13 +225,17 @@ const FlowsnakeMinSize = 2500   // px
 const FlowsnakeSizeRange = 1000 // px
 
 type Asset struct {
-	Url string
+	Url        string
+	Srcset     string
+	WebpSrcset string
 
 	ID            string
 	Filename      string
 	Size          int
 	MimeType      string
 	Width, Height int
+	AltText       string
+	Caption       string
 }
 
 type Follow struct {
@@ -245,6 +254,8 @@ type SnippetEdit struct {
 	SubmitUrl             string
 	OnDeleteRedirectUrl   string
 	AssetMaxSize          int
+	MaxAttachments        int
+	CanCrosspost          bool
 }
 
 type User struct {
@@ -255,14 +266,16 @@ type User struct {
 	Status   int
 	Featured bool
 
-	Name       string
-	Blurb      string
-	Bio        string
-	Signature  string
-	DateJoined time.Time
-	Avatar     *Asset
-	AvatarUrl  string
-	ProfileUrl string
+	Name             string
+	Blurb            string
+	Bio              string
+	Signature        string
+	DateJoined       time.Time
+	Avatar           *Asset
+	AvatarUrl        string
+	AvatarSrcset     string
+	AvatarWebpSrcset string
+	ProfileUrl       string
 
 	ShowEmail bool
 	Timezone  string
@@ -314,6 +327,7 @@ type PodcastEpisode struct {
 	ImageUrl        string
 	FileUrl         string
 	FileSize        int64
+	FileMimeType    string
 	PublicationDate time.Time
 	Duration        int
 }
@@ -400,11 +414,15 @@ type TimelineItem struct {
 	OwnerUrl       string
 
 	Projects       []Project
+	Topics         []Topic
 	Description    template.HTML
 	RawDescription string
 
 	Media []TimelineItemMedia
 
+	Reactions    []SnippetReaction
+	CommentCount int
+
 	Unread bool
 
 	ForumLayout         bool
@@ -426,13 +444,21 @@ const (
 
 type TimelineItemMedia struct {
 	Type                TimelineItemMediaType
+	AssetID             string // Empty for embeds
 	AssetUrl            string
+	LowBandwidthUrl     string // For videos, a smaller encoding for small screens
 	EmbedHTML           template.HTML
 	ThumbnailUrl        string
+	Srcset              string
+	WebpSrcset          string
 	MimeType            string
 	Width, Height       int
 	Filename            string
 	FileSize            int
+	AltText             string
+	Caption             string
+	Duration            float64 // For audio, in seconds
+	WaveformPath        string  // For audio, an SVG path drawing the waveform in a 1000x100 viewBox
 	ExtraOpenGraphItems []OpenGraphItem
 }
 
@@ -479,6 +505,34 @@ type Tag struct {
 	Url  string
 }
 
+type Topic struct {
+	ID             int
+	Slug           string
+	Name           string
+	Description    template.HTML
+	RawDescription string
+	Aliases        string // comma-separated
+
+	Url     string
+	EditUrl string
+}
+
+type SnippetReaction struct {
+	Emoji    string // a Unicode emoji, or the name of a custom Discord emoji
+	ImageUrl string // only set for custom Discord emoji
+	Count    int
+	Reacted  bool
+}
+
+type SnippetComment struct {
+	ID        int
+	Author    User
+	Date      time.Time
+	Content   template.HTML
+	DeleteUrl string
+	CanDelete bool
+}
+
 type TextEditor struct {
 	ParserName  string
 	MaxFileSize int
@@ -497,6 +551,7 @@ type EduArticle struct {
 	Description string
 	Published   bool
 	Type        string
+	Topics      []Topic
 
 	Url       string
 	EditUrl   string
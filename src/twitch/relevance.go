@@ -0,0 +1,113 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+)
+
+type RelevanceRules struct {
+	Rules     []*models.TwitchRelevanceRule
+	Overrides []*models.TwitchIgnoreListEntry
+}
+
+func FetchRelevanceRules(ctx context.Context, dbConn db.ConnOrTx) (*RelevanceRules, error) {
+	rules, err := db.Query[models.TwitchRelevanceRule](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM twitch_relevance_rule
+		ORDER BY kind, value
+		`,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch twitch relevance rules")
+	}
+
+	overrides, err := db.Query[models.TwitchIgnoreListEntry](ctx, dbConn,
+		`
+		SELECT $columns
+		FROM twitch_ignore_list
+		ORDER BY twitch_login
+		`,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch twitch ignore list")
+	}
+
+	return &RelevanceRules{
+		Rules:     rules,
+		Overrides: overrides,
+	}, nil
+}
+
+// Decides whether a stream should be announced, and explains why for the admin
+// page. Streamer overrides come first, then rules that hide streams, then rules
+// that show them. Streams that match nothing are hidden.
+func (r *RelevanceRules) Check(login string, categoryID string, tags []string) (bool, string) {
+	for _, override := range r.Overrides {
+		if !strings.EqualFold(override.TwitchLogin, login) {
+			continue
+		}
+		if override.Banned {
+			return false, "Streamer is ignored"
+		}
+		if override.Relevant != nil {
+			if *override.Relevant {
+				return true, "Streamer is always shown"
+			} else {
+				return false, "Streamer is never shown"
+			}
+		}
+	}
+
+	for _, relevant := range []bool{false, true} {
+		for _, rule := range r.Rules {
+			if rule.Relevant != relevant || !ruleMatches(rule, categoryID, tags) {
+				continue
+			}
+			verb := "Hidden"
+			if relevant {
+				verb = "Shown"
+			}
+			return relevant, fmt.Sprintf("%s by %s rule %q", verb, strings.ToLower(rule.Kind.Description()), rule.Value)
+		}
+	}
+
+	return false, "No rule matches"
+}
+
+func (r *RelevanceRules) IsRelevant(login string, categoryID string, tags []string) bool {
+	relevant, _ := r.Check(login, categoryID, tags)
+	return relevant
+}
+
+func ruleMatches(rule *models.TwitchRelevanceRule, categoryID string, tags []string) bool {
+	switch rule.Kind {
+	case models.TwitchRelevanceRuleCategory:
+		return rule.Value == categoryID
+	case models.TwitchRelevanceRuleTag:
+		// Twitch returns every tag in `tags`, but only one in `tag_ids`, so
+		// tags are matched by name.
+		for _, tag := range tags {
+			if strings.EqualFold(rule.Value, tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Lets the streams channel catch up with changes to the rules, or to who is
+// banned. A link sync picks up both, and ends by updating Discord.
+func RelevanceRulesUpdated() {
+	if linksChangedChannel != nil {
+		select {
+		case linksChangedChannel <- struct{}{}:
+		default:
+		}
+	}
+}
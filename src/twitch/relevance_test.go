@@ -0,0 +1,40 @@
+package twitch
+
+import (
+	"testing"
+
+	"git.handmade.network/hmn/hmn/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelevanceRules(t *testing.T) {
+	yes, no := true, false
+	rules := RelevanceRules{
+		Rules: []*models.TwitchRelevanceRule{
+			{Kind: models.TwitchRelevanceRuleCategory, Value: "1469308723", Relevant: true},
+			{Kind: models.TwitchRelevanceRuleCategory, Value: "509660", Relevant: true}, // Art
+			{Kind: models.TwitchRelevanceRuleTag, Value: "Programming", Relevant: true},
+			{Kind: models.TwitchRelevanceRuleTag, Value: "Gambling", Relevant: false},
+		},
+		Overrides: []*models.TwitchIgnoreListEntry{
+			{TwitchLogin: "hardwareperson", Relevant: &yes},
+			{TwitchLogin: "offtopic", Relevant: &no},
+			{TwitchLogin: "banned", Banned: true, Relevant: &yes},
+			{TwitchLogin: "nobody"},
+		},
+	}
+
+	assert.True(t, rules.IsRelevant("someone", "1469308723", nil))
+	assert.True(t, rules.IsRelevant("someone", "509660", nil))
+	assert.True(t, rules.IsRelevant("someone", "0", []string{"programming"}))
+	assert.False(t, rules.IsRelevant("someone", "0", []string{"Chatting"}))
+	assert.False(t, rules.IsRelevant("someone", "1469308723", []string{"gambling"}))
+	assert.False(t, rules.IsRelevant("nobody", "0", nil))
+
+	assert.True(t, rules.IsRelevant("HardwarePerson", "0", nil))
+	assert.False(t, rules.IsRelevant("offtopic", "1469308723", nil))
+	assert.False(t, rules.IsRelevant("banned", "1469308723", nil))
+
+	_, reason := rules.Check("someone", "1469308723", []string{"Gambling"})
+	assert.Equal(t, `Hidden by tag rule "Gambling"`, reason)
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"slices"
 	"time"
 
 	"git.handmade.network/hmn/hmn/src/config"
@@ -478,9 +477,14 @@ func notifyDiscordOfLiveStream(ctx context.Context, dbConn db.ConnOrTx) error {
 		return oops.New(err, "failed to fetch twitch history")
 	}
 
+	rules, err := FetchRelevanceRules(ctx, dbConn)
+	if err != nil {
+		return err
+	}
+
 	updatedHistories := make([]*models.TwitchStreamHistory, 0)
 	for _, h := range history {
-		relevant := isStreamRelevant(h.CategoryID, h.Tags)
+		relevant := rules.IsRelevant(h.TwitchLogin, h.CategoryID, h.Tags)
 		if relevant && h.StreamEnded {
 			msgId, err := discord.PostStreamHistory(ctx, h)
 			if err != nil {
@@ -525,7 +529,7 @@ func notifyDiscordOfLiveStream(ctx context.Context, dbConn db.ConnOrTx) error {
 
 	var streamDetails []hmndata.StreamDetails
 	for _, s := range streams {
-		if isStreamRelevant(s.CategoryID, s.Tags) {
+		if rules.IsRelevant(s.TwitchLogin, s.CategoryID, s.Tags) {
 			streamDetails = append(streamDetails, hmndata.StreamDetails{
 				Username:  s.TwitchLogin,
 				StartTime: s.StartedAt,
@@ -1180,36 +1184,6 @@ func verifyHistoryVODs(ctx context.Context, dbConn db.ConnOrTx) error {
 	return nil
 }
 
-var RelevantCategories = []string{
-	"1469308723", // Software and Game Development
-}
-
-/*
-NOTE(asaf): Looks like twitch returns `tags` properly, but only one in `tag_ids`
-
-	var RelevantTags = []string{
-		"a59f1e4e-257b-4bd0-90c7-189c3efbf917", // Programming
-		"6f86127d-6051-4a38-94bb-f7b475dde109", // Software Development
-	}
-*/
-var RelevantTags = []string{
-	"Programming",          // Programming
-	"Software Development", // Software Development
-}
-
-func isStreamRelevant(catID string, tags []string) bool {
-	if slices.Contains(RelevantCategories, catID) {
-		return true
-	}
-
-	for _, tag := range RelevantTags {
-		if slices.Contains(tags, tag) {
-			return true
-		}
-	}
-	return false
-}
-
 func twitchLog(ctx context.Context, conn db.ConnOrTx, logType models.TwitchLogType, login string, message string, payload string) {
 	_, err := conn.Exec(ctx,
 		`
@@ -14,6 +14,7 @@ import (
 	"git.handmade.network/hmn/hmn/src/auth"
 	"git.handmade.network/hmn/hmn/src/config"
 	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/discord"
 	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
 	"git.handmade.network/hmn/hmn/src/models"
@@ -178,7 +179,7 @@ func AdminApprovalQueue(c *RequestContext) ResponseData {
 		if s.Snippet.When.After(userData.Date) {
 			userData.Date = s.Snippet.When
 		}
-		timelineItem := SnippetToTimelineItem(&s.Snippet, s.Asset, s.DiscordMessage, s.Projects, s.Owner, false)
+		timelineItem := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, false)
 		timelineItem.OwnerAvatarUrl = ""
 		userData.Timeline = append(userData.Timeline, timelineItem)
 	}
@@ -346,6 +347,7 @@ func AdminApprovalQueueSubmit(c *RequestContext) ResponseData {
 	} else {
 		whatHappened = fmt.Sprintf("Unrecognized action: %s", action)
 	}
+	discord.QueueRoleSyncForUsers(c, c.Conn, user.ID)
 
 	res := c.Redirect(hmnurl.BuildAdminApprovalQueue(), http.StatusSeeOther)
 	res.AddFutureNotice("success", whatHappened)
@@ -485,13 +487,16 @@ func deleteAllPostsForUser(ctx context.Context, conn *pgxpool.Pool, userId int)
 		return oops.New(err, "failed to fetch posts to delete for user")
 	}
 
+	var threadIDs []int
 	for _, row := range rows {
 		hmndata.DeletePost(ctx, tx, row.ThreadID, row.PostID)
+		threadIDs = append(threadIDs, row.ThreadID)
 	}
 	err = tx.Commit(ctx)
 	if err != nil {
 		return oops.New(err, "failed to commit transaction")
 	}
+	discord.QueueForumBridge(threadIDs...)
 	return nil
 }
 
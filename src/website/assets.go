@@ -1,19 +1,28 @@
 package website
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"io"
 	"net/http"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"git.handmade.network/hmn/hmn/src/assets"
+	"git.handmade.network/hmn/hmn/src/db"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
 	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/utils"
+	"github.com/google/uuid"
 )
 
 type AssetUploadResult struct {
@@ -35,6 +44,20 @@ func AssetMaxSize(user *models.User) int {
 
 func AssetUpload(c *RequestContext) ResponseData {
 	maxFilesize := AssetMaxSize(c.CurrentUser)
+	private := c.Req.URL.Query().Get("private") == "true"
+
+	// The only editor that uploads private files is the one for unpublished
+	// education articles, so nobody else has any business doing it.
+	if private && !c.CurrentUser.CanAuthorEducation() {
+		res := ResponseData{
+			StatusCode: http.StatusForbidden,
+		}
+		jsonString, _ := json.Marshal(AssetUploadResult{
+			Error: "You can't upload private files.",
+		})
+		res.Write(jsonString)
+		return res
+	}
 
 	contentLength, hasLength := c.Req.Header["Content-Length"]
 	if hasLength {
@@ -60,9 +83,13 @@ func AssetUpload(c *RequestContext) ResponseData {
 		}
 	}
 
-	bodyReader := http.MaxBytesReader(c.Res, c.Req.Body, int64(maxFilesize))
-	data, err := io.ReadAll(bodyReader)
-	if err != nil {
+	if lengthHeader := c.Req.Header.Get("Hmn-Upload-Length"); lengthHeader != "" {
+		return assetUploadStart(c, originalFilename, lengthHeader, maxFilesize, private)
+	}
+
+	bodyReader := bufio.NewReaderSize(http.MaxBytesReader(c.Res, c.Req.Body, int64(maxFilesize)), 512)
+	head, err := bodyReader.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
 		res := ResponseData{
 			StatusCode: http.StatusBadRequest,
 			Errors:     []error{err},
@@ -70,11 +97,24 @@ func AssetUpload(c *RequestContext) ResponseData {
 		return res
 	}
 
-	mimeType := http.DetectContentType(data)
+	mimeType := http.DetectContentType(head)
+	var content io.Reader = bodyReader
 	width := 0
 	height := 0
 
 	if strings.HasPrefix(mimeType, "image") {
+		// Images are small enough to read fully, which we need to do to get
+		// their dimensions. Everything else is streamed.
+		data, err := io.ReadAll(bodyReader)
+		if err != nil {
+			res := ResponseData{
+				StatusCode: http.StatusBadRequest,
+				Errors:     []error{err},
+			}
+			return res
+		}
+		content = bytes.NewReader(data)
+
 		config, _, err := image.DecodeConfig(bytes.NewReader(data))
 		if err == nil {
 			width = config.Width
@@ -86,12 +126,13 @@ func AssetUpload(c *RequestContext) ResponseData {
 	}
 
 	asset, err := assets.Create(c, c.Conn, assets.CreateInput{
-		Content:     data,
+		Content:     content,
 		Filename:    originalFilename,
 		ContentType: mimeType,
 		UploaderID:  &c.CurrentUser.ID,
 		Width:       width,
 		Height:      height,
+		Private:     private,
 	})
 
 	if err != nil {
@@ -106,9 +147,305 @@ func AssetUpload(c *RequestContext) ResponseData {
 		StatusCode: http.StatusOK,
 	}
 	jsonString, err := json.Marshal(AssetUploadResult{
-		Url:  hmnurl.BuildS3Asset(asset.S3Key),
+		Url:  hmnurl.BuildAssetObject(asset, asset.S3Key),
 		Mime: asset.MimeType,
 	})
 	res.Write(jsonString)
 	return res
 }
+
+/*
+Large files can be uploaded in chunks so that a flaky connection doesn't mean
+starting over. To start, POST to the normal upload URL with an empty body and
+the file size in Hmn-Upload-Length. The response says where to send chunks and
+how big they must be.
+
+Each chunk is a PATCH to the chunk URL, with Hmn-Upload-Offset saying where in
+the file it starts. After a failure, a HEAD request to the chunk URL returns
+the offset to resume from in Hmn-Upload-Offset. The response to the final
+chunk is the same as for a normal upload.
+*/
+
+type AssetUploadStartResult struct {
+	ChunkUrl  string `json:"chunkUrl,omitempty"`
+	ChunkSize int    `json:"chunkSize,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type AssetUploadChunkResult struct {
+	AssetUploadResult
+	Received int64 `json:"received"`
+}
+
+const uploadOffsetHeader = "Hmn-Upload-Offset"
+
+func assetUploadStart(c *RequestContext, filename string, lengthHeader string, maxFilesize int, private bool) ResponseData {
+	var res ResponseData
+
+	size, err := strconv.ParseInt(lengthHeader, 10, 64)
+	if err != nil || size <= 0 {
+		res.StatusCode = http.StatusBadRequest
+		res.WriteJson(AssetUploadStartResult{Error: "Invalid upload length."}, c.Perf)
+		return res
+	}
+	if size > int64(maxFilesize) {
+		res.StatusCode = http.StatusOK
+		res.WriteJson(AssetUploadStartResult{
+			Error: fmt.Sprintf("Filesize too big. Maximum size is %d.", maxFilesize),
+		}, c.Perf)
+		return res
+	}
+
+	upload, err := assets.StartUpload(c, c.Conn, filename, size, c.CurrentUser.ID, private)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	res.StatusCode = http.StatusOK
+	res.WriteJson(AssetUploadStartResult{
+		ChunkUrl:  c.UrlContext.BuildAssetUploadChunk(upload.ID.String()),
+		ChunkSize: assets.UploadChunkSize,
+	}, c.Perf)
+	return res
+}
+
+func AssetUploadChunk(c *RequestContext) ResponseData {
+	upload, ok := fetchAssetUpload(c)
+	if !ok {
+		return FourOhFour(c)
+	}
+
+	var res ResponseData
+	offset, err := strconv.ParseInt(c.Req.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil {
+		res.StatusCode = http.StatusBadRequest
+		res.WriteJson(AssetUploadChunkResult{
+			AssetUploadResult: AssetUploadResult{Error: "Missing or invalid upload offset."},
+			Received:          upload.Received,
+		}, c.Perf)
+		return res
+	}
+
+	body := http.MaxBytesReader(c.Res, c.Req.Body, assets.UploadChunkSize)
+	asset, err := assets.WriteUploadChunk(c, c.Conn, upload, offset, body)
+	res.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Received, 10))
+	if errors.Is(err, assets.UploadOffsetMismatch) {
+		res.StatusCode = http.StatusConflict
+		res.WriteJson(AssetUploadChunkResult{
+			AssetUploadResult: AssetUploadResult{Error: err.Error()},
+			Received:          upload.Received,
+		}, c.Perf)
+		return res
+	} else if err != nil {
+		res.StatusCode = http.StatusBadRequest
+		res.Errors = []error{err}
+		return res
+	}
+
+	result := AssetUploadChunkResult{Received: upload.Received}
+	if asset != nil {
+		result.Url = hmnurl.BuildAssetObject(asset, asset.S3Key)
+		result.Mime = asset.MimeType
+	}
+	res.StatusCode = http.StatusOK
+	res.WriteJson(result, c.Perf)
+	return res
+}
+
+func AssetUploadStatus(c *RequestContext) ResponseData {
+	upload, ok := fetchAssetUpload(c)
+	if !ok {
+		return FourOhFour(c)
+	}
+
+	res := ResponseData{StatusCode: http.StatusNoContent}
+	res.Header().Set(uploadOffsetHeader, strconv.FormatInt(upload.Received, 10))
+	return res
+}
+
+func fetchAssetUpload(c *RequestContext) (*models.AssetUpload, bool) {
+	uploadID, err := uuid.Parse(c.PathParams["uploadid"])
+	if err != nil {
+		return nil, false
+	}
+	upload, err := assets.FetchUpload(c, c.Conn, uploadID, c.CurrentUser.ID)
+	if err != nil {
+		if !errors.Is(err, db.NotFound) {
+			c.Logger.Error().Err(err).Msg("failed to fetch asset upload")
+		}
+		return nil, false
+	}
+	return upload, true
+}
+
+// How long the presigned URLs handed out for private assets work. Long enough
+// for a browser to follow the redirect and stream a video, short enough that
+// a leaked URL soon stops working.
+const privateAssetUrlExpiry = 5 * time.Minute
+
+// Redirects to one of an asset's files. For private assets, this checks that
+// the current user is allowed to see the asset and redirects to a short-lived
+// presigned URL.
+func AssetFile(c *RequestContext) ResponseData {
+	assetID, err := uuid.Parse(c.PathParams["assetid"])
+	if err != nil {
+		return FourOhFour(c)
+	}
+	asset, err := db.QueryOne[models.Asset](c, c.Conn,
+		`
+		SELECT $columns
+		FROM asset
+		WHERE id = $1
+		`,
+		assetID,
+	)
+	if errors.Is(err, db.NotFound) {
+		return FourOhFour(c)
+	} else if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch asset"))
+	}
+
+	key := utils.OrDefault(c.Req.URL.Query().Get("file"), asset.S3Key)
+	if !slices.Contains(assets.ObjectKeys(asset), key) {
+		return FourOhFour(c)
+	}
+
+	if !asset.Private {
+		return c.Redirect(hmnurl.BuildS3Asset(key), http.StatusFound)
+	}
+
+	// Private assets pretend not to exist for people who can't see them.
+	canSee, err := canSeePrivateAsset(c, asset)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	if !canSee {
+		return FourOhFour(c)
+	}
+
+	presignedUrl, err := assets.PresignedURL(c, key, privateAssetUrlExpiry)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	res := c.Redirect(presignedUrl, http.StatusFound)
+	res.Header().Set("Cache-Control", "private, no-store")
+	return res
+}
+
+// People can see a private asset if they uploaded it, own a project that uses
+// it, or can see the unpublished education article it appears in.
+func canSeePrivateAsset(c *RequestContext, asset *models.Asset) (bool, error) {
+	if c.CurrentUser == nil {
+		return false, nil
+	}
+	if c.CurrentUser.IsStaff {
+		return true, nil
+	}
+	if asset.UploaderID != nil && *asset.UploaderID == c.CurrentUser.ID {
+		return true, nil
+	}
+
+	ownsProject, err := db.QueryOneScalar[bool](c, c.Conn,
+		`
+		SELECT EXISTS (
+			SELECT 1
+			FROM
+				user_project
+				JOIN project ON project.id = user_project.project_id
+			WHERE
+				user_project.user_id = $2
+				AND (
+					project.logo_asset_id = $1
+					OR project.header_asset_id = $1
+					OR EXISTS (
+						SELECT 1
+						FROM project_screenshot
+						WHERE project_screenshot.project_id = project.id AND project_screenshot.asset_id = $1
+					)
+				)
+		)
+		`,
+		asset.ID,
+		c.CurrentUser.ID,
+	)
+	if err != nil {
+		return false, oops.New(err, "failed to check if user owns a project using asset")
+	}
+	if ownsProject {
+		return true, nil
+	}
+
+	if c.CurrentUser.CanSeeUnpublishedEducationContent() {
+		inArticle, err := db.QueryOneScalar[bool](c, c.Conn,
+			`
+			SELECT EXISTS (
+				SELECT 1
+				FROM
+					education_article AS art
+					JOIN education_article_version AS ver ON ver.id = art.current_version
+				WHERE ver.content_raw LIKE '%' || $1 || '%'
+			)
+			`,
+			hmnurl.BuildAssetFile(asset.ID.String(), ""),
+		)
+		if err != nil {
+			return false, oops.New(err, "failed to check if asset is used in an education article")
+		}
+		if inArticle {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Serves asset files when they are stored on local disk instead of in S3.
+func LocalAsset(c *RequestContext) ResponseData {
+	key := c.PathParams["key"]
+
+	query := c.Req.URL.Query()
+	signed := query.Has("signature")
+	if signed && !assets.CheckLocalSignature(key, query) {
+		return ResponseData{StatusCode: http.StatusForbidden}
+	}
+
+	file, err := assets.OpenLocalObject(key, signed)
+	if errors.Is(err, assets.ObjectNotFound) {
+		return FourOhFour(c)
+	} else if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to open local asset"))
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to stat local asset"))
+	}
+	if info.IsDir() {
+		return FourOhFour(c)
+	}
+
+	var res ResponseData
+	if signed {
+		res.Header().Set("Cache-Control", "private, max-age=60")
+	} else {
+		res.Header().Set("Cache-Control", "public, max-age=604800")
+	}
+	res.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	// These are user uploads served from our own origin, so make sure nobody
+	// can get a browser to run them as a page.
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.Header().Set("Content-Security-Policy", "sandbox")
+
+	addCORSHeaders(c, &res)
+
+	// Files can be huge videos, so they are streamed straight to the client
+	// instead of being buffered like other responses. ServeContent handles
+	// HEAD, ranges, and conditional requests.
+	for name, vals := range res.Header() {
+		c.Res.Header()[name] = vals
+	}
+	http.ServeContent(c.Res, c.Req, path.Base(key), info.ModTime(), file)
+	res.hijacked = true
+	return res
+}
@@ -1,17 +1,16 @@
 package website
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
-	"git.handmade.network/hmn/hmn/src/assets"
 	"git.handmade.network/hmn/hmn/src/config"
 	"git.handmade.network/hmn/hmn/src/db"
 	"git.handmade.network/hmn/hmn/src/discord"
+	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
 	"git.handmade.network/hmn/hmn/src/models"
 	"git.handmade.network/hmn/hmn/src/oops"
@@ -222,7 +221,7 @@ func DiscordOAuthCallback(c *RequestContext) ResponseData {
 		var avatarAssetID *uuid.UUID
 		if avatarHash != nil {
 			// Note! Not using the transaction here. Don't want to fail the login due to avatars.
-			if avatarAsset, err := saveDiscordAvatar(c, c.Conn, user.ID, *user.Avatar); err == nil {
+			if avatarAsset, err := discord.SaveAvatar(c, c.Conn, user.ID, *user.Avatar); err == nil {
 				avatarAssetID = &avatarAsset.ID
 			} else {
 				c.Logger.Warn().Err(err).Msg("failed to save Discord avatar")
@@ -309,12 +308,9 @@ func DiscordOAuthCallback(c *RequestContext) ResponseData {
 		)
 	}
 
-	// Add the role on Discord
+	// Give them their roles on Discord
 	if hmnMember != nil {
-		err = discord.AddGuildMemberRole(c, user.ID, config.Config.Discord.MemberRoleID)
-		if err != nil {
-			c.Logger.Error().Err(err).Msg("failed to add member role")
-		}
+		discord.QueueRoleSync(user.ID)
 	}
 
 	// We only expect direct URLs to HMN pages, or values that were sanitized on their way into
@@ -368,10 +364,8 @@ func DiscordUnlink(c *RequestContext) ResponseData {
 		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to commit Discord user delete"))
 	}
 
-	err = discord.RemoveGuildMemberRole(c, discordUser.UserID, config.Config.Discord.MemberRoleID)
-	if err != nil {
-		c.Logger.Warn().Err(err).Msg("failed to remove member role on unlink")
-	}
+	// Now that they're unlinked, the sync will take away their roles.
+	discord.QueueRoleSync(discordUser.UserID)
 
 	return c.Redirect(hmnurl.BuildUserSettings("discord"), http.StatusSeeOther)
 }
@@ -423,43 +417,63 @@ func DiscordShowcaseBacklog(c *RequestContext) ResponseData {
 	return c.Redirect(hmnurl.BuildUserProfile(c.CurrentUser.Username), http.StatusSeeOther)
 }
 
-func saveDiscordAvatar(ctx context.Context, conn db.ConnOrTx, userID, avatarHash string) (*models.Asset, error) {
-	const size = 256
-
-	filename := fmt.Sprintf("%s.png", avatarHash)
-	url := fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s?size=%d", userID, filename, size)
-
-	content, _, err := discord.DownloadDiscordResource(ctx, url)
-	if err != nil {
-		return nil, oops.New(err, "failed to download Discord avatar")
+func DiscordBotDebugPage(c *RequestContext) ResponseData {
+	type backfillRange struct {
+		hmndata.DiscordBackfillRange
+		ReachedTime time.Time // when the last handled message was sent
+		BeforeTime  time.Time
 	}
-
-	asset, err := assets.Create(ctx, conn, assets.CreateInput{
-		Content:     content,
-		Filename:    filename,
-		ContentType: "image/png",
-
-		Width:  size,
-		Height: size,
-	})
-	if err != nil {
-		return nil, oops.New(err, "failed to save asset for Discord attachment")
+	type backfill struct {
+		ChannelID    string
+		ChannelName  string
+		Ranges       []backfillRange
+		LastFinished *time.Time
 	}
-
-	return asset, nil
-}
-
-func DiscordBotDebugPage(c *RequestContext) ResponseData {
 	type DiscordBotDebugData struct {
 		templates.BaseData
 		BotEvents []discord.BotEvent
+		Backfills []backfill
 	}
 	botEvents := discord.GetBotEvents()
-	var res ResponseData
-	res.MustWriteTemplate("discord_bot_debug.html", DiscordBotDebugData{
+
+	backfills, err := discord.FetchBackfills(c, c.Conn)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	channels, err := discord.FetchChannels(c, c.Conn)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	channelNames := make(map[string]string)
+	for _, channel := range channels {
+		channelNames[channel.ChannelID] = channel.Name
+	}
+
+	data := DiscordBotDebugData{
 		BaseData: getBaseData(c, "", nil),
 
 		BotEvents: botEvents,
-	}, c.Perf)
+	}
+	for _, b := range backfills {
+		tmplBackfill := backfill{
+			ChannelID:    b.ChannelID,
+			ChannelName:  channelNames[b.ChannelID],
+			LastFinished: b.LastFinished,
+		}
+		for _, r := range b.Ranges {
+			tmplRange := backfillRange{
+				DiscordBackfillRange: r,
+				ReachedTime:          discord.SnowflakeTime(r.After),
+			}
+			if r.Before != "" {
+				tmplRange.BeforeTime = discord.SnowflakeTime(r.Before)
+			}
+			tmplBackfill.Ranges = append(tmplBackfill.Ranges, tmplRange)
+		}
+		data.Backfills = append(data.Backfills, tmplBackfill)
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("discord_bot_debug.html", data, c.Perf)
 	return res
 }
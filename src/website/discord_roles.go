@@ -0,0 +1,61 @@
+package website
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/discord"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/templates"
+)
+
+// Shows what the last role sync did. Working out what a sync would change right
+// now means paging through every member of the guild, so that only happens
+// when someone asks for a preview.
+func AdminDiscordRoles(c *RequestContext) ResponseData {
+	type AdminDiscordRolesData struct {
+		templates.BaseData
+		Roles      []discord.ManagedRole
+		Previewing bool
+		Pending    []discord.RoleChange
+		PendingErr error
+		LastSync   *discord.RoleSyncReport
+		SubmitUrl  string
+	}
+
+	data := AdminDiscordRolesData{
+		BaseData:  getBaseData(c, "Discord Roles", nil),
+		Roles:     discord.ManagedRoles(),
+		LastSync:  discord.LastRoleSync(),
+		SubmitUrl: hmnurl.BuildAdminDiscordRoles(),
+	}
+	if c.Req.URL.Query().Get("preview") == "true" {
+		data.Previewing = true
+		// Discord being unreachable shouldn't take the whole page down.
+		data.Pending, data.PendingErr = discord.PlanGuildRoleChanges(c, c.Conn)
+		if data.PendingErr != nil {
+			c.Logger.Error().Err(data.PendingErr).Msg("failed to plan Discord role changes")
+		}
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("admin_discord_roles.html", data, c.Perf)
+	return res
+}
+
+func AdminDiscordRolesSubmit(c *RequestContext) ResponseData {
+	start := time.Now()
+	report := discord.SyncGuildRoles(c, c.Conn)
+	if report.Err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, report.Err)
+	}
+
+	res := c.Redirect(hmnurl.BuildAdminDiscordRoles(), http.StatusSeeOther)
+	if report.Failed > 0 {
+		res.AddFutureNotice("warn", fmt.Sprintf("Made %d role changes, but %d failed. Check the logs.", len(report.Changes)-report.Failed, report.Failed))
+	} else {
+		res.AddFutureNotice("success", fmt.Sprintf("Made %d role changes in %s.", len(report.Changes), time.Since(start).Round(time.Second)))
+	}
+	return res
+}
@@ -0,0 +1,461 @@
+package website
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/discord"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/templates"
+)
+
+// How many stored messages a rule is tested against.
+const discordRuleTestMessages = 500
+
+type adminDiscordChannel struct {
+	Channel *models.DiscordChannel
+	Url     string
+}
+
+type adminDiscordRule struct {
+	Rule          *models.DiscordChannelRule // nil for a new rule
+	AllKinds      []models.DiscordChannelRuleKind
+	Domains       string
+	ExemptRoleIDs string
+	AppliesAfter  string
+	SubmitUrl     string
+	TestUrl       string
+}
+
+func AdminDiscordChannels(c *RequestContext) ResponseData {
+	type AdminDiscordChannelsData struct {
+		templates.BaseData
+		Channels  []adminDiscordChannel
+		SubmitUrl string
+	}
+
+	channels, err := discord.FetchChannels(c, c.Conn)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	data := AdminDiscordChannelsData{
+		BaseData:  getBaseData(c, "Discord Channels", nil),
+		SubmitUrl: hmnurl.BuildAdminDiscordChannels(),
+	}
+	for _, channel := range channels {
+		data.Channels = append(data.Channels, adminDiscordChannel{
+			Channel: channel,
+			Url:     hmnurl.BuildAdminDiscordChannel(channel.ChannelID),
+		})
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("admin_discord_channels.html", data, c.Perf)
+	return res
+}
+
+var reDiscordSnowflake = regexp.MustCompile(`^[0-9]+$`)
+
+func AdminDiscordChannelsSubmit(c *RequestContext) ResponseData {
+	form, err := c.GetFormValues()
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to parse channel form"))
+	}
+
+	channelID := strings.TrimSpace(form.Get("channel_id"))
+	if !reDiscordSnowflake.MatchString(channelID) {
+		return c.RejectRequest("Discord channel IDs are numbers. Turn on developer mode in Discord to copy them.")
+	}
+	name := strings.TrimPrefix(strings.TrimSpace(form.Get("name")), "#")
+	if name == "" {
+		return c.RejectRequest("Channels must have a name.")
+	}
+
+	_, err = c.Conn.Exec(c,
+		`
+		INSERT INTO discord_channel (channel_id, name)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+		`,
+		channelID,
+		name,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to add Discord channel"))
+	}
+	discord.ChannelRulesUpdated()
+
+	return c.Redirect(hmnurl.BuildAdminDiscordChannel(channelID), http.StatusSeeOther)
+}
+
+func AdminDiscordChannel(c *RequestContext) ResponseData {
+	type AdminDiscordChannelData struct {
+		templates.BaseData
+		Channel      *models.DiscordChannel
+		SubmitUrl    string
+		NewRuleUrl   string
+		Rules        []adminDiscordRule
+		NewRule      adminDiscordRule
+		AutoSnippets []models.DiscordChannelAutoSnippet
+	}
+
+	channel, rules, errRes := fetchAdminDiscordChannel(c)
+	if errRes != nil {
+		return *errRes
+	}
+
+	data := AdminDiscordChannelData{
+		BaseData: getBaseData(c, fmt.Sprintf("#%s", channel.Name), []templates.Breadcrumb{
+			{Name: "Discord Channels", Url: hmnurl.BuildAdminDiscordChannels()},
+		}),
+		Channel:      channel,
+		SubmitUrl:    hmnurl.BuildAdminDiscordChannel(channel.ChannelID),
+		NewRuleUrl:   hmnurl.BuildAdminDiscordChannelRuleNew(channel.ChannelID),
+		NewRule:      adminDiscordRule{AllKinds: models.AllDiscordChannelRuleKinds},
+		AutoSnippets: models.AllDiscordChannelAutoSnippets,
+	}
+	for _, rule := range rules {
+		appliesAfter := ""
+		if rule.AppliesAfter != nil {
+			appliesAfter = rule.AppliesAfter.UTC().Format(time.DateOnly)
+		}
+		data.Rules = append(data.Rules, adminDiscordRule{
+			Rule:          rule,
+			AllKinds:      models.AllDiscordChannelRuleKinds,
+			Domains:       strings.Join(rule.Domains, ", "),
+			ExemptRoleIDs: strings.Join(rule.ExemptRoleIDs, ", "),
+			AppliesAfter:  appliesAfter,
+			SubmitUrl:     hmnurl.BuildAdminDiscordChannelRule(channel.ChannelID, rule.ID),
+			TestUrl:       hmnurl.BuildAdminDiscordChannelRuleTest(channel.ChannelID, rule.ID),
+		})
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("admin_discord_channel.html", data, c.Perf)
+	return res
+}
+
+func AdminDiscordChannelSubmit(c *RequestContext) ResponseData {
+	channel, _, errRes := fetchAdminDiscordChannel(c)
+	if errRes != nil {
+		return *errRes
+	}
+
+	form, err := c.GetFormValues()
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to parse channel form"))
+	}
+
+	if strings.ToLower(form.Get("action")) == "delete" {
+		_, err = c.Conn.Exec(c, `DELETE FROM discord_channel WHERE channel_id = $1`, channel.ChannelID)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to delete Discord channel"))
+		}
+		discord.ChannelRulesUpdated()
+		res := c.Redirect(hmnurl.BuildAdminDiscordChannels(), http.StatusSeeOther)
+		res.AddFutureNotice("success", fmt.Sprintf("The bot will now ignore #%s.", channel.Name))
+		return res
+	}
+
+	name := strings.TrimPrefix(strings.TrimSpace(form.Get("name")), "#")
+	if name == "" {
+		return c.RejectRequest("Channels must have a name.")
+	}
+	autoSnippet, err := strconv.Atoi(form.Get("auto_snippet"))
+	if err != nil {
+		return c.RejectRequest("Invalid snippet policy.")
+	}
+
+	_, err = c.Conn.Exec(c,
+		`
+		UPDATE discord_channel SET
+			name = $2,
+			store_messages = $3,
+			auto_snippet = $4
+		WHERE channel_id = $1
+		`,
+		channel.ChannelID,
+		name,
+		form.Get("store_messages") != "",
+		models.DiscordChannelAutoSnippet(autoSnippet),
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to update Discord channel"))
+	}
+	discord.ChannelRulesUpdated()
+
+	res := c.Redirect(hmnurl.BuildAdminDiscordChannel(channel.ChannelID), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Channel updated.")
+	return res
+}
+
+func AdminDiscordChannelRuleNewSubmit(c *RequestContext) ResponseData {
+	channel, rules, errRes := fetchAdminDiscordChannel(c)
+	if errRes != nil {
+		return *errRes
+	}
+
+	form, rejection, err := parseDiscordRuleForm(c)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
+
+	// New rules go last, and start out disabled so they can be tested first.
+	ordering := 0
+	for _, rule := range rules {
+		ordering = max(ordering, rule.Ordering+1)
+	}
+	_, err = c.Conn.Exec(c,
+		`
+		INSERT INTO discord_channel_rule (channel_id, kind, domains, rebuke_message, exempt_role_ids, applies_after, enabled, ordering)
+		VALUES ($1, $2, $3, $4, $5, $6, FALSE, $7)
+		`,
+		channel.ChannelID,
+		form.Kind, form.Domains, form.RebukeMessage, form.ExemptRoleIDs, form.AppliesAfter,
+		ordering,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to create Discord channel rule"))
+	}
+	discord.ChannelRulesUpdated()
+
+	res := c.Redirect(hmnurl.BuildAdminDiscordChannel(channel.ChannelID), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Rule created. Test it, then enable it.")
+	return res
+}
+
+func AdminDiscordChannelRuleSubmit(c *RequestContext) ResponseData {
+	channel, rule, errRes := fetchAdminDiscordChannelRule(c)
+	if errRes != nil {
+		return *errRes
+	}
+
+	if strings.ToLower(c.Req.PostFormValue("action")) == "delete" {
+		_, err := c.Conn.Exec(c, `DELETE FROM discord_channel_rule WHERE id = $1`, rule.ID)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to delete Discord channel rule"))
+		}
+		discord.ChannelRulesUpdated()
+		res := c.Redirect(hmnurl.BuildAdminDiscordChannel(channel.ChannelID), http.StatusSeeOther)
+		res.AddFutureNotice("success", "Rule deleted.")
+		return res
+	}
+
+	form, rejection, err := parseDiscordRuleForm(c)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
+
+	_, err = c.Conn.Exec(c,
+		`
+		UPDATE discord_channel_rule SET
+			kind = $2,
+			domains = $3,
+			rebuke_message = $4,
+			exempt_role_ids = $5,
+			applies_after = $6,
+			enabled = $7,
+			ordering = $8
+		WHERE id = $1
+		`,
+		rule.ID,
+		form.Kind, form.Domains, form.RebukeMessage, form.ExemptRoleIDs, form.AppliesAfter,
+		form.Enabled, form.Ordering,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to update Discord channel rule"))
+	}
+	discord.ChannelRulesUpdated()
+
+	res := c.Redirect(hmnurl.BuildAdminDiscordChannel(channel.ChannelID), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Rule updated.")
+	return res
+}
+
+func AdminDiscordChannelRuleTest(c *RequestContext) ResponseData {
+	type AdminDiscordRuleTestData struct {
+		templates.BaseData
+		Channel  *models.DiscordChannel
+		Rule     *models.DiscordChannelRule
+		Checked  int
+		Affected []discord.RuleTestResult
+	}
+
+	channel, rule, errRes := fetchAdminDiscordChannelRule(c)
+	if errRes != nil {
+		return *errRes
+	}
+
+	results, err := discord.TestChannelRule(c, c.Conn, rule, discordRuleTestMessages)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	data := AdminDiscordRuleTestData{
+		BaseData: getBaseData(c, fmt.Sprintf("Testing \"%s\"", rule.Kind.Description()), []templates.Breadcrumb{
+			{Name: "Discord Channels", Url: hmnurl.BuildAdminDiscordChannels()},
+			{Name: fmt.Sprintf("#%s", channel.Name), Url: hmnurl.BuildAdminDiscordChannel(channel.ChannelID)},
+		}),
+		Channel: channel,
+		Rule:    rule,
+		Checked: len(results),
+	}
+	for _, result := range results {
+		if result.Broken || result.SuppressEmbeds {
+			data.Affected = append(data.Affected, result)
+		}
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("admin_discord_rule_test.html", data, c.Perf)
+	return res
+}
+
+func fetchAdminDiscordChannel(c *RequestContext) (*models.DiscordChannel, []*models.DiscordChannelRule, *ResponseData) {
+	channel, err := discord.FetchChannel(c, c.Conn, c.PathParams["channelid"])
+	if err != nil {
+		res := c.ErrorResponse(http.StatusInternalServerError, err)
+		return nil, nil, &res
+	}
+	if channel == nil {
+		res := FourOhFour(c)
+		return nil, nil, &res
+	}
+
+	rules, err := discord.FetchChannelRules(c, c.Conn, channel.ChannelID, false)
+	if err != nil {
+		res := c.ErrorResponse(http.StatusInternalServerError, err)
+		return nil, nil, &res
+	}
+
+	return channel, rules, nil
+}
+
+func fetchAdminDiscordChannelRule(c *RequestContext) (*models.DiscordChannel, *models.DiscordChannelRule, *ResponseData) {
+	channel, _, errRes := fetchAdminDiscordChannel(c)
+	if errRes != nil {
+		return nil, nil, errRes
+	}
+
+	ruleID, err := strconv.Atoi(c.PathParams["ruleid"])
+	if err != nil {
+		res := FourOhFour(c)
+		return nil, nil, &res
+	}
+	rule, err := db.QueryOne[models.DiscordChannelRule](c, c.Conn,
+		`
+		SELECT $columns
+		FROM discord_channel_rule
+		WHERE id = $1 AND channel_id = $2
+		`,
+		ruleID,
+		channel.ChannelID,
+	)
+	if errors.Is(err, db.NotFound) {
+		res := FourOhFour(c)
+		return nil, nil, &res
+	} else if err != nil {
+		res := c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch Discord channel rule"))
+		return nil, nil, &res
+	}
+
+	return channel, rule, nil
+}
+
+type discordRuleForm struct {
+	Kind          models.DiscordChannelRuleKind
+	Domains       []string
+	RebukeMessage string
+	ExemptRoleIDs []string
+	AppliesAfter  *time.Time
+	Enabled       bool
+	Ordering      int
+}
+
+func parseDiscordRuleForm(c *RequestContext) (discordRuleForm, string, error) {
+	var res discordRuleForm
+
+	form, err := c.GetFormValues()
+	if err != nil {
+		return res, "", oops.New(err, "failed to parse rule form")
+	}
+
+	kind, err := strconv.Atoi(form.Get("kind"))
+	if err != nil {
+		return res, "Invalid rule kind.", nil
+	}
+	res.Kind = models.DiscordChannelRuleKind(kind)
+	found := false
+	for _, k := range models.AllDiscordChannelRuleKinds {
+		if k == res.Kind {
+			found = true
+		}
+	}
+	if !found {
+		return res, "Invalid rule kind.", nil
+	}
+
+	res.Domains = splitAdminList(form.Get("domains"))
+	for i, domain := range res.Domains {
+		domain = strings.ToLower(domain)
+		domain = strings.TrimPrefix(domain, "https://")
+		domain = strings.TrimPrefix(domain, "http://")
+		domain = strings.TrimPrefix(domain, "www.")
+		res.Domains[i] = strings.TrimSuffix(domain, "/")
+	}
+	if res.Kind.UsesDomains() && len(res.Domains) == 0 {
+		return res, "This kind of rule needs at least one domain.", nil
+	}
+
+	res.RebukeMessage = strings.TrimSpace(form.Get("rebuke_message"))
+	if res.Kind != models.DiscordChannelRuleSuppressEmbeds && res.RebukeMessage == "" {
+		return res, "Rules that delete messages need a message to tell the author why.", nil
+	}
+
+	res.ExemptRoleIDs = splitAdminList(form.Get("exempt_role_ids"))
+	for _, roleID := range res.ExemptRoleIDs {
+		if !reDiscordSnowflake.MatchString(roleID) {
+			return res, "Exempt roles must be role IDs, not names.", nil
+		}
+	}
+
+	if appliesAfter := strings.TrimSpace(form.Get("applies_after")); appliesAfter != "" {
+		t, err := time.Parse(time.DateOnly, appliesAfter)
+		if err != nil {
+			return res, "Invalid date.", nil
+		}
+		res.AppliesAfter = &t
+	}
+
+	res.Enabled = form.Get("enabled") != ""
+	res.Ordering, _ = strconv.Atoi(form.Get("ordering"))
+
+	return res, "", nil
+}
+
+// Splits a comma- or space-separated list typed into a form. Never returns
+// nil, so that it can go straight into a NOT NULL array column.
+func splitAdminList(s string) []string {
+	items := []string{}
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -12,12 +12,16 @@ import (
 	"strings"
 	"time"
 
+	"git.handmade.network/hmn/hmn/src/assets"
 	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
 	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
 	"git.handmade.network/hmn/hmn/src/parsing"
 	"git.handmade.network/hmn/hmn/src/templates"
 	"git.handmade.network/hmn/hmn/src/utils"
+	"github.com/jackc/pgx/v5"
 )
 
 func EducationIndex(c *RequestContext) ResponseData {
@@ -134,12 +138,18 @@ func EducationArticle(c *RequestContext) ResponseData {
 		return c.ErrorResponse(http.StatusInternalServerError, err)
 	}
 
+	topics, err := hmndata.FetchEduArticleTopics(c, c.Conn, article.ID)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
 	tmpl := articleData{
 		BaseData:  getBaseData(c, article.Title, nil),
 		Article:   templates.EducationArticleToTemplate(article),
 		EditUrl:   hmnurl.BuildEducationArticleEdit(article.Slug),
 		DeleteUrl: hmnurl.BuildEducationArticleDelete(article.Slug),
 	}
+	tmpl.Article.Topics = templates.TopicsToTemplate(topics)
 	tmpl.OpenGraphItems = append(tmpl.OpenGraphItems,
 		templates.OpenGraphItem{Property: "og:description", Value: string(article.Description)},
 	)
@@ -198,6 +208,13 @@ func EducationArticleNewSubmit(c *RequestContext) ResponseData {
 	}
 
 	art, ver := getEduArticleFromForm(form)
+	topics, rejection, err := topicsFromForm(c, c.Conn, form.Get("topics"))
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
 
 	dupe := 0 < utils.Must1(db.QueryOneScalar[int](c, c.Conn,
 		`
@@ -210,7 +227,7 @@ func EducationArticleNewSubmit(c *RequestContext) ResponseData {
 		return c.RejectRequest("A resource already exists with that slug.")
 	}
 
-	createEduArticle(c, art, ver)
+	createEduArticle(c, art, ver, topics)
 
 	res := c.Redirect(eduArticleURL(&art), http.StatusSeeOther)
 	res.AddFutureNotice("success", "Created new education article.")
@@ -230,10 +247,16 @@ func EducationArticleEdit(c *RequestContext) ResponseData {
 		panic(err)
 	}
 
+	topics, err := hmndata.FetchEduArticleTopics(c, c.Conn, article.ID)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
 	tmpl := adminData{
 		editorData: getEditorDataForEduArticle(c.UrlContext, c.CurrentUser, getBaseData(c, "Edit Education Article", nil), article),
 		Article:    templates.EducationArticleToTemplate(article),
 	}
+	tmpl.Article.Topics = templates.TopicsToTemplate(topics)
 	tmpl.editorData.SubmitUrl = hmnurl.BuildEducationArticleEdit(c.PathParams["slug"])
 
 	var res ResponseData
@@ -255,7 +278,14 @@ func EducationArticleEditSubmit(c *RequestContext) ResponseData {
 	}
 
 	art, ver := getEduArticleFromForm(form)
-	updateEduArticle(c, c.PathParams["slug"], art, ver)
+	topics, rejection, err := topicsFromForm(c, c.Conn, form.Get("topics"))
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
+	updateEduArticle(c, c.PathParams["slug"], art, ver, topics)
 
 	res := c.Redirect(eduArticleURL(&art), http.StatusSeeOther)
 	res.AddFutureNotice("success", "Edited education article.")
@@ -320,6 +350,7 @@ func EducationRerender(c *RequestContext) ResponseData {
 
 type EduArticleQuery struct {
 	Types              []models.EduArticleType
+	TopicIDs           []int
 	IncludeUnpublished bool // If true, unpublished articles will be fetched even if they would not otherwise be visible
 }
 
@@ -346,6 +377,9 @@ func fetchEduArticles(
 	if len(q.Types) > 0 {
 		qb.Add(`AND a.type = ANY($?)`, q.Types)
 	}
+	if len(q.TopicIDs) > 0 {
+		qb.Add(`AND a.id IN (SELECT article_id FROM education_article_topic WHERE topic_id = ANY($?))`, q.TopicIDs)
+	}
 	if (currentUser == nil || !currentUser.CanSeeUnpublishedEducationContent()) && !q.IncludeUnpublished {
 		qb.Add(`AND a.published`)
 	}
@@ -411,6 +445,13 @@ func getEditorDataForEduArticle(
 	baseData templates.BaseData,
 	article *models.EduArticle,
 ) editorData {
+	// Media for unpublished articles stays private until the article is
+	// published.
+	uploadUrl := urlContext.BuildPrivateAssetUpload()
+	if article != nil && article.Published {
+		uploadUrl = urlContext.BuildAssetUpload()
+	}
+
 	result := editorData{
 		BaseData:    baseData,
 		SubmitLabel: "Submit",
@@ -422,7 +463,7 @@ func getEditorDataForEduArticle(
 		TextEditor: templates.TextEditor{
 			ParserName:  "parseMarkdownEdu",
 			MaxFileSize: AssetMaxSize(currentUser),
-			UploadUrl:   urlContext.BuildAssetUpload(),
+			UploadUrl:   uploadUrl,
 		},
 	}
 
@@ -454,7 +495,7 @@ func getEduArticleFromForm(form url.Values) (art models.EduArticle, ver models.E
 	return
 }
 
-func createEduArticle(c *RequestContext, art models.EduArticle, ver models.EduArticleVersion) {
+func createEduArticle(c *RequestContext, art models.EduArticle, ver models.EduArticleVersion, topics []*models.Topic) {
 	tx := utils.Must1(c.Conn.Begin(c))
 	defer tx.Rollback(c)
 	{
@@ -478,11 +519,15 @@ func createEduArticle(c *RequestContext, art models.EduArticle, ver models.EduAr
 			`UPDATE education_article SET current_version = $1 WHERE id = $2`,
 			versionID, articleID,
 		)
+		utils.Must(hmndata.SetEduArticleTopics(c, tx, articleID, topics))
+		if art.Published {
+			utils.Must(publishEduArticleAssets(c, tx, ver.ContentRaw))
+		}
 	}
 	utils.Must(tx.Commit(c))
 }
 
-func updateEduArticle(c *RequestContext, slug string, art models.EduArticle, ver models.EduArticleVersion) {
+func updateEduArticle(c *RequestContext, slug string, art models.EduArticle, ver models.EduArticleVersion, topics []*models.Topic) {
 	tx := utils.Must1(c.Conn.Begin(c))
 	defer tx.Rollback(c)
 	{
@@ -511,10 +556,42 @@ func updateEduArticle(c *RequestContext, slug string, art models.EduArticle, ver
 			versionID,
 			articleID,
 		)
+		utils.Must(hmndata.SetEduArticleTopics(c, tx, articleID, topics))
+		if art.Published {
+			utils.Must(publishEduArticleAssets(c, tx, ver.ContentRaw))
+		}
 	}
 	utils.Must(tx.Commit(c))
 }
 
+// Makes the private media in an article public once the article is published.
+// Unpublishing the article again does not make it private, since by then the
+// media may have been shared around.
+func publishEduArticleAssets(ctx context.Context, tx pgx.Tx, contentRaw string) error {
+	ids := assets.FindAssetFileLinks(contentRaw)
+	if len(ids) == 0 {
+		return nil
+	}
+	privateAssets, err := db.Query[models.Asset](ctx, tx,
+		`
+		SELECT $columns
+		FROM asset
+		WHERE id = ANY($1) AND private
+		`,
+		ids,
+	)
+	if err != nil {
+		return oops.New(err, "failed to fetch private assets in education article")
+	}
+	for _, asset := range privateAssets {
+		err := assets.SetAssetPrivate(ctx, tx, asset, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func eduArticleURL(a *models.EduArticle) string {
 	switch a.Type {
 	case models.EduArticleTypeArticle:
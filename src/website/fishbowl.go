@@ -1,13 +1,14 @@
 package website
 
 import (
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
 	"net/http"
 	"regexp"
-	"sort"
+	"slices"
 	"strings"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"git.handmade.network/hmn/hmn/src/hmnurl"
 	"git.handmade.network/hmn/hmn/src/models"
 	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/parsing"
 	"git.handmade.network/hmn/hmn/src/templates"
 	"git.handmade.network/hmn/hmn/src/utils"
 )
@@ -26,161 +28,176 @@ import (
 var fishbowlFS = utils.Must1(fs.Sub(templates.FishbowlFS, "src/fishbowls"))
 var fishbowlHTTPFS = http.StripPrefix("/fishbowl", http.FileServer(http.FS(fishbowlFS)))
 
-type fishbowlInfo struct {
-	Slug               string
-	Title, Description string // The description is used for OpenGraph, so it must be plain text, no HTML.
-	Month              time.Month
-	Year               int
-	ContentsPath       string
+// Fetches fishbowls, newest first.
+func fetchFishbowls(c *RequestContext, onlyPublished bool) ([]*models.Fishbowl, error) {
+	fishbowls, err := db.Query[models.Fishbowl](c, c.Conn,
+		`
+		SELECT $columns
+		FROM fishbowl
+		WHERE $1 OR published
+		ORDER BY year DESC, month DESC, id DESC
+		`,
+		!onlyPublished,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch fishbowls")
+	}
+	return fishbowls, nil
+}
+
+// Returns nil if the fishbowl doesn't exist.
+func fetchFishbowl(c *RequestContext, slug string) (*models.Fishbowl, error) {
+	fishbowl, err := db.QueryOne[models.Fishbowl](c, c.Conn,
+		`
+		SELECT $columns
+		FROM fishbowl
+		WHERE slug = $1
+		`,
+		slug,
+	)
+	if errors.Is(err, db.NotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, oops.New(err, "failed to fetch fishbowl")
+	}
+	return fishbowl, nil
 }
 
-var fishbowls = [...]fishbowlInfo{
-	{
-		Slug:        "internet-os",
-		Title:       "The future of operating systems in an Internet world",
-		Description: `Despite the web's technical problems, it dominates software development today, largely due to its cross-platform support and ease of distribution. At the same time, our discussions about the future of programming tend to involve new "operating systems", but those discussions rarely take the Internet into account. What could future operating systems look like in a world defined by the Internet?`,
-		Month:       time.May, Year: 2020,
-		ContentsPath: "internet-os/internet-os.html",
-	},
-	{
-		Slug:        "metaprogramming",
-		Title:       "Compile-time introspection and metaprogramming",
-		Description: `Thanks to new languages like Zig and Jai, compile-time execution and metaprogramming are a popular topic of discussion in the community. This fishbowl explores metaprogramming in more detail, and discusses to what extent it is actually necessary, or just a waste of time.`,
-		Month:       time.June, Year: 2020,
-		ContentsPath: "metaprogramming/metaprogramming.html",
-	},
-	{
-		Slug:        "lisp-jam",
-		Title:       "Lessons from the Lisp Jam",
-		Description: `In the summer of 2020 we held a Lisp jam, where many community members made exploratory Lisp-inspired projects. We held this fishbowl as a recap, as a time for the participants to share what they learned and explore how those lessons relate to our day-to-day programming.`,
-		Month:       time.August, Year: 2020,
-		ContentsPath: "lisp-jam/lisp-jam.html",
-	},
-	{
-		Slug:        "parallel-programming",
-		Title:       "Approaches to parallel programming",
-		Description: `A discussion of many aspects of parallelism and concurrency in programming, and the pros and cons of different programming methodologies.`,
-		Month:       time.November, Year: 2020,
-		ContentsPath: "parallel-programming/parallel-programming.html",
-	},
-	{
-		Slug:        "skimming",
-		Title:       "Code skimmability as the root cause for bad code structure decisions", // real snappy, this one
-		Description: `Programmers tend to care a lot about "readability". This usually means having small classes, small functions, small files. This code might be "readable" at a glance, but this doesn't really help you understand the program—it's just "skimmable". How can we think about "readability" in a more productive way?`,
-		Month:       time.January, Year: 2021,
-		ContentsPath: "skimming/skimming.html",
-	},
-	{
-		Slug:        "config",
-		Title:       "How to design to avoid configuration",
-		Description: `Configuration sucks. How can we avoid it, while still making software that supports a wide range of behaviors? What is the essence of "configuration", and how can we identify it? How can we identify what is "bad config", and design our software to avoid it?`,
-		Month:       time.March, Year: 2021,
-		ContentsPath: "config/config.html",
-	},
-	{
-		Slug:        "simplicity-performance",
-		Title:       "The relationship of simplicity and performance",
-		Description: "In the community, we talk a lot about performance. We also talk a lot about having simple code—and the two feel somewhat intertwined. What relationship is there between simplicity and performance? Are there better ways to reason about \"simplicity\" with this in mind?",
-		Month:       time.May, Year: 2021,
-		ContentsPath: "simplicity-performance/simplicity-performance.html",
-	},
-	{
-		Slug:        "teaching-software",
-		Title:       "How software development is taught",
-		Description: "The Handmade Network exists because we are unhappy with the software status quo. To a large extent, this is because of how software development is taught. What are the good parts of software education today, what are the flaws, and how might we change things to improve the state of software?",
-		Month:       time.June, Year: 2021,
-		ContentsPath: "teaching-software/teaching-software.html",
-	},
-	{
-		Slug:        "flexible-software",
-		Title:       "How to design flexible software",
-		Description: "We previously held a fishbowl about how to design to avoid configuration. But when you can't avoid configuration, how do you do it well? And if we want our software to be flexible, what other options do we have besides configuration? What other ways are there to make software flexible?",
-		Month:       time.December, Year: 2021,
-		ContentsPath: "flexible-software/flexible-software.html",
-	},
-	{
-		Slug:        "oop",
-		Title:       "What, if anything, is OOP?",
-		Description: "Is object-oriented programming bad? Is it good? What even is it, anyway? This fishbowl explores OOP more carefully—what is the essence of it, what are the good parts, why did it take over the world, and why do we criticize it so much?",
-		Month:       time.May, Year: 2022,
-		ContentsPath: "oop/OOP.html",
-	},
-	{
-		Slug:        "libraries",
-		Title:       "When do libraries go sour?",
-		Description: "The Handmade community is often opposed to using libraries. But let's get more specific about why that can be, and whether that's reasonable. What do we look for in a library? When do libraries go sour? How do we evaluate libraries before using them? How can the libraries we make avoid these problems?",
-		Month:       time.July, Year: 2022,
-		ContentsPath: "libraries/libraries.html",
-	},
-	{
-		Slug:        "entrepreneurship",
-		Title:       "Entrepreneurship and the Handmade ethos",
-		Description: "What does it look like to turn a Handmade project into a sustainable business? How can the Handmade ethos set our software apart from its competitors? And how might we sustain the development of important software, if we're not sure how to sell it?",
-		Month:       time.October, Year: 2022,
-		ContentsPath: "entrepreneurship/entrepreneurship.html",
-	},
-	{
-		Slug:        "testing",
-		Title:       "What even is testing?",
-		Description: "Everybody knows testing is important, but the software industry is overrun by terrible testing practices. Because of this, there has often been a negative sentiment against testing in the Handmade community. This fishbowl explores the kinds of testing the community has found most effective, the costs of testing, and the actual purpose behind testing techniques.",
-		Month:       time.May, Year: 2023,
-		ContentsPath: "testing/testing.html",
-	},
+type fishbowlMessage struct {
+	models.FishbowlMessage
+	Avatar      *models.Asset
+	Attachments []*models.Asset
+}
+
+// Fetches a fishbowl's stored messages in order, with their avatars and
+// attachments.
+func fetchFishbowlMessages(c *RequestContext, fishbowlID int, includeHidden bool) ([]*fishbowlMessage, error) {
+	type messageRow struct {
+		Message models.FishbowlMessage `db:"msg"`
+		Avatar  *models.Asset          `db:"avatar"`
+	}
+	rows, err := db.Query[messageRow](c, c.Conn,
+		`
+		SELECT $columns
+		FROM
+			fishbowl_message AS msg
+			LEFT JOIN asset AS avatar ON avatar.id = msg.author_avatar_asset_id
+		WHERE
+			msg.fishbowl_id = $1
+			AND ($2 OR NOT msg.hidden)
+		ORDER BY msg.ordering, msg.sent_at
+		`,
+		fishbowlID,
+		includeHidden,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch fishbowl messages")
+	}
+
+	type attachmentRow struct {
+		MessageID int           `db:"fishbowl_message_attachment.message_id"`
+		Asset     *models.Asset `db:"asset"`
+	}
+	attachments, err := db.Query[attachmentRow](c, c.Conn,
+		`
+		SELECT $columns
+		FROM
+			fishbowl_message_attachment
+			JOIN fishbowl_message AS msg ON msg.id = fishbowl_message_attachment.message_id
+			JOIN asset ON asset.id = fishbowl_message_attachment.asset_id
+		WHERE msg.fishbowl_id = $1
+		ORDER BY fishbowl_message_attachment.ordering
+		`,
+		fishbowlID,
+	)
+	if err != nil {
+		return nil, oops.New(err, "failed to fetch fishbowl attachments")
+	}
+
+	res := make([]*fishbowlMessage, 0, len(rows))
+	byID := make(map[int]*fishbowlMessage, len(rows))
+	for _, row := range rows {
+		msg := &fishbowlMessage{
+			FishbowlMessage: row.Message,
+			Avatar:          row.Avatar,
+		}
+		res = append(res, msg)
+		byID[msg.ID] = msg
+	}
+	for _, attachment := range attachments {
+		if msg, ok := byID[attachment.MessageID]; ok {
+			msg.Attachments = append(msg.Attachments, attachment.Asset)
+		}
+	}
+
+	return res, nil
 }
 
 func FishbowlIndex(c *RequestContext) ResponseData {
 	type fishbowlTmpl struct {
-		Fishbowl fishbowlInfo
+		Fishbowl *models.Fishbowl
 		Url      string
-		Valid    bool
-
-		date time.Time
 	}
 	type tmpl struct {
 		templates.BaseData
 		Fishbowls []fishbowlTmpl
 	}
 
+	fishbowls, err := fetchFishbowls(c, true)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
 	tmplData := tmpl{
 		BaseData: getBaseData(c, "Fishbowls", nil),
 	}
-
-	var fishbowlTmpls []fishbowlTmpl
 	for _, f := range fishbowls {
-		fishbowlTmpls = append(fishbowlTmpls, fishbowlTmpl{
+		tmplData.Fishbowls = append(tmplData.Fishbowls, fishbowlTmpl{
 			Fishbowl: f,
 			Url:      hmnurl.BuildFishbowl(f.Slug),
-			Valid:    f.ContentsPath != "",
-
-			date: time.Date(f.Year, f.Month, 0, 0, 0, 0, 0, time.UTC),
 		})
 	}
-	sort.Slice(fishbowlTmpls, func(i, j int) bool {
-		return fishbowlTmpls[j].date.Before(fishbowlTmpls[i].date) // reverse
-	})
-	tmplData.Fishbowls = fishbowlTmpls
 
 	var res ResponseData
-	err := res.WriteTemplate("fishbowl_index.html", tmplData, c.Perf)
+	err = res.WriteTemplate("fishbowl_index.html", tmplData, c.Perf)
 	if err != nil {
 		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to render fishbowl index page"))
 	}
 	return res
 }
 
+// Consecutive messages from the same person, shown under one name and avatar
+// like on Discord.
+type fishbowlMessageGroup struct {
+	AuthorName string
+	AuthorUrl  string // The author's HMN profile, if their account is linked
+	AvatarUrl  string
+	Messages   []fishbowlMessageTmpl
+}
+
+type fishbowlMessageTmpl struct {
+	MessageID   string
+	SentAt      time.Time
+	Content     template.HTML
+	Attachments []fishbowlAttachmentTmpl
+}
+
+type fishbowlAttachmentTmpl struct {
+	Asset   *templates.Asset
+	IsImage bool
+	IsVideo bool
+}
+
+// How long someone can pause before their next message starts a new group.
+const fishbowlGroupGap = 7 * time.Minute
+
 func Fishbowl(c *RequestContext) ResponseData {
-	slug := c.PathParams["slug"]
-	var info fishbowlInfo
-
-	// Only serve up valid fishbowls (with content)
-	exists := false
-	for _, fishbowl := range fishbowls {
-		if fishbowl.Slug == slug && fishbowl.ContentsPath != "" {
-			exists = true
-			info = fishbowl
-		}
+	info, err := fetchFishbowl(c, c.PathParams["slug"])
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
 	}
-	if !exists {
+	// Staff can preview unpublished fishbowls.
+	if info == nil || (!info.Published && (c.CurrentUser == nil || !c.CurrentUser.IsStaff)) {
 		return FourOhFour(c)
 	}
 
@@ -192,28 +209,40 @@ func Fishbowl(c *RequestContext) ResponseData {
 	type FishbowlData struct {
 		templates.BaseData
 		Slug     string
-		Info     fishbowlInfo
+		Info     *models.Fishbowl
 		Contents template.HTML
-	}
-
-	contentsFile := utils.Must1(fishbowlFS.Open(info.ContentsPath))
-	contents := string(utils.Must1(io.ReadAll(contentsFile)))
-	contents, err := linkifyDiscordContent(c, c.Conn, contents)
-	if err != nil {
-		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to linkify fishbowl content"))
+		Groups   []fishbowlMessageGroup
 	}
 
 	tmpl := FishbowlData{
 		BaseData: getBaseData(c, info.Title, nil),
-		Slug:     slug,
+		Slug:     info.Slug,
 		Info:     info,
-		Contents: template.HTML(contents),
 	}
 	tmpl.BaseData.OpenGraphItems = append(tmpl.BaseData.OpenGraphItems, templates.OpenGraphItem{
 		Property: "og:description",
 		Value:    info.Description,
 	})
 
+	if info.ContentsPath != "" {
+		contentsFile := utils.Must1(fishbowlFS.Open(info.ContentsPath))
+		contents := string(utils.Must1(io.ReadAll(contentsFile)))
+		contents, err := linkifyDiscordContent(c, c.Conn, contents)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to linkify fishbowl content"))
+		}
+		tmpl.Contents = template.HTML(contents)
+	} else {
+		messages, err := fetchFishbowlMessages(c, info.ID, false)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, err)
+		}
+		tmpl.Groups, err = groupFishbowlMessages(c, messages)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, err)
+		}
+	}
+
 	var res ResponseData
 	err = res.WriteTemplate("fishbowl.html", tmpl, c.Perf)
 	if err != nil {
@@ -222,6 +251,64 @@ func Fishbowl(c *RequestContext) ResponseData {
 	return res
 }
 
+func groupFishbowlMessages(c *RequestContext, messages []*fishbowlMessage) ([]fishbowlMessageGroup, error) {
+	var discordUserIDs []string
+	for _, msg := range messages {
+		if !slices.Contains(discordUserIDs, msg.AuthorID) {
+			discordUserIDs = append(discordUserIDs, msg.AuthorID)
+		}
+	}
+	var hmnUsers []*models.User
+	if len(discordUserIDs) > 0 {
+		var err error
+		hmnUsers, err = hmndata.FetchUsers(c, c.Conn, c.CurrentUser, hmndata.UsersQuery{
+			DiscordUserIDs: discordUserIDs,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var groups []fishbowlMessageGroup
+	var prev *fishbowlMessage
+	for _, msg := range messages {
+		if prev == nil || prev.AuthorID != msg.AuthorID || msg.SentAt.Sub(prev.SentAt) > fishbowlGroupGap {
+			group := fishbowlMessageGroup{
+				AuthorName: msg.AuthorName,
+				AvatarUrl:  templates.UserAvatarDefaultUrl("dark"),
+			}
+			if msg.Avatar != nil {
+				group.AvatarUrl = hmnurl.BuildAssetObject(msg.Avatar, msg.Avatar.S3Key)
+			}
+			for _, u := range hmnUsers {
+				if u.DiscordUser.UserID == msg.AuthorID {
+					group.AuthorUrl = hmnurl.BuildUserProfile(u.Username)
+					break
+				}
+			}
+			groups = append(groups, group)
+		}
+		prev = msg
+
+		msgTmpl := fishbowlMessageTmpl{
+			MessageID: msg.MessageID,
+			SentAt:    msg.SentAt,
+			Content:   template.HTML(parsing.ParseMarkdown(msg.Content, parsing.DiscordMarkdown)),
+		}
+		for _, a := range msg.Attachments {
+			msgTmpl.Attachments = append(msgTmpl.Attachments, fishbowlAttachmentTmpl{
+				Asset:   templates.AssetToTemplate(a),
+				IsImage: strings.HasPrefix(a.MimeType, "image/"),
+				IsVideo: strings.HasPrefix(a.MimeType, "video/"),
+			})
+		}
+		group := &groups[len(groups)-1]
+		group.Messages = append(group.Messages, msgTmpl)
+	}
+
+	return groups, nil
+}
+
 func FishbowlFiles(c *RequestContext) ResponseData {
 	var res ResponseData
 	fishbowlHTTPFS.ServeHTTP(&res, c.Req)
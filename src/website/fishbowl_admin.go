@@ -0,0 +1,327 @@
+package website
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.handmade.network/hmn/hmn/src/discord"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/parsing"
+	"git.handmade.network/hmn/hmn/src/templates"
+)
+
+// Slugs double as directory names for the old hand-edited fishbowls, so keep
+// them boring.
+var reFishbowlSlug = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// The value of an <input type="month">.
+const fishbowlMonthFormat = "2006-01"
+
+type adminFishbowl struct {
+	Fishbowl *models.Fishbowl
+	Url      string
+}
+
+func AdminFishbowls(c *RequestContext) ResponseData {
+	type AdminFishbowlsData struct {
+		templates.BaseData
+		Fishbowls []adminFishbowl
+		SubmitUrl string
+	}
+
+	fishbowls, err := fetchFishbowls(c, false)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	data := AdminFishbowlsData{
+		BaseData:  getBaseData(c, "Fishbowls", nil),
+		SubmitUrl: hmnurl.BuildAdminFishbowls(),
+	}
+	for _, fishbowl := range fishbowls {
+		data.Fishbowls = append(data.Fishbowls, adminFishbowl{
+			Fishbowl: fishbowl,
+			Url:      hmnurl.BuildAdminFishbowl(fishbowl.Slug),
+		})
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("admin_fishbowls.html", data, c.Perf)
+	return res
+}
+
+func AdminFishbowlsSubmit(c *RequestContext) ResponseData {
+	form, err := c.GetFormValues()
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to parse fishbowl form"))
+	}
+
+	slug := strings.TrimSpace(form.Get("slug"))
+	if !reFishbowlSlug.MatchString(slug) {
+		return c.RejectRequest("Slugs may only contain lowercase letters, numbers, and dashes.")
+	}
+	title := strings.TrimSpace(form.Get("title"))
+	if title == "" {
+		return c.RejectRequest("Fishbowls must have a title.")
+	}
+	month, err := time.Parse(fishbowlMonthFormat, form.Get("month"))
+	if err != nil {
+		return c.RejectRequest("Invalid month.")
+	}
+	threadID, rejection := parseFishbowlThreadID(form.Get("thread_id"))
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
+
+	tag, err := c.Conn.Exec(c,
+		`
+		INSERT INTO fishbowl (slug, title, month, year, thread_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING
+		`,
+		slug,
+		title,
+		int(month.Month()),
+		month.Year(),
+		threadID,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to create fishbowl"))
+	}
+	if tag.RowsAffected() == 0 {
+		return c.RejectRequest(fmt.Sprintf("There is already a fishbowl at /fishbowl/%s.", slug))
+	}
+
+	return c.Redirect(hmnurl.BuildAdminFishbowl(slug), http.StatusSeeOther)
+}
+
+func AdminFishbowl(c *RequestContext) ResponseData {
+	type adminFishbowlMessage struct {
+		ID          int
+		AuthorName  string
+		SentAt      time.Time
+		Content     template.HTML
+		Attachments int
+		Hidden      bool
+	}
+	type AdminFishbowlData struct {
+		templates.BaseData
+		Fishbowl  *models.Fishbowl
+		Month     string
+		ThreadID  string
+		Messages  []adminFishbowlMessage
+		PublicUrl string
+		SubmitUrl string
+		ImportUrl string
+		Import    *discord.FishbowlImport
+	}
+
+	fishbowl, errRes := fetchAdminFishbowl(c)
+	if errRes != nil {
+		return *errRes
+	}
+
+	messages, err := fetchFishbowlMessages(c, fishbowl.ID, true)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	data := AdminFishbowlData{
+		BaseData: getBaseData(c, fishbowl.Title, []templates.Breadcrumb{
+			{Name: "Fishbowls", Url: hmnurl.BuildAdminFishbowls()},
+		}),
+		Fishbowl:  fishbowl,
+		Month:     time.Date(fishbowl.Year, fishbowl.Month, 1, 0, 0, 0, 0, time.UTC).Format(fishbowlMonthFormat),
+		PublicUrl: hmnurl.BuildFishbowl(fishbowl.Slug),
+		SubmitUrl: hmnurl.BuildAdminFishbowl(fishbowl.Slug),
+		ImportUrl: hmnurl.BuildAdminFishbowlImport(fishbowl.Slug),
+		Import:    discord.FishbowlImportProgress(fishbowl.ID),
+	}
+	if fishbowl.ThreadID != nil {
+		data.ThreadID = *fishbowl.ThreadID
+	}
+	for _, msg := range messages {
+		data.Messages = append(data.Messages, adminFishbowlMessage{
+			ID:          msg.ID,
+			AuthorName:  msg.AuthorName,
+			SentAt:      msg.SentAt,
+			Content:     template.HTML(parsing.ParseMarkdown(msg.Content, parsing.DiscordMarkdown)),
+			Attachments: len(msg.Attachments),
+			Hidden:      msg.Hidden,
+		})
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("admin_fishbowl.html", data, c.Perf)
+	return res
+}
+
+func AdminFishbowlSubmit(c *RequestContext) ResponseData {
+	fishbowl, errRes := fetchAdminFishbowl(c)
+	if errRes != nil {
+		return *errRes
+	}
+
+	form, err := c.GetFormValues()
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to parse fishbowl form"))
+	}
+
+	if strings.ToLower(form.Get("action")) == "delete" {
+		_, err = c.Conn.Exec(c, `DELETE FROM fishbowl WHERE id = $1`, fishbowl.ID)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to delete fishbowl"))
+		}
+		res := c.Redirect(hmnurl.BuildAdminFishbowls(), http.StatusSeeOther)
+		res.AddFutureNotice("success", fmt.Sprintf("Deleted \"%s\".", fishbowl.Title))
+		return res
+	}
+
+	title := strings.TrimSpace(form.Get("title"))
+	if title == "" {
+		return c.RejectRequest("Fishbowls must have a title.")
+	}
+	month, err := time.Parse(fishbowlMonthFormat, form.Get("month"))
+	if err != nil {
+		return c.RejectRequest("Invalid month.")
+	}
+	threadID, rejection := parseFishbowlThreadID(form.Get("thread_id"))
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
+
+	// Every message has an entry in "order", in the order they're shown on
+	// the page. Only the hidden ones have an entry in "hidden".
+	var order []int
+	for _, idStr := range form["order"] {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			return c.RejectRequest("Invalid message order.")
+		}
+		order = append(order, id)
+	}
+	var hidden []int
+	for _, idStr := range form["hidden"] {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			return c.RejectRequest("Invalid hidden message.")
+		}
+		hidden = append(hidden, id)
+	}
+
+	tx, err := c.Conn.Begin(c)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to start transaction"))
+	}
+	defer tx.Rollback(c)
+
+	_, err = tx.Exec(c,
+		`
+		UPDATE fishbowl SET
+			title = $2,
+			description = $3,
+			month = $4,
+			year = $5,
+			thread_id = $6,
+			published = $7
+		WHERE id = $1
+		`,
+		fishbowl.ID,
+		title,
+		strings.TrimSpace(form.Get("description")),
+		int(month.Month()),
+		month.Year(),
+		threadID,
+		form.Get("published") != "",
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to update fishbowl"))
+	}
+
+	_, err = tx.Exec(c,
+		`
+		UPDATE fishbowl_message AS msg SET
+			ordering = new.ordering - 1,
+			hidden = msg.id = ANY(COALESCE($3::INT[], '{}'))
+		FROM unnest($2::INT[]) WITH ORDINALITY AS new(id, ordering)
+		WHERE
+			msg.id = new.id
+			AND msg.fishbowl_id = $1
+		`,
+		fishbowl.ID,
+		order,
+		hidden,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to update fishbowl messages"))
+	}
+
+	err = tx.Commit(c)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to commit fishbowl changes"))
+	}
+
+	res := c.Redirect(hmnurl.BuildAdminFishbowl(fishbowl.Slug), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Fishbowl updated.")
+	return res
+}
+
+func AdminFishbowlImportSubmit(c *RequestContext) ResponseData {
+	fishbowl, errRes := fetchAdminFishbowl(c)
+	if errRes != nil {
+		return *errRes
+	}
+	if fishbowl.ContentsPath != "" {
+		return c.RejectRequest("This fishbowl was archived by hand before imports existed.")
+	}
+	if fishbowl.ThreadID == nil {
+		return c.RejectRequest("Set the fishbowl's Discord thread before importing it.")
+	}
+
+	// Downloading every attachment takes longer than anyone should wait for a
+	// request, so the import runs in the background and the page shows how
+	// it's going.
+	res := c.Redirect(hmnurl.BuildAdminFishbowl(fishbowl.Slug), http.StatusSeeOther)
+	if discord.StartFishbowlImport(c.Conn, fishbowl, c.CurrentUser.ID) {
+		res.AddFutureNotice("success", "Import started.")
+	} else {
+		res.AddFutureNotice("warn", "This fishbowl is already being imported.")
+	}
+	return res
+}
+
+func fetchAdminFishbowl(c *RequestContext) (*models.Fishbowl, *ResponseData) {
+	fishbowl, err := fetchFishbowl(c, c.PathParams["slug"])
+	if err != nil {
+		res := c.ErrorResponse(http.StatusInternalServerError, err)
+		return nil, &res
+	}
+	if fishbowl == nil {
+		res := FourOhFour(c)
+		return nil, &res
+	}
+	return fishbowl, nil
+}
+
+// Accepts either a thread ID or a link to the thread.
+func parseFishbowlThreadID(value string) (*string, string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, ""
+	}
+	if strings.HasPrefix(value, "https://") {
+		parts := strings.Split(strings.TrimSuffix(value, "/"), "/")
+		value = parts[len(parts)-1]
+	}
+	if !reDiscordSnowflake.MatchString(value) {
+		return nil, "Discord thread IDs are numbers. Turn on developer mode in Discord to copy them."
+	}
+	return &value, ""
+}
@@ -1,6 +1,7 @@
 package website
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"image"
@@ -24,6 +25,7 @@ type FormImage struct {
 	Width    int
 	Height   int
 	Size     int64
+	AltText  string
 }
 
 // NOTE(asaf): This assumes that you already called ParseMultipartForm (which is why there's no size limit here).
@@ -78,14 +80,16 @@ func GetFormImage(c *RequestContext, fieldName string) (FormImage, error) {
 	return res, nil
 }
 
-func SaveFormImage(ctx context.Context, dbConn db.ConnOrTx, img FormImage, uploaderID *int) (*models.Asset, error) {
+func SaveFormImage(ctx context.Context, dbConn db.ConnOrTx, img FormImage, uploaderID *int, private bool) (*models.Asset, error) {
 	utils.Assert(img.Exists)
 	return assets.Create(ctx, dbConn, assets.CreateInput{
-		Content:     img.Content,
+		Content:     bytes.NewReader(img.Content),
 		Filename:    img.Filename,
 		ContentType: img.Mime,
 		UploaderID:  uploaderID,
 		Width:       img.Width,
 		Height:      img.Height,
+		AltText:     img.AltText,
+		Private:     private,
 	})
 }
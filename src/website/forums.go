@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/discord"
 	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
 	"git.handmade.network/hmn/hmn/src/logging"
@@ -548,6 +549,7 @@ func ForumNewThreadSubmit(c *RequestContext) ResponseData {
 	if err != nil {
 		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to create new forum thread"))
 	}
+	discord.QueueForumBridge(threadId)
 
 	newThreadUrl := c.UrlContext.BuildForumThread(cd.LineageBuilder.GetSubforumLineageSlugs(cd.SubforumID), threadId, title, 1)
 	return c.Redirect(newThreadUrl, http.StatusSeeOther)
@@ -629,6 +631,7 @@ func ForumPostReplySubmit(c *RequestContext) ResponseData {
 	if err != nil {
 		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to reply to forum post"))
 	}
+	discord.QueueForumBridge(post.Thread.ID)
 
 	newPostUrl := c.UrlContext.BuildForumPost(cd.LineageBuilder.GetSubforumLineageSlugs(*post.Thread.SubforumID), post.Thread.ID, newPostId)
 	return c.Redirect(newPostUrl, http.StatusSeeOther)
@@ -732,6 +735,7 @@ func ForumPostEditSubmit(c *RequestContext) ResponseData {
 	if err != nil {
 		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to edit forum post"))
 	}
+	discord.QueueForumBridge(post.Thread.ID)
 
 	postUrl := c.UrlContext.BuildForumPost(cd.LineageBuilder.GetSubforumLineageSlugs(*post.Thread.SubforumID), post.Thread.ID, post.Post.ID)
 	return c.Redirect(postUrl, http.StatusSeeOther)
@@ -806,6 +810,7 @@ func ForumPostDeleteSubmit(c *RequestContext) ResponseData {
 	if err != nil {
 		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to delete post"))
 	}
+	discord.QueueForumBridge(cd.ThreadID)
 
 	if threadDeleted {
 		forumUrl := c.UrlContext.BuildForum(cd.LineageBuilder.GetSubforumLineageSlugs(cd.SubforumID), 1)
@@ -379,7 +379,7 @@ func JamIndex2023(c *RequestContext) ResponseData {
 		}
 		showcaseItems = make([]templates.TimelineItem, 0, len(snippets))
 		for _, s := range snippets {
-			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Asset, s.DiscordMessage, s.Projects, s.Owner, false)
+			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, false)
 			if timelineItem.CanShowcase {
 				showcaseItems = append(showcaseItems, timelineItem)
 			}
@@ -447,7 +447,7 @@ func JamFeed2023(c *RequestContext) ResponseData {
 
 		timelineItems = make([]templates.TimelineItem, 0, len(snippets))
 		for _, s := range snippets {
-			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Asset, s.DiscordMessage, s.Projects, s.Owner, false)
+			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, false)
 			timelineItems = append(timelineItems, timelineItem)
 		}
 	}
@@ -568,7 +568,7 @@ func JamIndex2023_Visibility(c *RequestContext) ResponseData {
 		}
 		showcaseItems = make([]templates.TimelineItem, 0, len(snippets))
 		for _, s := range snippets {
-			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Asset, s.DiscordMessage, s.Projects, s.Owner, false)
+			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, false)
 			if timelineItem.CanShowcase {
 				showcaseItems = append(showcaseItems, timelineItem)
 			}
@@ -618,7 +618,7 @@ func JamFeed2023_Visibility(c *RequestContext) ResponseData {
 
 		timelineItems = make([]templates.TimelineItem, 0, len(snippets))
 		for _, s := range snippets {
-			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Asset, s.DiscordMessage, s.Projects, s.Owner, false)
+			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, false)
 			timelineItems = append(timelineItems, timelineItem)
 		}
 	}
@@ -784,7 +784,7 @@ func JamIndex2022(c *RequestContext) ResponseData {
 		}
 		showcaseItems = make([]templates.TimelineItem, 0, len(snippets))
 		for _, s := range snippets {
-			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Asset, s.DiscordMessage, s.Projects, s.Owner, false)
+			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, false)
 			if timelineItem.CanShowcase {
 				showcaseItems = append(showcaseItems, timelineItem)
 			}
@@ -833,7 +833,7 @@ func JamFeed2022(c *RequestContext) ResponseData {
 
 		timelineItems = make([]templates.TimelineItem, 0, len(snippets))
 		for _, s := range snippets {
-			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Asset, s.DiscordMessage, s.Projects, s.Owner, false)
+			timelineItem := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, false)
 			timelineItems = append(timelineItems, timelineItem)
 		}
 	}
@@ -898,7 +898,7 @@ func JamIndex2021(c *RequestContext) ResponseData {
 	}
 	showcaseItems := make([]templates.TimelineItem, 0, len(snippets))
 	for _, s := range snippets {
-		timelineItem := SnippetToTimelineItem(&s.Snippet, s.Asset, s.DiscordMessage, s.Projects, s.Owner, false)
+		timelineItem := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, false)
 		if timelineItem.CanShowcase {
 			showcaseItems = append(showcaseItems, timelineItem)
 		}
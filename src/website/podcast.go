@@ -1,17 +1,18 @@
 package website
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
+	"math"
 	"net/http"
 	"os"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"git.handmade.network/hmn/hmn/src/assets"
 	"git.handmade.network/hmn/hmn/src/db"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
 	"git.handmade.network/hmn/hmn/src/models"
@@ -55,7 +56,7 @@ func PodcastIndex(c *RequestContext) ResponseData {
 	}
 
 	for _, episode := range podcastResult.Episodes {
-		podcastIndexData.Episodes = append(podcastIndexData.Episodes, templates.PodcastEpisodeToTemplate(episode, podcastResult.Image, 0))
+		podcastIndexData.Episodes = append(podcastIndexData.Episodes, templates.PodcastEpisodeToTemplate(episode, podcastResult.Image, podcastResult.Audio[episode.GUID], 0))
 	}
 	var res ResponseData
 	err = res.WriteTemplate("podcast_index.html", podcastIndexData, c.Perf)
@@ -166,7 +167,7 @@ func PodcastEditSubmit(c *RequestContext) ResponseData {
 		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to read image from form"))
 	}
 	if image.Exists {
-		imageAsset, err := SaveFormImage(c, tx, image, &c.CurrentUser.ID)
+		imageAsset, err := SaveFormImage(c, tx, image, &c.CurrentUser.ID, false)
 		if err != nil {
 			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to save podcast image"))
 		}
@@ -217,7 +218,7 @@ func PodcastEpisode(c *RequestContext) ResponseData {
 	}
 
 	podcast := templates.PodcastToTemplate(podcastResult.Podcast, podcastResult.Image)
-	episode := templates.PodcastEpisodeToTemplate(podcastResult.Episodes[0], podcastResult.Image, 0)
+	episode := templates.PodcastEpisodeToTemplate(podcastResult.Episodes[0], podcastResult.Image, podcastResult.Audio[podcastResult.Episodes[0].GUID], 0)
 	baseData := getBaseData(c, fmt.Sprintf("%s | %s", episode.Title, podcast.Title), nil)
 
 	podcastEpisodeData := PodcastEpisodeData{
@@ -243,7 +244,6 @@ type PodcastEpisodeEditData struct {
 	EpisodeNumber int
 	SeasonNumber  int
 	CurrentFile   string
-	EpisodeFiles  []string
 }
 
 func PodcastEpisodeNew(c *RequestContext) ResponseData {
@@ -258,18 +258,12 @@ func PodcastEpisodeNew(c *RequestContext) ResponseData {
 		return FourOhFour(c)
 	}
 
-	episodeFiles, err := GetEpisodeFiles(c.CurrentProject.Slug)
-	if err != nil {
-		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "Failed to fetch podcast episode file list"))
-	}
-
 	podcast := templates.PodcastToTemplate(podcastResult.Podcast, podcastResult.Image)
 	var res ResponseData
 	baseData := getBaseData(c, fmt.Sprintf("New episode | %s", podcast.Title), nil)
 	err = res.WriteTemplate("podcast_episode_edit.html", PodcastEpisodeEditData{
-		BaseData:     baseData,
-		IsEdit:       false,
-		EpisodeFiles: episodeFiles,
+		BaseData: baseData,
+		IsEdit:   false,
 	}, c.Perf)
 	if err != nil {
 		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to render podcast episode new page"))
@@ -294,14 +288,14 @@ func PodcastEpisodeEdit(c *RequestContext) ResponseData {
 		return FourOhFour(c)
 	}
 
-	episodeFiles, err := GetEpisodeFiles(c.CurrentProject.Slug)
-	if err != nil {
-		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "Failed to fetch podcast episode file list"))
-	}
 	episode := podcastResult.Episodes[0]
+	currentFile := episode.AudioFile
+	if audio := podcastResult.Audio[episode.GUID]; audio != nil {
+		currentFile = audio.Filename
+	}
 
 	podcast := templates.PodcastToTemplate(podcastResult.Podcast, podcastResult.Image)
-	podcastEpisode := templates.PodcastEpisodeToTemplate(episode, podcastResult.Image, 0)
+	podcastEpisode := templates.PodcastEpisodeToTemplate(episode, podcastResult.Image, podcastResult.Audio[episode.GUID], 0)
 	baseData := getBaseData(c, fmt.Sprintf("Edit episode %s | %s", podcastEpisode.Title, podcast.Title), nil)
 	podcastEpisodeEditData := PodcastEpisodeEditData{
 		BaseData:      baseData,
@@ -310,8 +304,7 @@ func PodcastEpisodeEdit(c *RequestContext) ResponseData {
 		Description:   episode.Description,
 		EpisodeNumber: episode.EpisodeNumber,
 		SeasonNumber:  episode.SeasonNumber,
-		CurrentFile:   episode.AudioFile,
-		EpisodeFiles:  episodeFiles,
+		CurrentFile:   currentFile,
 	}
 
 	var res ResponseData
@@ -337,12 +330,19 @@ func PodcastEpisodeSubmit(c *RequestContext) ResponseData {
 		return FourOhFour(c)
 	}
 
-	episodeFiles, err := GetEpisodeFiles(c.CurrentProject.Slug)
-	if err != nil {
-		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "Failed to fetch podcast episode file list"))
+	{
+		b := c.Perf.StartBlock("PODCAST", "Parsing form")
+		c.Req.Body = http.MaxBytesReader(c.Res, c.Req.Body, PodcastEpisodeMaxFileSize+1024*1024)
+		err = c.Req.ParseMultipartForm(32 * 1024 * 1024)
+		b.End()
+	}
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		return c.RejectRequest(fmt.Sprintf("Episode file is too large. The maximum size is %d MB.", PodcastEpisodeMaxFileSize/1024/1024))
+	} else if err != nil {
+		return c.RejectRequest("Failed to read the form. Please try again.")
 	}
 
-	c.Req.ParseForm()
 	title := c.Req.Form.Get("title")
 	if len(strings.TrimSpace(title)) == 0 {
 		return c.RejectRequest("Episode title is empty")
@@ -361,44 +361,36 @@ func PodcastEpisodeSubmit(c *RequestContext) ResponseData {
 	if err != nil {
 		return c.RejectRequest("Episode number can't be parsed")
 	}
-	episodeFile := c.Req.Form.Get("episode_file")
-	found = slices.Contains(episodeFiles, episodeFile)
 
-	if !found {
-		return c.RejectRequest("Requested episode file not found")
-	}
-
-	var duration float64
-	{
-		b := c.Perf.StartBlock("MP3", "Parsing mp3 file for duration")
-		defer b.End()
-
-		file, err := os.Open(fmt.Sprintf("public/media/podcast/%s/%s", c.CurrentProject.Slug, episodeFile))
-		if err != nil {
-			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "Failed to open podcast file"))
-		}
-
-		mp3Decoder := mp3.NewDecoder(file)
-		skipped := 0
-		var decodingError error
-		var f mp3.Frame
-		for {
-			if err = mp3Decoder.Decode(&f, &skipped); err != nil {
-				if err == io.EOF {
-					break
-				} else {
-					decodingError = err
-					break
-				}
-			}
-			duration = duration + f.Duration().Seconds()
-		}
-		file.Close()
-		if decodingError != nil {
-			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "Failed to decode mp3 file"))
+	var audio *models.Asset
+	var duration int
+	if isEdit {
+		audio = podcastResult.Audio[podcastResult.Episodes[0].GUID]
+		duration = podcastResult.Episodes[0].Duration
+	}
+	audioFile, audioHeader, err := c.Req.FormFile("episode_audio")
+	if err == nil {
+		defer audioFile.Close()
+		contentType := audioHeader.Header.Get("Content-Type")
+		if !strings.HasPrefix(contentType, "audio/") {
+			return c.RejectRequest("Episode file must be audio")
 		}
 
+		b := c.Perf.StartBlock("PODCAST", "Saving episode audio")
+		audio, duration, err = CreatePodcastEpisodeAudio(c, c.Conn, audioFile, audioHeader.Filename, contentType, &c.CurrentUser.ID)
 		b.End()
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, err)
+		}
+	} else if !errors.Is(err, http.ErrMissingFile) {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to read episode file from form"))
+	}
+	if audio == nil && (!isEdit || podcastResult.Episodes[0].AudioFile == "") {
+		return c.RejectRequest("Episode file is missing")
+	}
+	var audioID *uuid.UUID
+	if audio != nil {
+		audioID = &audio.ID
 	}
 
 	b := c.Perf.StartBlock("MARKDOWN", "Parsing description")
@@ -416,7 +408,7 @@ func PodcastEpisodeSubmit(c *RequestContext) ResponseData {
 				title = $1,
 				description = $2,
 				description_rendered = $3,
-				audio_filename = $4,
+				audio_asset = $4,
 				duration = $5,
 				episode_number = $6,
 				season_number = $7
@@ -426,7 +418,7 @@ func PodcastEpisodeSubmit(c *RequestContext) ResponseData {
 			title,
 			description,
 			descriptionRendered,
-			episodeFile,
+			audioID,
 			duration,
 			episodeNumber,
 			seasonNumber,
@@ -442,15 +434,15 @@ func PodcastEpisodeSubmit(c *RequestContext) ResponseData {
 			`
 			---- Creating new podcast episode
 			INSERT INTO podcast_episode
-				(guid, title, description, description_rendered, audio_filename, duration, pub_date, episode_number, season_number, podcast_id)
+				(guid, title, description, description_rendered, audio_asset, audio_filename, duration, pub_date, episode_number, season_number, podcast_id)
 			VALUES
-				($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+				($1, $2, $3, $4, $5, '', $6, $7, $8, $9, $10)
 			`,
 			guid,
 			title,
 			description,
 			descriptionRendered,
-			episodeFile,
+			audioID,
 			duration,
 			time.Now(),
 			episodeNumber,
@@ -467,11 +459,55 @@ func PodcastEpisodeSubmit(c *RequestContext) ResponseData {
 	return res
 }
 
-func GetEpisodeFiles(projectSlug string) ([]string, error) {
-	folderStr := fmt.Sprintf("public/media/podcast/%s/", projectSlug)
-	folder := os.DirFS(folderStr)
-	files, err := fs.Glob(folder, "*.mp3")
-	return files, err
+const PodcastEpisodeMaxFileSize = 500 * 1024 * 1024
+
+// Uploads an episode's audio as an asset and works out how many seconds long
+// it is. ffmpeg measures audio assets as they're created; without it, we can
+// still decode MP3s ourselves.
+func CreatePodcastEpisodeAudio(
+	ctx context.Context,
+	dbConn db.ConnOrTx,
+	file io.ReadSeeker,
+	filename string,
+	contentType string,
+	uploaderID *int,
+) (*models.Asset, int, error) {
+	audio, err := assets.Create(ctx, dbConn, assets.CreateInput{
+		Content:     file,
+		Filename:    filename,
+		ContentType: contentType,
+		UploaderID:  uploaderID,
+		// Episodes are long, and ffmpeg runs slowly on purpose.
+		AnalyzeAudioLater: true,
+	})
+	if err != nil {
+		return nil, 0, oops.New(err, "failed to save podcast episode audio")
+	}
+	if audio.AudioAnalyzed {
+		return audio, int(math.Round(audio.Duration)), nil
+	}
+	if audio.MimeType != "audio/mpeg" {
+		return audio, 0, nil
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, 0, oops.New(err, "failed to rewind podcast episode audio")
+	}
+	var duration float64
+	mp3Decoder := mp3.NewDecoder(file)
+	skipped := 0
+	var f mp3.Frame
+	for {
+		if err = mp3Decoder.Decode(&f, &skipped); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, 0, oops.New(err, "Failed to decode mp3 file")
+		}
+		duration = duration + f.Duration().Seconds()
+	}
+	return audio, int(math.Round(duration)), nil
 }
 
 type PodcastRSSData struct {
@@ -494,14 +530,17 @@ func PodcastRSS(c *RequestContext) ResponseData {
 	}
 
 	for _, episode := range podcastResult.Episodes {
-		var filesize int64
-		stat, err := os.Stat(fmt.Sprintf("./public/media/podcast/%s/%s", c.CurrentProject.Slug, episode.AudioFile))
-		if err != nil {
-			c.Logger.Err(err).Msg("Couldn't get filesize for podcast episode")
-		} else {
-			filesize = stat.Size()
+		audio := podcastResult.Audio[episode.GUID]
+		var legacyFilesize int64
+		if audio == nil {
+			stat, err := os.Stat(fmt.Sprintf("./public/media/podcast/%s/%s", c.CurrentProject.Slug, episode.AudioFile))
+			if err != nil {
+				c.Logger.Err(err).Msg("Couldn't get filesize for podcast episode")
+			} else {
+				legacyFilesize = stat.Size()
+			}
 		}
-		podcastRSSData.Episodes = append(podcastRSSData.Episodes, templates.PodcastEpisodeToTemplate(episode, podcastResult.Image, filesize))
+		podcastRSSData.Episodes = append(podcastRSSData.Episodes, templates.PodcastEpisodeToTemplate(episode, podcastResult.Image, audio, legacyFilesize))
 	}
 
 	var res ResponseData
@@ -515,6 +554,7 @@ func PodcastRSS(c *RequestContext) ResponseData {
 type PodcastResult struct {
 	Podcast  *models.Podcast
 	Episodes []*models.PodcastEpisode
+	Audio    map[uuid.UUID]*models.Asset // By episode GUID
 	Image    *models.Asset
 }
 
@@ -584,6 +624,33 @@ func FetchPodcastAndEpisodes(
 		}
 	}
 
+	result.Audio = make(map[uuid.UUID]*models.Asset)
+	var audioIDs []uuid.UUID
+	for _, episode := range result.Episodes {
+		if episode.AudioAssetID != nil {
+			audioIDs = append(audioIDs, *episode.AudioAssetID)
+		}
+	}
+	if len(audioIDs) > 0 {
+		audioAssets, err := db.Query[models.Asset](c, c.Conn,
+			`
+			---- Fetch podcast episode audio
+			SELECT $columns FROM asset WHERE id = ANY($1)
+			`,
+			audioIDs,
+		)
+		if err != nil {
+			return result, oops.New(err, "failed to fetch podcast episode audio")
+		}
+		for _, episode := range result.Episodes {
+			for _, audio := range audioAssets {
+				if episode.AudioAssetID != nil && *episode.AudioAssetID == audio.ID {
+					result.Audio[episode.GUID] = audio
+				}
+			}
+		}
+	}
+
 	if podcast.ImageID != nil {
 		imageAsset, err := db.QueryOne[models.Asset](c, c.Conn,
 			`SELECT $columns FROM asset WHERE id = $1`,
@@ -12,7 +12,9 @@ import (
 	"strings"
 	"time"
 
+	"git.handmade.network/hmn/hmn/src/assets"
 	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/discord"
 	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
 	"git.handmade.network/hmn/hmn/src/models"
@@ -199,6 +201,7 @@ func ProjectHomepage(c *RequestContext) ResponseData {
 		templates.BaseData
 		Project                      templates.Project
 		Owners                       []templates.User
+		Topics                       []templates.Topic
 		Screenshots                  []string
 		PrimaryLinks, SecondaryLinks []templates.Link
 		RecentActivity               []templates.TimelineItem
@@ -230,6 +233,12 @@ func ProjectHomepage(c *RequestContext) ResponseData {
 	for _, owner := range owners {
 		templateData.Owners = append(templateData.Owners, templates.UserToTemplate(owner))
 	}
+
+	topics, err := hmndata.FetchProjectTopics(c, c.Conn, c.CurrentProject.ID)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	templateData.Topics = templates.TopicsToTemplate(topics)
 	templateData.CanEdit = c.CurrentUserCanEditCurrentProject
 	templateData.EditUrl = c.UrlContext.BuildProjectEdit("")
 
@@ -274,7 +283,7 @@ func ProjectHomepage(c *RequestContext) ResponseData {
 	}
 
 	for _, screenshot := range screenshotAssets {
-		templateData.Screenshots = append(templateData.Screenshots, hmnurl.BuildS3Asset(screenshot.S3Key))
+		templateData.Screenshots = append(templateData.Screenshots, hmnurl.BuildAssetObject(screenshot, screenshot.S3Key))
 	}
 
 	if c.CurrentProject.HasBlog() {
@@ -361,8 +370,15 @@ func ProjectHomepage(c *RequestContext) ResponseData {
 			AvailableProjectsJSON: templates.SnippetEditProjectsToJSON(templateProjects),
 			SubmitUrl:             hmnurl.BuildSnippetSubmit(),
 			AssetMaxSize:          AssetMaxSize(c.CurrentUser),
+			MaxAttachments:        models.SnippetMaxAttachments,
 		}
 
+		crosspostUser, err := fetchSnippetCrosspostDiscordUser(c)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, err)
+		}
+		templateData.SnippetEdit.CanCrosspost = crosspostUser != nil
+
 		followUrl = hmnurl.BuildFollowProject()
 		following, err = db.QueryOneScalar[bool](c, c.Conn, `
 			SELECT COUNT(*) > 0
@@ -412,6 +428,7 @@ type ProjectEditData struct {
 	TextEditor templates.TextEditor
 
 	DiscordSettingsUrl string
+	TopicIndexUrl      string
 }
 
 func ProjectNew(c *RequestContext) ResponseData {
@@ -460,6 +477,7 @@ func ProjectNew(c *RequestContext) ResponseData {
 		},
 
 		DiscordSettingsUrl: hmnurl.BuildUserSettings("discord"),
+		TopicIndexUrl:      hmnurl.BuildTopicIndex(),
 	}, c.Perf)
 	return res
 }
@@ -518,6 +536,7 @@ func ProjectNewSubmit(c *RequestContext) ResponseData {
 	}
 
 	tx.Commit(c)
+	queueRoleSyncForProjectOwners(c, projectId, nil)
 
 	urlContext := &hmnurl.UrlContext{
 		PersonalProject: true,
@@ -575,6 +594,12 @@ func ProjectEdit(c *RequestContext) ResponseData {
 
 	projectSettings.LinksJSON = string(utils.Must1(json.Marshal(templates.LinksToTemplate(projectLinks))))
 
+	projectTopics, err := hmndata.FetchProjectTopics(c, c.Conn, p.Project.ID)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	projectSettings.Topics = templates.TopicsToTemplate(projectTopics)
+
 	projectSettings.JamParticipation = make([]templates.ProjectJamParticipation, 0, len(projectJams))
 	for _, jam := range projectJams {
 		projectSettings.JamParticipation = append(projectSettings.JamParticipation, templates.ProjectJamParticipation{
@@ -603,6 +628,7 @@ func ProjectEdit(c *RequestContext) ResponseData {
 		},
 
 		DiscordSettingsUrl: hmnurl.BuildUserSettings("discord"),
+		TopicIndexUrl:      hmnurl.BuildTopicIndex(),
 	}, c.Perf)
 	return res
 }
@@ -627,12 +653,18 @@ func ProjectEditSubmit(c *RequestContext) ResponseData {
 
 	formResult.Payload.ProjectID = c.CurrentProject.ID
 
+	previousOwners, err := hmndata.FetchProjectOwners(c, c.Conn, c.CurrentProject.ID)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
 	err = updateProject(c, tx, c.CurrentUser, &formResult.Payload)
 	if err != nil {
 		return c.ErrorResponse(http.StatusInternalServerError, err)
 	}
 
 	tx.Commit(c)
+	queueRoleSyncForProjectOwners(c, c.CurrentProject.ID, previousOwners)
 
 	urlContext := &hmnurl.UrlContext{
 		PersonalProject: formResult.Payload.Personal,
@@ -656,7 +688,9 @@ type ProjectPayload struct {
 	OwnerUsernames        []string
 	Logo                  FormImage
 	HeaderImage           FormImage
+	HeaderImageAltText    *string
 	Tag                   string
+	Topics                []*models.Topic
 	JamParticipationSlugs []string
 	JamHidden             bool
 	SortScore             int
@@ -712,6 +746,16 @@ func ParseProjectEditForm(c *RequestContext) ProjectEditFormResult {
 		return res
 	}
 
+	topics, rejection, err := topicsFromForm(c, c.Conn, c.Req.Form.Get("topics"))
+	if err != nil {
+		res.Error = err
+		return res
+	}
+	if rejection != "" {
+		res.RejectionReason = rejection
+		return res
+	}
+
 	hiddenStr := c.Req.Form.Get("hidden")
 	hidden := len(hiddenStr) > 0
 
@@ -747,6 +791,13 @@ func ParseProjectEditForm(c *RequestContext) ProjectEditFormResult {
 	jamParticipationSlugs := c.Req.Form["jam_participation"]
 	jamHidden := c.Req.Form.Has("jam_hidden")
 
+	var headerImageAltText *string
+	if c.Req.Form.Has("header_image_alt") {
+		altText := strings.TrimSpace(c.Req.Form.Get("header_image_alt"))
+		headerImageAltText = &altText
+		headerImage.AltText = altText
+	}
+
 	sortScoreStr := c.Req.Form.Get("sort_score")
 	sortScore, _ := strconv.Atoi(sortScoreStr)
 
@@ -761,7 +812,9 @@ func ParseProjectEditForm(c *RequestContext) ProjectEditFormResult {
 		OwnerUsernames:        owners,
 		Logo:                  logo,
 		HeaderImage:           headerImage,
+		HeaderImageAltText:    headerImageAltText,
 		Tag:                   tag,
+		Topics:                topics,
 		JamParticipationSlugs: jamParticipationSlugs,
 		JamHidden:             jamHidden,
 		Slug:                  slug,
@@ -775,9 +828,19 @@ func ParseProjectEditForm(c *RequestContext) ProjectEditFormResult {
 }
 
 func updateProject(ctx context.Context, tx pgx.Tx, user *models.User, payload *ProjectPayload) error {
+	// Only staff can hide projects, so for everyone else the form doesn't say.
+	hidden := payload.Hidden
+	if !user.IsStaff {
+		var err error
+		hidden, err = db.QueryOneScalar[bool](ctx, tx, `SELECT hidden FROM project WHERE id = $1`, payload.ProjectID)
+		if err != nil {
+			return oops.New(err, "Failed to check whether project is hidden")
+		}
+	}
+
 	var logoUUID *uuid.UUID
 	if payload.Logo.Exists {
-		logoAsset, err := SaveFormImage(ctx, tx, payload.Logo, &user.ID)
+		logoAsset, err := SaveFormImage(ctx, tx, payload.Logo, &user.ID, hidden)
 		if err != nil {
 			return oops.New(err, "Failed to save asset")
 		}
@@ -786,7 +849,7 @@ func updateProject(ctx context.Context, tx pgx.Tx, user *models.User, payload *P
 
 	var headerImageUUID *uuid.UUID
 	if payload.HeaderImage.Exists {
-		headerImageAsset, err := SaveFormImage(ctx, tx, payload.HeaderImage, &user.ID)
+		headerImageAsset, err := SaveFormImage(ctx, tx, payload.HeaderImage, &user.ID, hidden)
 		if err != nil {
 			return oops.New(err, "Failed to save asset")
 		}
@@ -832,6 +895,11 @@ func updateProject(ctx context.Context, tx pgx.Tx, user *models.User, payload *P
 		return err
 	}
 
+	err = hmndata.SetProjectTopics(ctx, tx, payload.ProjectID, payload.Topics)
+	if err != nil {
+		return err
+	}
+
 	if user.IsStaff {
 		slugAliases := strings.Split(payload.SlugAliases, ",")
 		for i := range slugAliases {
@@ -899,6 +967,33 @@ func updateProject(ctx context.Context, tx pgx.Tx, user *models.User, payload *P
 		}
 	}
 
+	if payload.HeaderImageAltText != nil {
+		// The header image may be shared with other uploads of the same file,
+		// so only the person who uploaded it gets to describe it.
+		_, err = tx.Exec(ctx,
+			`
+			UPDATE asset
+			SET alt_text = $2
+			FROM project
+			WHERE
+				project.id = $1
+				AND asset.id = project.header_asset_id
+				AND asset.uploader_id = $3
+			`,
+			payload.ProjectID,
+			*payload.HeaderImageAltText,
+			user.ID,
+		)
+		if err != nil {
+			return oops.New(err, "Failed to update header image alt text")
+		}
+	}
+
+	err = setProjectAssetsPrivate(ctx, tx, payload.ProjectID, hidden)
+	if err != nil {
+		return err
+	}
+
 	owners, err := db.Query[models.User](ctx, tx,
 		`
 		SELECT $columns
@@ -1025,6 +1120,76 @@ func updateProject(ctx context.Context, tx pgx.Tx, user *models.User, payload *P
 	return nil
 }
 
+// Makes a project's images private while it is hidden, and public again once
+// it isn't. Images that are also used somewhere else are left alone, since
+// hiding them would break the other places, and showing them might reveal
+// something that is meant to stay hidden.
+func setProjectAssetsPrivate(ctx context.Context, tx pgx.Tx, projectID int, private bool) error {
+	const projectAssetRefs = `
+		SELECT logo_asset_id AS asset_id FROM project WHERE id = $1
+		UNION ALL
+		SELECT header_asset_id FROM project WHERE id = $1
+		UNION ALL
+		SELECT asset_id FROM project_screenshot WHERE project_id = $1
+	`
+
+	projectAssets, err := db.Query[models.Asset](ctx, tx,
+		fmt.Sprintf(
+			`
+			SELECT $columns
+			FROM asset
+			WHERE
+				asset.private != $2
+				AND asset.id IN (%s)
+			`,
+			projectAssetRefs,
+		),
+		projectID,
+		private,
+	)
+	if err != nil {
+		return oops.New(err, "Failed to fetch project images")
+	}
+
+	for _, asset := range projectAssets {
+		ownRefs, err := db.QueryOneScalar[int](ctx, tx,
+			fmt.Sprintf(`SELECT COUNT(*) FROM (%s) AS refs WHERE refs.asset_id = $2`, projectAssetRefs),
+			projectID,
+			asset.ID,
+		)
+		if err != nil {
+			return oops.New(err, "Failed to count project references to image")
+		}
+		allRefs, err := assets.CountReferences(ctx, tx, asset)
+		if err != nil {
+			return err
+		}
+		if allRefs > ownRefs {
+			continue
+		}
+		err = assets.SetAssetPrivate(ctx, tx, asset, private)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Owning a featured or jam project earns people Discord roles, so any change
+// to a project may change the roles of the people who own it, or used to.
+func queueRoleSyncForProjectOwners(c *RequestContext, projectID int, previousOwners []*models.User) {
+	owners, err := hmndata.FetchProjectOwners(c, c.Conn, projectID)
+	if err != nil {
+		c.Logger.Error().Err(err).Msg("failed to fetch project owners for role sync")
+	}
+
+	var userIDs []int
+	for _, owner := range append(owners, previousOwners...) {
+		userIDs = append(userIDs, owner.ID)
+	}
+	discord.QueueRoleSyncForUsers(c, c.Conn, userIDs...)
+}
+
 func CanEditProject(user *models.User, owners []*models.User) bool {
 	if user != nil {
 		if user.IsStaff {
@@ -101,6 +101,7 @@ func NewWebsiteRoutes(conn *pgxpool.Pool, perfCollector *perf.PerfCollector) htt
 		return res
 	})
 	routes.GET(hmnurl.RegexFishbowlFiles, FishbowlFiles)
+	routes.GET(hmnurl.RegexLocalAsset, LocalAsset)
 
 	// NOTE(asaf): HMN-only routes:
 	hmnOnly.GET(hmnurl.RegexManifesto, Manifesto)
@@ -108,6 +109,7 @@ func NewWebsiteRoutes(conn *pgxpool.Pool, perfCollector *perf.PerfCollector) htt
 	hmnOnly.GET(hmnurl.RegexAbout, About)
 	hmnOnly.GET(hmnurl.RegexCommunicationGuidelines, CommunicationGuidelines)
 	hmnOnly.GET(hmnurl.RegexContactPage, ContactPage)
+	hmnOnly.GET(hmnurl.RegexAssetFile, AssetFile)
 	hmnOnly.GET(hmnurl.RegexOldNewsletterSignup, func(c *RequestContext) ResponseData {
 		return c.Redirect(hmnurl.HMNProjectContext.BuildBlog(1), http.StatusFound)
 	})
@@ -188,12 +190,33 @@ func NewWebsiteRoutes(conn *pgxpool.Pool, perfCollector *perf.PerfCollector) htt
 	hmnOnly.POST(hmnurl.RegexAdminApprovalQueue, adminsOnly(csrfMiddleware(AdminApprovalQueueSubmit)))
 	hmnOnly.POST(hmnurl.RegexAdminSetUserOptions, adminsOnly(csrfMiddleware(UserProfileAdminSetOptions)))
 	hmnOnly.POST(hmnurl.RegexAdminNukeUser, adminsOnly(csrfMiddleware(UserProfileAdminNuke)))
+	hmnOnly.GET(hmnurl.RegexAdminDiscordChannels, adminsOnly(AdminDiscordChannels))
+	hmnOnly.POST(hmnurl.RegexAdminDiscordChannels, adminsOnly(csrfMiddleware(AdminDiscordChannelsSubmit)))
+	hmnOnly.GET(hmnurl.RegexAdminDiscordChannel, adminsOnly(AdminDiscordChannel))
+	hmnOnly.POST(hmnurl.RegexAdminDiscordChannel, adminsOnly(csrfMiddleware(AdminDiscordChannelSubmit)))
+	hmnOnly.POST(hmnurl.RegexAdminDiscordChannelRuleNew, adminsOnly(csrfMiddleware(AdminDiscordChannelRuleNewSubmit)))
+	hmnOnly.POST(hmnurl.RegexAdminDiscordChannelRule, adminsOnly(csrfMiddleware(AdminDiscordChannelRuleSubmit)))
+	hmnOnly.GET(hmnurl.RegexAdminDiscordChannelRuleTest, adminsOnly(AdminDiscordChannelRuleTest))
+	hmnOnly.GET(hmnurl.RegexAdminDiscordRoles, adminsOnly(AdminDiscordRoles))
+	hmnOnly.POST(hmnurl.RegexAdminDiscordRoles, adminsOnly(csrfMiddleware(AdminDiscordRolesSubmit)))
+	hmnOnly.GET(hmnurl.RegexAdminTwitch, adminsOnly(AdminTwitch))
+	hmnOnly.POST(hmnurl.RegexAdminTwitchRuleNew, adminsOnly(csrfMiddleware(AdminTwitchRuleNewSubmit)))
+	hmnOnly.POST(hmnurl.RegexAdminTwitchRule, adminsOnly(csrfMiddleware(AdminTwitchRuleSubmit)))
+	hmnOnly.POST(hmnurl.RegexAdminTwitchStreamers, adminsOnly(csrfMiddleware(AdminTwitchStreamersSubmit)))
+	hmnOnly.GET(hmnurl.RegexAdminFishbowls, adminsOnly(AdminFishbowls))
+	hmnOnly.POST(hmnurl.RegexAdminFishbowls, adminsOnly(csrfMiddleware(AdminFishbowlsSubmit)))
+	hmnOnly.GET(hmnurl.RegexAdminFishbowl, adminsOnly(AdminFishbowl))
+	hmnOnly.POST(hmnurl.RegexAdminFishbowl, adminsOnly(csrfMiddleware(AdminFishbowlSubmit)))
+	hmnOnly.POST(hmnurl.RegexAdminFishbowlImport, adminsOnly(csrfMiddleware(AdminFishbowlImportSubmit)))
 
 	hmnOnly.GET(hmnurl.RegexPerfmon, adminsOnly(Perfmon))
 
 	hmnOnly.GET(hmnurl.RegexFeed, Feed) // TODO: Remove / rework this page
 	hmnOnly.GET(hmnurl.RegexAtomFeed, AtomFeed)
 	hmnOnly.GET(hmnurl.RegexSnippet, Snippet)
+	hmnOnly.POST(hmnurl.RegexSnippetReact, needsAuth(csrfMiddleware(SnippetReact)))
+	hmnOnly.POST(hmnurl.RegexSnippetComment, needsAuth(csrfMiddleware(SnippetCommentSubmit)))
+	hmnOnly.POST(hmnurl.RegexSnippetCommentDelete, needsAuth(csrfMiddleware(SnippetCommentDelete)))
 	hmnOnly.GET(hmnurl.RegexProjectIndex, ProjectIndex)
 
 	hmnOnly.GET(hmnurl.RegexFollowingTest, needsAuth(FollowingTest))
@@ -244,6 +267,13 @@ func NewWebsiteRoutes(conn *pgxpool.Pool, perfCollector *perf.PerfCollector) htt
 	hmnOnly.GET(hmnurl.RegexEducationArticleDelete, educationAuthorsOnly(EducationArticleDelete))
 	hmnOnly.POST(hmnurl.RegexEducationArticleDelete, educationAuthorsOnly(csrfMiddleware(EducationArticleDeleteSubmit)))
 
+	hmnOnly.GET(hmnurl.RegexTopicIndex, TopicIndex)
+	hmnOnly.GET(hmnurl.RegexTopicNew, adminsOnly(TopicNew))
+	hmnOnly.POST(hmnurl.RegexTopicNew, adminsOnly(csrfMiddleware(TopicNewSubmit)))
+	hmnOnly.GET(hmnurl.RegexTopic, Topic) // Must come after TopicNew so `/new` does not match as a topic slug
+	hmnOnly.GET(hmnurl.RegexTopicEdit, adminsOnly(TopicEdit))
+	hmnOnly.POST(hmnurl.RegexTopicEdit, adminsOnly(csrfMiddleware(TopicEditSubmit)))
+
 	hmnOnly.GET(hmnurl.RegexStyleTest, StyleTest)
 
 	// NOTE(asaf): CheckUsername requires a logged-in user, so it can't use the apiRoutes middleware.
@@ -328,6 +358,8 @@ func NewWebsiteRoutes(conn *pgxpool.Pool, perfCollector *perf.PerfCollector) htt
 		})
 
 		rb.POST(hmnurl.RegexAssetUpload, AssetUpload)
+		rb.Handle([]string{http.MethodPatch}, hmnurl.RegexAssetUploadChunk, needsAuth(AssetUploadChunk))
+		rb.GET(hmnurl.RegexAssetUploadChunk, needsAuth(AssetUploadStatus)) // also serves HEAD
 	}
 	officialProjectRoutes := anyProject.WithMiddleware(officialProjectMiddleware)
 	personalProjectRoutes := hmnOnly.Group(hmnurl.RegexPersonalProject, personalProjectMiddleware)
@@ -2,17 +2,22 @@ package website
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"git.handmade.network/hmn/hmn/src/assets"
+	"git.handmade.network/hmn/hmn/src/config"
 	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/discord"
 	"git.handmade.network/hmn/hmn/src/embed"
 	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
@@ -30,6 +35,13 @@ type SnippetData struct {
 
 	CanEditSnippet bool
 	SnippetEdit    templates.SnippetEdit
+
+	Comments         []templates.SnippetComment
+	CanRespond       bool
+	ReactionPalette  []templates.SnippetReaction
+	ReactUrl         string
+	CommentUrl       string
+	CommentMaxLength int
 }
 
 func Snippet(c *RequestContext) ResponseData {
@@ -55,7 +67,8 @@ func Snippet(c *RequestContext) ResponseData {
 	}
 
 	canEdit := (c.CurrentUser != nil && (c.CurrentUser.IsStaff || c.CurrentUser.ID == s.Owner.ID))
-	snippet := SnippetToTimelineItem(&s.Snippet, s.Asset, s.DiscordMessage, s.Projects, s.Owner, canEdit)
+	snippet := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, canEdit)
+	snippet.Topics = templates.TopicsToTemplate(s.Topics)
 
 	opengraph := []templates.OpenGraphItem{
 		{Property: "og:site_name", Value: "Handmade Network"},
@@ -115,14 +128,50 @@ func Snippet(c *RequestContext) ResponseData {
 			SubmitUrl:             hmnurl.BuildSnippetSubmit(),
 			OnDeleteRedirectUrl:   hmnurl.BuildUserProfile(s.Owner.Username),
 			AssetMaxSize:          AssetMaxSize(c.CurrentUser),
+			MaxAttachments:        models.SnippetMaxAttachments,
+		}
+	}
+	comments, err := hmndata.FetchSnippetComments(c, c.Conn, s.Snippet.ID)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch snippet comments"))
+	}
+	templateComments := make([]templates.SnippetComment, 0, len(comments))
+	for _, comment := range comments {
+		tmplComment := templates.SnippetCommentToTemplate(comment)
+		if canDeleteSnippetComment(c.CurrentUser, &s, &comment.Comment) {
+			tmplComment.CanDelete = true
+			tmplComment.DeleteUrl = hmnurl.BuildSnippetCommentDelete(s.Snippet.ID, comment.Comment.ID)
 		}
+		templateComments = append(templateComments, tmplComment)
 	}
+
+	// Show every reaction that can be used from the website, along with
+	// whatever people have already left on the snippet.
+	reactionPalette := make([]templates.SnippetReaction, 0, len(models.SnippetReactionEmojis))
+	for _, emoji := range models.SnippetReactionEmojis {
+		reaction := templates.SnippetReaction{Emoji: emoji}
+		for _, existing := range snippet.Reactions {
+			if existing.Emoji == emoji && existing.ImageUrl == "" {
+				reaction = existing
+				break
+			}
+		}
+		reactionPalette = append(reactionPalette, reaction)
+	}
+
 	var res ResponseData
 	err = res.WriteTemplate("snippet.html", SnippetData{
 		BaseData:       baseData,
 		Snippet:        snippet,
 		CanEditSnippet: canEdit,
 		SnippetEdit:    snippetEdit,
+
+		Comments:         templateComments,
+		CanRespond:       canRespondToSnippets(c.CurrentUser),
+		ReactionPalette:  reactionPalette,
+		ReactUrl:         hmnurl.BuildSnippetReact(s.Snippet.ID),
+		CommentUrl:       hmnurl.BuildSnippetComment(s.Snippet.ID),
+		CommentMaxLength: models.SnippetCommentMaxLength,
 	}, c.Perf)
 	if err != nil {
 		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to render snippet template"))
@@ -132,7 +181,7 @@ func Snippet(c *RequestContext) ResponseData {
 
 func SnippetEditSubmit(c *RequestContext) ResponseData {
 	maxUploadSize := AssetMaxSize(c.CurrentUser)
-	maxBodySize := int64(maxUploadSize + 1024*1024)
+	maxBodySize := int64(maxUploadSize*models.SnippetMaxAttachments + 1024*1024)
 	c.Req.Body = http.MaxBytesReader(c.Res, c.Req.Body, maxBodySize)
 	err := c.Req.ParseMultipartForm(maxBodySize)
 	if err != nil {
@@ -148,7 +197,7 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 	var existingSnippet *hmndata.SnippetAndStuff
 	originalText := ""
 	var embedUrl *string
-	var assetID *uuid.UUID
+	var assetIDs []uuid.UUID
 
 	if len(existingSnippetIdStr) > 0 {
 		existingSnippetId, err := strconv.Atoi(existingSnippetIdStr)
@@ -169,7 +218,9 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 		}
 		originalText = snip.Snippet.Description
 		embedUrl = snip.Snippet.Url
-		assetID = snip.Snippet.AssetID
+		for _, asset := range snip.Assets {
+			assetIDs = append(assetIDs, asset.ID)
+		}
 		if snip.Snippet.Url != nil {
 			embedUrl = snip.Snippet.Url
 		}
@@ -194,21 +245,43 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 	} else {
 		if form.Get("remove_attachment") == "true" {
 			embedUrl = nil
-			assetID = nil
+			assetIDs = nil
 		}
 		text := strings.TrimSpace(form.Get("text"))
 		textHtml := parsing.ParseMarkdown(text, parsing.DiscordMarkdown)
 		projectAssociations := form["project_id"]
-		var assetData *assets.CreateInput
-
-		file, header, err := c.Req.FormFile("file")
-		if err != nil && !errors.Is(err, http.ErrMissingFile) {
-			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to get form file"))
+		var topics []*models.Topic
+		if form.Has("topics") {
+			var rejection string
+			topics, rejection, err = topicsFromForm(c, c.Conn, form.Get("topics"))
+			if err != nil {
+				return c.ErrorResponse(http.StatusInternalServerError, err)
+			}
+			if rejection != "" {
+				return c.RejectRequest(rejection)
+			}
 		}
+		var newAssets []assets.CreateInput
 
-		if header != nil {
-			content := make([]byte, header.Size)
-			_, err = file.Read(content)
+		var fileHeaders []*multipart.FileHeader
+		if c.Req.MultipartForm != nil {
+			fileHeaders = c.Req.MultipartForm.File["file"]
+		}
+		if len(fileHeaders) > models.SnippetMaxAttachments {
+			return c.RejectRequest(fmt.Sprintf("Snippets can have at most %d attachments.", models.SnippetMaxAttachments))
+		}
+		altTexts := form["file_alt_text"]
+		captions := form["file_caption"]
+		for i, header := range fileHeaders {
+			if header.Size > int64(maxUploadSize) {
+				return c.RejectRequest(fmt.Sprintf("%s is too big. The maximum file size is %d bytes.", header.Filename, maxUploadSize))
+			}
+			file, err := header.Open()
+			if err != nil {
+				return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to open uploaded file"))
+			}
+			content, err := io.ReadAll(file)
+			file.Close()
 			if err != nil {
 				return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to read uploaded file"))
 			}
@@ -219,24 +292,33 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 			width := 0
 			height := 0
 			if strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml" {
-				file.Seek(0, io.SeekStart)
-				config, _, err := image.DecodeConfig(file)
+				config, _, err := image.DecodeConfig(bytes.NewReader(content))
 				if err == nil {
 					width = config.Width
 					height = config.Height
 				}
 			}
-			assetData = &assets.CreateInput{
-				Content:     content,
+			altText := ""
+			if i < len(altTexts) {
+				altText = strings.TrimSpace(altTexts[i])
+			}
+			caption := ""
+			if i < len(captions) {
+				caption = strings.TrimSpace(captions[i])
+			}
+			newAssets = append(newAssets, assets.CreateInput{
+				Content:     bytes.NewReader(content),
 				Filename:    header.Filename,
 				ContentType: contentType,
 				UploaderID:  &c.CurrentUser.ID,
 				Width:       width,
 				Height:      height,
-			}
+				AltText:     altText,
+				Caption:     caption,
+			})
 		}
 
-		if originalText != text && assetData == nil && embedUrl == nil && assetID == nil {
+		if originalText != text && len(newAssets) == 0 && embedUrl == nil && len(assetIDs) == 0 {
 			urls := xurls.Relaxed().FindAllString(text, -1)
 			if urls != nil {
 				embeddable, err := embed.GetEmbeddableFromUrls(c, urls, maxUploadSize, time.Second*10, 3)
@@ -258,20 +340,20 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 								height = config.Height
 							}
 						}
-						assetData = &assets.CreateInput{
-							Content:     embeddable.File.Data,
+						newAssets = append(newAssets, assets.CreateInput{
+							Content:     bytes.NewReader(embeddable.File.Data),
 							Filename:    embeddable.File.Filename,
 							ContentType: embeddable.File.ContentType,
 							UploaderID:  &c.CurrentUser.ID,
 							Width:       width,
 							Height:      height,
-						}
+						})
 					}
 				}
 			}
 		}
 
-		if text == "" && assetData == nil && embedUrl == nil && assetID == nil {
+		if text == "" && len(newAssets) == 0 && embedUrl == nil && len(assetIDs) == 0 {
 			return c.RejectRequest("You must provide a description or a file attachment.")
 		}
 
@@ -281,13 +363,21 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 		}
 		defer tx.Rollback(c)
 
-		var asset *models.Asset
-		if assetData != nil {
-			asset, err = assets.Create(c, tx, *assetData)
+		if len(newAssets) > 0 {
+			// New uploads replace whatever was attached before.
+			assetIDs = nil
+			for _, assetData := range newAssets {
+				asset, err := assets.Create(c, tx, assetData)
+				if err != nil {
+					return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to create asset"))
+				}
+				assetIDs = append(assetIDs, asset.ID)
+			}
+		} else {
+			err = updateAssetDescriptions(c, tx, c.CurrentUser.ID, assetIDs, form["asset_id"], form["asset_alt_text"], form["asset_caption"])
 			if err != nil {
-				return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to create asset"))
+				return c.ErrorResponse(http.StatusInternalServerError, err)
 			}
-			assetID = &asset.ID
 		}
 
 		snippetId := 0
@@ -298,15 +388,13 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 					url = $2,
 					description = $3,
 					_description_html = $4,
-					asset_id = $5,
-					edited_on_website = $6
+					edited_on_website = $5
 				WHERE id = $1
 				`,
 				existingSnippet.Snippet.ID,
 				embedUrl,
 				text,
 				textHtml,
-				assetID,
 				true,
 			)
 			if err != nil {
@@ -316,15 +404,14 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 		} else {
 			newSnippetId, err := db.QueryOne[int](c, tx,
 				`
-				INSERT INTO snippet (url, "when", description, _description_html, asset_id, owner_id, edited_on_website)
-				VALUES ($1, $2, $3, $4, $5, $6 ,$7)
+				INSERT INTO snippet (url, "when", description, _description_html, owner_id, edited_on_website)
+				VALUES ($1, $2, $3, $4, $5, $6)
 				RETURNING id
 				`,
 				embedUrl,
 				time.Now(),
 				text,
 				textHtml,
-				assetID,
 				c.CurrentUser.ID,
 				true,
 			)
@@ -334,6 +421,11 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 			snippetId = *newSnippetId
 		}
 
+		err = hmndata.SetSnippetAssets(c, tx, snippetId, assetIDs)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to save snippet attachments"))
+		}
+
 		_, err = tx.Exec(c,
 			`
 			DELETE FROM snippet_project
@@ -377,6 +469,30 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 			}
 		}
 
+		// Stale copies of the snippet editor won't send topics, so don't
+		// clear them out in that case.
+		if form.Has("topics") {
+			err = hmndata.SetSnippetTopics(c, tx, snippetId, topics)
+			if err != nil {
+				return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to set snippet topics"))
+			}
+		}
+
+		// Only brand new snippets get cross-posted. Edits stay on the website.
+		if existingSnippet == nil && form.Get("crosspost_discord") == "true" {
+			crosspostUser, err := fetchSnippetCrosspostDiscordUser(c)
+			if err != nil {
+				return c.ErrorResponse(http.StatusInternalServerError, err)
+			}
+			if crosspostUser == nil {
+				return c.RejectRequest("You must link your Discord account to post snippets to Discord.")
+			}
+			err = discord.CrosspostSnippet(c, tx, crosspostUser.UserID, text, hmnurl.BuildSnippet(snippetId))
+			if err != nil {
+				return c.ErrorResponse(http.StatusInternalServerError, err)
+			}
+		}
+
 		hmndata.UpdateSnippetLastPostedForAllProjects(c, tx)
 
 		err = tx.Commit(c)
@@ -387,3 +503,59 @@ func SnippetEditSubmit(c *RequestContext) ResponseData {
 
 	return c.Redirect(redirect, http.StatusSeeOther)
 }
+
+// Returns the Discord account that a new website snippet can be cross-posted
+// from, or nil if the current user can't cross-post. Only approved users can
+// cross-post, since unapproved users' snippets aren't shown publicly either.
+func fetchSnippetCrosspostDiscordUser(c *RequestContext) (*models.DiscordUser, error) {
+	if config.Config.Discord.ShowcaseChannelID == "" || !canRespondToSnippets(c.CurrentUser) {
+		return nil, nil
+	}
+
+	duser, err := db.QueryOne[models.DiscordUser](c, c.Conn,
+		`
+		SELECT $columns
+		FROM discord_user
+		WHERE hmn_user_id = $1
+		`,
+		c.CurrentUser.ID,
+	)
+	if errors.Is(err, db.NotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, oops.New(err, "failed to fetch user's Discord account")
+	}
+
+	return duser, nil
+}
+
+// Saves edited alt text and captions for a snippet's existing attachments.
+// Only assets that are still attached to the snippet are touched. Identical
+// uploads share one asset, which may be shown on other people's snippets, so
+// people can only describe the assets they uploaded themselves.
+func updateAssetDescriptions(ctx context.Context, conn db.ConnOrTx, uploaderID int, attachedIDs []uuid.UUID, ids, altTexts, captions []string) error {
+	for i, idStr := range ids {
+		id, err := uuid.Parse(idStr)
+		if err != nil || !slices.Contains(attachedIDs, id) {
+			continue
+		}
+		if i >= len(altTexts) || i >= len(captions) {
+			break
+		}
+		_, err = conn.Exec(ctx,
+			`
+			UPDATE asset
+			SET alt_text = $2, caption = $3
+			WHERE id = $1 AND uploader_id = $4
+			`,
+			id,
+			strings.TrimSpace(altTexts[i]),
+			strings.TrimSpace(captions[i]),
+			uploaderID,
+		)
+		if err != nil {
+			return oops.New(err, "failed to update asset description")
+		}
+	}
+	return nil
+}
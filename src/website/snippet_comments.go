@@ -0,0 +1,175 @@
+package website
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/email"
+	"git.handmade.network/hmn/hmn/src/hmndata"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/parsing"
+)
+
+// Only approved users can react to and comment on snippets, for the same
+// reason that only approved users' snippets are shown publicly.
+func canRespondToSnippets(user *models.User) bool {
+	return user != nil && (user.IsStaff || user.Status == models.UserStatusApproved)
+}
+
+func fetchSnippetFromPath(c *RequestContext) (*hmndata.SnippetAndStuff, ResponseData, bool) {
+	snippetID, err := strconv.Atoi(c.PathParams["snippetid"])
+	if err != nil || snippetID < 1 {
+		return nil, FourOhFour(c), false
+	}
+
+	s, err := hmndata.FetchSnippet(c, c.Conn, c.CurrentUser, snippetID, hmndata.SnippetQuery{})
+	if err != nil {
+		if errors.Is(err, db.NotFound) {
+			return nil, FourOhFour(c), false
+		} else {
+			return nil, c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch snippet")), false
+		}
+	}
+
+	return &s, ResponseData{}, true
+}
+
+func SnippetReact(c *RequestContext) ResponseData {
+	if !canRespondToSnippets(c.CurrentUser) {
+		return c.RejectRequest("Your account must be approved before you can react to snippets.")
+	}
+
+	s, res, ok := fetchSnippetFromPath(c)
+	if !ok {
+		return res
+	}
+
+	form, err := c.GetFormValues()
+	if err != nil {
+		return c.ErrorResponse(http.StatusBadRequest, NewSafeError(err, "request must contain form data"))
+	}
+
+	emoji := form.Get("emoji")
+	if !models.IsValidSnippetReactionEmoji(emoji) {
+		return c.RejectRequest("That's not a reaction you can use on snippets.")
+	}
+
+	_, err = hmndata.ToggleSnippetReaction(c, c.Conn, s.Snippet.ID, c.CurrentUser.ID, emoji)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to toggle snippet reaction"))
+	}
+
+	return c.Redirect(hmnurl.BuildSnippet(s.Snippet.ID), http.StatusSeeOther)
+}
+
+func SnippetCommentSubmit(c *RequestContext) ResponseData {
+	if !canRespondToSnippets(c.CurrentUser) {
+		return c.RejectRequest("Your account must be approved before you can comment on snippets.")
+	}
+
+	s, res, ok := fetchSnippetFromPath(c)
+	if !ok {
+		return res
+	}
+
+	form, err := c.GetFormValues()
+	if err != nil {
+		return c.ErrorResponse(http.StatusBadRequest, NewSafeError(err, "request must contain form data"))
+	}
+
+	body := strings.TrimSpace(form.Get("body"))
+	if body == "" {
+		return c.RejectRequest("Your comment was empty.")
+	}
+	if utf8.RuneCountInString(body) > models.SnippetCommentMaxLength {
+		return c.RejectRequest("Your comment is too long.")
+	}
+	bodyHTML := parsing.ParseMarkdown(body, parsing.DiscordMarkdown)
+
+	_, err = c.Conn.Exec(c,
+		`
+		INSERT INTO snippet_comment (snippet_id, author_id, created_at, body, body_html)
+		VALUES ($1, $2, NOW(), $3, $4)
+		`,
+		s.Snippet.ID,
+		c.CurrentUser.ID,
+		body,
+		bodyHTML,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to save snippet comment"))
+	}
+
+	if s.Owner.ID != c.CurrentUser.ID && s.Owner.Status == models.UserStatusApproved && s.Owner.EmailSnippetComments {
+		err = email.SendSnippetCommentEmail(
+			s.Owner.Email,
+			s.Owner.BestName(),
+			c.CurrentUser.BestName(),
+			hmnurl.BuildSnippetComments(s.Snippet.ID),
+			bodyHTML,
+			c.Perf,
+		)
+		if err != nil {
+			// The comment is already saved, so don't fail the request over this.
+			c.Logger.Error().Err(err).Int("snippetID", s.Snippet.ID).Msg("failed to send snippet comment email")
+		}
+	}
+
+	return c.Redirect(hmnurl.BuildSnippetComments(s.Snippet.ID), http.StatusSeeOther)
+}
+
+func SnippetCommentDelete(c *RequestContext) ResponseData {
+	s, res, ok := fetchSnippetFromPath(c)
+	if !ok {
+		return res
+	}
+
+	commentID, err := strconv.Atoi(c.PathParams["commentid"])
+	if err != nil {
+		return FourOhFour(c)
+	}
+
+	comment, err := db.QueryOne[models.SnippetComment](c, c.Conn,
+		`
+		SELECT $columns
+		FROM snippet_comment
+		WHERE id = $1 AND snippet_id = $2
+		`,
+		commentID,
+		s.Snippet.ID,
+	)
+	if err != nil {
+		if errors.Is(err, db.NotFound) {
+			return FourOhFour(c)
+		} else {
+			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch snippet comment"))
+		}
+	}
+
+	if !canDeleteSnippetComment(c.CurrentUser, s, comment) {
+		return FourOhFour(c)
+	}
+
+	_, err = c.Conn.Exec(c, `DELETE FROM snippet_comment WHERE id = $1`, comment.ID)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to delete snippet comment"))
+	}
+
+	res = c.Redirect(hmnurl.BuildSnippetComments(s.Snippet.ID), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Deleted comment.")
+	return res
+}
+
+// Comments can be deleted by their author, by the owner of the snippet, or by staff.
+func canDeleteSnippetComment(user *models.User, s *hmndata.SnippetAndStuff, comment *models.SnippetComment) bool {
+	if user == nil {
+		return false
+	}
+	return user.IsStaff || user.ID == comment.AuthorID || user.ID == s.Owner.ID
+}
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"git.handmade.network/hmn/hmn/src/assets"
 	"git.handmade.network/hmn/hmn/src/db"
 	"git.handmade.network/hmn/hmn/src/hmndata"
 	"git.handmade.network/hmn/hmn/src/hmnurl"
@@ -246,10 +247,12 @@ func TwitchStreamToTimelineItem(
 
 func SnippetToTimelineItem(
 	snippet *models.Snippet,
-	asset *models.Asset,
+	assets []*models.Asset,
 	discordMessage *models.DiscordMessage,
 	projects []*hmndata.ProjectAndStuff,
 	owner *models.User,
+	reactions []hmndata.SnippetReactionCount,
+	commentCount int,
 	editable bool,
 ) templates.TimelineItem {
 	item := templates.TimelineItem{
@@ -265,20 +268,15 @@ func SnippetToTimelineItem(
 		Description:    template.HTML(snippet.DescriptionHtml),
 		RawDescription: snippet.Description,
 
+		Reactions:    templates.SnippetReactionsToTemplate(reactions),
+		CommentCount: commentCount,
+
 		CanShowcase: true,
 		Editable:    editable,
 	}
 
-	if asset != nil {
-		if strings.HasPrefix(asset.MimeType, "image/") {
-			item.Media = append(item.Media, imageMediaItem(asset))
-		} else if strings.HasPrefix(asset.MimeType, "video/") {
-			item.Media = append(item.Media, videoMediaItem(asset))
-		} else if strings.HasPrefix(asset.MimeType, "audio/") {
-			item.Media = append(item.Media, audioMediaItem(asset))
-		} else {
-			item.Media = append(item.Media, unknownMediaItem(asset))
-		}
+	for _, asset := range assets {
+		item.Media = append(item.Media, assetMediaItem(asset))
 	}
 
 	if snippet.Url != nil {
@@ -324,13 +322,41 @@ func getYoutubeVideoID(url string) string {
 	return ""
 }
 
+func assetMediaItem(asset *models.Asset) templates.TimelineItemMedia {
+	var item templates.TimelineItemMedia
+	if strings.HasPrefix(asset.MimeType, "image/") {
+		item = imageMediaItem(asset)
+	} else if strings.HasPrefix(asset.MimeType, "video/") {
+		item = videoMediaItem(asset)
+	} else if strings.HasPrefix(asset.MimeType, "audio/") {
+		item = audioMediaItem(asset)
+	} else {
+		item = unknownMediaItem(asset)
+	}
+	item.AssetID = asset.ID.String()
+	item.AltText = asset.AltText
+	item.Caption = asset.Caption
+	return item
+}
+
 func imageMediaItem(asset *models.Asset) templates.TimelineItemMedia {
-	assetUrl := hmnurl.BuildS3Asset(asset.S3Key)
+	assetUrl := hmnurl.BuildAssetObject(asset, asset.S3Key)
+	srcset, webpSrcset := templates.AssetSrcsets(asset)
+
+	// Use the largest variant that's still smaller than a typical thumbnail.
+	thumbnailUrl := assetUrl
+	for _, width := range asset.VariantWidths {
+		if width <= 640 && len(asset.VariantFormats) > 0 {
+			thumbnailUrl = hmnurl.BuildAssetObject(asset, assets.VariantKey(asset.ID.String(), width, asset.VariantFormats[0]))
+		}
+	}
 
 	return templates.TimelineItemMedia{
 		Type:         templates.TimelineItemMediaTypeImage,
 		AssetUrl:     assetUrl,
-		ThumbnailUrl: assetUrl, // TODO: Use smaller thumbnails?
+		ThumbnailUrl: thumbnailUrl,
+		Srcset:       srcset,
+		WebpSrcset:   webpSrcset,
 		MimeType:     asset.MimeType,
 		Width:        asset.Width,
 		Height:       asset.Height,
@@ -338,32 +364,54 @@ func imageMediaItem(asset *models.Asset) templates.TimelineItemMedia {
 }
 
 func videoMediaItem(asset *models.Asset) templates.TimelineItemMedia {
-	assetUrl := hmnurl.BuildS3Asset(asset.S3Key)
+	assetUrl := hmnurl.BuildAssetObject(asset, asset.S3Key)
+	mimeType := asset.MimeType
+	var lowBandwidthUrl string
+	if asset.TranscodeStatus == models.TranscodeStatusDone {
+		assetUrl = hmnurl.BuildAssetObject(asset, asset.TranscodedS3Key)
+		lowBandwidthUrl = hmnurl.BuildAssetObject(asset, asset.TranscodedLowS3Key)
+		mimeType = "video/mp4"
+	}
 	var thumbnailUrl string
 	if asset.ThumbnailS3Key != "" {
-		thumbnailUrl = hmnurl.BuildS3Asset(asset.ThumbnailS3Key)
+		thumbnailUrl = hmnurl.BuildAssetObject(asset, asset.ThumbnailS3Key)
 	}
 
 	return templates.TimelineItemMedia{
-		Type:         templates.TimelineItemMediaTypeVideo,
+		Type:            templates.TimelineItemMediaTypeVideo,
+		AssetUrl:        assetUrl,
+		LowBandwidthUrl: lowBandwidthUrl,
+		ThumbnailUrl:    thumbnailUrl,
+		MimeType:        mimeType,
+		Width:           asset.Width,
+		Height:          asset.Height,
+	}
+}
+
+func audioMediaItem(asset *models.Asset) templates.TimelineItemMedia {
+	assetUrl := hmnurl.BuildAssetObject(asset, asset.S3Key)
+
+	return templates.TimelineItemMedia{
+		Type:         templates.TimelineItemMediaTypeAudio,
 		AssetUrl:     assetUrl,
-		ThumbnailUrl: thumbnailUrl,
 		MimeType:     asset.MimeType,
 		Width:        asset.Width,
 		Height:       asset.Height,
+		Duration:     asset.Duration,
+		WaveformPath: waveformPath(asset.Waveform),
 	}
 }
 
-func audioMediaItem(asset *models.Asset) templates.TimelineItemMedia {
-	assetUrl := hmnurl.BuildS3Asset(asset.S3Key)
-
-	return templates.TimelineItemMedia{
-		Type:     templates.TimelineItemMediaTypeAudio,
-		AssetUrl: assetUrl,
-		MimeType: asset.MimeType,
-		Width:    asset.Width,
-		Height:   asset.Height,
+// Draws a waveform as one bar per sample, centered vertically in a 1000x100
+// box.
+func waveformPath(waveform []byte) string {
+	var path strings.Builder
+	step := 1000 / float64(len(waveform))
+	for i, level := range waveform {
+		height := max(2, int(level)*100/255)
+		fmt.Fprintf(&path, "M%.1f %dh%.1fv%dh-%.1fz", (float64(i)+0.15)*step, (100-height)/2, step*0.7, height, step*0.7)
 	}
+	return path.String()
 }
 
 func youtubeMediaItem(videoId string) templates.TimelineItemMedia {
@@ -382,7 +430,7 @@ func youtubeMediaItem(videoId string) templates.TimelineItemMedia {
 }
 
 func unknownMediaItem(asset *models.Asset) templates.TimelineItemMedia {
-	assetUrl := hmnurl.BuildS3Asset(asset.S3Key)
+	assetUrl := hmnurl.BuildAssetObject(asset, asset.S3Key)
 
 	return templates.TimelineItemMedia{
 		Type:     templates.TimelineItemMediaTypeUnknown,
@@ -471,11 +519,15 @@ func TimelineItemToTemplate(item *hmndata.TimelineItemAndStuff, lineageBuilder *
 		OwnerUrl:       ownerTmpl.ProfileUrl,
 
 		Projects:       nil,
+		Topics:         templates.TopicsToTemplate(item.Topics),
 		Description:    template.HTML(item.Item.ParsedDescription),
 		RawDescription: item.Item.RawDescription,
 
 		Media: nil,
 
+		Reactions:    templates.SnippetReactionsToTemplate(item.Reactions),
+		CommentCount: item.CommentCount,
+
 		ForumLayout:         item.Item.Type == models.TimelineItemTypePost,
 		AllowTitleWrap:      false,
 		TruncateDescription: false,
@@ -483,16 +535,8 @@ func TimelineItemToTemplate(item *hmndata.TimelineItemAndStuff, lineageBuilder *
 		Editable:            item.Item.Type == models.TimelineItemTypeSnippet && editable,
 	}
 
-	if item.Asset != nil {
-		if strings.HasPrefix(item.Asset.MimeType, "image/") {
-			ti.Media = append(ti.Media, imageMediaItem(item.Asset))
-		} else if strings.HasPrefix(item.Asset.MimeType, "video/") {
-			ti.Media = append(ti.Media, videoMediaItem(item.Asset))
-		} else if strings.HasPrefix(item.Asset.MimeType, "audio/") {
-			ti.Media = append(ti.Media, audioMediaItem(item.Asset))
-		} else {
-			ti.Media = append(ti.Media, unknownMediaItem(item.Asset))
-		}
+	for _, asset := range item.Assets {
+		ti.Media = append(ti.Media, assetMediaItem(asset))
 	}
 
 	if item.Item.ExternalUrl != nil {
@@ -0,0 +1,408 @@
+package website
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/hmndata"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/parsing"
+	"git.handmade.network/hmn/hmn/src/templates"
+)
+
+const maxTopicSnippets = 60
+
+type TopicTreeNode struct {
+	Topic    templates.Topic
+	Children []TopicTreeNode
+}
+
+func TopicIndex(c *RequestContext) ResponseData {
+	type TopicIndexData struct {
+		templates.BaseData
+		Topics []TopicTreeNode
+	}
+
+	topics, err := hmndata.FetchTopics(c, c.Conn, hmndata.TopicQuery{})
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	baseData := getBaseData(c, "Topics", nil)
+	if c.CurrentUser != nil && c.CurrentUser.IsStaff {
+		baseData.Header.Actions = []templates.Action{
+			{
+				Name: "New Topic",
+				Url:  hmnurl.BuildTopicNew(),
+				Icon: "add",
+			},
+		}
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("topic_index.html", TopicIndexData{
+		BaseData: baseData,
+		Topics:   buildTopicTree(topics, nil, map[int]bool{}),
+	}, c.Perf)
+	return res
+}
+
+func buildTopicTree(topics []*models.Topic, parentID *int, visited map[int]bool) []TopicTreeNode {
+	var nodes []TopicTreeNode
+	for _, topic := range topics {
+		isChild := (parentID == nil && topic.ParentID == nil) ||
+			(parentID != nil && topic.ParentID != nil && *parentID == *topic.ParentID)
+		if !isChild || visited[topic.ID] {
+			continue
+		}
+		visited[topic.ID] = true
+		nodes = append(nodes, TopicTreeNode{
+			Topic:    templates.TopicToTemplate(topic),
+			Children: buildTopicTree(topics, &topic.ID, visited),
+		})
+	}
+	return nodes
+}
+
+func Topic(c *RequestContext) ResponseData {
+	slug := strings.ToLower(c.PathParams["slug"])
+	topic, err := hmndata.FetchTopic(c, c.Conn, hmndata.TopicQuery{Slugs: []string{slug}})
+	if errors.Is(err, db.NotFound) {
+		return FourOhFour(c)
+	} else if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	if topic.Slug != slug {
+		// The topic was found by one of its aliases.
+		return c.Redirect(hmnurl.BuildTopic(topic.Slug), http.StatusMovedPermanently)
+	}
+
+	lineage, err := hmndata.FetchTopicLineage(c, c.Conn, topic)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch topic lineage"))
+	}
+	breadcrumbs := []templates.Breadcrumb{{Name: "Topics", Url: hmnurl.BuildTopicIndex()}}
+	for i := len(lineage) - 1; i >= 1; i-- {
+		breadcrumbs = append(breadcrumbs, templates.Breadcrumb{
+			Name: lineage[i].Name,
+			Url:  hmnurl.BuildTopic(lineage[i].Slug),
+		})
+	}
+
+	subtopics, err := hmndata.FetchTopics(c, c.Conn, hmndata.TopicQuery{ParentIDs: []int{topic.ID}})
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	topicIDs, err := hmndata.FetchTopicDescendantIDs(c, c.Conn, topic.ID)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	projects, err := hmndata.FetchProjects(c, c.Conn, c.CurrentUser, hmndata.ProjectsQuery{
+		TopicIDs: topicIDs,
+		OrderBy:  "sort_score DESC, name ASC",
+	})
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch projects for topic"))
+	}
+	templateProjects := make([]templates.Project, 0, len(projects))
+	for _, p := range projects {
+		templateProjects = append(templateProjects, templates.ProjectAndStuffToTemplate(&p))
+	}
+
+	articles, err := fetchEduArticles(c, c.Conn, c.CurrentUser, EduArticleQuery{TopicIDs: topicIDs})
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch education articles for topic"))
+	}
+	templateArticles := make([]templates.EduArticle, 0, len(articles))
+	for _, article := range articles {
+		tmplArticle := templates.EducationArticleToTemplate(&article)
+		tmplArticle.Url = eduArticleURL(&article)
+		templateArticles = append(templateArticles, tmplArticle)
+	}
+
+	snippets, err := hmndata.FetchSnippets(c, c.Conn, c.CurrentUser, hmndata.SnippetQuery{
+		TopicIDs: topicIDs,
+		Limit:    maxTopicSnippets,
+	})
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch snippets for topic"))
+	}
+	timelineItems := make([]templates.TimelineItem, 0, len(snippets))
+	for _, s := range snippets {
+		item := SnippetToTimelineItem(&s.Snippet, s.Assets, s.DiscordMessage, s.Projects, s.Owner, s.Reactions, s.CommentCount, false)
+		item.Topics = templates.TopicsToTemplate(s.Topics)
+		timelineItems = append(timelineItems, item)
+	}
+
+	type TopicData struct {
+		templates.BaseData
+		Topic     templates.Topic
+		Subtopics []templates.Topic
+		Projects  []templates.Project
+		Articles  []templates.EduArticle
+		Snippets  []templates.TimelineItem
+	}
+
+	baseData := getBaseData(c, topic.Name, breadcrumbs)
+	baseData.OpenGraphItems = append(baseData.OpenGraphItems, templates.OpenGraphItem{
+		Property: "og:description",
+		Value:    topic.Description,
+	})
+	if c.CurrentUser != nil && c.CurrentUser.IsStaff {
+		baseData.Header.Actions = []templates.Action{
+			{
+				Name: "Edit Topic",
+				Url:  hmnurl.BuildTopicEdit(topic.Slug),
+				Icon: "edit-line",
+			},
+		}
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("topic.html", TopicData{
+		BaseData:  baseData,
+		Topic:     templates.TopicToTemplate(topic),
+		Subtopics: templates.TopicsToTemplate(subtopics),
+		Projects:  templateProjects,
+		Articles:  templateArticles,
+		Snippets:  timelineItems,
+	}, c.Perf)
+	return res
+}
+
+type TopicEditData struct {
+	templates.BaseData
+	Editing   bool
+	Topic     templates.Topic
+	ParentID  int
+	AllTopics []templates.Topic
+	SubmitUrl string
+}
+
+func TopicNew(c *RequestContext) ResponseData {
+	allTopics, err := hmndata.FetchTopics(c, c.Conn, hmndata.TopicQuery{})
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("topic_edit.html", TopicEditData{
+		BaseData:  getBaseData(c, "New Topic", []templates.Breadcrumb{{Name: "Topics", Url: hmnurl.BuildTopicIndex()}}),
+		AllTopics: templates.TopicsToTemplate(allTopics),
+		SubmitUrl: hmnurl.BuildTopicNew(),
+	}, c.Perf)
+	return res
+}
+
+func TopicNewSubmit(c *RequestContext) ResponseData {
+	form, rejection, err := parseTopicForm(c, nil)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
+
+	_, err = c.Conn.Exec(c,
+		`
+		INSERT INTO topic (slug, name, description, description_html, aliases, parent_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`,
+		form.Slug, form.Name, form.Description, form.DescriptionHTML, form.Aliases, form.ParentID,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to create topic"))
+	}
+
+	res := c.Redirect(hmnurl.BuildTopic(form.Slug), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Created new topic.")
+	return res
+}
+
+func TopicEdit(c *RequestContext) ResponseData {
+	topic, err := hmndata.FetchTopic(c, c.Conn, hmndata.TopicQuery{Slugs: []string{c.PathParams["slug"]}})
+	if errors.Is(err, db.NotFound) {
+		return FourOhFour(c)
+	} else if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	allTopics, err := hmndata.FetchTopics(c, c.Conn, hmndata.TopicQuery{})
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	otherTopics := make([]*models.Topic, 0, len(allTopics))
+	for _, t := range allTopics {
+		if t.ID != topic.ID {
+			otherTopics = append(otherTopics, t)
+		}
+	}
+
+	parentID := 0
+	if topic.ParentID != nil {
+		parentID = *topic.ParentID
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("topic_edit.html", TopicEditData{
+		BaseData: getBaseData(c, fmt.Sprintf("Editing \"%s\"", topic.Name), []templates.Breadcrumb{
+			{Name: "Topics", Url: hmnurl.BuildTopicIndex()},
+			{Name: topic.Name, Url: hmnurl.BuildTopic(topic.Slug)},
+		}),
+		Editing:   true,
+		Topic:     templates.TopicToTemplate(topic),
+		ParentID:  parentID,
+		AllTopics: templates.TopicsToTemplate(otherTopics),
+		SubmitUrl: hmnurl.BuildTopicEdit(topic.Slug),
+	}, c.Perf)
+	return res
+}
+
+func TopicEditSubmit(c *RequestContext) ResponseData {
+	topic, err := hmndata.FetchTopic(c, c.Conn, hmndata.TopicQuery{Slugs: []string{c.PathParams["slug"]}})
+	if errors.Is(err, db.NotFound) {
+		return FourOhFour(c)
+	} else if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+
+	if strings.ToLower(c.Req.PostFormValue("action")) == "delete" {
+		_, err = c.Conn.Exec(c, `DELETE FROM topic WHERE id = $1`, topic.ID)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to delete topic"))
+		}
+		res := c.Redirect(hmnurl.BuildTopicIndex(), http.StatusSeeOther)
+		res.AddFutureNotice("success", "Topic deleted.")
+		return res
+	}
+
+	form, rejection, err := parseTopicForm(c, topic)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
+
+	_, err = c.Conn.Exec(c,
+		`
+		UPDATE topic SET
+			slug = $2,
+			name = $3,
+			description = $4,
+			description_html = $5,
+			aliases = $6,
+			parent_id = $7
+		WHERE id = $1
+		`,
+		topic.ID,
+		form.Slug, form.Name, form.Description, form.DescriptionHTML, form.Aliases, form.ParentID,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to update topic"))
+	}
+
+	res := c.Redirect(hmnurl.BuildTopic(form.Slug), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Topic updated.")
+	return res
+}
+
+type topicForm struct {
+	Slug            string
+	Name            string
+	Description     string
+	DescriptionHTML string
+	Aliases         []string
+	ParentID        *int
+}
+
+// Parses and validates the staff topic editor. `existing` is nil when
+// creating a new topic.
+func parseTopicForm(c *RequestContext, existing *models.Topic) (topicForm, string, error) {
+	var res topicForm
+
+	form, err := c.GetFormValues()
+	if err != nil {
+		return res, "", oops.New(err, "failed to parse topic form")
+	}
+
+	res.Slug = strings.ToLower(strings.TrimSpace(form.Get("slug")))
+	if !models.ValidateTopicSlug(res.Slug) {
+		return res, "Topic slugs must be all lowercase, and can use hyphens to separate words.", nil
+	}
+	res.Name = strings.TrimSpace(form.Get("name"))
+	if res.Name == "" {
+		return res, "Topics must have a name.", nil
+	}
+	res.Description = strings.TrimSpace(form.Get("description"))
+	res.DescriptionHTML = parsing.ParseMarkdown(res.Description, parsing.PostMarkdown)
+
+	res.Aliases = hmndata.ParseTopicSlugs(form.Get("aliases"))
+	if res.Aliases == nil {
+		res.Aliases = []string{}
+	}
+	for _, alias := range res.Aliases {
+		if !models.ValidateTopicSlug(alias) {
+			return res, fmt.Sprintf("The alias \"%s\" is not a valid topic slug.", alias), nil
+		}
+	}
+
+	// Slugs and aliases share a namespace, so make sure nobody else is using them.
+	conflicts, err := hmndata.FetchTopics(c, c.Conn, hmndata.TopicQuery{
+		Slugs: append([]string{res.Slug}, res.Aliases...),
+	})
+	if err != nil {
+		return res, "", err
+	}
+	for _, conflict := range conflicts {
+		if existing == nil || conflict.ID != existing.ID {
+			return res, fmt.Sprintf("The slug or aliases conflict with the topic \"%s\".", conflict.Name), nil
+		}
+	}
+
+	if parentSlug := form.Get("parent"); parentSlug != "" {
+		parent, err := hmndata.FetchTopic(c, c.Conn, hmndata.TopicQuery{Slugs: []string{parentSlug}})
+		if errors.Is(err, db.NotFound) {
+			return res, "The parent topic does not exist.", nil
+		} else if err != nil {
+			return res, "", err
+		}
+
+		if existing != nil {
+			lineage, err := hmndata.FetchTopicLineage(c, c.Conn, parent)
+			if err != nil {
+				return res, "", err
+			}
+			for _, ancestor := range lineage {
+				if ancestor.ID == existing.ID {
+					return res, "A topic cannot be nested inside itself.", nil
+				}
+			}
+		}
+
+		res.ParentID = &parent.ID
+	}
+
+	return res, "", nil
+}
+
+// Resolves a comma-separated list of topic slugs from a form field, returning
+// a rejection reason if any of them are not real topics.
+func topicsFromForm(c *RequestContext, dbConn db.ConnOrTx, value string) ([]*models.Topic, string, error) {
+	topics, unknown, err := hmndata.ResolveTopicSlugs(c, dbConn, hmndata.ParseTopicSlugs(value))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Sprintf("Unknown topics: %s. See %s for the full list.", strings.Join(unknown, ", "), hmnurl.BuildTopicIndex()), nil
+	}
+	return topics, "", nil
+}
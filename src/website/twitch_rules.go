@@ -0,0 +1,322 @@
+package website
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"git.handmade.network/hmn/hmn/src/db"
+	"git.handmade.network/hmn/hmn/src/hmnurl"
+	"git.handmade.network/hmn/hmn/src/models"
+	"git.handmade.network/hmn/hmn/src/oops"
+	"git.handmade.network/hmn/hmn/src/templates"
+	"git.handmade.network/hmn/hmn/src/twitch"
+)
+
+type adminTwitchRule struct {
+	Rule      *models.TwitchRelevanceRule // nil for a new rule
+	AllKinds  []models.TwitchRelevanceRuleKind
+	SubmitUrl string
+}
+
+type adminTwitchStreamer struct {
+	Login  string
+	Policy string
+}
+
+type adminTwitchStream struct {
+	Stream        *models.TwitchLatestStatus
+	Url           string
+	CategoryLabel string
+	Shown         bool
+	Reason        string
+}
+
+func AdminTwitch(c *RequestContext) ResponseData {
+	type AdminTwitchData struct {
+		templates.BaseData
+		Rules        []adminTwitchRule
+		NewRule      adminTwitchRule
+		NewRuleUrl   string
+		Streamers    []adminTwitchStreamer
+		StreamersUrl string
+		Streams      []adminTwitchStream
+	}
+
+	rules, err := twitch.FetchRelevanceRules(c, c.Conn)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	live, err := db.Query[models.TwitchLatestStatus](c, c.Conn,
+		`
+		SELECT $columns
+		FROM twitch_latest_status
+		WHERE live = TRUE
+		ORDER BY started_at ASC
+		`,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch live twitch streamers"))
+	}
+
+	data := AdminTwitchData{
+		BaseData:     getBaseData(c, "Twitch Streams", nil),
+		NewRule:      adminTwitchRule{AllKinds: models.AllTwitchRelevanceRuleKinds},
+		NewRuleUrl:   hmnurl.BuildAdminTwitchRuleNew(),
+		StreamersUrl: hmnurl.BuildAdminTwitchStreamers(),
+	}
+	categoryLabels := make(map[string]string)
+	for _, rule := range rules.Rules {
+		data.Rules = append(data.Rules, adminTwitchRule{
+			Rule:      rule,
+			AllKinds:  models.AllTwitchRelevanceRuleKinds,
+			SubmitUrl: hmnurl.BuildAdminTwitchRule(rule.ID),
+		})
+		if rule.Kind == models.TwitchRelevanceRuleCategory {
+			categoryLabels[rule.Value] = rule.Label
+		}
+	}
+	for _, override := range rules.Overrides {
+		policy := twitchStreamerPolicy(override)
+		if policy == "rules" {
+			continue
+		}
+		data.Streamers = append(data.Streamers, adminTwitchStreamer{
+			Login:  override.TwitchLogin,
+			Policy: policy,
+		})
+	}
+	for _, stream := range live {
+		shown, reason := rules.Check(stream.TwitchLogin, stream.CategoryID, stream.Tags)
+		data.Streams = append(data.Streams, adminTwitchStream{
+			Stream:        stream,
+			Url:           "https://twitch.tv/" + stream.TwitchLogin,
+			CategoryLabel: categoryLabels[stream.CategoryID],
+			Shown:         shown,
+			Reason:        reason,
+		})
+	}
+
+	var res ResponseData
+	res.MustWriteTemplate("admin_twitch.html", data, c.Perf)
+	return res
+}
+
+func AdminTwitchRuleNewSubmit(c *RequestContext) ResponseData {
+	form, rejection, err := parseTwitchRuleForm(c)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
+
+	tag, err := c.Conn.Exec(c,
+		`
+		INSERT INTO twitch_relevance_rule (kind, value, label, relevant)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+		`,
+		form.Kind, form.Value, form.Label, form.Relevant,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to create twitch relevance rule"))
+	}
+	if tag.RowsAffected() == 0 {
+		return c.RejectRequest("There is already a rule for that.")
+	}
+	twitch.RelevanceRulesUpdated()
+
+	res := c.Redirect(hmnurl.BuildAdminTwitch(), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Rule created.")
+	return res
+}
+
+func AdminTwitchRuleSubmit(c *RequestContext) ResponseData {
+	ruleID, err := strconv.Atoi(c.PathParams["ruleid"])
+	if err != nil {
+		return FourOhFour(c)
+	}
+	rule, err := db.QueryOne[models.TwitchRelevanceRule](c, c.Conn,
+		`
+		SELECT $columns
+		FROM twitch_relevance_rule
+		WHERE id = $1
+		`,
+		ruleID,
+	)
+	if errors.Is(err, db.NotFound) {
+		return FourOhFour(c)
+	} else if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to fetch twitch relevance rule"))
+	}
+
+	if strings.ToLower(c.Req.PostFormValue("action")) == "delete" {
+		_, err := c.Conn.Exec(c, `DELETE FROM twitch_relevance_rule WHERE id = $1`, rule.ID)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to delete twitch relevance rule"))
+		}
+		twitch.RelevanceRulesUpdated()
+		res := c.Redirect(hmnurl.BuildAdminTwitch(), http.StatusSeeOther)
+		res.AddFutureNotice("success", "Rule deleted.")
+		return res
+	}
+
+	form, rejection, err := parseTwitchRuleForm(c)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, err)
+	}
+	if rejection != "" {
+		return c.RejectRequest(rejection)
+	}
+
+	duplicate, err := db.QueryOneScalar[bool](c, c.Conn,
+		`
+		SELECT COUNT(*) > 0
+		FROM twitch_relevance_rule
+		WHERE kind = $1 AND value = $2 AND id != $3
+		`,
+		form.Kind, form.Value, rule.ID,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to check for duplicate twitch relevance rules"))
+	}
+	if duplicate {
+		return c.RejectRequest("There is already a rule for that.")
+	}
+
+	_, err = c.Conn.Exec(c,
+		`
+		UPDATE twitch_relevance_rule SET
+			kind = $2,
+			value = $3,
+			label = $4,
+			relevant = $5
+		WHERE id = $1
+		`,
+		rule.ID,
+		form.Kind, form.Value, form.Label, form.Relevant,
+	)
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to update twitch relevance rule"))
+	}
+	twitch.RelevanceRulesUpdated()
+
+	res := c.Redirect(hmnurl.BuildAdminTwitch(), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Rule updated.")
+	return res
+}
+
+var reTwitchLogin = regexp.MustCompile(`^[a-z0-9_]{1,25}$`)
+
+func AdminTwitchStreamersSubmit(c *RequestContext) ResponseData {
+	form, err := c.GetFormValues()
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to parse streamer form"))
+	}
+
+	login := strings.ToLower(strings.TrimSpace(form.Get("login")))
+	login = strings.TrimPrefix(login, "https://")
+	login = strings.TrimPrefix(login, "www.")
+	login = strings.TrimPrefix(login, "twitch.tv/")
+	if !reTwitchLogin.MatchString(login) {
+		return c.RejectRequest("That doesn't look like a Twitch login.")
+	}
+
+	switch form.Get("policy") {
+	case "rules":
+		_, err = c.Conn.Exec(c, `DELETE FROM twitch_ignore_list WHERE twitch_login = $1`, login)
+	case "show", "hide":
+		_, err = c.Conn.Exec(c,
+			`
+			INSERT INTO twitch_ignore_list (twitch_login, banned, relevant)
+			VALUES ($1, FALSE, $2)
+			ON CONFLICT (twitch_login) DO UPDATE SET
+				banned = EXCLUDED.banned,
+				relevant = EXCLUDED.relevant
+			`,
+			login,
+			form.Get("policy") == "show",
+		)
+	case "ignore":
+		_, err = c.Conn.Exec(c,
+			`
+			INSERT INTO twitch_ignore_list (twitch_login, banned, relevant)
+			VALUES ($1, TRUE, NULL)
+			ON CONFLICT (twitch_login) DO UPDATE SET
+				banned = EXCLUDED.banned,
+				relevant = EXCLUDED.relevant
+			`,
+			login,
+		)
+	default:
+		return c.RejectRequest("Invalid streamer policy.")
+	}
+	if err != nil {
+		return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to update twitch ignore list"))
+	}
+	twitch.RelevanceRulesUpdated()
+
+	res := c.Redirect(hmnurl.BuildAdminTwitch(), http.StatusSeeOther)
+	res.AddFutureNotice("success", "Streamer updated.")
+	return res
+}
+
+// The admin page folds the ignore list's two columns into one choice.
+func twitchStreamerPolicy(entry *models.TwitchIgnoreListEntry) string {
+	switch {
+	case entry.Banned:
+		return "ignore"
+	case entry.Relevant == nil:
+		return "rules"
+	case *entry.Relevant:
+		return "show"
+	default:
+		return "hide"
+	}
+}
+
+type twitchRuleForm struct {
+	Kind     models.TwitchRelevanceRuleKind
+	Value    string
+	Label    string
+	Relevant bool
+}
+
+var reTwitchCategoryID = regexp.MustCompile(`^[0-9]+$`)
+
+func parseTwitchRuleForm(c *RequestContext) (twitchRuleForm, string, error) {
+	var res twitchRuleForm
+
+	form, err := c.GetFormValues()
+	if err != nil {
+		return res, "", oops.New(err, "failed to parse rule form")
+	}
+
+	kind, err := strconv.Atoi(form.Get("kind"))
+	if err != nil {
+		return res, "Invalid rule kind.", nil
+	}
+	res.Kind = models.TwitchRelevanceRuleKind(kind)
+	switch res.Kind {
+	case models.TwitchRelevanceRuleCategory:
+		res.Value = strings.TrimSpace(form.Get("value"))
+		if !reTwitchCategoryID.MatchString(res.Value) {
+			return res, "Categories are matched by ID. The Twitch API's \"Get Games\" endpoint will tell you a category's ID.", nil
+		}
+	case models.TwitchRelevanceRuleTag:
+		res.Value = strings.TrimPrefix(strings.TrimSpace(form.Get("value")), "#")
+		if res.Value == "" {
+			return res, "Tag rules need a tag.", nil
+		}
+	default:
+		return res, "Invalid rule kind.", nil
+	}
+
+	res.Label = strings.TrimSpace(form.Get("label"))
+	res.Relevant = form.Get("relevant") == "true"
+
+	return res, "", nil
+}
@@ -148,8 +148,15 @@ func UserProfile(c *RequestContext) ResponseData {
 			AvailableProjectsJSON: templates.SnippetEditProjectsToJSON(templateProjects),
 			SubmitUrl:             hmnurl.BuildSnippetSubmit(),
 			AssetMaxSize:          AssetMaxSize(c.CurrentUser),
+			MaxAttachments:        models.SnippetMaxAttachments,
 		}
 
+		crosspostUser, err := fetchSnippetCrosspostDiscordUser(c)
+		if err != nil {
+			return c.ErrorResponse(http.StatusInternalServerError, err)
+		}
+		snippetEdit.CanCrosspost = crosspostUser != nil
+
 		if !ownProfile {
 			followUrl = hmnurl.BuildFollowUser()
 			following, err = db.QueryOneScalar[bool](c, c.Conn, `
@@ -207,6 +214,8 @@ func UserSettings(c *RequestContext) ResponseData {
 		LinksJSON   string
 		HasPassword bool
 
+		EmailSnippetComments bool
+
 		SubmitUrl  string
 		ContactUrl string
 
@@ -283,6 +292,8 @@ func UserSettings(c *RequestContext) ResponseData {
 		LinksJSON:         linksJSON,
 		HasPassword:       c.CurrentUser.Password != "",
 
+		EmailSnippetComments: c.CurrentUser.EmailSnippetComments,
+
 		SubmitUrl:  hmnurl.BuildUserSettings(""),
 		ContactUrl: hmnurl.BuildContactPage(),
 
@@ -333,6 +344,7 @@ func UserSettingsSave(c *RequestContext) ResponseData {
 	}
 
 	showEmail := form.Get("showemail") != ""
+	emailSnippetComments := form.Get("email-snippet-comments") != ""
 
 	blurb := form.Get("shortbio")
 	signature := form.Get("signature")
@@ -349,6 +361,7 @@ func UserSettingsSave(c *RequestContext) ResponseData {
 			name = $?,
 			email = $?,
 			showemail = $?,
+			email_snippet_comments = $?,
 			blurb = $?,
 			signature = $?,
 			bio = $?
@@ -356,6 +369,7 @@ func UserSettingsSave(c *RequestContext) ResponseData {
 		name,
 		email,
 		showEmail,
+		emailSnippetComments,
 		blurb,
 		signature,
 		bio,
@@ -432,7 +446,7 @@ func UserSettingsSave(c *RequestContext) ResponseData {
 	}
 	var avatarUUID *uuid.UUID
 	if newAvatar.Exists {
-		avatarAsset, err := SaveFormImage(c, tx, newAvatar, &c.CurrentUser.ID)
+		avatarAsset, err := SaveFormImage(c, tx, newAvatar, &c.CurrentUser.ID, false)
 		if err != nil {
 			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to upload avatar"))
 		}
@@ -526,6 +540,7 @@ func UserProfileAdminSetOptions(c *RequestContext) ResponseData {
 			return c.ErrorResponse(http.StatusInternalServerError, oops.New(err, "failed to log out user"))
 		}
 	}
+	discord.QueueRoleSyncForUsers(c, c.Conn, userId)
 	res := c.Redirect(hmnurl.BuildUserProfile(c.Req.Form.Get("username")), http.StatusSeeOther)
 	res.AddFutureNotice("success", "Successfully set admin options")
 	return res
@@ -48,10 +48,19 @@ var WebsiteCommand = &cobra.Command{
 			auth.PeriodicallyDeleteInactiveUsers(conn),
 			perfCollectorJob,
 			discord.RunDiscordBot(conn),
-			// discord.RunHistoryWatcher(conn),
+			discord.RunRoleSync(conn),
+			discord.RunHistoryWatcher(conn),
+			discord.RunScheduledEventSync(conn),
+			discord.RunForumBridge(conn),
 			twitch.MonitorTwitchSubscriptions(conn),
 			hmns3.StartServer(),
 			assets.BackgroundPreviewGeneration(conn),
+			assets.BackgroundVariantGeneration(conn),
+			assets.BackgroundVideoTranscoding(conn),
+			assets.BackgroundAudioAnalysis(conn),
+			// Report-only until we're sure every kind of reference to an asset
+			// is accounted for. Run `admin asset gc` to actually delete things.
+			assets.PeriodicallyCollectGarbage(conn, true),
 			calendar.MonitorCalendars(),
 			bundle.RunEsBuildServer(),
 			email.MonitorBounces(conn),